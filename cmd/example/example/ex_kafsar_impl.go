@@ -32,7 +32,7 @@ func (e ExampleKafsarImpl) AuthTopicGroup(username string, password, clientId, c
 	return true, nil
 }
 
-func (e ExampleKafsarImpl) SubscriptionName(groupId string) (string, error) {
+func (e ExampleKafsarImpl) SubscriptionName(username, groupId string) (string, error) {
 	return groupId, nil
 }
 
@@ -48,6 +48,6 @@ func (e ExampleKafsarImpl) ListTopic(username string) ([]string, error) {
 	return nil, nil
 }
 
-func (e ExampleKafsarImpl) HasFlowQuota(username, topic string) bool {
-	return true
+func (e ExampleKafsarImpl) ReserveFetchQuota(username, topic string, bytes int) int {
+	return bytes
 }
@@ -36,7 +36,7 @@ func (e ItKafsarImpl) AuthTopicGroup(username string, password, clientId, consum
 	return true, nil
 }
 
-func (e ItKafsarImpl) SubscriptionName(groupId string) (string, error) {
+func (e ItKafsarImpl) SubscriptionName(username, groupId string) (string, error) {
 	return groupId, nil
 }
 
@@ -60,6 +60,6 @@ func (e ItKafsarImpl) ListTopic(username string) ([]string, error) {
 	return nil, nil
 }
 
-func (e ItKafsarImpl) HasFlowQuota(username, topic string) bool {
-	return true
+func (e ItKafsarImpl) ReserveFetchQuota(username, topic string, bytes int) int {
+	return bytes
 }
@@ -26,16 +26,77 @@ const (
 	TimeEarliest = int64(-2)
 	TimeLasted   = int64(-1)
 
-	OffsetReaderEarliestName = "OFFSET_LIST_EARLIEST"
+	UnknownTimestamp = int64(-1)
+
+	CooperativeStickyProtocol = "cooperative-sticky"
+
+	OffsetResetEarliest = "earliest"
+	OffsetResetLatest   = "latest"
+
+	OffsetReaderEarliestName  = "OFFSET_LIST_EARLIEST"
+	OffsetReaderTimestampName = "OFFSET_LIST_TIMESTAMP"
+	ProduceOffsetReaderName   = "PRODUCE_OFFSET"
 
 	DefaultProducerSendTimeout = 1 * time.Second
 	DefaultMaxPendingMsg       = 100
+	DefaultProduceTimeout      = 30 * time.Second
+	DefaultCloseGracePeriod    = 10 * time.Second
+	DefaultBacklogCacheTime    = 5 * time.Second
+	ReaderDrainCheckInterval   = 10 * time.Millisecond
+	DefaultCreationCooldown    = 5 * time.Second
+	// DefaultReaderCreateMaxRetries retries of a reader creation failed with a retryable pulsar error
+	DefaultReaderCreateMaxRetries   = 3
+	DefaultReaderCreateRetryBackoff = 100 * time.Millisecond
+	// ChannelReaderRetryBackoff ChannelReaderMaxRetryBackoff the prefetch of a reader retries a failed read after the
+	// backoff, doubled for each consecutive failure
+	ChannelReaderRetryBackoff    = 100 * time.Millisecond
+	ChannelReaderMaxRetryBackoff = 5 * time.Second
+	// DefaultBatchingMaxPublishDelay pulsar client default batching delay
+	DefaultBatchingMaxPublishDelay = 10 * time.Millisecond
+	// QuotaWindow the burst allowed by byte rate quotas, same as kafka quota.window.size.seconds
+	QuotaWindow = 1 * time.Second
+
+	NoProducerId         = int64(-1)
+	NoProducerEpoch      = int16(-1)
+	ProducerIdExpiration = 24 * time.Hour
+
+	OffsetManagerStartMaxRetries    = 10
+	OffsetManagerStartRetryInterval = 1 * time.Second
+	// DefaultOffsetRetentionCheckInterval same as kafka offsets.retention.check.interval.ms
+	DefaultOffsetRetentionCheckInterval = 10 * time.Minute
+
+	// PulsarKeepAliveInterval the pulsar client pings its connections at this interval, it is not configurable yet
+	PulsarKeepAliveInterval = 30 * time.Second
 
 	PartitionSuffixFormat = "-partition-%d"
+
+	// MaxPreallocatedFetchRecords the records slice of a partition fetch never preallocates more than this
+	MaxPreallocatedFetchRecords = 1024
+
+	SourceClusterProperty = "__source_cluster"
+
+	// ChunkIdProperty ChunkIndexProperty ChunkTotalProperty mark the pulsar messages carrying the chunks of a large record
+	ChunkIdProperty    = "__chunk_id"
+	ChunkIndexProperty = "__chunk_index"
+	ChunkTotalProperty = "__chunk_total"
+	// DefaultMaxMessageBytes leave room for the message metadata in the 5MB default max message size of pulsar
+	DefaultMaxMessageBytes = 5*1024*1024 - 64*1024
+	// ChunkIndexBits the low bits of the pulsar sequence id of a chunk hold the chunk index
+	ChunkIndexBits = 16
+	// MaxPendingChunkedRecords the chunked records of different producers a reader reassembles at once,
+	// the oldest partial record is dropped beyond it
+	MaxPendingChunkedRecords = 16
+
+	RecordBatchTransactionalFlag = uint16(0x10)
+	RecordBatchControlFlag       = uint16(0x20)
 )
 
 const (
-	LastMsgIdUrl = "/admin/v2/persistent/%s/%s/%s/lastMessageId"
+	LastMsgIdUrl    = "/admin/v2/persistent/%s/%s/%s/lastMessageId"
+	RetentionUrl    = "/admin/v2/persistent/%s/%s/%s/retention"
+	TopicStatsUrl   = "/admin/v2/persistent/%s/%s/%s/stats"
+	TopicListUrl    = "/admin/v2/persistent/%s/%s"
+	BrokerHealthUrl = "/admin/v2/brokers/health"
 )
 
 const (
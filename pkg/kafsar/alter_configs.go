@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"fmt"
+	"github.com/paashzj/kafka_go_pulsar/pkg/model"
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+	"strconv"
+	"time"
+)
+
+const bytesPerMB = 1024 * 1024
+
+type AlterConfigsResource struct {
+	ResourceType ConfigResourceType
+	ResourceName string
+	Configs      []*AlterableConfig
+}
+
+type AlterableConfig struct {
+	Name  string
+	Value string
+}
+
+type AlterConfigsResult struct {
+	ResourceType ConfigResourceType
+	ResourceName string
+	ErrorCode    codec.ErrorCode
+	ErrorMessage string
+}
+
+// AlterConfigs topic retention configs are set as pulsar topic policies, the other configs are read only.
+// configs not in the request keep their value. validateOnly check the request without altering anything
+func (b *Broker) AlterConfigs(addr net.Addr, resources []*AlterConfigsResource, validateOnly bool) ([]*AlterConfigsResult, error) {
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	results := make([]*AlterConfigsResult, len(resources))
+	for i, resource := range resources {
+		result := &AlterConfigsResult{
+			ResourceType: resource.ResourceType,
+			ResourceName: resource.ResourceName,
+			ErrorCode:    codec.NONE,
+		}
+		results[i] = result
+		if !exist {
+			b.logger.Errorf("alter configs failed when get userinfo by addr %s, resource: %s", addr.String(), resource.ResourceName)
+			result.ErrorCode = codec.UNKNOWN_SERVER_ERROR
+			continue
+		}
+		switch resource.ResourceType {
+		case ConfigResourceTopic:
+			result.ErrorCode = b.authorize(user.principal(), OperationAlterConfigs, Resource{Type: ResourceTopic, Name: resource.ResourceName})
+			if result.ErrorCode != codec.NONE {
+				b.logger.Warnf("alter configs of topic %s denied. username: %s", resource.ResourceName, user.username)
+				continue
+			}
+			result.ErrorCode, result.ErrorMessage = b.alterTopicConfigs(user, resource.ResourceName, resource.Configs, validateOnly)
+		case ConfigResourceBroker:
+			result.ErrorCode = b.authorize(user.principal(), OperationAlterConfigs, Resource{Type: ResourceCluster, Name: resource.ResourceName})
+			if result.ErrorCode != codec.NONE {
+				b.logger.Warnf("alter configs of broker %s denied. username: %s", resource.ResourceName, user.username)
+				continue
+			}
+			if resource.ResourceName != strconv.Itoa(int(b.kafsarConfig.NodeId)) {
+				b.logger.Errorf("alter configs failed, broker %s is not this node", resource.ResourceName)
+				result.ErrorCode = codec.INVALID_REQUEST
+				continue
+			}
+			if len(resource.Configs) > 0 {
+				result.ErrorCode = codec.INVALID_CONFIG
+				result.ErrorMessage = fmt.Sprintf("broker config %s is read only", resource.Configs[0].Name)
+			}
+		default:
+			b.logger.Errorf("alter configs failed, unsupported resource type %d", resource.ResourceType)
+			result.ErrorCode = codec.INVALID_REQUEST
+		}
+	}
+	return results, nil
+}
+
+func (b *Broker) alterTopicConfigs(user *userInfo, kafkaTopic string, configs []*AlterableConfig, validateOnly bool) (codec.ErrorCode, string) {
+	pulsarTopic, err := b.pulsarTopic(user.username, kafkaTopic)
+	if err != nil {
+		b.logger.Errorf("get pulsar topic failed. username: %s, topic: %s, err: %s", user.username, kafkaTopic, err)
+		return codec.UNKNOWN_TOPIC_OR_PARTITION, ""
+	}
+	if _, err := b.partitionNum(user.username, kafkaTopic); err != nil {
+		b.logger.Errorf("get partition num failed. username: %s, topic: %s, err: %s", user.username, kafkaTopic, err)
+		return codec.UNKNOWN_TOPIC_OR_PARTITION, ""
+	}
+	if len(configs) == 0 {
+		return codec.NONE, ""
+	}
+	retention, err := utils.GetTopicRetention(pulsarTopic, b.getPulsarHttpUrl())
+	if err != nil {
+		b.logger.Errorf("get topic retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return codec.UNKNOWN_SERVER_ERROR, ""
+	}
+	if err := applyRetentionConfigs(retention, configs); err != nil {
+		b.logger.Warnf("alter configs of topic %s failed. err: %s", kafkaTopic, err)
+		return codec.INVALID_CONFIG, err.Error()
+	}
+	if validateOnly {
+		return codec.NONE, ""
+	}
+	if err := utils.SetTopicRetention(pulsarTopic, b.getPulsarHttpUrl(), retention); err != nil {
+		b.logger.Errorf("set topic retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return codec.UNKNOWN_SERVER_ERROR, ""
+	}
+	b.logger.Infof("alter retention of topic %s to %d minutes, %d MB", pulsarTopic, retention.RetentionTimeInMinutes, retention.RetentionSizeInMB)
+	return codec.NONE, ""
+}
+
+// applyRetentionConfigs convert kafka configs to pulsar retention, the inverse of retentionConfigs.
+// pulsar retention is in minutes and megabytes, other values can not be kept as is and are rejected
+func applyRetentionConfigs(retention *model.RetentionPolicies, configs []*AlterableConfig) error {
+	for _, config := range configs {
+		switch config.Name {
+		case ConfigRetentionMs:
+			retentionMs, err := retentionValue(config, int64(time.Minute/time.Millisecond))
+			if err != nil {
+				return err
+			}
+			retention.RetentionTimeInMinutes = int(retentionMs / int64(time.Minute/time.Millisecond))
+			if retentionMs < 0 {
+				retention.RetentionTimeInMinutes = -1
+			}
+		case ConfigRetentionBytes:
+			retentionBytes, err := retentionValue(config, bytesPerMB)
+			if err != nil {
+				return err
+			}
+			retention.RetentionSizeInMB = retentionBytes / bytesPerMB
+			if retentionBytes < 0 {
+				retention.RetentionSizeInMB = -1
+			}
+		case ConfigNumPartitions:
+			return fmt.Errorf("topic config %s is read only", config.Name)
+		default:
+			return fmt.Errorf("unknown topic config %s", config.Name)
+		}
+	}
+	return nil
+}
+
+// retentionValue parse the config, -1 means infinite, other values must be a multiple of unit
+func retentionValue(config *AlterableConfig, unit int64) (int64, error) {
+	value, err := strconv.ParseInt(config.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s of config %s", config.Value, config.Name)
+	}
+	if value == -1 {
+		return value, nil
+	}
+	if value < 0 || value%unit != 0 {
+		return 0, fmt.Errorf("value of config %s must be -1 or a multiple of %d", config.Name, unit)
+	}
+	return value, nil
+}
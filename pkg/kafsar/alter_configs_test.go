@@ -0,0 +1,88 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"encoding/json"
+	"github.com/paashzj/kafka_go_pulsar/pkg/model"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAlterTopicConfigs(t *testing.T) {
+	k := newTestBroker(KafsarConfig{})
+	retention := &model.RetentionPolicies{RetentionTimeInMinutes: 10, RetentionSizeInMB: 2}
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			_ = json.NewDecoder(r.Body).Decode(retention)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(retention)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+
+	configs := []*AlterableConfig{{Name: ConfigRetentionMs, Value: "3600000"}}
+	results, err := k.AlterConfigs(&addr, []*AlterConfigsResource{{ResourceType: ConfigResourceTopic, ResourceName: "topic", Configs: configs}}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	assert.Equal(t, 0, posts)
+
+	results, err = k.AlterConfigs(&addr, []*AlterConfigsResource{{ResourceType: ConfigResourceTopic, ResourceName: "topic", Configs: configs}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	assert.Equal(t, 1, posts)
+	assert.Equal(t, 60, retention.RetentionTimeInMinutes)
+	assert.Equal(t, int64(2), retention.RetentionSizeInMB)
+
+	results, err = k.AlterConfigs(&addr, []*AlterConfigsResource{
+		{ResourceType: ConfigResourceTopic, ResourceName: "topic", Configs: []*AlterableConfig{{Name: ConfigNumPartitions, Value: "3"}}},
+		{ResourceType: ConfigResourceBroker, ResourceName: "0", Configs: []*AlterableConfig{{Name: ConfigGroupMaxSize, Value: "3"}}},
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_CONFIG, results[0].ErrorCode)
+	assert.Equal(t, codec.INVALID_CONFIG, results[1].ErrorCode)
+	assert.Equal(t, 1, posts)
+}
+
+func TestApplyRetentionConfigs(t *testing.T) {
+	retention := &model.RetentionPolicies{RetentionTimeInMinutes: 10, RetentionSizeInMB: 2}
+	err := applyRetentionConfigs(retention, []*AlterableConfig{
+		{Name: ConfigRetentionMs, Value: "-1"},
+		{Name: ConfigRetentionBytes, Value: "5242880"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, -1, retention.RetentionTimeInMinutes)
+	assert.Equal(t, int64(5), retention.RetentionSizeInMB)
+
+	assert.NotNil(t, applyRetentionConfigs(retention, []*AlterableConfig{{Name: ConfigRetentionMs, Value: "1000"}}))
+	assert.NotNil(t, applyRetentionConfigs(retention, []*AlterableConfig{{Name: ConfigRetentionBytes, Value: "abc"}}))
+	assert.NotNil(t, applyRetentionConfigs(retention, []*AlterableConfig{{Name: "cleanup.policy", Value: "compact"}}))
+}
@@ -0,0 +1,134 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+)
+
+// AclOperation same as kafka acl operation
+type AclOperation string
+
+const (
+	OperationRead            AclOperation = "Read"
+	OperationWrite           AclOperation = "Write"
+	OperationCreate          AclOperation = "Create"
+	OperationDelete          AclOperation = "Delete"
+	OperationDescribe        AclOperation = "Describe"
+	OperationDescribeConfigs AclOperation = "DescribeConfigs"
+	OperationAlterConfigs    AclOperation = "AlterConfigs"
+	OperationAll             AclOperation = "All"
+)
+
+// ResourceType same as kafka acl resource type
+type ResourceType int8
+
+const (
+	ResourceTopic   ResourceType = 2
+	ResourceGroup   ResourceType = 3
+	ResourceCluster ResourceType = 4
+)
+
+type Resource struct {
+	Type ResourceType
+	Name string
+}
+
+// Principal the sasl authenticated client
+type Principal struct {
+	Username string
+	Password string
+	ClientId string
+}
+
+// Authorizer is optionally implemented by Server to authorize every operation on topics, groups and the cluster
+// in one place. Without it, topics are authorized by AuthTopic and groups by AuthTopicGroup
+type Authorizer interface {
+	Authorize(principal Principal, operation AclOperation, resource Resource) (bool, error)
+}
+
+// serverAuthorizer adapt the auth methods of Server to Authorizer
+type serverAuthorizer struct {
+	server Server
+}
+
+func (s serverAuthorizer) Authorize(principal Principal, operation AclOperation, resource Resource) (bool, error) {
+	switch resource.Type {
+	case ResourceTopic:
+		return s.server.AuthTopic(principal.Username, principal.Password, principal.ClientId, resource.Name, permissionType(operation))
+	case ResourceGroup:
+		return s.server.AuthTopicGroup(principal.Username, principal.Password, principal.ClientId, resource.Name)
+	default:
+		// the principal is already authenticated, Server has no cluster level permission
+		return true, nil
+	}
+}
+
+// permissionType the permission type of Server.AuthTopic
+func permissionType(operation AclOperation) string {
+	switch operation {
+	case OperationRead, OperationDescribe, OperationDescribeConfigs:
+		return "R"
+	case OperationWrite:
+		return "W"
+	default:
+		return "ALL"
+	}
+}
+
+// permissionOperation the operation of the permission type passed by the network layer
+func permissionOperation(permissionType string) AclOperation {
+	switch permissionType {
+	case "R":
+		return OperationRead
+	case "W":
+		return OperationWrite
+	default:
+		return OperationAll
+	}
+}
+
+func (b *Broker) authorizer() Authorizer {
+	if authorizer, ok := b.server.(Authorizer); ok {
+		return authorizer
+	}
+	return serverAuthorizer{server: b.server}
+}
+
+// authorize return the authorization failed code of the resource type if the principal is denied
+func (b *Broker) authorize(principal Principal, operation AclOperation, resource Resource) codec.ErrorCode {
+	auth, err := b.authorizer().Authorize(principal, operation, resource)
+	if err != nil {
+		b.logger.Errorf("authorize failed. username: %s, operation: %s, resource: %s, err: %s", principal.Username, operation, resource.Name, err)
+	}
+	if err == nil && auth {
+		return codec.NONE
+	}
+	switch resource.Type {
+	case ResourceTopic:
+		return codec.TOPIC_AUTHORIZATION_FAILED
+	case ResourceGroup:
+		return codec.GROUP_AUTHORIZATION_FAILED
+	default:
+		return codec.CLUSTER_AUTHORIZATION_FAILED
+	}
+}
+
+func (u *userInfo) principal() Principal {
+	return Principal{Username: u.username, Password: u.password, ClientId: u.clientId}
+}
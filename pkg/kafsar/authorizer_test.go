@@ -0,0 +1,97 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/test"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// permissionServer record the permission type asked by the default authorizer
+type permissionServer struct {
+	test.KafsarImpl
+	permissionTypes []string
+}
+
+func (p *permissionServer) AuthTopic(username string, password, clientId, topic, permissionType string) (bool, error) {
+	p.permissionTypes = append(p.permissionTypes, permissionType)
+	return permissionType == "R", nil
+}
+
+// denyAuthorizer deny every operation on the denied resources
+type denyAuthorizer struct {
+	test.KafsarImpl
+	denied map[string]bool
+}
+
+func (d denyAuthorizer) Authorize(principal Principal, operation AclOperation, resource Resource) (bool, error) {
+	return !d.denied[resource.Name], nil
+}
+
+func TestServerAuthorizer(t *testing.T) {
+	server := &permissionServer{}
+	k := newTestBroker(kafsarConfig)
+	k.server = server
+	req := codec.SaslAuthenticateReq{BaseReq: codec.BaseReq{ClientId: clientId}, Username: username, Password: password}
+	auth, code := k.SaslAuthTopic(&addr, req, "topic", "R")
+	assert.True(t, auth)
+	assert.Equal(t, codec.NONE, code)
+	auth, code = k.SaslAuthTopic(&addr, req, "topic", "W")
+	assert.False(t, auth)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, code)
+	code = k.authorize(Principal{Username: username}, OperationDelete, Resource{Type: ResourceTopic, Name: "topic"})
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, code)
+	assert.Equal(t, []string{"R", "W", "ALL"}, server.permissionTypes)
+	code = k.authorize(Principal{Username: username}, OperationDescribeConfigs, Resource{Type: ResourceCluster, Name: "1"})
+	assert.Equal(t, codec.NONE, code)
+}
+
+func TestAuthorizer(t *testing.T) {
+	k := newTestBroker(KafsarConfig{NodeId: 1})
+	k.server = denyAuthorizer{denied: map[string]bool{"topic": true, groupId: true, "1": true}}
+	req := codec.SaslAuthenticateReq{BaseReq: codec.BaseReq{ClientId: clientId}, Username: username, Password: password}
+	auth, code := k.SaslAuthTopic(&addr, req, "topic", "R")
+	assert.False(t, auth)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, code)
+	auth, code = k.SaslAuthConsumerGroup(&addr, req, groupId)
+	assert.False(t, auth)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, code)
+	auth, _ = k.SaslAuthConsumerGroup(&addr, req, "other-group")
+	assert.True(t, auth)
+
+	deleteResults, err := k.DeleteGroups(&addr, []string{groupId, "other-group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, groupId, deleteResults[0].GroupId)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, deleteResults[0].ErrorCode)
+	assert.Equal(t, "other-group", deleteResults[1].GroupId)
+	assert.Equal(t, codec.GROUP_ID_NOT_FOUND, deleteResults[1].ErrorCode)
+
+	describeResults, err := k.DescribeConfigs(&addr, []*DescribeConfigsResource{
+		{ResourceType: ConfigResourceTopic, ResourceName: "topic"},
+		{ResourceType: ConfigResourceBroker, ResourceName: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, describeResults[0].ErrorCode)
+	assert.Equal(t, codec.CLUSTER_AUTHORIZATION_FAILED, describeResults[1].ErrorCode)
+}
@@ -0,0 +1,128 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+	"sync"
+	"time"
+)
+
+// byteRateQuota throttle the users exceeding their byte rate the way kafka does, the broker answers how long the
+// client should back off instead of refusing the request. a burst of one quota window is free
+type byteRateQuota struct {
+	mutex sync.Mutex
+	paid  map[string]time.Time // the time the recorded bytes of the user are paid off at the quota rate
+}
+
+func newByteRateQuota() *byteRateQuota {
+	return &byteRateQuota{paid: make(map[string]time.Time)}
+}
+
+// record the bytes of the user, return the throttle time. quota 0 means no quota
+func (q *byteRateQuota) record(username string, bytes, quota int64, now time.Time) time.Duration {
+	if quota <= 0 {
+		return 0
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	paid := q.paid[username]
+	if burst := now.Add(-constant.QuotaWindow); paid.Before(burst) {
+		paid = burst
+	}
+	paid = paid.Add(time.Duration(float64(bytes) / float64(quota) * float64(time.Second)))
+	q.paid[username] = paid
+	return throttleTime(paid, now)
+}
+
+func (q *byteRateQuota) throttleTime(username string, now time.Time) time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	paid, exist := q.paid[username]
+	if !exist {
+		return 0
+	}
+	if !paid.After(now) {
+		delete(q.paid, username)
+	}
+	return throttleTime(paid, now)
+}
+
+func throttleTime(paid, now time.Time) time.Duration {
+	if paid.After(now) {
+		return paid.Sub(now)
+	}
+	return 0
+}
+
+func (b *Broker) byteRateQuota(username string) (produceBytesPerSec, fetchBytesPerSec int64) {
+	if resolver, ok := b.server.(QuotaResolver); ok {
+		return resolver.ByteRateQuota(username)
+	}
+	return 0, 0
+}
+
+func (b *Broker) recordProduceBytes(user *userInfo, records []*codec.Record) {
+	quota, _ := b.byteRateQuota(user.username)
+	if quota <= 0 {
+		return
+	}
+	b.produceQuota.record(user.username, int64(recordsBytes(records)), quota, time.Now())
+}
+
+func (b *Broker) recordFetchBytes(addr net.Addr, topicRespList []*codec.FetchTopicResp) {
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if !exist {
+		return
+	}
+	_, quota := b.byteRateQuota(user.username)
+	if quota <= 0 {
+		return
+	}
+	bytes := 0
+	for _, topicResp := range topicRespList {
+		for _, partitionResp := range topicResp.PartitionRespList {
+			if partitionResp != nil && partitionResp.RecordBatch != nil {
+				bytes += recordsBytes(partitionResp.RecordBatch.Records)
+			}
+		}
+	}
+	b.fetchQuota.record(user.username, int64(bytes), quota, time.Now())
+}
+
+// ThrottleTimeMs the time the client of the connection should back off after a produce or fetch request
+func (b *Broker) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if !exist {
+		return 0
+	}
+	var throttle time.Duration
+	switch apiKey {
+	case codec.Produce:
+		throttle = b.produceQuota.throttleTime(user.username, time.Now())
+	case codec.Fetch:
+		throttle = b.fetchQuota.throttleTime(user.username, time.Now())
+	}
+	return int(throttle.Milliseconds())
+}
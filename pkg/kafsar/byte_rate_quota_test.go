@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/test"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestByteRateQuota(t *testing.T) {
+	q := newByteRateQuota()
+	now := time.Now()
+	// a burst of one window is free
+	assert.Equal(t, time.Duration(0), q.record("user", 1000, 1000, now))
+	assert.Equal(t, 500*time.Millisecond, q.record("user", 500, 1000, now))
+	assert.Equal(t, 500*time.Millisecond, q.throttleTime("user", now))
+	assert.Equal(t, 300*time.Millisecond, q.throttleTime("user", now.Add(200*time.Millisecond)))
+	// other users have their own quota
+	assert.Equal(t, time.Duration(0), q.throttleTime("other", now))
+	// paid off after the throttle time
+	assert.Equal(t, time.Duration(0), q.throttleTime("user", now.Add(time.Second)))
+	assert.Empty(t, q.paid)
+	// the unused rate is not saved beyond one window
+	later := now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), q.record("user", 1000, 1000, later))
+	assert.Equal(t, time.Second, q.record("user", 1000, 1000, later))
+	// no quota
+	assert.Equal(t, time.Duration(0), q.record("none", 1000000, 0, now))
+}
+
+// quotaServer limit the byte rate of all users
+type quotaServer struct {
+	test.KafsarImpl
+	produceBytesPerSec int64
+	fetchBytesPerSec   int64
+}
+
+func (q quotaServer) ByteRateQuota(username string) (int64, int64) {
+	return q.produceBytesPerSec, q.fetchBytesPerSec
+}
+
+func TestThrottleTimeMs(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	k.userInfoManager[addr.String()] = &userInfo{username: username}
+	records := []*codec.Record{{Key: []byte("key"), Value: make([]byte, 997)}, {Value: make([]byte, 1000)}}
+	k.recordProduceBytes(k.userInfoManager[addr.String()], records)
+	assert.Equal(t, 0, k.ThrottleTimeMs(&addr, codec.Produce))
+
+	k.server = quotaServer{produceBytesPerSec: 1000, fetchBytesPerSec: 2000}
+	k.recordProduceBytes(k.userInfoManager[addr.String()], records)
+	throttle := k.ThrottleTimeMs(&addr, codec.Produce)
+	assert.Greater(t, throttle, 900)
+	assert.LessOrEqual(t, throttle, 1000)
+	assert.Equal(t, 0, k.ThrottleTimeMs(&addr, codec.Fetch))
+
+	k.recordFetchBytes(&addr, []*codec.FetchTopicResp{{
+		Topic: "topic",
+		PartitionRespList: []*codec.FetchPartitionResp{
+			{RecordBatch: &codec.RecordBatch{Records: records}},
+			{RecordBatch: &codec.RecordBatch{Records: records}},
+			{ErrorCode: codec.OFFSET_OUT_OF_RANGE},
+		},
+	}})
+	throttle = k.ThrottleTimeMs(&addr, codec.Fetch)
+	assert.Greater(t, throttle, 900)
+	assert.LessOrEqual(t, throttle, 1000)
+}
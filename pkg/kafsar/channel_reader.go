@@ -0,0 +1,133 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// channelReader prefetch the messages of the reader to the channel, so that fetches drain them without blocking on
+// Next and many readers can be drained by a single goroutine. the pulsar client ignores ReaderOptions.MessageChannel,
+// a goroutine calls Next instead. seeks stop the goroutine and drop the messages read before the seek
+type channelReader struct {
+	pulsar.Reader
+	channel chan pulsar.ReaderMessage
+	// mutex guard cancel, done and closed against concurrent seeks and close
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	closed bool
+}
+
+func newChannelReader(reader pulsar.Reader, channel chan pulsar.ReaderMessage) *channelReader {
+	c := &channelReader{Reader: reader, channel: channel}
+	c.start()
+	return c
+}
+
+func (c *channelReader) start() {
+	if c.closed {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go c.pump(ctx, c.done)
+}
+
+// pump read the messages to the channel until ctx is done, failed reads are retried after a backoff
+func (c *channelReader) pump(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	backoff := constant.ChannelReaderRetryBackoff
+	for {
+		message, err := c.Reader.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logrus.Warnf("prefetch message failed, retry after %s. topic: %s, err: %s", backoff, c.Reader.Topic(), err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > constant.ChannelReaderMaxRetryBackoff {
+				backoff = constant.ChannelReaderMaxRetryBackoff
+			}
+			continue
+		}
+		backoff = constant.ChannelReaderRetryBackoff
+		select {
+		case c.channel <- pulsar.ReaderMessage{Reader: c, Message: message}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop the goroutine and drain the channel, the caller must hold the mutex
+func (c *channelReader) stop() {
+	c.cancel()
+	<-c.done
+	for {
+		select {
+		case <-c.channel:
+		default:
+			return
+		}
+	}
+}
+
+func (c *channelReader) Next(ctx context.Context) (pulsar.Message, error) {
+	select {
+	case message := <-c.channel:
+		return message.Message, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *channelReader) Seek(messageId pulsar.MessageID) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stop()
+	defer c.start()
+	return c.Reader.Seek(messageId)
+}
+
+func (c *channelReader) SeekByTime(time time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stop()
+	defer c.start()
+	return c.Reader.SeekByTime(time)
+}
+
+func (c *channelReader) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.stop()
+	c.closed = true
+	c.Reader.Close()
+}
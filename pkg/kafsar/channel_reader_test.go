@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChannelReaderSeek(t *testing.T) {
+	messages := make([]pulsar.Message, 3)
+	for i := range messages {
+		messages[i] = &testMessage{id: &testMessageID{ledgerID: 1, entryID: int64(i)}}
+	}
+	inner := &testReader{messages: messages}
+	reader := newChannelReader(inner, make(chan pulsar.ReaderMessage, 2))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	message, err := reader.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, messages[0].ID(), message.ID())
+	// the messages prefetched before the seek are dropped
+	assert.Nil(t, reader.Seek(messages[0].ID()))
+	for i := range messages {
+		message, err = reader.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, messages[i].ID(), message.ID())
+	}
+	reader.Close()
+	assert.True(t, inner.closed)
+}
+
+// failingReader fail every read, e.g. the topic is deleted
+type failingReader struct {
+	testReader
+	reads int32
+}
+
+func (f *failingReader) Next(ctx context.Context) (pulsar.Message, error) {
+	atomic.AddInt32(&f.reads, 1)
+	return nil, errors.New("topic not found")
+}
+
+func TestChannelReaderBackoff(t *testing.T) {
+	inner := &failingReader{}
+	reader := newChannelReader(inner, make(chan pulsar.ReaderMessage, 2))
+	time.Sleep(500 * time.Millisecond)
+	reader.Close()
+	// retried after 100ms, 200ms, 400ms instead of spinning
+	reads := atomic.LoadInt32(&inner.reads)
+	assert.True(t, reads >= 2 && reads <= 4, "reads: %d", reads)
+}
+
+func TestFetchPartitionDrainChannel(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 100
+	config.MaxFetchRecord = 100
+	k := newPartitionsBroker(t, config, 1)
+	useChannelReaders(k, 0)
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: 0}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 5, len(resp.RecordBatch.Records))
+}
@@ -0,0 +1,136 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/google/uuid"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// chunkedRecord the chunks of a large record read so far
+type chunkedRecord struct {
+	total     int
+	nextIndex int
+	payload   []byte
+}
+
+func (b *Broker) maxMessageBytes() int {
+	if b.kafsarConfig.MaxMessageBytes > 0 {
+		return b.kafsarConfig.MaxMessageBytes
+	}
+	return constant.DefaultMaxMessageBytes
+}
+
+// chunkMessages split the message into messages of at most maxBytes payload, the message itself if it fits.
+// the chunks share the key so that they are routed to the same partition, the chunk id if the message has no key
+func chunkMessages(message *pulsar.ProducerMessage, maxBytes int) []*pulsar.ProducerMessage {
+	if len(message.Payload) <= maxBytes {
+		return []*pulsar.ProducerMessage{message}
+	}
+	id := uuid.NewString()
+	total := (len(message.Payload) + maxBytes - 1) / maxBytes
+	chunks := make([]*pulsar.ProducerMessage, total)
+	for i := range chunks {
+		end := (i + 1) * maxBytes
+		if end > len(message.Payload) {
+			end = len(message.Payload)
+		}
+		chunk := *message
+		chunk.Payload = message.Payload[i*maxBytes : end]
+		if chunk.Key == "" {
+			chunk.Key = id
+		}
+		chunk.Properties = make(map[string]string, len(message.Properties)+3)
+		for k, v := range message.Properties {
+			chunk.Properties[k] = v
+		}
+		chunk.Properties[constant.ChunkIdProperty] = id
+		chunk.Properties[constant.ChunkIndexProperty] = strconv.Itoa(i)
+		chunk.Properties[constant.ChunkTotalProperty] = strconv.Itoa(total)
+		chunks[i] = &chunk
+	}
+	return chunks
+}
+
+func isChunk(message pulsar.Message) bool {
+	_, exist := message.Properties()[constant.ChunkIdProperty]
+	return exist
+}
+
+// appendChunk add the chunk to the record being reassembled, the record value is returned with the last chunk.
+// the chunks of records of different producers may interleave, each record is reassembled by its chunk id.
+// a chunk out of order discards its partial record, e.g. after the reader seeks
+func (r *ReaderMetadata) appendChunk(message pulsar.Message) ([]byte, bool, error) {
+	properties := message.Properties()
+	id := properties[constant.ChunkIdProperty]
+	index, err := strconv.Atoi(properties[constant.ChunkIndexProperty])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid chunk index of chunk %s", id)
+	}
+	total, err := strconv.Atoi(properties[constant.ChunkTotalProperty])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid chunk total of chunk %s", id)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if index == 0 {
+		r.removeChunk(id)
+		r.addChunk(id, &chunkedRecord{total: total})
+	}
+	chunk, exist := r.chunks[id]
+	if !exist || chunk.nextIndex != index || chunk.total != total {
+		r.removeChunk(id)
+		return nil, false, errors.Errorf("chunk %d of %s out of order", index, id)
+	}
+	chunk.payload = append(chunk.payload, message.Payload()...)
+	chunk.nextIndex++
+	if chunk.nextIndex < total {
+		return nil, false, nil
+	}
+	r.removeChunk(id)
+	return chunk.payload, true, nil
+}
+
+// addChunk start reassembling a record, evict the oldest partial record beyond constant.MaxPendingChunkedRecords
+func (r *ReaderMetadata) addChunk(id string, chunk *chunkedRecord) {
+	if r.chunks == nil {
+		r.chunks = make(map[string]*chunkedRecord)
+	}
+	if len(r.chunkIds) >= constant.MaxPendingChunkedRecords {
+		delete(r.chunks, r.chunkIds[0])
+		r.chunkIds = r.chunkIds[1:]
+	}
+	r.chunks[id] = chunk
+	r.chunkIds = append(r.chunkIds, id)
+}
+
+func (r *ReaderMetadata) removeChunk(id string) {
+	if _, exist := r.chunks[id]; !exist {
+		return
+	}
+	delete(r.chunks, id)
+	for i, chunkId := range r.chunkIds {
+		if chunkId == id {
+			r.chunkIds = append(r.chunkIds[:i], r.chunkIds[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChunkMessages(t *testing.T) {
+	message := &pulsar.ProducerMessage{Payload: []byte("0123456789"), Properties: map[string]string{"k": "v"}}
+	assert.Equal(t, []*pulsar.ProducerMessage{message}, chunkMessages(message, 10))
+
+	chunks := chunkMessages(message, 4)
+	assert.Len(t, chunks, 3)
+	id := chunks[0].Properties[constant.ChunkIdProperty]
+	assert.NotEmpty(t, id)
+	payloads := []string{"0123", "4567", "89"}
+	for i, chunk := range chunks {
+		assert.Equal(t, payloads[i], string(chunk.Payload))
+		// routed together by the chunk id
+		assert.Equal(t, id, chunk.Key)
+		assert.Equal(t, id, chunk.Properties[constant.ChunkIdProperty])
+		assert.Equal(t, []string{"v", "3"}, []string{chunk.Properties["k"], chunk.Properties[constant.ChunkTotalProperty]})
+	}
+	assert.Equal(t, "2", chunks[2].Properties[constant.ChunkIndexProperty])
+	// the record itself is not modified
+	assert.Equal(t, map[string]string{"k": "v"}, message.Properties)
+
+	keyed := chunkMessages(&pulsar.ProducerMessage{Key: "key", Payload: []byte("0123456789")}, 4)
+	for _, chunk := range keyed {
+		assert.Equal(t, "key", chunk.Key)
+	}
+}
+
+func chunkTestMessages(chunks []*pulsar.ProducerMessage, firstEntry int64) []pulsar.Message {
+	messages := make([]pulsar.Message, len(chunks))
+	for i, chunk := range chunks {
+		messages[i] = &testMessage{
+			id:         &testMessageID{ledgerID: 1, entryID: firstEntry + int64(i)},
+			payload:    chunk.Payload,
+			properties: chunk.Properties,
+		}
+	}
+	return messages
+}
+
+func TestAppendChunkOutOfOrder(t *testing.T) {
+	readerMetadata := &ReaderMetadata{}
+	messages := chunkTestMessages(chunkMessages(&pulsar.ProducerMessage{Payload: []byte("0123456789")}, 4), 0)
+	_, complete, err := readerMetadata.appendChunk(messages[0])
+	assert.Nil(t, err)
+	assert.False(t, complete)
+	// the reader skipped a chunk
+	_, _, err = readerMetadata.appendChunk(messages[2])
+	assert.NotNil(t, err)
+	assert.Empty(t, readerMetadata.chunks)
+	_, _, err = readerMetadata.appendChunk(messages[1])
+	assert.NotNil(t, err)
+
+	for i, message := range messages {
+		payload, complete, err := readerMetadata.appendChunk(message)
+		assert.Nil(t, err)
+		assert.Equal(t, i == len(messages)-1, complete)
+		if complete {
+			assert.Equal(t, "0123456789", string(payload))
+		}
+	}
+}
+
+func TestAppendChunkInterleaved(t *testing.T) {
+	readerMetadata := &ReaderMetadata{}
+	first := chunkTestMessages(chunkMessages(&pulsar.ProducerMessage{Payload: []byte("0123456789")}, 4), 0)
+	second := chunkTestMessages(chunkMessages(&pulsar.ProducerMessage{Payload: []byte("abcdefgh")}, 4), 10)
+	// two producers send large records to the partition at the same time
+	interleaved := []pulsar.Message{first[0], second[0], first[1], second[1], first[2]}
+	var values []string
+	for _, message := range interleaved {
+		payload, complete, err := readerMetadata.appendChunk(message)
+		assert.Nil(t, err)
+		if complete {
+			values = append(values, string(payload))
+		}
+	}
+	assert.Equal(t, []string{"abcdefgh", "0123456789"}, values)
+	assert.Empty(t, readerMetadata.chunks)
+	assert.Empty(t, readerMetadata.chunkIds)
+}
+
+func TestAppendChunkEvictOldest(t *testing.T) {
+	readerMetadata := &ReaderMetadata{}
+	var records [][]pulsar.Message
+	for i := 0; i <= constant.MaxPendingChunkedRecords; i++ {
+		messages := chunkTestMessages(chunkMessages(&pulsar.ProducerMessage{Payload: []byte("01234567")}, 4), int64(i*2))
+		_, complete, err := readerMetadata.appendChunk(messages[0])
+		assert.Nil(t, err)
+		assert.False(t, complete)
+		records = append(records, messages)
+	}
+	assert.Len(t, readerMetadata.chunks, constant.MaxPendingChunkedRecords)
+	// the first record is evicted, the others are still reassembled
+	_, _, err := readerMetadata.appendChunk(records[0][1])
+	assert.NotNil(t, err)
+	payload, complete, err := readerMetadata.appendChunk(records[1][1])
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, "01234567", string(payload))
+}
+
+// chunkProducer keep the sent messages
+type chunkProducer struct {
+	stalledProducer
+	messages []*pulsar.ProducerMessage
+}
+
+func (p *chunkProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	p.messages = append(p.messages, message)
+	callback(&testMessageID{ledgerID: 1, entryID: int64(len(p.messages) - 1)}, message, nil)
+}
+
+func TestProduceFetchChunkedRecord(t *testing.T) {
+	config := kafsarConfig
+	config.ChunkLargeMessage = true
+	config.MaxMessageBytes = 4
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	producer := &chunkProducer{}
+	k.producerManager[addr.String()] = producer
+	resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId: constant.NoProducerId,
+			Records:    []*codec.Record{{Value: []byte("sma")}, {Value: []byte("0123456789")}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Len(t, producer.messages, 4)
+	assert.Equal(t, 0, k.pendingProduce.count(addr.String()))
+
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := chunkTestMessages(producer.messages, 0)
+	for _, message := range messages {
+		message.(*testMessage).topic = partitionedTopic
+	}
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+	fetchResp := k.FetchPartition(&addr, "topic", clientId, &codec.FetchPartitionReq{PartitionId: partition}, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, fetchResp.ErrorCode)
+	assert.Len(t, fetchResp.RecordBatch.Records, 2)
+	assert.Equal(t, "sma", string(fetchResp.RecordBatch.Records[0].Value))
+	assert.Equal(t, "0123456789", string(fetchResp.RecordBatch.Records[1].Value))
+	// the reassembled record is committed by the message id of its last chunk
+	assert.Len(t, readerMetadata.messageIds, 2)
+	assert.Equal(t, messages[3].ID(), readerMetadata.messageIds[1].MessageId)
+}
+
+func TestProduceChunkSequenceIds(t *testing.T) {
+	config := kafsarConfig
+	config.ChunkLargeMessage = true
+	config.MaxMessageBytes = 4
+	k := newTestBroker(config)
+	messages := k.outgoingMessages(&codec.RecordBatch{
+		BaseSequence: 5,
+		Records:      []*codec.Record{{Key: []byte("k"), Value: []byte("0123456789")}, {Value: []byte("a")}},
+	}, []int64{0, 0}, nil, true)
+	sequences := make([]int64, len(messages))
+	for i, message := range messages {
+		sequences[i] = *message.message.SequenceID
+	}
+	assert.Equal(t, []int64{5 << 16, 5<<16 + 1, 5<<16 + 2, 6 << 16}, sequences)
+	assert.Equal(t, []int32{0, 0, 0, 1}, []int32{messages[0].recordIndex, messages[1].recordIndex, messages[2].recordIndex, messages[3].recordIndex})
+	assert.Equal(t, 1+10+1, outgoingBytes(messages))
+}
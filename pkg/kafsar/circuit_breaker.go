@@ -0,0 +1,81 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// circuitBreaker fail fast for a cooldown after threshold consecutive failures, so that clients retrying against
+// an unhealthy pulsar do not pile up slow creations. after the cooldown a single attempt decides whether to close
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	now       func() time.Time
+}
+
+// newCircuitBreaker threshold 0 means never open
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func newCreationBreaker(config KafsarConfig) *circuitBreaker {
+	cooldown := constant.DefaultCreationCooldown
+	if config.CreationCooldownMs > 0 {
+		cooldown = time.Duration(config.CreationCooldownMs) * time.Millisecond
+	}
+	return newCircuitBreaker(config.CreationFailureThreshold, cooldown)
+}
+
+// allow return errCircuitOpen during the cooldown
+func (c *circuitBreaker) allow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.threshold <= 0 || c.failures < c.threshold {
+		return nil
+	}
+	now := c.now()
+	if now.Before(c.openUntil) {
+		return errCircuitOpen
+	}
+	// half open, let this attempt through and fail fast the others until it reports
+	c.openUntil = now.Add(c.cooldown)
+	return nil
+}
+
+func (c *circuitBreaker) report(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err == nil {
+		c.failures = 0
+		c.openUntil = time.Time{}
+		return
+	}
+	c.failures++
+	if c.threshold > 0 && c.failures >= c.threshold {
+		c.openUntil = c.now().Add(c.cooldown)
+	}
+}
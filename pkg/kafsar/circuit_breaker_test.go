@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"errors"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(2, time.Second)
+	breaker.now = func() time.Time { return now }
+	failure := errors.New("pulsar unavailable")
+
+	assert.Nil(t, breaker.allow())
+	breaker.report(failure)
+	assert.Nil(t, breaker.allow())
+	breaker.report(failure)
+	assert.ErrorIs(t, breaker.allow(), errCircuitOpen)
+
+	// half open after the cooldown, only a single attempt goes through
+	now = now.Add(time.Second)
+	assert.Nil(t, breaker.allow())
+	assert.ErrorIs(t, breaker.allow(), errCircuitOpen)
+	breaker.report(nil)
+	assert.Nil(t, breaker.allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		breaker.report(errors.New("pulsar unavailable"))
+		assert.Nil(t, breaker.allow())
+	}
+}
+
+type failingProducerClient struct {
+	pulsar.Client
+	creations int32
+}
+
+func (c *failingProducerClient) CreateProducer(options pulsar.ProducerOptions) (pulsar.Producer, error) {
+	atomic.AddInt32(&c.creations, 1)
+	return nil, errors.New("pulsar unavailable")
+}
+
+func TestProduceCircuitOpen(t *testing.T) {
+	config := kafsarConfig
+	config.CreationFailureThreshold = 2
+	config.CreationCooldownMs = 60000
+	k := newTestBroker(config)
+	client := &failingProducerClient{}
+	k.pulsarCommonClient = client
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId: constant.NoProducerId,
+			Records:    []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 0, req)
+		assert.Nil(t, err)
+		assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, resp.ErrorCode)
+	}
+	for i := 0; i < 5; i++ {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 0, req)
+		assert.Nil(t, err)
+		assert.Equal(t, codec.LEADER_NOT_AVAILABLE, resp.ErrorCode)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&client.creations))
+}
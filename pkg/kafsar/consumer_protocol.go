@@ -0,0 +1,90 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+)
+
+var errMalformedAssignment = errors.New("malformed consumer protocol assignment")
+
+// decodeAssignment parse the partitions of a consumer protocol assignment, the user data is ignored.
+// nil or empty data is an empty assignment
+func decodeAssignment(data []byte) (map[string][]int32, error) {
+	partitions := make(map[string][]int32)
+	if len(data) == 0 {
+		return partitions, nil
+	}
+	// skip the version
+	idx := 2
+	topicCount, idx, err := readAssignmentInt32(data, idx)
+	if err != nil {
+		return nil, err
+	}
+	for i := int32(0); i < topicCount; i++ {
+		if idx+2 > len(data) {
+			return nil, errMalformedAssignment
+		}
+		topicLen := int(binary.BigEndian.Uint16(data[idx:]))
+		idx += 2
+		if idx+topicLen > len(data) {
+			return nil, errMalformedAssignment
+		}
+		topic := string(data[idx : idx+topicLen])
+		idx += topicLen
+		var partitionCount int32
+		partitionCount, idx, err = readAssignmentInt32(data, idx)
+		if err != nil {
+			return nil, err
+		}
+		for j := int32(0); j < partitionCount; j++ {
+			var partition int32
+			partition, idx, err = readAssignmentInt32(data, idx)
+			if err != nil {
+				return nil, err
+			}
+			partitions[topic] = append(partitions[topic], partition)
+		}
+	}
+	return partitions, nil
+}
+
+func readAssignmentInt32(data []byte, idx int) (int32, int, error) {
+	if idx+4 > len(data) {
+		return 0, idx, errMalformedAssignment
+	}
+	return int32(binary.BigEndian.Uint32(data[idx:])), idx + 4, nil
+}
+
+// revokedPartitions the partitions of the previous assignment which are not in the current one
+func revokedPartitions(previous, current map[string][]int32) map[string][]int32 {
+	revoked := make(map[string][]int32)
+	for topic, partitions := range previous {
+		kept := make(map[int32]bool, len(current[topic]))
+		for _, partition := range current[topic] {
+			kept[partition] = true
+		}
+		for _, partition := range partitions {
+			if !kept[partition] {
+				revoked[topic] = append(revoked[topic], partition)
+			}
+		}
+	}
+	return revoked
+}
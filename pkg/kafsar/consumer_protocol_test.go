@@ -0,0 +1,69 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// encodeAssignment a consumer protocol assignment of version 1 without user data
+func encodeAssignment(partitions map[string][]int32) []byte {
+	topics := make([]string, 0, len(partitions))
+	for topic := range partitions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, int16(1))
+	_ = binary.Write(buf, binary.BigEndian, int32(len(topics)))
+	for _, topic := range topics {
+		_ = binary.Write(buf, binary.BigEndian, int16(len(topic)))
+		buf.WriteString(topic)
+		_ = binary.Write(buf, binary.BigEndian, int32(len(partitions[topic])))
+		_ = binary.Write(buf, binary.BigEndian, partitions[topic])
+	}
+	_ = binary.Write(buf, binary.BigEndian, int32(-1))
+	return buf.Bytes()
+}
+
+func TestDecodeAssignment(t *testing.T) {
+	partitions := map[string][]int32{"topic-a": {0, 2}, "topic-b": {1}}
+	decoded, err := decodeAssignment(encodeAssignment(partitions))
+	assert.Nil(t, err)
+	assert.Equal(t, partitions, decoded)
+
+	decoded, err = decodeAssignment(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, decoded)
+
+	data := encodeAssignment(partitions)
+	_, err = decodeAssignment(data[:len(data)-10])
+	assert.ErrorIs(t, err, errMalformedAssignment)
+}
+
+func TestRevokedPartitions(t *testing.T) {
+	previous := map[string][]int32{"topic-a": {0, 1, 2}, "topic-b": {0}}
+	current := map[string][]int32{"topic-a": {1, 3}}
+	assert.Equal(t, map[string][]int32{"topic-a": {0, 2}, "topic-b": {0}}, revokedPartitions(previous, current))
+	assert.Empty(t, revokedPartitions(current, map[string][]int32{"topic-a": {1, 3}}))
+	assert.Empty(t, revokedPartitions(map[string][]int32{}, current))
+}
@@ -0,0 +1,92 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+)
+
+// DeleteGroups delete the groups without members and purge their committed offsets
+func (b *Broker) DeleteGroups(addr net.Addr, groupIds []string) ([]*DeleteGroupResult, error) {
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if !exist {
+		b.logger.Errorf("delete groups failed when get userinfo by addr %s, groups: %v", addr.String(), groupIds)
+		results := make([]*DeleteGroupResult, len(groupIds))
+		for i, groupId := range groupIds {
+			results[i] = &DeleteGroupResult{GroupId: groupId, ErrorCode: codec.UNKNOWN_SERVER_ERROR}
+		}
+		return results, nil
+	}
+	results := make([]*DeleteGroupResult, len(groupIds))
+	authorized := make([]string, 0, len(groupIds))
+	for i, groupId := range groupIds {
+		code := b.authorize(user.principal(), OperationDelete, Resource{Type: ResourceGroup, Name: groupId})
+		if code != codec.NONE {
+			b.logger.Warnf("delete group %s denied. username: %s", groupId, user.username)
+			results[i] = &DeleteGroupResult{GroupId: groupId, ErrorCode: code}
+			continue
+		}
+		authorized = append(authorized, groupId)
+	}
+	// the partitions are gone with the group, remember them to find the offsets
+	groupTopics := make(map[string][]string)
+	for _, groupId := range authorized {
+		group, err := b.groupCoordinator.GetGroup(user.username, groupId)
+		if err == nil {
+			groupTopics[groupId] = group.subscribedTopics()
+		}
+	}
+	deleted, err := b.groupCoordinator.DeleteGroups(user.username, authorized)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range deleted {
+		if result.ErrorCode == codec.NONE {
+			b.purgeGroup(user.username, result.GroupId, groupTopics[result.GroupId])
+		}
+	}
+	// the coordinator answers the authorized groups in order
+	for i := range results {
+		if results[i] == nil {
+			results[i] = deleted[0]
+			deleted = deleted[1:]
+		}
+	}
+	return results, nil
+}
+
+func (b *Broker) purgeGroup(username, groupId string, partitionedTopics []string) {
+	for _, partitionedTopic := range partitionedTopics {
+		b.mutex.Lock()
+		partition, exist := b.kafkaPartitions[username+partitionedTopic]
+		if b.topicGroupManager[username+partitionedTopic] == groupId {
+			delete(b.topicGroupManager, username+partitionedTopic)
+		}
+		b.mutex.Unlock()
+		if !exist {
+			b.logger.Warnf("kafka partition of %s not found, offset of group %s is not purged", partitionedTopic, groupId)
+			continue
+		}
+		if !b.offsetManager.RemoveOffset(username, partition.topic, groupId, partition.partition) {
+			b.logger.Errorf("purge offset of group %s failed. topic: %s, partition: %d", groupId, partition.topic, partition.partition)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeleteGroupsPurgeOffsets(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	k.offsetManager = NewOffsetManagerMemory()
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	resp, err := groupCoordinator.HandleJoinGroup(username, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.kafkaPartitions[username+partitionedTopic] = kafkaPartition{topic: "topic", partition: partition}
+	k.topicGroupManager[username+partitionedTopic] = groupId
+	group := groupCoordinator.groupManager[username+groupId]
+	group.addPartitionedTopic(partitionedTopic)
+	err = k.offsetManager.CommitOffset(username, "topic", groupId, partition, MessageIdPair{MessageId: &testMessageID{ledgerID: 1}, Offset: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := k.DeleteGroups(&addr, []string{groupId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NON_EMPTY_GROUP, results[0].ErrorCode)
+	_, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+
+	_, err = groupCoordinator.HandleLeaveGroup(username, groupId, []*codec.LeaveGroupMember{{MemberId: resp.MemberId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = k.DeleteGroups(&addr, []string{groupId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	_, exist = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+	_, exist = k.topicGroupManager[username+partitionedTopic]
+	assert.False(t, exist)
+}
+
+func TestDeleteGroupsAfterLastMemberLeft(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	config.InitialDelayedJoinMs = 0
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 2)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	k.pulsarClientManage = map[string]pulsar.Client{
+		readerKey(username, partitionedTopic, clientId): &producedMessageClient{messages: messages},
+	}
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+	offsetFetchResp, err := k.OffsetFetch(&addr, "topic", clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, offsetFetchResp.ErrorCode)
+	fetchResp := k.FetchPartition(&addr, "topic", clientId, &codec.FetchPartitionReq{PartitionId: partition}, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, 2, len(fetchResp.RecordBatch.Records))
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: fetchResp.RecordBatch.Offset})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+
+	_, err = k.GroupLeave(&addr, &codec.LeaveGroupReq{
+		BaseReq: codec.BaseReq{ClientId: clientId},
+		GroupId: groupId,
+		Members: []*codec.LeaveGroupMember{{MemberId: joinGroupResp.MemberId}},
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, k.readerManager)
+	assert.Empty(t, k.topicGroupManager)
+	_, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+
+	results, err := k.DeleteGroups(&addr, []string{groupId})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	_, exist = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+}
@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+	"sort"
+)
+
+// ClusterNode a kafsar node advertised to kafka clients
+type ClusterNode struct {
+	NodeId int32
+	Host   string
+	Port   int
+}
+
+type DescribeClusterResult struct {
+	ErrorCode    codec.ErrorCode
+	ClusterId    string
+	ControllerId int32
+	Brokers      []*ClusterNode
+}
+
+// DescribeCluster a standalone broker is the sole broker and the controller. in cluster mode all the ClusterNodes
+// are returned and the one with the lowest node id is the controller, so that every node answers the same
+func (b *Broker) DescribeCluster(addr net.Addr) (*DescribeClusterResult, error) {
+	b.mutex.RLock()
+	_, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if !exist {
+		b.logger.Errorf("describe cluster failed when get userinfo by addr %s", addr.String())
+		return &DescribeClusterResult{ErrorCode: codec.UNKNOWN_SERVER_ERROR}, nil
+	}
+	brokers := b.clusterNodes()
+	return &DescribeClusterResult{
+		ErrorCode:    codec.NONE,
+		ClusterId:    b.kafsarConfig.ClusterId,
+		ControllerId: brokers[0].NodeId,
+		Brokers:      brokers,
+	}, nil
+}
+
+// clusterNodes sorted by node id, always include this node
+func (b *Broker) clusterNodes() []*ClusterNode {
+	self := &ClusterNode{NodeId: b.kafsarConfig.NodeId, Host: b.kafsarConfig.AdvertiseHost, Port: b.kafsarConfig.AdvertisePort}
+	nodes := []*ClusterNode{self}
+	if b.kafsarConfig.GroupCoordinatorType != Cluster {
+		return nodes
+	}
+	for i := range b.kafsarConfig.ClusterNodes {
+		node := b.kafsarConfig.ClusterNodes[i]
+		if node.NodeId == self.NodeId {
+			continue
+		}
+		nodes = append(nodes, &node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeId < nodes[j].NodeId
+	})
+	return nodes
+}
@@ -0,0 +1,60 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDescribeClusterStandalone(t *testing.T) {
+	k := newTestBroker(KafsarConfig{ClusterId: "cluster", NodeId: 1, AdvertiseHost: "kafsar-1", AdvertisePort: 9092})
+	result, err := k.DescribeCluster(&addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, result.ErrorCode)
+	assert.Equal(t, "cluster", result.ClusterId)
+	assert.Equal(t, int32(1), result.ControllerId)
+	assert.Equal(t, []*ClusterNode{{NodeId: 1, Host: "kafsar-1", Port: 9092}}, result.Brokers)
+}
+
+func TestDescribeClusterNodes(t *testing.T) {
+	k := newTestBroker(KafsarConfig{
+		ClusterId:            "cluster",
+		NodeId:               2,
+		AdvertiseHost:        "kafsar-2",
+		AdvertisePort:        9092,
+		GroupCoordinatorType: Cluster,
+		ClusterNodes: []ClusterNode{
+			{NodeId: 3, Host: "kafsar-3", Port: 9092},
+			{NodeId: 1, Host: "kafsar-1", Port: 9092},
+		},
+	})
+	result, err := k.DescribeCluster(&addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, result.ErrorCode)
+	assert.Equal(t, int32(1), result.ControllerId)
+	assert.Equal(t, 3, len(result.Brokers))
+	assert.Equal(t, "kafsar-1", result.Brokers[0].Host)
+	assert.Equal(t, "kafsar-2", result.Brokers[1].Host)
+	assert.Equal(t, "kafsar-3", result.Brokers[2].Host)
+}
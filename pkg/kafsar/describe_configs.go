@@ -0,0 +1,181 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/model"
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+	"strconv"
+)
+
+// ConfigResourceType same as kafka config resource type
+type ConfigResourceType int8
+
+const (
+	ConfigResourceTopic  ConfigResourceType = 2
+	ConfigResourceBroker ConfigResourceType = 4
+)
+
+const (
+	ConfigRetentionMs                = "retention.ms"
+	ConfigRetentionBytes             = "retention.bytes"
+	ConfigNumPartitions              = "num.partitions"
+	ConfigMaxConnections             = "max.connections"
+	ConfigGroupMaxSize               = "group.max.size"
+	ConfigGroupMinSessionTimeoutMs   = "group.min.session.timeout.ms"
+	ConfigGroupMaxSessionTimeoutMs   = "group.max.session.timeout.ms"
+	ConfigGroupInitialRebalanceDelay = "group.initial.rebalance.delay.ms"
+	ConfigFetchMaxWaitMs             = "fetch.max.wait.ms"
+	ConfigFetchMaxRecords            = "fetch.max.records"
+)
+
+type DescribeConfigsResource struct {
+	ResourceType ConfigResourceType
+	ResourceName string
+	// ConfigNames return all configs if empty
+	ConfigNames []string
+}
+
+type DescribeConfigsResult struct {
+	ResourceType ConfigResourceType
+	ResourceName string
+	ErrorCode    codec.ErrorCode
+	Configs      []*ConfigEntry
+}
+
+type ConfigEntry struct {
+	Name     string
+	Value    string
+	ReadOnly bool
+}
+
+// DescribeConfigs topic configs come from pulsar admin api, broker configs come from KafsarConfig
+func (b *Broker) DescribeConfigs(addr net.Addr, resources []*DescribeConfigsResource) ([]*DescribeConfigsResult, error) {
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	results := make([]*DescribeConfigsResult, len(resources))
+	for i, resource := range resources {
+		result := &DescribeConfigsResult{
+			ResourceType: resource.ResourceType,
+			ResourceName: resource.ResourceName,
+			ErrorCode:    codec.NONE,
+		}
+		results[i] = result
+		if !exist {
+			b.logger.Errorf("describe configs failed when get userinfo by addr %s, resource: %s", addr.String(), resource.ResourceName)
+			result.ErrorCode = codec.UNKNOWN_SERVER_ERROR
+			continue
+		}
+		var configs []*ConfigEntry
+		switch resource.ResourceType {
+		case ConfigResourceTopic:
+			result.ErrorCode = b.authorize(user.principal(), OperationDescribeConfigs, Resource{Type: ResourceTopic, Name: resource.ResourceName})
+			if result.ErrorCode != codec.NONE {
+				b.logger.Warnf("describe configs of topic %s denied. username: %s", resource.ResourceName, user.username)
+				continue
+			}
+			configs, result.ErrorCode = b.describeTopicConfigs(user, resource.ResourceName)
+		case ConfigResourceBroker:
+			result.ErrorCode = b.authorize(user.principal(), OperationDescribeConfigs, Resource{Type: ResourceCluster, Name: resource.ResourceName})
+			if result.ErrorCode != codec.NONE {
+				b.logger.Warnf("describe configs of broker %s denied. username: %s", resource.ResourceName, user.username)
+				continue
+			}
+			if resource.ResourceName != strconv.Itoa(int(b.kafsarConfig.NodeId)) {
+				b.logger.Errorf("describe configs failed, broker %s is not this node", resource.ResourceName)
+				result.ErrorCode = codec.INVALID_REQUEST
+				continue
+			}
+			configs = b.describeBrokerConfigs()
+		default:
+			b.logger.Errorf("describe configs failed, unsupported resource type %d", resource.ResourceType)
+			result.ErrorCode = codec.INVALID_REQUEST
+		}
+		result.Configs = filterConfigs(configs, resource.ConfigNames)
+	}
+	return results, nil
+}
+
+func (b *Broker) describeTopicConfigs(user *userInfo, kafkaTopic string) ([]*ConfigEntry, codec.ErrorCode) {
+	pulsarTopic, err := b.pulsarTopic(user.username, kafkaTopic)
+	if err != nil {
+		b.logger.Errorf("get pulsar topic failed. username: %s, topic: %s, err: %s", user.username, kafkaTopic, err)
+		return nil, codec.UNKNOWN_TOPIC_OR_PARTITION
+	}
+	partitionNum, err := b.partitionNum(user.username, kafkaTopic)
+	if err != nil {
+		b.logger.Errorf("get partition num failed. username: %s, topic: %s, err: %s", user.username, kafkaTopic, err)
+		return nil, codec.UNKNOWN_TOPIC_OR_PARTITION
+	}
+	retention, err := utils.GetTopicRetention(pulsarTopic, b.getPulsarHttpUrl())
+	if err != nil {
+		b.logger.Errorf("get topic retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return nil, codec.UNKNOWN_SERVER_ERROR
+	}
+	configs := retentionConfigs(retention)
+	configs = append(configs, &ConfigEntry{Name: ConfigNumPartitions, Value: strconv.Itoa(partitionNum), ReadOnly: true})
+	return configs, codec.NONE
+}
+
+// retentionConfigs convert pulsar retention to kafka configs, negative means infinite in both
+func retentionConfigs(retention *model.RetentionPolicies) []*ConfigEntry {
+	retentionMs := int64(retention.RetentionTimeInMinutes) * 60 * 1000
+	if retention.RetentionTimeInMinutes < 0 {
+		retentionMs = -1
+	}
+	retentionBytes := retention.RetentionSizeInMB * 1024 * 1024
+	if retention.RetentionSizeInMB < 0 {
+		retentionBytes = -1
+	}
+	return []*ConfigEntry{
+		{Name: ConfigRetentionMs, Value: strconv.FormatInt(retentionMs, 10)},
+		{Name: ConfigRetentionBytes, Value: strconv.FormatInt(retentionBytes, 10)},
+	}
+}
+
+// describeBrokerConfigs KafsarConfig is static, all broker configs are read only
+func (b *Broker) describeBrokerConfigs() []*ConfigEntry {
+	return []*ConfigEntry{
+		{Name: ConfigMaxConnections, Value: strconv.Itoa(int(b.kafsarConfig.MaxConn)), ReadOnly: true},
+		{Name: ConfigGroupMaxSize, Value: strconv.Itoa(b.kafsarConfig.MaxConsumersPerGroup), ReadOnly: true},
+		{Name: ConfigGroupMinSessionTimeoutMs, Value: strconv.Itoa(b.kafsarConfig.GroupMinSessionTimeoutMs), ReadOnly: true},
+		{Name: ConfigGroupMaxSessionTimeoutMs, Value: strconv.Itoa(b.kafsarConfig.GroupMaxSessionTimeoutMs), ReadOnly: true},
+		{Name: ConfigGroupInitialRebalanceDelay, Value: strconv.Itoa(b.kafsarConfig.InitialDelayedJoinMs), ReadOnly: true},
+		{Name: ConfigFetchMaxWaitMs, Value: strconv.Itoa(b.kafsarConfig.MaxFetchWaitMs), ReadOnly: true},
+		{Name: ConfigFetchMaxRecords, Value: strconv.Itoa(b.kafsarConfig.MaxFetchRecord), ReadOnly: true},
+	}
+}
+
+func filterConfigs(configs []*ConfigEntry, configNames []string) []*ConfigEntry {
+	if len(configNames) == 0 {
+		return configs
+	}
+	filtered := make([]*ConfigEntry, 0, len(configNames))
+	for _, config := range configs {
+		for _, name := range configNames {
+			if config.Name == name {
+				filtered = append(filtered, config)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,58 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/model"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDescribeBrokerConfigs(t *testing.T) {
+	k := newTestBroker(KafsarConfig{NodeId: 1, MaxConsumersPerGroup: 10, MaxFetchWaitMs: 500})
+	results, err := k.DescribeConfigs(&addr, []*DescribeConfigsResource{
+		{ResourceType: ConfigResourceBroker, ResourceName: "1", ConfigNames: []string{ConfigGroupMaxSize, ConfigFetchMaxWaitMs}},
+		{ResourceType: ConfigResourceBroker, ResourceName: "2"},
+		{ResourceType: ConfigResourceType(1), ResourceName: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	assert.Equal(t, 2, len(results[0].Configs))
+	assert.Equal(t, ConfigGroupMaxSize, results[0].Configs[0].Name)
+	assert.Equal(t, "10", results[0].Configs[0].Value)
+	assert.True(t, results[0].Configs[0].ReadOnly)
+	assert.Equal(t, "500", results[0].Configs[1].Value)
+	assert.Equal(t, codec.INVALID_REQUEST, results[1].ErrorCode)
+	assert.Equal(t, codec.INVALID_REQUEST, results[2].ErrorCode)
+}
+
+func TestRetentionConfigs(t *testing.T) {
+	configs := retentionConfigs(&model.RetentionPolicies{RetentionTimeInMinutes: 10, RetentionSizeInMB: 2})
+	assert.Equal(t, ConfigRetentionMs, configs[0].Name)
+	assert.Equal(t, "600000", configs[0].Value)
+	assert.Equal(t, ConfigRetentionBytes, configs[1].Name)
+	assert.Equal(t, "2097152", configs[1].Value)
+
+	configs = retentionConfigs(&model.RetentionPolicies{RetentionTimeInMinutes: -1, RetentionSizeInMB: -1})
+	assert.Equal(t, "-1", configs[0].Value)
+	assert.Equal(t, "-1", configs[1].Value)
+}
@@ -0,0 +1,130 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"sync"
+	"time"
+)
+
+// failoverReader back the reader of a group partition by a pulsar consumer with failover subscription,
+// pulsar keeps exactly one member of the group reading the partition and fails over automatically
+type failoverReader struct {
+	topic    string
+	consumer pulsar.Consumer
+	// mutex guard skipId, Seek is called by other goroutines than the one calling Next
+	mutex sync.Mutex
+	// skipId the consumer redeliver the message it seek to, which is already committed
+	skipId pulsar.MessageID
+}
+
+func (f *failoverReader) Topic() string {
+	return f.topic
+}
+
+func (f *failoverReader) Next(ctx context.Context) (pulsar.Message, error) {
+	for {
+		message, err := f.consumer.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if f.skip(message.ID()) {
+			continue
+		}
+		return message, nil
+	}
+}
+
+// skip whether the message is the one the consumer seek to, only the first message received after the seek is checked
+func (f *failoverReader) skip(messageId pulsar.MessageID) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	skip := f.skipId != nil && sameMessageId(messageId, f.skipId)
+	f.skipId = nil
+	return skip
+}
+
+func (f *failoverReader) HasNext() bool {
+	return true
+}
+
+func (f *failoverReader) Close() {
+	f.consumer.Close()
+}
+
+func (f *failoverReader) Seek(messageId pulsar.MessageID) error {
+	f.mutex.Lock()
+	f.skipId = messageId
+	f.mutex.Unlock()
+	return f.consumer.Seek(messageId)
+}
+
+func (f *failoverReader) SeekByTime(time time.Time) error {
+	f.mutex.Lock()
+	f.skipId = nil
+	f.mutex.Unlock()
+	return f.consumer.SeekByTime(time)
+}
+
+// ack acknowledge the committed messages, so that the member taking over the partition starts after them
+func (f *failoverReader) ack(messageIds []MessageIdPair) {
+	for _, pair := range messageIds {
+		f.consumer.AckID(pair.MessageId)
+	}
+}
+
+func sameMessageId(a, b pulsar.MessageID) bool {
+	return a.LedgerID() == b.LedgerID() && a.EntryID() == b.EntryID() && a.BatchIdx() == b.BatchIdx()
+}
+
+func (b *Broker) isFailoverGroup(groupId string) bool {
+	for _, group := range b.kafsarConfig.FailoverSubscriptionGroups {
+		if group == groupId {
+			return true
+		}
+	}
+	return false
+}
+
+// createFailoverReader the caller must not hold the broker mutex, readerClient locks it
+func (b *Broker) createFailoverReader(username, partitionedTopic, subscriptionName string, messageId pulsar.MessageID, clientId string) (pulsar.Reader, error) {
+	client, err := b.readerClient(username, partitionedTopic, clientId)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := client.Subscribe(pulsar.ConsumerOptions{
+		Topic:                       partitionedTopic,
+		SubscriptionName:            subscriptionName,
+		Type:                        pulsar.Failover,
+		SubscriptionInitialPosition: pulsar.SubscriptionPositionEarliest,
+		ReceiverQueueSize:           b.kafsarConfig.ConsumerReceiveQueueSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	reader := &failoverReader{topic: partitionedTopic, consumer: consumer}
+	if !sameMessageId(messageId, pulsar.EarliestMessageID()) {
+		if err := reader.Seek(messageId); err != nil {
+			consumer.Close()
+			return nil, err
+		}
+	}
+	return reader, nil
+}
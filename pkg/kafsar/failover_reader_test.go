@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testConsumer struct {
+	pulsar.Consumer
+	reader  *testReader
+	options pulsar.ConsumerOptions
+	acked   []pulsar.MessageID
+}
+
+func (c *testConsumer) Receive(ctx context.Context) (pulsar.Message, error) {
+	return c.reader.Next(ctx)
+}
+
+func (c *testConsumer) Seek(id pulsar.MessageID) error {
+	return c.reader.Seek(id)
+}
+
+func (c *testConsumer) AckID(id pulsar.MessageID) {
+	c.acked = append(c.acked, id)
+}
+
+type subscribeClient struct {
+	pulsar.Client
+	consumer *testConsumer
+}
+
+func (c *subscribeClient) Subscribe(options pulsar.ConsumerOptions) (pulsar.Consumer, error) {
+	c.consumer.options = options
+	return c.consumer, nil
+}
+
+func TestFailoverReader(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	config.FailoverSubscriptionGroups = []string{groupId}
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	assert.True(t, k.isFailoverGroup(groupId))
+	assert.False(t, k.isFailoverGroup("other-group"))
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 4)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(testContent),
+		}
+	}
+	consumer := &testConsumer{reader: &testReader{messages: messages}}
+	k.pulsarClientManage = map[string]pulsar.Client{
+		readerKey(username, partitionedTopic, clientId): &subscribeClient{consumer: consumer},
+	}
+
+	// start after the committed second message
+	err = k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, messages[1].ID(), constant.UnknownOffset, clientId)
+	assert.Nil(t, err)
+	assert.Equal(t, pulsar.Failover, consumer.options.Type)
+	assert.Equal(t, "subscription", consumer.options.SubscriptionName)
+	readerMetadata := k.readerManager[readerKey(username, partitionedTopic, clientId)]
+	_, ok := readerMetadata.reader.(*failoverReader)
+	assert.True(t, ok)
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	assert.Equal(t, 2, len(readerMetadata.messageIds))
+	assert.Equal(t, messages[2].ID(), readerMetadata.messageIds[0].MessageId)
+
+	// commit acknowledge the committed messages on the subscription
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: resp.RecordBatch.Offset})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	assert.Equal(t, []pulsar.MessageID{messages[2].ID()}, consumer.acked)
+}
+
+// channelConsumer receive the messages sent to the channel, seeking does not move it
+type channelConsumer struct {
+	pulsar.Consumer
+	messages chan pulsar.Message
+}
+
+func (c *channelConsumer) Receive(ctx context.Context) (pulsar.Message, error) {
+	select {
+	case message := <-c.messages:
+		return message, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *channelConsumer) Seek(id pulsar.MessageID) error {
+	return nil
+}
+
+func TestFailoverReaderConcurrentSeek(t *testing.T) {
+	consumer := &channelConsumer{messages: make(chan pulsar.Message, 2)}
+	reader := &failoverReader{topic: "topic", consumer: consumer}
+	seekId := &testMessageID{ledgerID: 1, entryID: 0}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = reader.Seek(seekId)
+		}
+	}()
+	for i := 1; i <= 100; i++ {
+		consumer.messages <- &testMessage{id: &testMessageID{ledgerID: 1, entryID: int64(i)}}
+		message, err := reader.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), message.ID().EntryID())
+	}
+	<-done
+
+	// the consumer redeliver the message it seek to
+	assert.Nil(t, reader.Seek(seekId))
+	consumer.messages <- &testMessage{id: seekId}
+	consumer.messages <- &testMessage{id: &testMessageID{ledgerID: 1, entryID: 1}}
+	message, err := reader.Next(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), message.ID().EntryID())
+}
@@ -0,0 +1,53 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"sync/atomic"
+)
+
+// fetchBudget the max bytes of a fetch request shared by its partition fetches, which may run concurrently.
+// like kafka, the record crossing the budget is still returned, so a fetch always makes progress
+type fetchBudget struct {
+	limit int64
+	used  int64
+}
+
+func newFetchBudget(maxBytes int) *fetchBudget {
+	return &fetchBudget{limit: int64(maxBytes)}
+}
+
+// consume take the bytes of a fetched record, return whether the budget has bytes left
+func (f *fetchBudget) consume(bytes int) bool {
+	return atomic.AddInt64(&f.used, int64(bytes)) < f.limit
+}
+
+// exhausted the records fetched by the partitions reached the budget
+func (f *fetchBudget) exhausted() bool {
+	used := atomic.LoadInt64(&f.used)
+	return used > 0 && used >= f.limit
+}
+
+// available the bytes left, the fetch quota is reserved for at most them
+func (f *fetchBudget) available() int {
+	available := f.limit - atomic.LoadInt64(&f.used)
+	if available < 0 {
+		return 0
+	}
+	return int(available)
+}
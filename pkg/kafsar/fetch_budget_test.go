@@ -0,0 +1,133 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestFetchBudget(t *testing.T) {
+	budget := newFetchBudget(25)
+	assert.False(t, budget.exhausted())
+	assert.True(t, budget.consume(10))
+	assert.Equal(t, 15, budget.available())
+	assert.False(t, budget.consume(20))
+	assert.True(t, budget.exhausted())
+	assert.Equal(t, 0, budget.available())
+
+	// an empty budget still lets the first record through
+	budget = newFetchBudget(0)
+	assert.False(t, budget.exhausted())
+	assert.False(t, budget.consume(10))
+	assert.True(t, budget.exhausted())
+}
+
+func TestFetchBudgetConcurrentConsume(t *testing.T) {
+	budget := newFetchBudget(1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				budget.consume(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.True(t, budget.exhausted())
+	assert.Equal(t, int64(1000), budget.used)
+}
+
+// newPartitionsBroker a broker whose reader of every partition has messages of 10 bytes
+func newPartitionsBroker(t *testing.T, config KafsarConfig, partitions int) *Broker {
+	k := newTestBroker(config)
+	for p := 0; p < partitions; p++ {
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := make([]pulsar.Message, 5)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: int64(p + 1), entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: []byte(fmt.Sprintf("content-%d-%d", p, i)[:10]),
+			}
+		}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+	}
+	return k
+}
+
+func fetchPartitionsReq(partitions int, maxBytes int) *codec.FetchReq {
+	partitionReqList := make([]*codec.FetchPartitionReq, partitions)
+	for p := range partitionReqList {
+		partitionReqList[p] = &codec.FetchPartitionReq{PartitionId: p}
+	}
+	return &codec.FetchReq{
+		BaseReq:      codec.BaseReq{ClientId: clientId},
+		MaxWaitTime:  100,
+		MinBytes:     maxBytes,
+		MaxBytes:     maxBytes,
+		TopicReqList: []*codec.FetchTopicReq{{Topic: "topic", PartitionReqList: partitionReqList}},
+	}
+}
+
+func TestFetchPartitionsShareMaxBytes(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 100
+	config.MaxFetchRecord = 100
+	k := newPartitionsBroker(t, config, 3)
+	resp, err := k.Fetch(context.Background(), &addr, fetchPartitionsReq(3, 25))
+	assert.Nil(t, err)
+	partitionRespList := resp[0].PartitionRespList
+	assert.Equal(t, 3, len(partitionRespList[0].RecordBatch.Records))
+	assert.Equal(t, 0, len(partitionRespList[1].RecordBatch.Records))
+	assert.Equal(t, 0, len(partitionRespList[2].RecordBatch.Records))
+}
+
+func TestFetchPartitionsConcurrently(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 100
+	config.MaxFetchRecord = 100
+	config.FetchConcurrency = 4
+	k := newPartitionsBroker(t, config, 4)
+	resp, err := k.Fetch(context.Background(), &addr, fetchPartitionsReq(4, 35))
+	assert.Nil(t, err)
+	records := 0
+	for p, partitionResp := range resp[0].PartitionRespList {
+		assert.Equal(t, p, partitionResp.PartitionIndex)
+		records += len(partitionResp.RecordBatch.Records)
+	}
+	// each partition checks the budget before reading, so at most one record per worker crosses it
+	assert.GreaterOrEqual(t, records, 4)
+	assert.LessOrEqual(t, records, 4+4)
+
+	resp, err = k.Fetch(context.Background(), &addr, fetchPartitionsReq(4, 1024))
+	assert.Nil(t, err)
+	for p, partitionResp := range resp[0].PartitionRespList {
+		assert.Equal(t, p, partitionResp.PartitionIndex)
+	}
+}
@@ -0,0 +1,124 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// fetchTopicMultiplexed read the partitions of the topic together, a single goroutine drains the channels of their
+// readers instead of a goroutine per partition blocking on Next. partitions whose reader has no channel, e.g.
+// failover readers, are read by Next meanwhile
+func (b *Broker) fetchTopicMultiplexed(parent context.Context, addr net.Addr, kafkaTopic, clientID string, reqList []*codec.FetchPartitionReq,
+	respList []*codec.FetchPartitionResp, budget *fetchBudget, minBytes int, maxWaitMs int, span LocalSpan) {
+	fetches := make([]*partitionFetch, len(reqList))
+	for i, req := range reqList {
+		p, resp := b.prepareFetchPartition(addr, kafkaTopic, clientID, req, budget, minBytes, span)
+		if resp != nil {
+			respList[i] = resp
+			continue
+		}
+		fetches[i] = p
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(maxWaitMs)*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	channelFetches := make([]*partitionFetch, 0, len(fetches))
+	for _, p := range fetches {
+		if p == nil {
+			continue
+		}
+		if p.readerMetadata.channel != nil {
+			channelFetches = append(channelFetches, p)
+			continue
+		}
+		wg.Add(1)
+		go func(p *partitionFetch) {
+			defer wg.Done()
+			b.readPartition(ctx, p, maxWaitMs)
+		}(p)
+	}
+	b.readChannels(ctx, channelFetches, minBytes, maxWaitMs)
+	wg.Wait()
+	for i, p := range fetches {
+		if p != nil {
+			respList[i] = p.resp()
+		}
+		b.metrics.FetchRequest(kafkaTopic, recordBatchBytes(respList[i].RecordBatch), respList[i].ErrorCode)
+	}
+}
+
+// readChannels drain the channels of the partitions until all of them are full, ctx is done, or their records
+// exceed minBytes after the min fetch wait. messages are only taken from the channels of partitions not full yet
+func (b *Broker) readChannels(ctx context.Context, fetches []*partitionFetch, minBytes int, maxWaitMs int) {
+	if len(fetches) == 0 {
+		return
+	}
+	doneCase, minWaitCase := len(fetches), len(fetches)+1
+	cases := make([]reflect.SelectCase, len(fetches)+2)
+	active := 0
+	for i, p := range fetches {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv}
+		if !b.partitionFull(p, maxWaitMs) {
+			cases[i].Chan = reflect.ValueOf(p.readerMetadata.channel)
+			active++
+		}
+	}
+	// the partitions of the topic share the min fetch wait
+	minWait := time.NewTimer(time.Duration(fetches[0].minFetchWaitMs)*time.Millisecond - time.Since(fetches[0].start))
+	defer minWait.Stop()
+	cases[doneCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	cases[minWaitCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(minWait.C)}
+	minWaitElapsed := false
+	byteLength := 0
+	for active > 0 {
+		chosen, value, ok := reflect.Select(cases)
+		switch chosen {
+		case doneCase:
+			return
+		case minWaitCase:
+			minWaitElapsed = true
+			cases[minWaitCase].Chan = reflect.Value{}
+		default:
+			p := fetches[chosen]
+			more := ok
+			if ok {
+				before := p.byteLength
+				_, more = b.addFetchedMessage(p, value.Interface().(pulsar.ReaderMessage).Message)
+				byteLength += p.byteLength - before
+			}
+			if !more || b.partitionFull(p, maxWaitMs) {
+				// a nil channel is never selected
+				cases[chosen].Chan = reflect.Value{}
+				active--
+			}
+		}
+		if minWaitElapsed && byteLength > minBytes {
+			return
+		}
+		if fetches[0].budget.exhausted() {
+			return
+		}
+	}
+}
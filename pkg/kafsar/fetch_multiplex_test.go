@@ -0,0 +1,79 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// useChannelReaders feed the readers of the partitions to their channels, as created with MultiplexFetch
+func useChannelReaders(k *Broker, partitions ...int) {
+	for _, p := range partitions {
+		partitionedTopic, _ := k.partitionedTopic(&userInfo{username: username}, "topic", p)
+		readerMetadata := k.readerManager[readerKey(username, partitionedTopic, clientId)]
+		readerMetadata.channel = make(chan pulsar.ReaderMessage, 2)
+		readerMetadata.reader = newChannelReader(readerMetadata.reader, readerMetadata.channel)
+	}
+}
+
+func fetchedRecords(resp []*codec.FetchTopicResp) []int {
+	records := make([]int, len(resp[0].PartitionRespList))
+	for i, partitionResp := range resp[0].PartitionRespList {
+		records[i] = len(partitionResp.RecordBatch.Records)
+	}
+	return records
+}
+
+func TestFetchMultiplexed(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 100
+	config.MaxFetchRecord = 100
+	config.MultiplexFetch = true
+	k := newPartitionsBroker(t, config, 3)
+	// the reader of partition 1 has no channel and is read by Next
+	useChannelReaders(k, 0, 2)
+	resp, err := k.Fetch(context.Background(), &addr, fetchPartitionsReq(3, 1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 5, 5}, fetchedRecords(resp))
+	for p, partitionResp := range resp[0].PartitionRespList {
+		assert.Equal(t, p, partitionResp.PartitionIndex)
+		assert.Equal(t, codec.NONE, partitionResp.ErrorCode)
+		assert.Equal(t, fmt.Sprintf("content-%d-", p), string(partitionResp.RecordBatch.Records[0].Value))
+	}
+}
+
+func TestFetchMultiplexedMinBytes(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 1000
+	config.MaxFetchRecord = 100
+	config.MultiplexFetch = true
+	k := newPartitionsBroker(t, config, 3)
+	useChannelReaders(k, 0, 1, 2)
+	req := fetchPartitionsReq(3, 1000)
+	req.MinBytes = 25
+	resp, err := k.Fetch(context.Background(), &addr, req)
+	assert.Nil(t, err)
+	// the records of all partitions count towards min bytes
+	records := fetchedRecords(resp)
+	assert.Equal(t, 3, records[0]+records[1]+records[2])
+}
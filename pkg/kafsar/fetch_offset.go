@@ -0,0 +1,155 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+)
+
+// seekToFetchOffset move the reader to the fetch offset when the client fetches from another position than the reader's.
+// the offset is translated to the message id by the fetched messages and the committed offset,
+// the reader keeps its position if the offset is unknown or the reader has not fetched yet
+func (b *Broker) seekToFetchOffset(username, kafkaTopic string, partition int, readerMetadata *ReaderMetadata, fetchOffset int64) {
+	readerMetadata.mutex.RLock()
+	nextOffset := readerMetadata.nextOffset
+	var fetched pulsar.MessageID
+	if index := searchMessageIdPair(readerMetadata.messageIds, fetchOffset); index >= 0 && readerMetadata.messageIds[index].Offset == fetchOffset {
+		fetched = readerMetadata.messageIds[index].MessageId
+	}
+	readerMetadata.mutex.RUnlock()
+	if nextOffset == constant.UnknownOffset || nextOffset == fetchOffset {
+		return
+	}
+	if fetched != nil {
+		b.logger.Infof("seek reader to fetched message %s. topic: %s, partition: %d, offset: %d, reader offset: %d",
+			fetched, kafkaTopic, partition, fetchOffset, nextOffset)
+		if err := readerMetadata.reader.Seek(fetched); err != nil {
+			b.logger.Errorf("seek reader to fetch offset failed. topic: %s, partition: %d, err: %s", kafkaTopic, partition, err)
+			return
+		}
+		readerMetadata.mutex.Lock()
+		// the messages from the fetch offset are fetched again
+		if index := searchMessageIdPair(readerMetadata.messageIds, fetchOffset); index >= 0 {
+			readerMetadata.messageIds = readerMetadata.messageIds[:index]
+		}
+		readerMetadata.nextOffset = fetchOffset
+		readerMetadata.skipId = nil
+		readerMetadata.mutex.Unlock()
+		return
+	}
+	committed, exist := b.offsetManager.AcquireOffset(username, kafkaTopic, readerMetadata.groupId, partition)
+	if !exist || (committed.Offset != fetchOffset && committed.Offset+1 != fetchOffset) {
+		b.logger.Warnf("fetch offset is unknown, read from the reader position. topic: %s, partition: %d, offset: %d, reader offset: %d",
+			kafkaTopic, partition, fetchOffset, nextOffset)
+		return
+	}
+	b.logger.Infof("seek reader to committed message %s. topic: %s, partition: %d, offset: %d, reader offset: %d",
+		committed.MessageId, kafkaTopic, partition, fetchOffset, nextOffset)
+	if err := seekToCommitted(readerMetadata, committed); err != nil {
+		b.logger.Errorf("seek reader to fetch offset failed. topic: %s, partition: %d, err: %s", kafkaTopic, partition, err)
+		return
+	}
+	if committed.Offset == fetchOffset {
+		// the client fetches the committed message again
+		readerMetadata.mutex.Lock()
+		readerMetadata.nextOffset = fetchOffset
+		readerMetadata.skipId = nil
+		readerMetadata.mutex.Unlock()
+	}
+}
+
+// checkFetchOffsetRange return OFFSET_OUT_OF_RANGE with the valid range if the fetch offset is out of the partition,
+// so that the client resets by its auto.offset.reset. nil if the offset is in range, or the reader fetches sequentially
+func (b *Broker) checkFetchOffsetRange(partitionedTopic string, req *codec.FetchPartitionReq, readerMetadata *ReaderMetadata) *codec.FetchPartitionResp {
+	readerMetadata.mutex.RLock()
+	nextOffset := readerMetadata.nextOffset
+	readerMetadata.mutex.RUnlock()
+	if req.FetchOffset == nextOffset {
+		return nil
+	}
+	logStartOffset, highWatermark, err := b.offsetRange(partitionedTopic)
+	if err != nil {
+		b.logger.Warnf("get offset range failed, skip offset range check. topic: %s, err: %s", partitionedTopic, err)
+		return nil
+	}
+	if req.FetchOffset >= logStartOffset && req.FetchOffset <= highWatermark {
+		return nil
+	}
+	b.logger.Warnf("fetch offset out of range. topic: %s, offset: %d, log start offset: %d, high watermark: %d",
+		partitionedTopic, req.FetchOffset, logStartOffset, highWatermark)
+	return &codec.FetchPartitionResp{
+		PartitionIndex: req.PartitionId,
+		ErrorCode:      codec.OFFSET_OUT_OF_RANGE,
+		HighWatermark:  highWatermark,
+		LogStartOffset: logStartOffset,
+	}
+}
+
+// checkCommitOffsetRange reject a commit offset beyond the high watermark, the client can not have consumed it.
+// the offsets up to the reader position are accepted without querying pulsar
+func (b *Broker) checkCommitOffsetRange(partitionedTopic string, offset int64, readerMetadata *ReaderMetadata) codec.ErrorCode {
+	readerMetadata.mutex.RLock()
+	nextOffset := readerMetadata.nextOffset
+	readerMetadata.mutex.RUnlock()
+	if nextOffset != constant.UnknownOffset && offset <= nextOffset {
+		return codec.NONE
+	}
+	_, highWatermark, err := b.offsetRange(partitionedTopic)
+	if err != nil {
+		b.logger.Warnf("get offset range failed, skip commit offset check. topic: %s, err: %s", partitionedTopic, err)
+		return codec.NONE
+	}
+	if offset > highWatermark {
+		b.logger.Errorf("commit offset beyond high watermark. topic: %s, offset: %d, high watermark: %d", partitionedTopic, offset, highWatermark)
+		return codec.OFFSET_OUT_OF_RANGE
+	}
+	return codec.NONE
+}
+
+// offsetRange the offset of the earliest message and the offset after the latest message of the partition
+func (b *Broker) offsetRange(partitionedTopic string) (int64, int64, error) {
+	earliestMsg, err := utils.ReadEarliestMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, b.pulsarCommonClient)
+	if err != nil {
+		return 0, 0, err
+	}
+	latestMsgId, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
+	if err != nil {
+		return 0, 0, err
+	}
+	latestMsg, err := utils.ReadLastedMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, latestMsgId, b.pulsarCommonClient)
+	if err != nil {
+		return 0, 0, err
+	}
+	if earliestMsg == nil || latestMsg == nil {
+		// the offsets of an empty partition are unknown
+		return 0, 0, errors.Errorf("partition %s is empty", partitionedTopic)
+	}
+	logStartOffset, err := convOffset(earliestMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+	latestOffset, err := convOffset(latestMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+	return logStartOffset, latestOffset + 1, nil
+}
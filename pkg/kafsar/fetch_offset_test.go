@@ -0,0 +1,292 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func newFetchOffsetBroker(t *testing.T) (*Broker, *testReader, []pulsar.Message) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 3
+	config.SeekToFetchOffset = true
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 5)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	reader := &testReader{messages: messages}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	return k, reader, messages
+}
+
+// fetchValues fetch from the offset, return the record values and the offset the client fetches next
+func fetchValues(k *Broker, fetchOffset int64) ([]string, int64) {
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition, FetchOffset: fetchOffset}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	values := make([]string, 0, len(resp.RecordBatch.Records))
+	for _, record := range resp.RecordBatch.Records {
+		values = append(values, string(record.Value))
+	}
+	if len(values) == 0 {
+		return values, fetchOffset
+	}
+	return values, resp.RecordBatch.Offset + int64(len(values))
+}
+
+func TestFetchSeekBackToFetchedOffset(t *testing.T) {
+	k, reader, messages := newFetchOffsetBroker(t)
+	baseOffset := ConvertMsgId(messages[0].ID())
+	values, _ := fetchValues(k, baseOffset)
+	assert.Equal(t, []string{testContent + "-0", testContent + "-1", testContent + "-2"}, values)
+	// the client seeks back to the second message
+	values, nextOffset := fetchValues(k, baseOffset+1)
+	assert.Equal(t, []string{testContent + "-1", testContent + "-2", testContent + "-3"}, values)
+	assert.Equal(t, 1, reader.seeks)
+	// the client continues from the reader position
+	values, _ = fetchValues(k, nextOffset)
+	assert.Equal(t, []string{testContent + "-4"}, values)
+	assert.Equal(t, 1, reader.seeks)
+}
+
+func TestFetchSeekToCommittedOffset(t *testing.T) {
+	k, reader, messages := newFetchOffsetBroker(t)
+	baseOffset := ConvertMsgId(messages[0].ID())
+	values, nextOffset := fetchValues(k, baseOffset)
+	assert.Equal(t, 3, len(values))
+	values, _ = fetchValues(k, nextOffset)
+	assert.Equal(t, 2, len(values))
+	// the client crashed after committing the second message, the fetched message ids are gone
+	err := k.offsetManager.CommitOffset(username, "topic", groupId, partition, MessageIdPair{MessageId: messages[1].ID(), Offset: baseOffset + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, readerMetadata := range k.readerManager {
+		readerMetadata.messageIds = make([]MessageIdPair, 0)
+	}
+	values, _ = fetchValues(k, baseOffset+2)
+	assert.Equal(t, []string{testContent + "-2", testContent + "-3", testContent + "-4"}, values)
+	assert.Equal(t, 1, reader.seeks)
+
+	// unknown offset, read from the reader position
+	values, _ = fetchValues(k, baseOffset+100)
+	assert.Empty(t, values)
+	assert.Equal(t, 1, reader.seeks)
+}
+
+// newOffsetRangeBroker the partition has the messages of index 2 to 6, the messages before are deleted by retention
+func newOffsetRangeBroker(t *testing.T, config KafsarConfig) (*Broker, *ReaderMetadata, func()) {
+	config.MaxFetchWaitMs = 100
+	config.ContinuousOffset = true
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := []uint64{2, 3, 4, 5, 6}
+	messages := make([]pulsar.Message, len(indexes))
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(indexes[i])},
+			index:   &indexes[i],
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	k.pulsarCommonClient = &producedMessageClient{messages: messages}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"LedgerID":1,"EntryID":6,"BatchIdx":-1,"PartitionIdx":-1}`)
+	}))
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0), nextOffset: constant.UnknownOffset}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+	return k, readerMetadata, server.Close
+}
+
+func TestFetchOffsetOutOfRange(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	config.CheckFetchOffsetRange = true
+	k, _, closeServer := newOffsetRangeBroker(t, config)
+	defer closeServer()
+
+	for _, fetchOffset := range []int64{0, 8} {
+		fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition, FetchOffset: fetchOffset}
+		resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+		assert.Equal(t, codec.OFFSET_OUT_OF_RANGE, resp.ErrorCode)
+		assert.Equal(t, int64(2), resp.LogStartOffset)
+		assert.Equal(t, int64(7), resp.HighWatermark)
+		assert.Empty(t, resp.RecordBatch.Records)
+	}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition, FetchOffset: 2}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 5, len(resp.RecordBatch.Records))
+	// the high watermark is in range, the client waits for new messages
+	fetchPartitionReq.FetchOffset = 7
+	resp = k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+}
+
+func TestCommitOffsetBeyondHighWatermark(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 3
+	config.CheckCommitOffsetRange = true
+	k, readerMetadata, closeServer := newOffsetRangeBroker(t, config)
+	defer closeServer()
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition, FetchOffset: 2}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+
+	commit := func(offset int64) codec.ErrorCode {
+		commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: offset})
+		assert.Nil(t, err)
+		return commitResp.ErrorCode
+	}
+	// a bogus offset does not ack the fetched messages
+	assert.Equal(t, codec.OFFSET_OUT_OF_RANGE, commit(100))
+	assert.Equal(t, 3, len(readerMetadata.messageIds))
+	_, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+
+	// the position after the fetched messages and the high watermark are accepted
+	assert.Equal(t, codec.NONE, commit(5))
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, int64(4), committed.Offset)
+	// the high watermark is the position after the last message, it is committed from the last fetched message
+	assert.Equal(t, codec.NONE, commit(7))
+	committed, _ = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(6), committed.Offset)
+	assert.Equal(t, int64(4), committed.MessageId.EntryID())
+}
+
+func TestCommitOffsetNotFetched(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 3
+	k, readerMetadata, closeServer := newOffsetRangeBroker(t, config)
+	defer closeServer()
+	reader := readerMetadata.reader.(*testReader)
+	commit := func(offset int64) codec.ErrorCode {
+		commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: offset})
+		assert.Nil(t, err)
+		return commitResp.ErrorCode
+	}
+	committedEntry := func() (int64, int64) {
+		committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+		assert.True(t, exist)
+		return committed.Offset, committed.MessageId.EntryID()
+	}
+
+	// the reader is recreated and fetched nothing, the position is committed from the earliest message
+	assert.Equal(t, codec.NONE, commit(4))
+	offset, entryId := committedEntry()
+	assert.Equal(t, int64(3), offset)
+	assert.Equal(t, pulsar.EarliestMessageID().EntryID(), entryId)
+
+	// the committed position again keeps the committed message
+	assert.Equal(t, codec.NONE, commit(4))
+	offset, _ = committedEntry()
+	assert.Equal(t, int64(3), offset)
+
+	// the member rejoins, the reader starts from the committed message and skips the messages up to the committed offset
+	committed, _ := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Nil(t, seekToCommitted(readerMetadata, committed))
+	assert.Equal(t, 0, reader.position)
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition, FetchOffset: 4}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	assert.Equal(t, int64(4), resp.RecordBatch.Offset)
+	assert.Equal(t, fmt.Sprintf("%s-%d", testContent, 2), string(resp.RecordBatch.Records[0].Value))
+
+	// a position beyond the fetched messages is committed from the last fetched message
+	assert.Equal(t, codec.NONE, commit(9))
+	offset, entryId = committedEntry()
+	assert.Equal(t, int64(8), offset)
+	assert.Equal(t, int64(6), entryId)
+	assert.Empty(t, readerMetadata.messageIds)
+
+	// an older position committed out of order starts from the earliest message
+	assert.Equal(t, codec.NONE, commit(3))
+	offset, entryId = committedEntry()
+	assert.Equal(t, int64(2), offset)
+	assert.Equal(t, pulsar.EarliestMessageID().EntryID(), entryId)
+}
+
+func TestCommitOffsetWithoutReaderUnlessRebalancing(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 3
+	k, _, closeServer := newOffsetRangeBroker(t, config)
+	defer closeServer()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the reader is closed by the rebalance and not recreated yet
+	delete(k.readerManager, readerKey(username, partitionedTopic, clientId))
+	group := &Group{groupId: groupId, groupStatus: Stable, members: make(map[string]*memberMetadata)}
+	k.groupCoordinator.(*GroupCoordinatorStandalone).groupManager[username+groupId] = group
+	commit := func(offset int64) codec.ErrorCode {
+		commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: offset})
+		assert.Nil(t, err)
+		return commitResp.ErrorCode
+	}
+
+	// the group of the connection is unknown
+	assert.Equal(t, codec.REBALANCE_IN_PROGRESS, commit(3))
+
+	k.memberManager[addr.String()] = &MemberInfo{memberId: "member", groupId: groupId}
+	assert.Equal(t, codec.NONE, commit(3))
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, int64(2), committed.Offset)
+	assert.Equal(t, pulsar.EarliestMessageID().EntryID(), committed.MessageId.EntryID())
+
+	group.groupStatus = PreparingRebalance
+	assert.Equal(t, codec.REBALANCE_IN_PROGRESS, commit(4))
+	group.groupStatus = Dead
+	assert.Equal(t, codec.UNKNOWN_MEMBER_ID, commit(4))
+	committed, _ = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(2), committed.Offset)
+}
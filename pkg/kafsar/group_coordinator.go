@@ -18,17 +18,16 @@
 package kafsar
 
 import (
-	"container/list"
 	"github.com/apache/pulsar-client-go/pulsar"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Group struct {
 	groupId            string
-	partitionedTopic   []string
 	groupStatus        GroupStatus
 	supportedProtocol  string
-	groupProtocols     map[string]string
 	protocolType       string
 	leader             string
 	members            map[string]*memberMetadata
@@ -39,6 +38,54 @@ type Group struct {
 	groupMemberLock    sync.RWMutex
 	groupNewMemberLock sync.RWMutex
 	sessionTimeoutMs   int
+	// members blocked in awaiting join or sync, value is the time they started waiting
+	awaitingLock        sync.Mutex
+	awaitingJoinMembers map[string]time.Time
+	awaitingSyncMembers map[string]time.Time
+	// lastJoinTime the last time a member joined or updated its protocols, guarded by groupMemberLock
+	lastJoinTime time.Time
+	// partitionedTopics the pulsar partitions the members of the group read
+	partitionedTopicLock sync.RWMutex
+	partitionedTopics    map[string]struct{}
+}
+
+func (g *Group) addPartitionedTopic(partitionedTopic string) {
+	g.partitionedTopicLock.Lock()
+	defer g.partitionedTopicLock.Unlock()
+	if g.partitionedTopics == nil {
+		g.partitionedTopics = make(map[string]struct{})
+	}
+	g.partitionedTopics[partitionedTopic] = struct{}{}
+}
+
+// subscribedTopics snapshot the partitioned topics of the group in order
+func (g *Group) subscribedTopics() []string {
+	g.partitionedTopicLock.RLock()
+	defer g.partitionedTopicLock.RUnlock()
+	topics := make([]string, 0, len(g.partitionedTopics))
+	for topic := range g.partitionedTopics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// status the status of the group, read under the status lock
+func (g *Group) status() GroupStatus {
+	g.groupStatusLock.RLock()
+	defer g.groupStatusLock.RUnlock()
+	return g.groupStatus
+}
+
+// memberClientIds the client ids of the members in the group
+func (g *Group) memberClientIds() map[string]bool {
+	g.groupMemberLock.RLock()
+	defer g.groupMemberLock.RUnlock()
+	clientIds := make(map[string]bool, len(g.members))
+	for _, member := range g.members {
+		clientIds[member.clientId] = true
+	}
+	return clientIds
 }
 
 type memberMetadata struct {
@@ -50,14 +97,36 @@ type memberMetadata struct {
 	protocols        map[string][]byte
 	joinGenerationId int
 	syncGenerationId int
+	joinTime         time.Time
+	// protocolNames the protocols in the order of member preference
+	protocolNames []string
+	// revoked the partitions the last cooperative assignment took from the member, its readers are closed at sync
+	revoked map[string][]int32
 }
 
 type ReaderMetadata struct {
-	groupId    string
+	groupId string
+	// channel prefetched messages of reader, nil if the reader is read by Next, e.g. failover readers
 	channel    chan pulsar.ReaderMessage
 	reader     pulsar.Reader
-	messageIds *list.List
-	mutex      sync.RWMutex
+	messageIds []MessageIdPair
+	// nextOffset the continuous offset of the next fetched message, constant.UnknownOffset to use the pulsar index
+	nextOffset int64
+	// skipId the reader redeliver the committed message it seek to, the messages up to skipOffset are skipped too
+	skipId     pulsar.MessageID
+	skipOffset int64
+	// chunks the large records whose chunks are being read by chunk id, guarded by mutex.
+	// chunkIds the ids in the order their first chunk was read, to evict the oldest
+	chunks   map[string]*chunkedRecord
+	chunkIds []string
+	mutex    sync.RWMutex
+}
+
+type pendingReaderMetadata struct {
+	groupId          string
+	subscriptionName string
+	messageId        pulsar.MessageID
+	nextOffset       int64
 }
 
 type GroupStatus int
@@ -33,4 +33,16 @@ type GroupCoordinator interface {
 	HandleHeartBeat(username, groupId, memberId string) *codec.HeartbeatResp
 
 	GetGroup(username, groupId string) (*Group, error)
+
+	GetAwaitingMetrics(username, groupId string) (*AwaitingMetrics, error)
+
+	FindCoordinator(username, key string, keyType byte) (*codec.FindCoordinatorResp, error)
+
+	// DeleteGroups delete the groups without members, NON_EMPTY_GROUP for the others
+	DeleteGroups(username string, groupIds []string) ([]*DeleteGroupResult, error)
+}
+
+type DeleteGroupResult struct {
+	GroupId   string
+	ErrorCode codec.ErrorCode
 }
@@ -18,14 +18,20 @@
 package kafsar
 
 import (
+	"github.com/pkg/errors"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"hash/crc32"
 )
 
+var errClusterGroupUnsupported = errors.New("groups are not supported by the cluster group coordinator")
+
 type GroupCoordinatorCluster struct {
+	// nodes the cluster nodes sorted by node id, every node of the cluster has the same ones
+	nodes []*ClusterNode
 }
 
-func NewGroupCoordinatorCluster() *GroupCoordinatorCluster {
-	return &GroupCoordinatorCluster{}
+func NewGroupCoordinatorCluster(nodes []*ClusterNode) *GroupCoordinatorCluster {
+	return &GroupCoordinatorCluster{nodes: nodes}
 }
 
 func (gcc *GroupCoordinatorCluster) HandleJoinGroup(username, groupId, memberId, clientId, protocolType string, sessionTimeoutMs int,
@@ -44,8 +50,35 @@ func (gcc *GroupCoordinatorCluster) HandleLeaveGroup(username, groupId string,
 }
 
 func (gcc *GroupCoordinatorCluster) GetGroup(username, groupId string) (*Group, error) {
-	panic("implement get group")
+	return nil, errors.Wrapf(errClusterGroupUnsupported, "get group %s", groupId)
 }
 func (gcc *GroupCoordinatorCluster) HandleHeartBeat(username, groupId, memberId string) *codec.HeartbeatResp {
 	panic("implement handle heart beat")
 }
+
+func (gcc *GroupCoordinatorCluster) GetAwaitingMetrics(username, groupId string) (*AwaitingMetrics, error) {
+	return nil, errors.Wrapf(errClusterGroupUnsupported, "get awaiting metrics of group %s", groupId)
+}
+
+// FindCoordinator the key is owned by the node its hash picks among the cluster nodes, so every node answers the same
+func (gcc *GroupCoordinatorCluster) FindCoordinator(username, key string, keyType byte) (*codec.FindCoordinatorResp, error) {
+	if len(gcc.nodes) == 0 {
+		return &codec.FindCoordinatorResp{ErrorCode: codec.COORDINATOR_NOT_AVAILABLE}, nil
+	}
+	node := gcc.nodes[crc32.ChecksumIEEE([]byte(key))%uint32(len(gcc.nodes))]
+	return &codec.FindCoordinatorResp{
+		ErrorCode: codec.NONE,
+		NodeId:    node.NodeId,
+		Host:      node.Host,
+		Port:      node.Port,
+	}, nil
+}
+
+// DeleteGroups the cluster coordinator keeps no group, COORDINATOR_NOT_AVAILABLE for every group
+func (gcc *GroupCoordinatorCluster) DeleteGroups(username string, groupIds []string) ([]*DeleteGroupResult, error) {
+	results := make([]*DeleteGroupResult, len(groupIds))
+	for i, groupId := range groupIds {
+		results[i] = &DeleteGroupResult{GroupId: groupId, ErrorCode: codec.COORDINATOR_NOT_AVAILABLE}
+	}
+	return results, nil
+}
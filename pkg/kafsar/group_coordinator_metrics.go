@@ -0,0 +1,57 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import "time"
+
+// AwaitingMetrics gauges of the members blocked in join or sync of a group
+type AwaitingMetrics struct {
+	AwaitingJoin int
+	AwaitingSync int
+	// LongestWait how long the longest waiter has been blocked
+	LongestWait time.Duration
+}
+
+func (g *Group) startAwaiting(awaitingMembers map[string]time.Time, memberId string, start time.Time) {
+	g.awaitingLock.Lock()
+	awaitingMembers[memberId] = start
+	g.awaitingLock.Unlock()
+}
+
+func (g *Group) stopAwaiting(awaitingMembers map[string]time.Time, memberId string) {
+	g.awaitingLock.Lock()
+	delete(awaitingMembers, memberId)
+	g.awaitingLock.Unlock()
+}
+
+func (g *Group) awaitingMetrics(now time.Time) *AwaitingMetrics {
+	g.awaitingLock.Lock()
+	defer g.awaitingLock.Unlock()
+	metrics := &AwaitingMetrics{
+		AwaitingJoin: len(g.awaitingJoinMembers),
+		AwaitingSync: len(g.awaitingSyncMembers),
+	}
+	for _, awaitingMembers := range []map[string]time.Time{g.awaitingJoinMembers, g.awaitingSyncMembers} {
+		for _, start := range awaitingMembers {
+			if wait := now.Sub(start); wait > metrics.LongestWait {
+				metrics.LongestWait = wait
+			}
+		}
+	}
+	return metrics
+}
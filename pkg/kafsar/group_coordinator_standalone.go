@@ -18,11 +18,12 @@
 package kafsar
 
 import (
+	"bytes"
 	"github.com/apache/pulsar-client-go/pulsar"
 	"github.com/google/uuid"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
 	"github.com/pkg/errors"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
-	"github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
@@ -33,20 +34,55 @@ type GroupCoordinatorStandalone struct {
 	pulsarClient pulsar.Client
 	mutex        sync.RWMutex
 	groupManager map[string]*Group
+	metrics      Metrics
+	logger       Logger
 }
 
 func NewGroupCoordinatorStandalone(pulsarConfig PulsarConfig, kafsarConfig KafsarConfig, pulsarClient pulsar.Client) *GroupCoordinatorStandalone {
-	coordinatorImpl := GroupCoordinatorStandalone{pulsarConfig: pulsarConfig, kafsarConfig: kafsarConfig, pulsarClient: pulsarClient}
+	coordinatorImpl := GroupCoordinatorStandalone{pulsarConfig: pulsarConfig, kafsarConfig: kafsarConfig, pulsarClient: pulsarClient, metrics: noopMetrics{}}
 	coordinatorImpl.groupManager = make(map[string]*Group)
+	coordinatorImpl.logger = NewLogrusLogger(nil)
 	return &coordinatorImpl
 }
 
 func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId, clientId, protocolType string, sessionTimeoutMs int,
+	protocols []*codec.GroupProtocol) (*codec.JoinGroupResp, error) {
+	resp, err := g.joinGroup(username, groupId, memberId, clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil || resp.ErrorCode != codec.NONE {
+		return resp, err
+	}
+	if !g.memberRegistered(username, groupId, resp.MemberId) {
+		// the coordinator state is reset while the member is joining, the member joined a group no longer served.
+		// the client finds the coordinator again and rejoins with its member id
+		g.logger.Warnf("group %s is reset during join, member %s must rejoin", groupId, resp.MemberId)
+		return &codec.JoinGroupResp{
+			MemberId:  resp.MemberId,
+			ErrorCode: codec.NOT_COORDINATOR,
+		}, nil
+	}
+	return resp, nil
+}
+
+// memberRegistered the member belongs to the group served by the coordinator
+func (g *GroupCoordinatorStandalone) memberRegistered(username, groupId, memberId string) bool {
+	g.mutex.RLock()
+	group, exist := g.groupManager[username+groupId]
+	g.mutex.RUnlock()
+	if !exist {
+		return false
+	}
+	group.groupMemberLock.RLock()
+	defer group.groupMemberLock.RUnlock()
+	_, exist = group.members[memberId]
+	return exist
+}
+
+func (g *GroupCoordinatorStandalone) joinGroup(username, groupId, memberId, clientId, protocolType string, sessionTimeoutMs int,
 	protocols []*codec.GroupProtocol) (*codec.JoinGroupResp, error) {
 	// do parameters check
 	memberId, code, err := g.joinGroupParamsCheck(clientId, groupId, memberId, sessionTimeoutMs, g.kafsarConfig)
 	if err != nil {
-		logrus.Errorf("join group %s params check failed, cause: %s", groupId, err)
+		g.logger.Errorf("join group %s params check failed, cause: %s", groupId, err)
 		return &codec.JoinGroupResp{
 			MemberId:  memberId,
 			ErrorCode: code,
@@ -63,7 +99,9 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 			members:          make(map[string]*memberMetadata),
 			canRebalance:     true,
 			sessionTimeoutMs: sessionTimeoutMs,
-			partitionedTopic: make([]string, 0),
+
+			awaitingJoinMembers: make(map[string]time.Time),
+			awaitingSyncMembers: make(map[string]time.Time),
 		}
 		g.groupManager[username+groupId] = group
 	}
@@ -71,7 +109,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 
 	code, err = g.joinGroupProtocolCheck(group, protocolType, protocols, g.kafsarConfig)
 	if err != nil {
-		logrus.Errorf("join group %s protocol check failed, cause: %s", groupId, err)
+		g.logger.Errorf("join group %s protocol check failed, cause: %s", groupId, err)
 		return &codec.JoinGroupResp{
 			MemberId:  memberId,
 			ErrorCode: code,
@@ -80,7 +118,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 
 	numMember := g.getGroupMembersLen(group)
 	if g.kafsarConfig.MaxConsumersPerGroup > 0 && numMember >= g.kafsarConfig.MaxConsumersPerGroup {
-		logrus.Errorf("join group failed, exceed maximum number of members. groupId: %s, memberId: %s, current: %d, maxConsumersPerGroup: %d",
+		g.logger.Errorf("join group failed, exceed maximum number of members. groupId: %s, memberId: %s, current: %d, maxConsumersPerGroup: %d",
 			groupId, memberId, numMember, g.kafsarConfig.MaxConsumersPerGroup)
 		return &codec.JoinGroupResp{
 			MemberId:  memberId,
@@ -89,7 +127,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 	}
 
 	if g.getGroupStatus(group) == Dead {
-		logrus.Errorf("join group failed, cause group status is dead. groupId: %s, memberId: %s", groupId, memberId)
+		g.logger.Errorf("join group failed, cause group status is dead. groupId: %s, memberId: %s", groupId, memberId)
 		return &codec.JoinGroupResp{
 			MemberId:  memberId,
 			ErrorCode: codec.UNKNOWN_MEMBER_ID,
@@ -100,7 +138,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 		if isNewMember || !g.checkMemberExist(group, memberId) {
 			memberId, err = g.addNewMemberAndReBalance(group, clientId, memberId, protocolType, protocols)
 			if err != nil {
-				logrus.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
+				g.logger.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
 				return &codec.JoinGroupResp{
 					MemberId:  memberId,
 					ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
@@ -109,7 +147,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 		}
 		err := g.awaitingJoin(group, memberId, g.kafsarConfig.RebalanceTickMs, sessionTimeoutMs)
 		if err != nil {
-			logrus.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
+			g.logger.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
 			if isNewMember {
 				g.deleteMember(group, memberId)
 			}
@@ -134,18 +172,18 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 		if isNewMember || !g.checkMemberExist(group, memberId) {
 			memberId, err = g.addNewMemberAndReBalance(group, clientId, memberId, protocolType, protocols)
 			if err != nil {
-				logrus.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
+				g.logger.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
 				return &codec.JoinGroupResp{
 					MemberId:  memberId,
 					ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
 				}, nil
 			}
 		} else {
-			if !matchProtocols(group.groupProtocols, protocols) {
+			if !g.memberMatchProtocols(group, memberId, protocols) {
 				// member is joining with the different metadata
 				err := g.updateMemberAndRebalance(group, clientId, memberId, protocolType, protocols, g.kafsarConfig.InitialDelayedJoinMs)
 				if err != nil {
-					logrus.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
+					g.logger.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
 					return &codec.JoinGroupResp{
 						MemberId:  memberId,
 						ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
@@ -156,7 +194,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 		members := g.getLeaderMembers(group, memberId)
 		err := g.awaitingJoin(group, memberId, g.kafsarConfig.RebalanceTickMs, sessionTimeoutMs)
 		if err != nil {
-			logrus.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
+			g.logger.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
 			if isNewMember {
 				g.deleteMember(group, memberId)
 			}
@@ -181,17 +219,17 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 			// avoid multi new member join an empty group
 			memberId, err = g.addNewMemberAndReBalance(group, clientId, memberId, protocolType, protocols)
 			if err != nil {
-				logrus.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
+				g.logger.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
 				return &codec.JoinGroupResp{
 					MemberId:  memberId,
 					ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
 				}, nil
 			}
 		} else {
-			if g.isMemberLeader(group, memberId) || !matchProtocols(group.groupProtocols, protocols) {
+			if g.isMemberLeader(group, memberId) || !g.memberMatchProtocols(group, memberId, protocols) {
 				err := g.updateMemberAndRebalance(group, clientId, memberId, protocolType, protocols, g.kafsarConfig.InitialDelayedJoinMs)
 				if err != nil {
-					logrus.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
+					g.logger.Errorf("member %s join group %s failed, cause: %s", memberId, groupId, err)
 					return &codec.JoinGroupResp{
 						MemberId:  memberId,
 						ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
@@ -201,7 +239,7 @@ func (g *GroupCoordinatorStandalone) HandleJoinGroup(username, groupId, memberId
 		}
 		err := g.awaitingJoin(group, memberId, g.kafsarConfig.RebalanceTickMs, sessionTimeoutMs)
 		if err != nil {
-			logrus.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
+			g.logger.Errorf("member %s join group %s failed, case: %s", memberId, groupId, err)
 			if isNewMember {
 				g.deleteMember(group, memberId)
 			}
@@ -231,21 +269,21 @@ func (g *GroupCoordinatorStandalone) HandleSyncGroup(username, groupId, memberId
 	groupAssignments []*codec.GroupAssignment) (*codec.SyncGroupResp, error) {
 	code, err := g.syncGroupParamsCheck(groupId, memberId)
 	if err != nil {
-		logrus.Errorf("member %s snyc group %s failed, cause: %s", memberId, groupId, err)
+		g.logger.Errorf("member %s snyc group %s failed, cause: %s", memberId, groupId, err)
 		return &codec.SyncGroupResp{ErrorCode: code}, nil
 	}
 	g.mutex.RLock()
 	group, exist := g.groupManager[username+groupId]
 	g.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("sync group %s failed, cause invalid groupId", groupId)
+		g.logger.Errorf("sync group %s failed, cause invalid groupId", groupId)
 		return &codec.SyncGroupResp{
 			ErrorCode: codec.INVALID_GROUP_ID,
 		}, nil
 	}
 	curMember, exist := group.members[memberId]
 	if !exist {
-		logrus.Errorf("sync group %s failed, cause invalid memberId %s", groupId, memberId)
+		g.logger.Errorf("sync group %s failed, cause invalid memberId %s", groupId, memberId)
 		return &codec.SyncGroupResp{
 			ErrorCode: codec.UNKNOWN_MEMBER_ID,
 		}, nil
@@ -268,10 +306,22 @@ func (g *GroupCoordinatorStandalone) HandleSyncGroup(username, groupId, memberId
 	if g.getGroupStatus(group) == CompletingRebalance {
 		// get assignment from leader member
 		if g.isMemberLeader(group, memberId) {
+			cooperative := isCooperative(group)
+			group.groupMemberLock.Lock()
 			for i := range groupAssignments {
-				logrus.Infof("Assignment %#+v received from leader %s for group %s for generation %d", groupAssignments[i], memberId, groupId, generation)
-				group.members[groupAssignments[i].MemberId].assignment = groupAssignments[i].MemberAssignment
+				g.logger.Infof("Assignment %#+v received from leader %s for group %s for generation %d", groupAssignments[i], memberId, groupId, generation)
+				member, exist := group.members[groupAssignments[i].MemberId]
+				if !exist {
+					g.logger.Warnf("assignment of unknown member %s in group %s", groupAssignments[i].MemberId, groupId)
+					continue
+				}
+				if cooperative {
+					// members keep the partitions assigned again, only the delta is revoked
+					member.revoked = g.assignmentRevoked(member.assignment, groupAssignments[i].MemberAssignment)
+				}
+				member.assignment = groupAssignments[i].MemberAssignment
 			}
+			group.groupMemberLock.Unlock()
 		}
 		group.groupMemberLock.Lock()
 		curMember.syncGenerationId = curMember.joinGenerationId
@@ -284,7 +334,7 @@ func (g *GroupCoordinatorStandalone) HandleSyncGroup(username, groupId, memberId
 		curMemberAssignment := curMember.assignment
 		group.groupMemberLock.RUnlock()
 		if err != nil {
-			logrus.Errorf("member %s sync group %s failed, cause: %s", memberId, groupId, err)
+			g.logger.Errorf("member %s sync group %s failed, cause: %s", memberId, groupId, err)
 			return &codec.SyncGroupResp{
 				ErrorCode:        codec.REBALANCE_IN_PROGRESS,
 				MemberAssignment: curMemberAssignment,
@@ -316,7 +366,7 @@ func (g *GroupCoordinatorStandalone) HandleLeaveGroup(username, groupId string,
 	members []*codec.LeaveGroupMember) (*codec.LeaveGroupResp, error) {
 	// reject if groupId is empty
 	if groupId == "" {
-		logrus.Errorf("leave group failed, cause groupId is empty")
+		g.logger.Errorf("leave group failed, cause groupId is empty")
 		return &codec.LeaveGroupResp{
 			ErrorCode: codec.INVALID_GROUP_ID,
 		}, nil
@@ -325,17 +375,14 @@ func (g *GroupCoordinatorStandalone) HandleLeaveGroup(username, groupId string,
 	group, exist := g.groupManager[username+groupId]
 	g.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("leave group failed, cause group not exist")
+		g.logger.Errorf("leave group failed, cause group not exist")
 		return &codec.LeaveGroupResp{
 			ErrorCode: codec.INVALID_GROUP_ID,
 		}, nil
 	}
 	for i := range members {
-		if members[i].MemberId == g.getMemberLeader(group) {
-			g.setMemberLeader(group, "")
-		}
 		g.deleteMember(group, members[i].MemberId)
-		logrus.Infof("reader member: %s success leave group: %s", members[i].MemberId, groupId)
+		g.logger.Infof("reader member: %s success leave group: %s", members[i].MemberId, groupId)
 	}
 	group.groupLock.Lock()
 	group.generationId++
@@ -359,36 +406,95 @@ func (g *GroupCoordinatorStandalone) GetGroup(username, groupId string) (*Group,
 	return group, nil
 }
 
-func (g *GroupCoordinatorStandalone) addMemberAndRebalance(group *Group, clientId, memberId, protocolType string, protocols []*codec.GroupProtocol, rebalanceDelayMs int) (string, error) {
-	if memberId == EmptyMemberId {
-		memberId = clientId + "-" + uuid.New().String()
+func (g *GroupCoordinatorStandalone) GetAwaitingMetrics(username, groupId string) (*AwaitingMetrics, error) {
+	group, err := g.GetGroup(username, groupId)
+	if err != nil {
+		return nil, err
 	}
-	protocolMap := make(map[string][]byte)
-	for i := range protocols {
-		protocolMap[protocols[i].ProtocolName] = protocols[i].ProtocolMetadata
+	return group.awaitingMetrics(time.Now()), nil
+}
+
+// FindCoordinator standalone coordinator own every group and transaction, so it is always this broker
+func (g *GroupCoordinatorStandalone) FindCoordinator(username, key string, keyType byte) (*codec.FindCoordinatorResp, error) {
+	return &codec.FindCoordinatorResp{
+		ErrorCode: codec.NONE,
+		NodeId:    g.kafsarConfig.NodeId,
+		Host:      g.kafsarConfig.AdvertiseHost,
+		Port:      g.kafsarConfig.AdvertisePort,
+	}, nil
+}
+
+func (g *GroupCoordinatorStandalone) DeleteGroups(username string, groupIds []string) ([]*DeleteGroupResult, error) {
+	results := make([]*DeleteGroupResult, len(groupIds))
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for i, groupId := range groupIds {
+		results[i] = &DeleteGroupResult{GroupId: groupId, ErrorCode: codec.NONE}
+		if groupId == "" {
+			results[i].ErrorCode = codec.INVALID_GROUP_ID
+			continue
+		}
+		group, exist := g.groupManager[username+groupId]
+		if !exist {
+			results[i].ErrorCode = codec.GROUP_ID_NOT_FOUND
+			continue
+		}
+		status := g.getGroupStatus(group)
+		if status != Empty && status != Dead {
+			g.logger.Warnf("delete group %s failed, group has %d members", groupId, g.getGroupMembersLen(group))
+			results[i].ErrorCode = codec.NON_EMPTY_GROUP
+			continue
+		}
+		// members holding the group see it dead and rejoin a new one
+		g.setGroupStatus(group, Dead)
+		delete(g.groupManager, username+groupId)
+		g.logger.Infof("delete group %s success", groupId)
 	}
-	if g.getGroupStatus(group) == Empty {
-		g.vote(group, protocols)
+	return results, nil
+}
+
+func (g *GroupCoordinatorStandalone) addMember(group *Group, clientId, memberId, protocolType string, protocols []*codec.GroupProtocol) string {
+	if memberId == EmptyMemberId {
+		memberId = clientId + "-" + uuid.New().String()
 	}
 	group.groupMemberLock.Lock()
-	group.members[memberId] = &memberMetadata{
-		clientId:     clientId,
-		memberId:     memberId,
-		metadata:     protocolMap[group.supportedProtocol],
-		protocolType: protocolType,
-		protocols:    protocolMap,
-	}
+	member := &memberMetadata{
+		clientId: clientId,
+		memberId: memberId,
+		joinTime: time.Now(),
+	}
+	setMemberProtocols(member, protocolType, protocols)
+	group.members[memberId] = member
+	group.lastJoinTime = member.joinTime
 	group.groupMemberLock.Unlock()
-	return memberId, g.doRebalance(group, rebalanceDelayMs)
+	g.vote(group)
+	return memberId
 }
 
 func (g *GroupCoordinatorStandalone) updateMemberAndRebalance(group *Group, clientId, memberId, protocolType string, protocols []*codec.GroupProtocol, rebalanceDelayMs int) error {
+	group.groupMemberLock.Lock()
+	if member, exist := group.members[memberId]; exist {
+		setMemberProtocols(member, protocolType, protocols)
+		group.lastJoinTime = time.Now()
+	}
+	group.groupMemberLock.Unlock()
+	g.vote(group)
 	return g.doRebalance(group, rebalanceDelayMs)
 }
 
+func setMemberProtocols(member *memberMetadata, protocolType string, protocols []*codec.GroupProtocol) {
+	member.protocolType = protocolType
+	member.protocols = make(map[string][]byte, len(protocols))
+	member.protocolNames = make([]string, 0, len(protocols))
+	for _, protocol := range protocols {
+		member.protocols[protocol.ProtocolName] = protocol.ProtocolMetadata
+		member.protocolNames = append(member.protocolNames, protocol.ProtocolName)
+	}
+}
+
 func (g *GroupCoordinatorStandalone) HandleHeartBeat(username, groupId, memberId string) *codec.HeartbeatResp {
 	if groupId == "" {
-		logrus.Errorf("groupId is empty.")
+		g.logger.Errorf("groupId is empty.")
 		return &codec.HeartbeatResp{
 			ErrorCode: codec.INVALID_GROUP_ID,
 		}
@@ -398,7 +504,7 @@ func (g *GroupCoordinatorStandalone) HandleHeartBeat(username, groupId, memberId
 	if !exist {
 		g.mutex.RUnlock()
 		// the group will not exist when the broker restart, rebalance is required
-		logrus.Warningf("get group failed. cause group not exist, groupId: %s", groupId)
+		g.logger.Warnf("get group failed. cause group not exist, groupId: %s", groupId)
 		return &codec.HeartbeatResp{
 			ErrorCode: codec.REBALANCE_IN_PROGRESS,
 		}
@@ -408,14 +514,17 @@ func (g *GroupCoordinatorStandalone) HandleHeartBeat(username, groupId, memberId
 	group.groupMemberLock.RUnlock()
 	if !memberExist {
 		g.mutex.RUnlock()
-		logrus.Warningf("get member failed. cause member not exist, groupId: %s, memberId: %s", groupId, memberId)
+		g.logger.Warnf("get member failed. cause member not exist, groupId: %s, memberId: %s", groupId, memberId)
 		return &codec.HeartbeatResp{
 			ErrorCode: codec.REBALANCE_IN_PROGRESS,
 		}
 	}
 	g.mutex.RUnlock()
+	if g.getGroupStatus(group) == CompletingRebalance && g.awaitingAssignment(group, memberId) {
+		return &codec.HeartbeatResp{ErrorCode: codec.NONE}
+	}
 	if g.getGroupStatus(group) == PreparingRebalance || g.getGroupStatus(group) == CompletingRebalance || g.getGroupStatus(group) == Dead {
-		logrus.Infof("preparing rebalance. groupId: %s", groupId)
+		g.logger.Infof("preparing rebalance. groupId: %s", groupId)
 		return &codec.HeartbeatResp{
 			ErrorCode: codec.REBALANCE_IN_PROGRESS,
 		}
@@ -423,6 +532,39 @@ func (g *GroupCoordinatorStandalone) HandleHeartBeat(username, groupId, memberId
 	return &codec.HeartbeatResp{ErrorCode: codec.NONE}
 }
 
+// isCooperative the group uses the incremental cooperative rebalance protocol
+func isCooperative(group *Group) bool {
+	group.groupLock.RLock()
+	defer group.groupLock.RUnlock()
+	return group.supportedProtocol == constant.CooperativeStickyProtocol
+}
+
+// assignmentRevoked nil if any assignment can not be decoded
+func (g *GroupCoordinatorStandalone) assignmentRevoked(previous, current []byte) map[string][]int32 {
+	previousPartitions, err := decodeAssignment(previous)
+	if err != nil {
+		g.logger.Warnf("decode previous assignment failed, err: %s", err)
+		return nil
+	}
+	currentPartitions, err := decodeAssignment(current)
+	if err != nil {
+		g.logger.Warnf("decode assignment failed, err: %s", err)
+		return nil
+	}
+	return revokedPartitions(previousPartitions, currentPartitions)
+}
+
+// awaitingAssignment the member joined the current generation and is syncing, the leader is computing the assignment
+// and a follower is waiting for it. rebalance again would abandon the sync. a member, the leader as well, which missed
+// the join of the generation must rejoin
+func (g *GroupCoordinatorStandalone) awaitingAssignment(group *Group, memberId string) bool {
+	generationId := g.getGroupGenerationId(group)
+	group.groupMemberLock.RLock()
+	defer group.groupMemberLock.RUnlock()
+	member, exist := group.members[memberId]
+	return exist && member.joinGenerationId == generationId
+}
+
 func (g *GroupCoordinatorStandalone) prepareRebalance(group *Group) {
 	g.setGroupStatus(group, PreparingRebalance)
 }
@@ -432,11 +574,14 @@ func (g *GroupCoordinatorStandalone) doRebalance(group *Group, rebalanceDelayMs
 	g.prepareRebalance(group)
 	if group.canRebalance {
 		group.canRebalance = false
-		logrus.Infof("preparing to rebalance group %s with old generation %d", group.groupId, group.generationId)
-		time.Sleep(time.Duration(rebalanceDelayMs) * time.Millisecond)
+		g.logger.Infof("preparing to rebalance group %s with old generation %d", group.groupId, group.generationId)
+		group.groupLock.Unlock()
+		g.delayJoin(group, rebalanceDelayMs)
+		group.groupLock.Lock()
 		g.setGroupStatus(group, CompletingRebalance)
 		group.generationId++
-		logrus.Infof("completing rebalance group %s with new generation %d", group.groupId, group.generationId)
+		g.logger.Infof("completing rebalance group %s with new generation %d", group.groupId, group.generationId)
+		g.metrics.Rebalance(group.groupId)
 		group.canRebalance = true
 		group.groupLock.Unlock()
 		return nil
@@ -446,10 +591,43 @@ func (g *GroupCoordinatorStandalone) doRebalance(group *Group, rebalanceDelayMs
 	}
 }
 
-func (g *GroupCoordinatorStandalone) vote(group *Group, protocols []*codec.GroupProtocol) {
-	// TODO make clear multiple protocol scene
+// delayJoin wait for more members before completing the rebalance, so that members starting together join one generation.
+// the delay restarts when a member joins, until MaxDelayedJoinMs
+func (g *GroupCoordinatorStandalone) delayJoin(group *Group, rebalanceDelayMs int) {
+	delay := time.Duration(rebalanceDelayMs) * time.Millisecond
+	maxDelay := time.Duration(g.kafsarConfig.MaxDelayedJoinMs) * time.Millisecond
+	if maxDelay < delay {
+		maxDelay = delay
+	}
+	deadline := time.Now().Add(maxDelay)
+	for {
+		group.groupMemberLock.RLock()
+		wait := time.Until(group.lastJoinTime.Add(delay))
+		group.groupMemberLock.RUnlock()
+		if remaining := time.Until(deadline); wait > remaining {
+			wait = remaining
+		}
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// vote select the group protocol among the members, and the metadata of the protocol each member sends to the leader.
+// the group keeps its protocol when there is no member left
+func (g *GroupCoordinatorStandalone) vote(group *Group) {
+	group.groupMemberLock.Lock()
+	protocol := selectProtocol(group.members)
+	for _, member := range group.members {
+		member.metadata = member.protocols[protocol]
+	}
+	group.groupMemberLock.Unlock()
+	if protocol == "" {
+		return
+	}
 	group.groupLock.Lock()
-	group.supportedProtocol = protocols[0].ProtocolName
+	group.supportedProtocol = protocol
 	group.groupLock.Unlock()
 }
 
@@ -467,10 +645,7 @@ func (g *GroupCoordinatorStandalone) awaitingRebalance(group *Group, rebalanceTi
 }
 
 func (g *GroupCoordinatorStandalone) getGroupStatus(group *Group) GroupStatus {
-	group.groupStatusLock.RLock()
-	status := group.groupStatus
-	group.groupStatusLock.RUnlock()
-	return status
+	return group.status()
 }
 
 func (g *GroupCoordinatorStandalone) getGroupGenerationId(group *Group) int {
@@ -525,7 +700,10 @@ func (g *GroupCoordinatorStandalone) joinGroupProtocolCheck(group *Group, protoc
 		if group.protocolType != protocolType {
 			return codec.INCONSISTENT_GROUP_PROTOCOL, errors.Errorf("invalid protocolType: %s, and this group protocolType is %s", protocolType, group.protocolType)
 		}
-		if !supportsProtocols(group.groupProtocols, protocols) {
+		group.groupMemberLock.RLock()
+		supported := supportsProtocols(group.members, protocols)
+		group.groupMemberLock.RUnlock()
+		if !supported {
 			return codec.INCONSISTENT_GROUP_PROTOCOL, errors.Errorf("protocols not match")
 		}
 	}
@@ -542,15 +720,85 @@ func (g *GroupCoordinatorStandalone) joinGroupProtocolCheck(group *Group, protoc
 	return codec.NONE, nil
 }
 
-func supportsProtocols(groupProtocols map[string]string, memberProtocols []*codec.GroupProtocol) bool {
-	// TODO groupProtocols must be contains memberProtocols
-	return true
+// supportsProtocols the joining member supports at least one protocol all the members support
+func supportsProtocols(members map[string]*memberMetadata, memberProtocols []*codec.GroupProtocol) bool {
+	if len(members) == 0 {
+		return true
+	}
+	candidates := candidateProtocols(members)
+	for _, protocol := range memberProtocols {
+		if candidates[protocol.ProtocolName] {
+			return true
+		}
+	}
+	return false
 }
 
-func matchProtocols(groupProtocols map[string]string, memberProtocols []*codec.GroupProtocol) bool {
+// matchProtocols the member joins with the same protocols and metadata as before, otherwise the group rebalance
+func matchProtocols(member *memberMetadata, memberProtocols []*codec.GroupProtocol) bool {
+	if member == nil || len(member.protocolNames) != len(memberProtocols) {
+		return false
+	}
+	for i, protocol := range memberProtocols {
+		if member.protocolNames[i] != protocol.ProtocolName || !bytes.Equal(member.protocols[protocol.ProtocolName], protocol.ProtocolMetadata) {
+			return false
+		}
+	}
 	return true
 }
 
+// candidateProtocols the protocols supported by every member
+func candidateProtocols(members map[string]*memberMetadata) map[string]bool {
+	candidates := make(map[string]bool)
+	first := true
+	for _, member := range members {
+		if first {
+			for _, name := range member.protocolNames {
+				candidates[name] = true
+			}
+			first = false
+			continue
+		}
+		for name := range candidates {
+			if _, exist := member.protocols[name]; !exist {
+				delete(candidates, name)
+			}
+		}
+	}
+	return candidates
+}
+
+// selectProtocol each member votes for its most preferred candidate protocol, the protocol with the most votes wins
+// and the preference of the oldest member breaks the tie. empty if the members have no common protocol
+func selectProtocol(members map[string]*memberMetadata) string {
+	candidates := candidateProtocols(members)
+	if len(candidates) == 0 {
+		return ""
+	}
+	votes := make(map[string]int)
+	for _, member := range members {
+		for _, name := range member.protocolNames {
+			if candidates[name] {
+				votes[name]++
+				break
+			}
+		}
+	}
+	selected := ""
+	for _, name := range members[oldestMember(members)].protocolNames {
+		if candidates[name] && (selected == "" || votes[name] > votes[selected]) {
+			selected = name
+		}
+	}
+	return selected
+}
+
+func (g *GroupCoordinatorStandalone) memberMatchProtocols(group *Group, memberId string, protocols []*codec.GroupProtocol) bool {
+	group.groupMemberLock.RLock()
+	defer group.groupMemberLock.RUnlock()
+	return matchProtocols(group.members[memberId], protocols)
+}
+
 func (g *GroupCoordinatorStandalone) isMemberLeader(group *Group, memberId string) bool {
 	return g.getMemberLeader(group) == memberId
 }
@@ -568,10 +816,32 @@ func (g *GroupCoordinatorStandalone) setMemberLeader(group *Group, leader string
 	group.groupMemberLock.Unlock()
 }
 
+// deleteMember elect the oldest remaining member when the leader is deleted, so that the group is never leaderless
 func (g *GroupCoordinatorStandalone) deleteMember(group *Group, memberId string) {
 	group.groupMemberLock.Lock()
 	delete(group.members, memberId)
+	if group.leader == memberId {
+		group.leader = oldestMember(group.members)
+		g.logger.Infof("leader %s left group %s, new leader: %s", memberId, group.groupId, group.leader)
+	}
 	group.groupMemberLock.Unlock()
+	// the leaving member may be the one restricting the protocols
+	g.vote(group)
+}
+
+// oldestMember the member joined first, member id breaks the tie. empty if there is no member
+func oldestMember(members map[string]*memberMetadata) string {
+	var oldest *memberMetadata
+	for _, member := range members {
+		if oldest == nil || member.joinTime.Before(oldest.joinTime) ||
+			(member.joinTime.Equal(oldest.joinTime) && member.memberId < oldest.memberId) {
+			oldest = member
+		}
+	}
+	if oldest == nil {
+		return ""
+	}
+	return oldest.memberId
 }
 
 func (g *GroupCoordinatorStandalone) getLeaderMembers(group *Group, memberId string) (members []*codec.Member) {
@@ -593,6 +863,8 @@ func (g *GroupCoordinatorStandalone) checkMemberExist(group *Group, memberId str
 
 func (g *GroupCoordinatorStandalone) awaitingJoin(group *Group, memberId string, rebalanceTickMs int, sessionTimeout int) error {
 	start := time.Now()
+	group.startAwaiting(group.awaitingJoinMembers, memberId, start)
+	defer group.stopAwaiting(group.awaitingJoinMembers, memberId)
 	for {
 		groupGenerationId := g.getGroupGenerationId(group)
 		curMember := group.members[memberId]
@@ -620,7 +892,7 @@ func (g *GroupCoordinatorStandalone) checkJoinMemberGenerationId(group *Group, m
 	for _, member := range group.members {
 		if member.joinGenerationId != g.getGroupGenerationId(group) {
 			group.groupMemberLock.RUnlock()
-			logrus.Debugf("wait for other member join. curMemberId = %s", memberId)
+			g.logger.Debugf("wait for other member join. curMemberId = %s", memberId)
 			return false
 		}
 	}
@@ -630,6 +902,8 @@ func (g *GroupCoordinatorStandalone) checkJoinMemberGenerationId(group *Group, m
 
 func (g *GroupCoordinatorStandalone) awaitingSync(group *Group, rebalanceTickMs int, sessionTimeout int, memberId string) error {
 	start := time.Now()
+	group.startAwaiting(group.awaitingSyncMembers, memberId, start)
+	defer group.stopAwaiting(group.awaitingSyncMembers, memberId)
 	for {
 		if g.checkSyncMemberGenerationId(group, memberId) {
 			return nil
@@ -646,7 +920,7 @@ func (g *GroupCoordinatorStandalone) checkSyncMemberGenerationId(group *Group, m
 	for _, member := range group.members {
 		if member.syncGenerationId != member.joinGenerationId {
 			group.groupMemberLock.RUnlock()
-			logrus.Debugf("wait for other member sync. curMemberId = %s", memberId)
+			g.logger.Debugf("wait for other member sync. curMemberId = %s", memberId)
 			return false
 		}
 	}
@@ -656,19 +930,22 @@ func (g *GroupCoordinatorStandalone) checkSyncMemberGenerationId(group *Group, m
 
 func (g *GroupCoordinatorStandalone) addNewMemberAndReBalance(group *Group, clientId, memberId, protocolType string, protocols []*codec.GroupProtocol) (string, error) {
 	group.groupNewMemberLock.Lock()
-	if g.getGroupMembersLen(group) > 0 && g.getGroupStatus(group) != Stable {
-		logrus.Warnf("new member wait for stable. Current group status is CompletingRebalance.")
-		err := g.awaitingRebalance(group, g.kafsarConfig.RebalanceTickMs, sessionTimeoutMs, Stable)
+	// new members join the preparing rebalance during its delay, wait for the others to complete
+	status := g.getGroupStatus(group)
+	if g.getGroupMembersLen(group) > 0 && status != Stable && status != PreparingRebalance {
+		g.logger.Warnf("new member wait for stable. Current group status is CompletingRebalance.")
+		err := g.awaitingRebalance(group, g.kafsarConfig.RebalanceTickMs, group.sessionTimeoutMs, Stable)
 		// avoid new member joined before sync-consumer leaving the sync loop
 		time.Sleep((time.Duration(g.kafsarConfig.RebalanceTickMs) + 100) * time.Millisecond)
 		if err != nil {
 			group.groupNewMemberLock.Unlock()
-			logrus.Errorf("new member join group %s failed. Current group status is %d, cause: %s, tickMs: %d, timeout: %d",
-				group.groupId, group.groupStatus, err, g.kafsarConfig.RebalanceTickMs, sessionTimeoutMs)
+			g.logger.Errorf("new member join group %s failed. Current group status is %d, cause: %s, tickMs: %d, timeout: %d",
+				group.groupId, group.groupStatus, err, g.kafsarConfig.RebalanceTickMs, group.sessionTimeoutMs)
 			return memberId, err
 		}
 	}
-	memberId, err := g.addMemberAndRebalance(group, clientId, memberId, protocolType, protocols, g.kafsarConfig.InitialDelayedJoinMs)
+	memberId = g.addMember(group, clientId, memberId, protocolType, protocols)
+	g.prepareRebalance(group)
 	group.groupNewMemberLock.Unlock()
-	return memberId, err
+	return memberId, g.doRebalance(group, g.kafsarConfig.InitialDelayedJoinMs)
 }
@@ -199,6 +199,74 @@ func TestHandleJoinGroupMultiMember(t *testing.T) {
 	assert.Equal(t, Stable, groupCoordinator.getGroupStatus(group))
 }
 
+func TestHandleJoinGroupDelayRestart(t *testing.T) {
+	config := KafsarConfig{
+		MaxConsumersPerGroup:     10,
+		GroupMaxSessionTimeoutMs: 30000,
+		InitialDelayedJoinMs:     300,
+		MaxDelayedJoinMs:         3000,
+		RebalanceTickMs:          100,
+	}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, config, nil)
+	resps := make([]*codec.JoinGroupResp, 3)
+	waitGroup := sync.WaitGroup{}
+	for i := range resps {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			resp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+			assert.Nil(t, err)
+			resps[i] = resp
+		}(i)
+		// each member joins within the delay of the previous one
+		time.Sleep(200 * time.Millisecond)
+	}
+	waitGroup.Wait()
+	for _, resp := range resps {
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		assert.Equal(t, 1, resp.GenerationId)
+	}
+	group, err := groupCoordinator.GetGroup(testUsername, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(group.members))
+}
+
+func TestDelayJoin(t *testing.T) {
+	group := &Group{lastJoinTime: time.Now()}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, KafsarConfig{}, nil)
+	start := time.Now()
+	groupCoordinator.delayJoin(group, 100)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// members keep joining, the delay is capped by the max delay
+	groupCoordinator = NewGroupCoordinatorStandalone(PulsarConfig{}, KafsarConfig{MaxDelayedJoinMs: 400}, nil)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				group.groupMemberLock.Lock()
+				group.lastJoinTime = time.Now()
+				group.groupMemberLock.Unlock()
+			}
+		}
+	}()
+	group.groupMemberLock.Lock()
+	group.lastJoinTime = time.Now()
+	group.groupMemberLock.Unlock()
+	start = time.Now()
+	groupCoordinator.delayJoin(group, 100)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
 func oneMemberRebalanceHandler(t *testing.T, groupCoordinator *GroupCoordinatorStandalone, waitGroup *sync.WaitGroup) {
 	rebalanceLock.Lock()
 	// one member join
@@ -392,7 +460,7 @@ func TestMultiConsumerLeaveGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(t, codec.NONE, leaveGroupResp.ErrorCode)
-	assert.Empty(t, group.leader)
+	assert.Equal(t, oldestMember(group.members), group.leader)
 
 	// follower member rejoin group
 	resp2, err = groupCoordinator.HandleJoinGroup(testUsername, groupId, resp2.MemberId, clientId, protocolType, sessionTimeoutMs, protocols)
@@ -403,6 +471,233 @@ func TestMultiConsumerLeaveGroup(t *testing.T) {
 	assert.Equal(t, resp2.MemberId, group.leader)
 }
 
+func TestLeaderLeaveElectOldestMember(t *testing.T) {
+	config := KafsarConfig{
+		MaxConsumersPerGroup:     10,
+		GroupMinSessionTimeoutMs: 0,
+		GroupMaxSessionTimeoutMs: 30000,
+		RebalanceTickMs:          100,
+	}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, config, nil)
+	resp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, memberId, clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	group := groupCoordinator.groupManager[testUsername+groupId]
+	leader := resp.MemberId
+	now := time.Now()
+	group.groupMemberLock.Lock()
+	// the member ids are in the reverse order of the join time
+	group.members["member-c"] = &memberMetadata{clientId: clientId, memberId: "member-c", joinTime: now.Add(time.Second)}
+	group.members["member-b"] = &memberMetadata{clientId: clientId, memberId: "member-b", joinTime: now.Add(2 * time.Second)}
+	group.members["member-a"] = &memberMetadata{clientId: clientId, memberId: "member-a", joinTime: now.Add(3 * time.Second)}
+	group.groupMemberLock.Unlock()
+
+	leaveGroupResp, err := groupCoordinator.HandleLeaveGroup(testUsername, groupId, []*codec.LeaveGroupMember{{MemberId: leader}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, leaveGroupResp.ErrorCode)
+	// elected before any member rejoins, the next sync has a leader
+	assert.Equal(t, "member-c", group.leader)
+
+	leaveGroupResp, err = groupCoordinator.HandleLeaveGroup(testUsername, groupId, []*codec.LeaveGroupMember{{MemberId: "member-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, leaveGroupResp.ErrorCode)
+	assert.Equal(t, "member-c", group.leader)
+}
+
+func TestOldestMember(t *testing.T) {
+	now := time.Now()
+	assert.Empty(t, oldestMember(map[string]*memberMetadata{}))
+	members := map[string]*memberMetadata{
+		"member-b": {memberId: "member-b", joinTime: now},
+		"member-a": {memberId: "member-a", joinTime: now},
+		"member-c": {memberId: "member-c", joinTime: now.Add(-time.Second)},
+	}
+	assert.Equal(t, "member-c", oldestMember(members))
+	delete(members, "member-c")
+	assert.Equal(t, "member-a", oldestMember(members))
+}
+
+func testMember(memberId string, joinTime time.Time, protocolNames ...string) *memberMetadata {
+	member := &memberMetadata{memberId: memberId, joinTime: joinTime}
+	setMemberProtocols(member, protocolType, testProtocols(protocolNames...))
+	return member
+}
+
+func testProtocols(protocolNames ...string) []*codec.GroupProtocol {
+	protocols := make([]*codec.GroupProtocol, len(protocolNames))
+	for i, name := range protocolNames {
+		protocols[i] = &codec.GroupProtocol{ProtocolName: name, ProtocolMetadata: []byte(name + "-metadata")}
+	}
+	return protocols
+}
+
+func TestSelectProtocol(t *testing.T) {
+	now := time.Now()
+	assert.Empty(t, selectProtocol(map[string]*memberMetadata{}))
+	members := map[string]*memberMetadata{
+		"member-a": testMember("member-a", now, "range", "cooperative-sticky"),
+	}
+	assert.Equal(t, "range", selectProtocol(members))
+	// range is not common any more
+	members["member-b"] = testMember("member-b", now.Add(time.Second), "cooperative-sticky", "roundrobin")
+	assert.Equal(t, "cooperative-sticky", selectProtocol(members))
+
+	// tie between the preferences, the oldest member decides
+	members = map[string]*memberMetadata{
+		"member-a": testMember("member-a", now.Add(time.Second), "range", "cooperative-sticky"),
+		"member-b": testMember("member-b", now, "cooperative-sticky", "range"),
+	}
+	assert.Equal(t, "cooperative-sticky", selectProtocol(members))
+	// most votes win
+	members["member-c"] = testMember("member-c", now.Add(2*time.Second), "range", "cooperative-sticky")
+	assert.Equal(t, "range", selectProtocol(members))
+
+	members["member-d"] = testMember("member-d", now.Add(3*time.Second), "roundrobin")
+	assert.Empty(t, selectProtocol(members))
+}
+
+func TestSupportsProtocols(t *testing.T) {
+	now := time.Now()
+	assert.True(t, supportsProtocols(map[string]*memberMetadata{}, testProtocols("range")))
+	members := map[string]*memberMetadata{
+		"member-a": testMember("member-a", now, "range", "cooperative-sticky"),
+		"member-b": testMember("member-b", now, "cooperative-sticky"),
+	}
+	assert.True(t, supportsProtocols(members, testProtocols("roundrobin", "cooperative-sticky")))
+	assert.False(t, supportsProtocols(members, testProtocols("range")))
+}
+
+func TestMatchProtocols(t *testing.T) {
+	member := testMember("member-a", time.Now(), "range", "cooperative-sticky")
+	assert.True(t, matchProtocols(member, testProtocols("range", "cooperative-sticky")))
+	assert.False(t, matchProtocols(member, testProtocols("cooperative-sticky", "range")))
+	assert.False(t, matchProtocols(member, testProtocols("range")))
+	assert.False(t, matchProtocols(nil, testProtocols("range")))
+	changed := testProtocols("range", "cooperative-sticky")
+	changed[0].ProtocolMetadata = []byte("new-subscription")
+	assert.False(t, matchProtocols(member, changed))
+}
+
+func TestJoinGroupOverlappingProtocols(t *testing.T) {
+	config := KafsarConfig{
+		MaxConsumersPerGroup:     10,
+		GroupMaxSessionTimeoutMs: 30000,
+		InitialDelayedJoinMs:     300,
+		RebalanceTickMs:          100,
+	}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, config, nil)
+	leaderProtocols := testProtocols("range", "cooperative-sticky")
+	resp1, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs, leaderProtocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "range", resp1.ProtocolName)
+	syncGroupResp, err := groupCoordinator.HandleSyncGroup(testUsername, groupId, resp1.MemberId, resp1.GenerationId,
+		[]*codec.GroupAssignment{{MemberId: resp1.MemberId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(2)
+	go func() {
+		defer waitGroup.Done()
+		// range is not supported by the other member
+		resp2, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs,
+			testProtocols("roundrobin", "cooperative-sticky"))
+		assert.Nil(t, err)
+		assert.Equal(t, codec.NONE, resp2.ErrorCode)
+		assert.Equal(t, "cooperative-sticky", resp2.ProtocolName)
+	}()
+	go func() {
+		defer waitGroup.Done()
+		time.Sleep(500 * time.Millisecond)
+		heartBeatResp := groupCoordinator.HandleHeartBeat(testUsername, groupId, resp1.MemberId)
+		assert.Equal(t, codec.REBALANCE_IN_PROGRESS, heartBeatResp.ErrorCode)
+		resp3, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, resp1.MemberId, clientId, protocolType, sessionTimeoutMs, leaderProtocols)
+		assert.Nil(t, err)
+		assert.Equal(t, codec.NONE, resp3.ErrorCode)
+		assert.Equal(t, "cooperative-sticky", resp3.ProtocolName)
+	}()
+	waitGroup.Wait()
+
+	// no protocol in common with the members
+	resp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs, testProtocols("sticky"))
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INCONSISTENT_GROUP_PROTOCOL, resp.ErrorCode)
+}
+
+func TestJoinGroupCoordinatorReset(t *testing.T) {
+	config := KafsarConfig{
+		MaxConsumersPerGroup:     10,
+		GroupMaxSessionTimeoutMs: 30000,
+		InitialDelayedJoinMs:     300,
+		RebalanceTickMs:          100,
+	}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, config, nil)
+	done := make(chan *codec.JoinGroupResp)
+	go func() {
+		resp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+		assert.Nil(t, err)
+		done <- resp
+	}()
+	// reset the coordinator state during the delayed join
+	time.Sleep(100 * time.Millisecond)
+	groupCoordinator.mutex.Lock()
+	groupCoordinator.groupManager = make(map[string]*Group)
+	groupCoordinator.mutex.Unlock()
+	resp := <-done
+	assert.Equal(t, codec.NOT_COORDINATOR, resp.ErrorCode)
+	assert.NotEmpty(t, resp.MemberId)
+
+	// the member rejoins with its member id and registers in the new group once
+	rejoinResp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, resp.MemberId, clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, rejoinResp.ErrorCode)
+	assert.Equal(t, resp.MemberId, rejoinResp.MemberId)
+	assert.Equal(t, resp.MemberId, rejoinResp.LeaderId)
+	group, err := groupCoordinator.GetGroup(testUsername, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(group.members))
+	assert.Equal(t, codec.NONE, groupCoordinator.HandleHeartBeat(testUsername, groupId, resp.MemberId).ErrorCode)
+}
+
+func TestJoinGroupVoteProtocol(t *testing.T) {
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil)
+	group := &Group{groupId: groupId, groupStatus: Stable, protocolType: protocolType, members: make(map[string]*memberMetadata)}
+	groupCoordinator.groupManager[testUsername+groupId] = group
+	group.members["member-a"] = testMember("member-a", time.Now(), "range", "cooperative-sticky")
+	groupCoordinator.vote(group)
+	assert.Equal(t, "range", group.supportedProtocol)
+
+	code, err := groupCoordinator.joinGroupProtocolCheck(group, protocolType, testProtocols("roundrobin"), kafsarConfig)
+	assert.NotNil(t, err)
+	assert.Equal(t, codec.INCONSISTENT_GROUP_PROTOCOL, code)
+	code, err = groupCoordinator.joinGroupProtocolCheck(group, protocolType, testProtocols("cooperative-sticky"), kafsarConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, code)
+
+	group.members["member-b"] = testMember("member-b", time.Now(), "cooperative-sticky")
+	groupCoordinator.vote(group)
+	assert.Equal(t, "cooperative-sticky", group.supportedProtocol)
+	// the leader receives the metadata of the selected protocol
+	assert.Equal(t, []byte("cooperative-sticky-metadata"), group.members["member-a"].metadata)
+	groupCoordinator.deleteMember(group, "member-b")
+	assert.Equal(t, "range", group.supportedProtocol)
+	assert.Equal(t, []byte("range-metadata"), group.members["member-a"].metadata)
+}
+
 func TestHeartBeatRebalanceInProgress(t *testing.T) {
 	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil)
 	testMemberId := "test_memberId"
@@ -420,6 +715,38 @@ func TestHeartBeatRebalanceInProgress(t *testing.T) {
 	assert.Equal(t, resp.ErrorCode, codec.REBALANCE_IN_PROGRESS)
 }
 
+func TestHeartBeatCompletingRebalance(t *testing.T) {
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil)
+	leaderId := "test_leader"
+	followerId := "test_follower"
+	lateFollowerId := "test_late_follower"
+	members := make(map[string]*memberMetadata)
+	members[leaderId] = &memberMetadata{memberId: leaderId, joinGenerationId: 2}
+	members[followerId] = &memberMetadata{memberId: followerId, joinGenerationId: 2}
+	members[lateFollowerId] = &memberMetadata{memberId: lateFollowerId, joinGenerationId: 1}
+	groupCoordinator.groupManager[testUsername+groupId] = &Group{
+		groupId:      groupId,
+		groupStatus:  CompletingRebalance,
+		generationId: 2,
+		leader:       leaderId,
+		members:      members,
+	}
+
+	// the leader heartbeats while computing the assignment, it must keep syncing
+	resp := groupCoordinator.HandleHeartBeat(testUsername, groupId, leaderId)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	resp = groupCoordinator.HandleHeartBeat(testUsername, groupId, followerId)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	// the follower missed the join of this generation
+	resp = groupCoordinator.HandleHeartBeat(testUsername, groupId, lateFollowerId)
+	assert.Equal(t, codec.REBALANCE_IN_PROGRESS, resp.ErrorCode)
+
+	// a new member triggered another generation, the leader must rejoin
+	groupCoordinator.groupManager[testUsername+groupId].generationId = 3
+	resp = groupCoordinator.HandleHeartBeat(testUsername, groupId, leaderId)
+	assert.Equal(t, codec.REBALANCE_IN_PROGRESS, resp.ErrorCode)
+}
+
 func TestHeartBeatInvalidGroupId(t *testing.T) {
 	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil)
 	resp := groupCoordinator.HandleHeartBeat(testUsername, "", "")
@@ -443,3 +770,91 @@ func TestHeartBeatNone(t *testing.T) {
 	resp := groupCoordinator.HandleHeartBeat(testUsername, groupId, testMemberId)
 	assert.Equal(t, resp.ErrorCode, codec.NONE)
 }
+
+func TestAwaitingMetrics(t *testing.T) {
+	config := KafsarConfig{
+		MaxConsumersPerGroup:     10,
+		GroupMinSessionTimeoutMs: 0,
+		GroupMaxSessionTimeoutMs: 30000,
+		InitialDelayedJoinMs:     100,
+		RebalanceTickMs:          100,
+	}
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, config, nil)
+	resp1, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, memberId, clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, resp1.ErrorCode)
+	syncGroupResp, err := groupCoordinator.HandleSyncGroup(testUsername, groupId, resp1.MemberId, resp1.GenerationId,
+		[]*codec.GroupAssignment{{MemberId: resp1.MemberId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+	metrics, err := groupCoordinator.GetAwaitingMetrics(testUsername, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, metrics.AwaitingJoin)
+	assert.Equal(t, 0, metrics.AwaitingSync)
+
+	// new member join, the old member stalls and never rejoin
+	joinTimeoutMs := 2000
+	done := make(chan struct{})
+	go func() {
+		resp2, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, joinTimeoutMs, protocols)
+		assert.Nil(t, err)
+		assert.Equal(t, codec.COORDINATOR_LOAD_IN_PROGRESS, resp2.ErrorCode)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		metrics, err = groupCoordinator.GetAwaitingMetrics(testUsername, groupId)
+		return err == nil && metrics.AwaitingJoin == 1
+	}, time.Duration(joinTimeoutMs)*time.Millisecond, 10*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	metrics, err = groupCoordinator.GetAwaitingMetrics(testUsername, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, metrics.AwaitingJoin)
+	assert.Equal(t, 0, metrics.AwaitingSync)
+	assert.GreaterOrEqual(t, metrics.LongestWait, 500*time.Millisecond)
+
+	<-done
+	metrics, err = groupCoordinator.GetAwaitingMetrics(testUsername, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, metrics.AwaitingJoin)
+	assert.Equal(t, time.Duration(0), metrics.LongestWait)
+}
+
+func TestDeleteGroups(t *testing.T) {
+	groupCoordinator := NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil)
+	resp, err := groupCoordinator.HandleJoinGroup(testUsername, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	results, err := groupCoordinator.DeleteGroups(testUsername, []string{groupId, "unknown-group", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NON_EMPTY_GROUP, results[0].ErrorCode)
+	assert.Equal(t, codec.GROUP_ID_NOT_FOUND, results[1].ErrorCode)
+	assert.Equal(t, codec.INVALID_GROUP_ID, results[2].ErrorCode)
+
+	_, err = groupCoordinator.HandleLeaveGroup(testUsername, groupId, []*codec.LeaveGroupMember{{MemberId: resp.MemberId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := groupCoordinator.groupManager[testUsername+groupId]
+	results, err = groupCoordinator.DeleteGroups(testUsername, []string{groupId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	assert.Equal(t, Dead, group.groupStatus)
+	_, exist := groupCoordinator.groupManager[testUsername+groupId]
+	assert.False(t, exist)
+}
@@ -0,0 +1,93 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+type kafkaPartition struct {
+	topic     string
+	partition int
+}
+
+type PartitionLag struct {
+	Topic     string
+	Partition int
+	// CommittedOffset is constant.UnknownOffset if the group never committed the partition
+	CommittedOffset int64
+	HighWatermark   int64
+	// Lag is constant.UnknownOffset if the group never committed the partition
+	Lag int64
+}
+
+// GroupLag compute the lag of every partition the group subscribes to, the difference between the high watermark
+// and the committed offset
+func (b *Broker) GroupLag(username, groupId string) ([]PartitionLag, error) {
+	group, err := b.groupCoordinator.GetGroup(username, groupId)
+	if err != nil {
+		return nil, err
+	}
+	partitionedTopics := group.subscribedTopics()
+	result := make([]PartitionLag, 0, len(partitionedTopics))
+	for _, partitionedTopic := range partitionedTopics {
+		b.mutex.RLock()
+		kafkaPartition, exist := b.kafkaPartitions[username+partitionedTopic]
+		b.mutex.RUnlock()
+		if !exist {
+			b.logger.Warnf("unknown kafka partition of topic %s, skip lag of group %s", partitionedTopic, groupId)
+			continue
+		}
+		highWatermark, err := b.highWatermark(partitionedTopic)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get high watermark of %s failed", partitionedTopic)
+		}
+		lag := PartitionLag{
+			Topic:           kafkaPartition.topic,
+			Partition:       kafkaPartition.partition,
+			CommittedOffset: constant.UnknownOffset,
+			HighWatermark:   highWatermark,
+			Lag:             constant.UnknownOffset,
+		}
+		committed, exist := b.offsetManager.AcquireOffset(username, kafkaPartition.topic, groupId, kafkaPartition.partition)
+		if exist {
+			lag.CommittedOffset = committed.Offset
+			lag.Lag = highWatermark - committed.Offset
+		}
+		result = append(result, lag)
+	}
+	return result, nil
+}
+
+// highWatermark the offset of the latest message in the partitioned topic, constant.DefaultOffset if empty
+func (b *Broker) highWatermark(partitionedTopic string) (int64, error) {
+	msgIdBytes, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
+	if err != nil {
+		return 0, err
+	}
+	lastedMsg, err := utils.ReadLastedMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, msgIdBytes, b.pulsarCommonClient)
+	if err != nil {
+		return 0, err
+	}
+	if lastedMsg == nil {
+		return constant.DefaultOffset, nil
+	}
+	return convOffset(lastedMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+}
@@ -0,0 +1,50 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/pkg/errors"
+	"sync/atomic"
+)
+
+var (
+	errListenerNotAccepting  = errors.New("kafka listener is not accepting connections")
+	errOffsetManagerNotReady = errors.New("offset manager is not started")
+)
+
+// Healthy report whether the broker is live: the kafka listener accepts connections and pulsar is reachable.
+// nil means healthy, e.g. to answer a liveness probe
+func (b *Broker) Healthy() error {
+	if b.kafkaServer == nil || !b.kafkaServer.Accepting() {
+		return errListenerNotAccepting
+	}
+	if err := utils.CheckBrokerHealth(b.getPulsarHttpUrl()); err != nil {
+		return errors.Wrap(err, "pulsar is not reachable")
+	}
+	return nil
+}
+
+// Ready report whether the broker can serve requests: healthy and the offset manager replayed the stored offsets.
+// nil means ready, e.g. to answer a readiness probe
+func (b *Broker) Ready() error {
+	if atomic.LoadInt32(&b.offsetManagerReady) == 0 {
+		return errOffsetManagerNotReady
+	}
+	return b.Healthy()
+}
@@ -0,0 +1,70 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/network"
+	"github.com/panjf2000/gnet"
+	"github.com/protocol-laboratory/kafka-codec-go/kgnet"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthyAndReady(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	var pulsarHealthy int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&pulsarHealthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+	k.kafkaServer, err = network.NewServer(&kgnet.GnetServerConfig{}, &network.KafkaProtocolConfig{}, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, errListenerNotAccepting, k.Healthy())
+
+	k.kafkaServer.OnInitComplete(gnet.Server{})
+	assert.Nil(t, k.Healthy())
+	assert.Equal(t, errOffsetManagerNotReady, k.Ready())
+
+	atomic.StoreInt32(&k.offsetManagerReady, 1)
+	assert.Nil(t, k.Ready())
+
+	atomic.StoreInt32(&pulsarHealthy, 0)
+	assert.NotNil(t, k.Healthy())
+	assert.NotNil(t, k.Ready())
+
+	atomic.StoreInt32(&pulsarHealthy, 1)
+	k.kafkaServer.StopAccept()
+	assert.Equal(t, errListenerNotAccepting, k.Ready())
+}
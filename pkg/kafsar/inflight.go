@@ -0,0 +1,71 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"sync"
+)
+
+// inflightTracker count the running produce and fetch handlers, so that close can wait for them to drain
+type inflightTracker struct {
+	mutex   sync.Mutex
+	count   int
+	closing bool
+	drained chan struct{}
+}
+
+// acquire return false if the broker is closing, the caller must not handle the request
+func (i *inflightTracker) acquire() bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.closing {
+		return false
+	}
+	i.count++
+	return true
+}
+
+func (i *inflightTracker) release() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.count--
+	if i.closing && i.count == 0 {
+		close(i.drained)
+	}
+}
+
+// drain reject new requests and wait until the running ones complete or ctx done
+func (i *inflightTracker) drain(ctx context.Context) error {
+	i.mutex.Lock()
+	if !i.closing {
+		i.closing = true
+		i.drained = make(chan struct{})
+		if i.count == 0 {
+			close(i.drained)
+		}
+	}
+	drained := i.drained
+	i.mutex.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,56 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestInflightDrain(t *testing.T) {
+	tracker := inflightTracker{}
+	assert.True(t, tracker.acquire())
+	drained := make(chan error)
+	go func() {
+		drained <- tracker.drain(context.Background())
+	}()
+	// wait until draining started
+	for tracker.acquire() {
+		tracker.release()
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-drained:
+		t.Fatal("drain returned with in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tracker.release()
+	assert.Nil(t, <-drained)
+}
+
+func TestInflightDrainTimeout(t *testing.T) {
+	tracker := inflightTracker{}
+	assert.True(t, tracker.acquire())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, tracker.drain(ctx))
+	assert.False(t, tracker.acquire())
+	tracker.release()
+}
@@ -18,19 +18,34 @@
 package kafsar
 
 import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
 	"github.com/protocol-laboratory/kafka-codec-go/kgnet"
 )
 
 type Config struct {
 	PulsarConfig PulsarConfig
 	KafsarConfig KafsarConfig
-	TraceConfig  NoErrorTracer
+	// TraceConfig e.g. SkywalkingTracerConfig, OtelTracerConfig exporting to jaeger or OtlpTracerConfig; NoopTracer when nil
+	TraceConfig NoErrorTracer
+	// OffsetManager custom offset manager, KafsarConfig.OffsetStoreType is ignored when set
+	OffsetManager OffsetManager
+	// Metrics record produce, fetch and group metrics, e.g. NewPrometheusMetrics; disabled when nil
+	Metrics Metrics
+	// Logger route the logs of kafsar, default the logrus standard logger
+	Logger Logger
 }
 
 type PulsarConfig struct {
 	Host     string
 	HttpPort int
 	TcpPort  int
+	// OperationTimeoutMs timeout of pulsar producer and reader creations, 0 means pulsar client default 30s
+	OperationTimeoutMs int
+	// ConnectionTimeoutMs timeout of establishing pulsar connections, 0 means pulsar client default 10s
+	ConnectionTimeoutMs int
+	// KeepAliveIntervalMs interval of the pings on pulsar connections, 0 means pulsar client default 30s.
+	// the pulsar client in use always pings every 30s, other values are rejected
+	KeepAliveIntervalMs int
 }
 
 type KafsarConfig struct {
@@ -38,33 +53,158 @@ type KafsarConfig struct {
 	GnetConfig kgnet.GnetServerConfig
 	NeedSasl   bool
 	MaxConn    int32
+	// MaxInflightRequestsPerConn limit concurrent produce and fetch requests of one connection, 0 means no limit
+	MaxInflightRequestsPerConn int32
+	// ConnectionIdleTimeoutMs close connections receiving no request for the duration, 0 means never.
+	// should exceed the produce and fetch wait time
+	ConnectionIdleTimeoutMs int
+	// ConnectionMaxLifetimeMs close connections opened for the duration, clients reconnect and rebalance across brokers.
+	// 0 means never
+	ConnectionMaxLifetimeMs int
 
 	// Kafka protocol config
 	ClusterId     string
+	NodeId        int32
 	AdvertiseHost string
 	AdvertisePort int
+	// MaxApiVersions cap the max version advertised in ApiVersions per api key, down to a version kafsar supports.
+	// work around buggy clients
+	MaxApiVersions map[codec.ApiCode]int16
 
 	MaxProducerRecordSize int
 	MaxBatchSize          int
+	// ProduceFlushTimeoutMs send deadline of a produce batch, caps the produce request timeout if set
+	ProduceFlushTimeoutMs int
+	// ProducerQueueFullFailFast reject the produce with REQUEST_TIMED_OUT when the pulsar producer queue is full,
+	// instead of blocking until the produce timeout
+	ProducerQueueFullFailFast bool
+	// PulsarClientPerUser produce and read with a pooled pulsar client per user instead of the shared client,
+	// so that a noisy user can not starve the others on the same pulsar connections
+	PulsarClientPerUser bool
+	// UserMaxConnectionsPerBroker connections of a user's pulsar client to each pulsar broker, 0 means pulsar default.
+	// only with PulsarClientPerUser
+	UserMaxConnectionsPerBroker int
+	// UserMaxPendingProduceBytes limit the produced bytes of a user not acknowledged by pulsar yet, 0 means no limit.
+	// only with PulsarClientPerUser
+	UserMaxPendingProduceBytes int
+	// PulsarDeduplication produce the batches of idempotent kafka producers by a pulsar producer per kafka producer and
+	// partition, with the kafka sequences as pulsar sequence ids, so that pulsar drops the retried batches.
+	// deduplication must be enabled on the pulsar namespace or topic
+	PulsarDeduplication bool
+	// BatchingMaxPublishDelayMs batching delay of pulsar producers, default 10ms.
+	// the offset of a produce is known after its batch is published, so produce waits up to the batching delay
+	BatchingMaxPublishDelayMs int
+	// ProduceFlushThresholdMs flush the producer after sending a produce batch if the batching delay exceeds it,
+	// so that the offset returns promptly. 0 means never flush
+	ProduceFlushThresholdMs int
+	// ChunkLargeMessage split a record value larger than MaxMessageBytes into ordered pulsar messages, the fetch
+	// reassembles them. it changes how records are stored, consumers reading the pulsar topic directly see the chunks
+	ChunkLargeMessage bool
+	// MaxMessageBytes max value bytes of a pulsar message, default constant.DefaultMaxMessageBytes. only with ChunkLargeMessage
+	MaxMessageBytes int
+	// MaxDecompressedBytes max bytes of the zstd compressed record batches of a produce request after decompression,
+	// larger requests close the connection. default MaxMessageBytes
+	MaxDecompressedBytes int
+	// MaxTimestampSkewMs max difference between record timestamps and server time, 0 means no validation
+	MaxTimestampSkewMs int64
+	// ClampInvalidTimestamp clamp out of range record timestamps to server time instead of rejecting with INVALID_TIMESTAMP
+	ClampInvalidTimestamp bool
+	// AcceptTransactionalProduce produce transactional records non-transactionally instead of rejecting them
+	AcceptTransactionalProduce bool
+	// LogAppendTime produce returns the pulsar publish time of the last message as the append time, otherwise -1
+	LogAppendTime bool
+	// ProduceLogStartOffset produce returns the earliest offset of the partition, cached for BacklogCacheMs
+	ProduceLogStartOffset bool
 
 	MaxConsumersPerGroup     int
 	GroupMinSessionTimeoutMs int
 	GroupMaxSessionTimeoutMs int
+	// ConsumerReceiveQueueSize receive queue of the pulsar readers, as many messages are prefetched to the reader channel
 	ConsumerReceiveQueueSize int
 	MaxFetchRecord           int
 	MinFetchWaitMs           int
 	MaxFetchWaitMs           int
 	ContinuousOffset         bool
+	// FetchConcurrency max partitions of a fetch request read at the same time, 0 or 1 reads them one by one
+	FetchConcurrency int
+	// MultiplexFetch read the partitions of a topic fetched by a client in a single goroutine draining the channels of
+	// their readers, instead of a goroutine per partition
+	MultiplexFetch bool
+	// DetectNonPartitionedTopic use the bare pulsar topic as partition 0 if it is non-partitioned, checked by the admin api
+	DetectNonPartitionedTopic bool
+	// OffsetOverflowUseIndex use broker entry index as offset when message id overflow int64
+	OffsetOverflowUseIndex bool
 	// PulsarTenant use for kafsar internal
 	PulsarTenant string
 	// PulsarNamespace use for kafsar internal
 	PulsarNamespace string
 	// OffsetTopic use to store kafka offset
 	OffsetTopic string
+	// OffsetStoreType enum: OffsetStorePulsar, OffsetStoreMemory; default OffsetStorePulsar
+	OffsetStoreType OffsetStoreType
+	// OffsetRetentionMs remove the committed offsets of groups without members once the commit is older than the duration,
+	// like kafka offsets.retention.minutes. 0 means offsets never expire, they never expire with the Cluster group coordinator either
+	OffsetRetentionMs int
+	// OffsetRetentionCheckIntervalMs how often the expired offsets are removed, default 10 minutes
+	OffsetRetentionCheckIntervalMs int
+	// OffsetManagerStartTimeoutMs abort NewKafsar if the offset manager is not ready in time, 0 means wait forever
+	OffsetManagerStartTimeoutMs int
 	// GroupCoordinatorType enum: Standalone, Cluster; default Standalone
 	GroupCoordinatorType GroupCoordinatorType
-	// InitialDelayedJoinMs
+	// ClusterNodes the kafsar nodes returned by DescribeCluster in Cluster mode, this node is always included.
+	// FindCoordinator picks the owner of a group among them
+	ClusterNodes []ClusterNode
+	// InitialDelayedJoinMs time the rebalance waits for more members, restarts when a member joins
 	InitialDelayedJoinMs int
+	// MaxDelayedJoinMs max time the rebalance waits for more members, the delay does not restart if not greater than InitialDelayedJoinMs
+	MaxDelayedJoinMs int
 	// RebalanceTickMs
 	RebalanceTickMs int
+	// TagSourceCluster set ClusterId as a property on every produced message
+	TagSourceCluster bool
+	// FilterSourceCluster skip messages produced by this cluster when fetching
+	FilterSourceCluster bool
+	// LazyCreateReader defer reader creation from OffsetFetch until the partition is fetched
+	LazyCreateReader bool
+	// CloseGracePeriodMs max time Close waits for in-flight produce and fetch requests, default 10s
+	CloseGracePeriodMs int
+	// FailoverSubscriptionGroups groups read by a pulsar consumer with failover subscription instead of a reader,
+	// so that exactly one member reads a partition
+	FailoverSubscriptionGroups []string
+	// ReaderDrainTimeoutMs max time Close waits for the fetched messages of readers to be committed before closing
+	// the readers, 0 closes the readers without waiting
+	ReaderDrainTimeoutMs int
+	// RecoverReaderOnFetch create the reader from the committed offset when a stable member fetch before offset fetch
+	RecoverReaderOnFetch bool
+	// AutoOffsetReset earliest or latest, where a reader starts when the group has no committed offset, default earliest.
+	// the client still applies its own auto.offset.reset by listing offsets afterwards
+	AutoOffsetReset string
+	// ListOffsetsKeepReaderPosition list offsets without seeking the reader of the client,
+	// so that a latest or earliest offset query does not skip or redeliver the messages of the consumer
+	ListOffsetsKeepReaderPosition bool
+	// SeekToCommittedOnFetch seek the reader forward when it is behind the committed offset of the group, skip committed messages
+	SeekToCommittedOnFetch bool
+	// SeekToFetchOffset seek the reader to the fetch offset when the client fetches from another position, e.g. seeks backward
+	SeekToFetchOffset bool
+	// CheckFetchOffsetRange reject fetch offsets before the log start or beyond the high watermark with OFFSET_OUT_OF_RANGE.
+	// only with ContinuousOffset, the offsets packed from message ids are not ordered
+	CheckFetchOffsetRange bool
+	// CheckCommitOffsetRange reject commit offsets beyond the high watermark with OFFSET_OUT_OF_RANGE, only with ContinuousOffset
+	CheckCommitOffsetRange bool
+	// CreationFailureThreshold consecutive reader or producer creation failures that open the circuit breaker,
+	// creations then fail fast with a retriable error for CreationCooldownMs. 0 means never open
+	CreationFailureThreshold int
+	// CreationCooldownMs how long the circuit breaker stays open, default 5s
+	CreationCooldownMs int
+	// ReaderCreateMaxRetries retries of a reader creation failed with a retryable pulsar error, e.g. the topic bundle
+	// is being transferred. the broker lock is held while retrying. default 3, negative means no retry
+	ReaderCreateMaxRetries int
+	// ReaderCreateRetryBackoffMs the backoff before the first retry, doubled for each retry, default 100ms
+	ReaderCreateRetryBackoffMs int
+	// BacklogCacheMs how long SubscriptionBacklog caches the pulsar subscription backlog, default 5s
+	BacklogCacheMs int
+	// TopicCacheTtlMs cache Server.PulsarTopic and Server.PartitionNum results, 0 means no cache
+	TopicCacheTtlMs int
+	// VerifyMemberIdentity reject sync group from a connection which did not join with the member id
+	VerifyMemberIdentity bool
 }
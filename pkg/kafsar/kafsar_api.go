@@ -24,14 +24,38 @@ type Server interface {
 
 	AuthTopicGroup(username string, password, clientId, consumerGroup string) (bool, error)
 
-	SubscriptionName(groupId string) (string, error)
+	// SubscriptionName the pulsar subscription of the group, should be unique per user if users share pulsar topics
+	SubscriptionName(username, groupId string) (string, error)
 
 	// PulsarTopic the corresponding topic in pulsar
 	PulsarTopic(username, topic string) (string, error)
 
 	PartitionNum(username, topic string) (int, error)
 
+	// ListTopic return empty if the user is authorized to see no topics, return error only if the backend failed
 	ListTopic(username string) ([]string, error)
 
-	HasFlowQuota(username, topic string) bool
+	// ReserveFetchQuota reserve a budget of bytes the fetch of the topic may read, once per partition fetch.
+	// granted may be less than the requested bytes, 0 means no quota left
+	ReserveFetchQuota(username, topic string, bytes int) (granted int)
+}
+
+// TopicMapper is optionally implemented by Server to override how a kafka partition maps to a pulsar topic,
+// e.g. a non-partitioned kafka topic to a single pulsar topic. Without it, the partition is mapped to
+// PulsarTopic with the pulsar partition suffix
+type TopicMapper interface {
+	PartitionedPulsarTopic(username, kafkaTopic string, partition int) (string, error)
+}
+
+// FetchWaitResolver is optionally implemented by Server to override MinFetchWaitMs per topic, e.g. near zero for
+// low latency control topics and higher for bulk topics. ok false falls back to MinFetchWaitMs
+type FetchWaitResolver interface {
+	MinFetchWaitMs(username, kafkaTopic string) (minFetchWaitMs int, ok bool)
+}
+
+// QuotaResolver is optionally implemented by Server to limit the produce and fetch byte rate of each user. clients
+// exceeding the quota are asked to back off by the throttle time of the responses instead of being refused.
+// 0 means no quota
+type QuotaResolver interface {
+	ByteRateQuota(username string) (produceBytesPerSec, fetchBytesPerSec int64)
 }
@@ -18,7 +18,6 @@
 package kafsar
 
 import (
-	"container/list"
 	"context"
 	"fmt"
 	"github.com/apache/pulsar-client-go/pulsar"
@@ -27,8 +26,8 @@ import (
 	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
-	"github.com/sirupsen/logrus"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -44,23 +43,58 @@ type Broker struct {
 	groupCoordinator   GroupCoordinator
 	kafsarConfig       KafsarConfig
 	readerManager      map[string]*ReaderMetadata
+	pendingReaders     map[string]*pendingReaderMetadata
 	mutex              sync.RWMutex
 	userInfoManager    map[string]*userInfo
 	offsetManager      OffsetManager
+	offsetManagerReady int32 // 1 once the offset manager replayed the stored offsets
 	memberManager      map[string]*MemberInfo
 	topicGroupManager  map[string]string
+	kafkaPartitions    map[string]kafkaPartition // username and partitioned topic to kafka topic and partition
+	nonPartitioned     map[string]bool           // cached non-partitioned determination of pulsar topics
+	pulsarTopicCache   map[string]cachedPulsarTopic
+	partitionNumCache  map[string]cachedPartitionNum
 	producerManager    map[string]pulsar.Producer
+	producerCreating   map[string]*producerCreation
+	readerCreating     map[string]*readerCreation
+	pendingProduce     *pendingProduce
+	readerBreaker      *circuitBreaker
+	producerBreaker    *circuitBreaker
+	producerStates     *producerStateManager
+	backlogCache       *backlogCache
+	logStartOffsets    *backlogCache
+	produceQuota       *byteRateQuota
+	fetchQuota         *byteRateQuota
+	userClients        *userClients
+	userPendingBytes   *pendingProduce // produced bytes not acknowledged per user
+	inflight           inflightTracker
+	stopCh             chan struct{}
+	stopOnce           sync.Once
+	metrics            Metrics
+	logger             Logger
 	tracer             NoErrorTracer // common tracer
 }
 
+var errUnknownPartition = errors.New("unknown partition")
+
+var (
+	// ErrPulsarUnreachable NewKafsar failed to start the offset manager and pulsar does not pass the health check
+	ErrPulsarUnreachable = errors.New("pulsar unreachable")
+	// ErrOffsetTopicInit NewKafsar failed to start the offset manager from the offset topic while pulsar is reachable
+	ErrOffsetTopicInit = errors.New("offset topic init failed")
+)
+
 type userInfo struct {
 	username string
+	password string
 	clientId string
 }
 
 type MessageIdPair struct {
 	MessageId pulsar.MessageID
 	Offset    int64
+	// Metadata the metadata of the offset commit, returned by offset fetch
+	Metadata string
 }
 
 type MemberInfo struct {
@@ -72,180 +106,876 @@ type MemberInfo struct {
 
 func NewKafsar(impl Server, config *Config) (*Broker, error) {
 	broker := Broker{server: impl, pulsarConfig: config.PulsarConfig, kafsarConfig: config.KafsarConfig}
-	pulsarUrl := fmt.Sprintf("pulsar://%s:%d", broker.pulsarConfig.Host, broker.pulsarConfig.TcpPort)
-	var err error
-	pulsarClient, err := pulsar.NewClient(pulsar.ClientOptions{URL: pulsarUrl})
+	err := checkKeepAliveInterval(config.PulsarConfig)
+	if err != nil {
+		return nil, err
+	}
+	pulsarClient, err := pulsar.NewClient(broker.pulsarClientOptions())
 	if err != nil {
 		return nil, err
 	}
 	pulsarAddr := broker.getPulsarHttpUrl()
-	broker.offsetManager, err = NewOffsetManager(pulsarClient, config.KafsarConfig, pulsarAddr)
+	if config.OffsetManager != nil {
+		broker.offsetManager = config.OffsetManager
+	} else if broker.kafsarConfig.OffsetStoreType == OffsetStorePulsar {
+		broker.offsetManager, err = NewOffsetManager(pulsarClient, config.KafsarConfig, pulsarAddr)
+		if err != nil {
+			err = broker.offsetManagerStartError(err)
+		}
+	} else if broker.kafsarConfig.OffsetStoreType == OffsetStoreMemory {
+		broker.offsetManager = NewOffsetManagerMemory()
+	} else {
+		err = errors.Errorf("unexpect OffsetStoreType: %v", broker.kafsarConfig.OffsetStoreType)
+	}
 	if err != nil {
 		pulsarClient.Close()
 		return nil, err
 	}
 
-	offsetChannel := broker.offsetManager.Start()
-	for {
-		if <-offsetChannel {
-			break
-		}
+	broker.metrics = config.Metrics
+	if broker.metrics == nil {
+		broker.metrics = noopMetrics{}
+	}
+	broker.logger = config.Logger
+	if broker.logger == nil {
+		broker.logger = NewLogrusLogger(nil)
+	}
+	err = broker.waitOffsetManagerStart()
+	if err != nil {
+		broker.offsetManager.Close()
+		pulsarClient.Close()
+		return nil, broker.offsetManagerStartError(err)
 	}
 	if broker.kafsarConfig.GroupCoordinatorType == Cluster {
-		broker.groupCoordinator = NewGroupCoordinatorCluster()
+		broker.groupCoordinator = NewGroupCoordinatorCluster(broker.clusterNodes())
 	} else if broker.kafsarConfig.GroupCoordinatorType == Standalone {
-		broker.groupCoordinator = NewGroupCoordinatorStandalone(broker.pulsarConfig, broker.kafsarConfig, pulsarClient)
+		coordinator := NewGroupCoordinatorStandalone(broker.pulsarConfig, broker.kafsarConfig, pulsarClient)
+		coordinator.metrics = broker.metrics
+		coordinator.logger = broker.logger
+		broker.groupCoordinator = coordinator
 	} else {
+		broker.offsetManager.Close()
+		pulsarClient.Close()
 		return nil, errors.Errorf("unexpect GroupCoordinatorType: %v", broker.kafsarConfig.GroupCoordinatorType)
 	}
+	broker.applyOffsetRetention()
 	broker.pulsarCommonClient = pulsarClient
 	broker.readerManager = make(map[string]*ReaderMetadata)
+	broker.pendingReaders = make(map[string]*pendingReaderMetadata)
 	broker.userInfoManager = make(map[string]*userInfo)
 	broker.memberManager = make(map[string]*MemberInfo)
 	broker.pulsarClientManage = make(map[string]pulsar.Client)
 	broker.topicGroupManager = make(map[string]string)
+	broker.kafkaPartitions = make(map[string]kafkaPartition)
+	broker.nonPartitioned = make(map[string]bool)
+	broker.pulsarTopicCache = make(map[string]cachedPulsarTopic)
+	broker.partitionNumCache = make(map[string]cachedPartitionNum)
 	broker.producerManager = make(map[string]pulsar.Producer)
+	broker.producerCreating = make(map[string]*producerCreation)
+	broker.readerCreating = make(map[string]*readerCreation)
+	broker.pendingProduce = newPendingProduce()
+	broker.readerBreaker = newCreationBreaker(broker.kafsarConfig)
+	broker.producerBreaker = newCreationBreaker(broker.kafsarConfig)
+	broker.producerStates = newProducerStateManager()
+	broker.backlogCache = newBacklogCache()
+	broker.logStartOffsets = newBacklogCache()
+	broker.produceQuota = newByteRateQuota()
+	broker.fetchQuota = newByteRateQuota()
+	broker.userClients = newUserClients()
+	broker.userPendingBytes = newPendingProduce()
+	broker.stopCh = make(chan struct{})
 	kfkProtocolConfig := &network.KafkaProtocolConfig{}
 	kfkProtocolConfig.ClusterId = config.KafsarConfig.ClusterId
+	kfkProtocolConfig.NodeId = config.KafsarConfig.NodeId
 	kfkProtocolConfig.AdvertiseHost = config.KafsarConfig.AdvertiseHost
 	kfkProtocolConfig.AdvertisePort = config.KafsarConfig.AdvertisePort
 	kfkProtocolConfig.NeedSasl = config.KafsarConfig.NeedSasl
 	kfkProtocolConfig.MaxConn = config.KafsarConfig.MaxConn
+	kfkProtocolConfig.MaxInflightRequestsPerConn = config.KafsarConfig.MaxInflightRequestsPerConn
+	kfkProtocolConfig.MaxApiVersions = config.KafsarConfig.MaxApiVersions
+	kfkProtocolConfig.ConnectionIdleTimeoutMs = config.KafsarConfig.ConnectionIdleTimeoutMs
+	kfkProtocolConfig.ConnectionMaxLifetimeMs = config.KafsarConfig.ConnectionMaxLifetimeMs
+	kfkProtocolConfig.MaxDecompressedBytes = config.KafsarConfig.MaxDecompressedBytes
+	if kfkProtocolConfig.MaxDecompressedBytes <= 0 {
+		kfkProtocolConfig.MaxDecompressedBytes = broker.maxMessageBytes()
+	}
 	var aux network.KafsarServer = &broker
 	broker.kafkaServer, err = network.NewServer(&config.KafsarConfig.GnetConfig, kfkProtocolConfig, aux)
 	if err != nil {
+		broker.offsetManager.Close()
+		pulsarClient.Close()
 		return nil, err
 	}
 	if config.TraceConfig == nil {
-		config.TraceConfig = &SkywalkingTracerConfig{}
+		config.TraceConfig = NoopTracer{}
 	}
 	broker.tracer = config.TraceConfig
 	broker.tracer.NewProvider()
 	return &broker, nil
 }
 
+// waitOffsetManagerStart wait until the offset manager replayed the stored offsets, fail or timeout
+func (b *Broker) waitOffsetManagerStart() error {
+	offsetChannel, errChannel := b.offsetManager.Start()
+	var timeout <-chan time.Time
+	if b.kafsarConfig.OffsetManagerStartTimeoutMs > 0 {
+		timer := time.NewTimer(time.Duration(b.kafsarConfig.OffsetManagerStartTimeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case ready := <-offsetChannel:
+			if ready {
+				atomic.StoreInt32(&b.offsetManagerReady, 1)
+				return nil
+			}
+		case err := <-errChannel:
+			return errors.Wrap(err, "start offset manager failed")
+		case <-timeout:
+			return errors.Errorf("start offset manager timeout after %d ms", b.kafsarConfig.OffsetManagerStartTimeoutMs)
+		}
+	}
+}
+
+// offsetManagerStartError tell an unreachable pulsar from an offset topic init failure by the pulsar health check
+func (b *Broker) offsetManagerStartError(err error) error {
+	if healthErr := utils.CheckBrokerHealth(b.getPulsarHttpUrl()); healthErr != nil {
+		return errors.Wrapf(ErrPulsarUnreachable, "%s, health check: %s", err, healthErr)
+	}
+	return errors.Wrap(ErrOffsetTopicInit, err.Error())
+}
+
 func (b *Broker) Run() error {
-	logrus.Info("kafsar started")
+	b.logger.Infof("kafsar started")
+	if b.offsetRetentionEnabled() {
+		go b.runOffsetRetention()
+	}
 	return b.kafkaServer.Run()
 }
 
-func (b *Broker) Produce(addr net.Addr, kafkaTopic string, partition int, req *codec.ProducePartitionReq) (*codec.ProducePartitionResp, error) {
-	span := b.tracer.NewSpan(context.Background(), "Produce", "broker produce msg starting")
+// Produce produce the record batch of the partition request, see ProduceBatches
+func (b *Broker) Produce(ctx context.Context, addr net.Addr, kafkaTopic string, partition int, timeoutMs int, req *codec.ProducePartitionReq) (*codec.ProducePartitionResp, error) {
+	return b.ProduceBatches(ctx, addr, kafkaTopic, partition, timeoutMs, []*codec.RecordBatch{req.RecordBatch})
+}
+
+// ProduceBatches the record batches of a partition are sent in order until ctx is canceled, e.g. the connection is closed
+func (b *Broker) ProduceBatches(ctx context.Context, addr net.Addr, kafkaTopic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (resp *codec.ProducePartitionResp, err error) {
+	span := b.tracer.NewSpan(ctx, "Produce", "broker produce msg starting")
 	b.tracer.SetAttribute(span, "action", "Produce")
-	defer b.tracer.EndSpan(span, fmt.Sprintf("produce msg %s:%d", kafkaTopic, partition))
+	b.tagPartition(span, kafkaTopic, partition)
+	if !b.tracer.IsDisabled() {
+		defer b.tracer.EndSpan(span, fmt.Sprintf("produce msg %s:%d", kafkaTopic, partition))
+	}
+	defer func() {
+		if resp != nil {
+			batchBytes := 0
+			for _, recordBatch := range batches {
+				batchBytes += recordBatchBytes(recordBatch)
+			}
+			b.metrics.ProduceRequest(kafkaTopic, batchBytes, resp.ErrorCode)
+		}
+	}()
+	if !b.inflight.acquire() {
+		b.logger.Warnf("broker is closing, reject produce. kafkaTopic: %s, partition: %d", kafkaTopic, partition)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+		}, nil
+	}
+	defer b.inflight.release()
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("user not exist. username: %s, kafkaTopic: %s", user.username, kafkaTopic)
+		b.logger.Errorf("produce failed when get userinfo by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
 		return &codec.ProducePartitionResp{
 			ErrorCode: codec.TOPIC_AUTHORIZATION_FAILED,
 		}, nil
 	}
-	producer, err := b.getProducer(addr, user.username, kafkaTopic)
+	return b.produceBatches(ctx, span, addr, user, kafkaTopic, partition, timeoutMs, batches), nil
+}
+
+// produceBatches produce the record batches of a partition in order and stop at the first failed batch,
+// the records of the batches before it are already produced. the response of the last batch tells the offset,
+// the record errors are indexed across the batches
+func (b *Broker) produceBatches(ctx context.Context, span LocalSpan, addr net.Addr, user *userInfo, kafkaTopic string, partition int, timeoutMs int,
+	batches []*codec.RecordBatch) *codec.ProducePartitionResp {
+	var resp *codec.ProducePartitionResp
+	recordIndex := int32(0)
+	for i, recordBatch := range batches {
+		resp = b.produceBatch(ctx, span, addr, user, kafkaTopic, partition, timeoutMs, recordBatch)
+		if resp.ErrorCode != codec.NONE {
+			for _, recordError := range resp.RecordErrorList {
+				recordError.BatchIndex += recordIndex
+			}
+			if i > 0 {
+				b.logger.Warnf("produce batch %d of %d failed, the batches before are produced. username: %s, kafkaTopic: %s, partition: %d",
+					i+1, len(batches), user.username, kafkaTopic, partition)
+			}
+			return resp
+		}
+		recordIndex += int32(len(recordBatch.Records))
+	}
+	return resp
+}
+
+// produceBatch produce the records of a batch, the offset of the response is the one of the last record
+func (b *Broker) produceBatch(parent context.Context, span LocalSpan, addr net.Addr, user *userInfo, kafkaTopic string, partition int, timeoutMs int,
+	recordBatch *codec.RecordBatch) *codec.ProducePartitionResp {
+	if !validRecordBatch(recordBatch) {
+		b.logger.Errorf("malformed record batch. username: %s, kafkaTopic: %s, partition: %d", user.username, kafkaTopic, partition)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.CORRUPT_MESSAGE,
+		}
+	}
+	if !b.kafsarConfig.AcceptTransactionalProduce && isTransactionalBatch(recordBatch) {
+		b.logger.Errorf("transactional produce is not supported. username: %s, kafkaTopic: %s", user.username, kafkaTopic)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.INVALID_TXN_STATE,
+		}
+	}
+	if len(recordBatch.Records) == 0 {
+		return b.produceEmptyBatch(user, kafkaTopic, partition)
+	}
+	b.recordProduceBytes(user, recordBatch.Records)
+	timestamps := recordTimestamps(recordBatch)
+	if b.kafsarConfig.MaxTimestampSkewMs > 0 {
+		errorCode := validateTimestamps(timestamps, time.Now(), b.kafsarConfig.MaxTimestampSkewMs, b.kafsarConfig.ClampInvalidTimestamp)
+		if errorCode != codec.NONE {
+			b.logger.Errorf("record timestamp out of range. username: %s, kafkaTopic: %s", user.username, kafkaTopic)
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   errorCode,
+			}
+		}
+	}
+	idempotent := recordBatch.ProducerId > constant.NoProducerId
+	var partitionedTopic string
+	lastSequence := recordBatch.BaseSequence + int32(len(recordBatch.Records)) - 1
+	// sendDone closed when pulsar answered every message of the batch
+	var sendDone chan struct{}
+	// completeSequence record the sent batch of an idempotent producer
+	var completeSequence func(offset int64)
+	if idempotent {
+		var err error
+		partitionedTopic, err = b.partitionedTopic(user, kafkaTopic, partition)
+		if err != nil {
+			b.logger.Errorf("get partitioned topic failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   partitionedTopicErrorCode(err),
+			}
+		}
+		duplicate, offset, errorCode := b.producerStates.reserveSequence(recordBatch.ProducerId, recordBatch.ProducerEpoch, partitionedTopic, recordBatch.BaseSequence, lastSequence)
+		if errorCode != codec.NONE {
+			b.logger.Errorf("check producer sequence failed. producerId: %d, epoch: %d, sequence: %d, topic: %s, errorCode: %d",
+				recordBatch.ProducerId, recordBatch.ProducerEpoch, recordBatch.BaseSequence, partitionedTopic, errorCode)
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   errorCode,
+			}
+		}
+		if duplicate {
+			b.logger.Warnf("duplicate batch, skip sending. producerId: %d, sequence: %d, topic: %s", recordBatch.ProducerId, recordBatch.BaseSequence, partitionedTopic)
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				Offset:      offset,
+				Time:        -1,
+			}
+		}
+		sequenceReserved := true
+		defer func() {
+			if !sequenceReserved {
+				return
+			}
+			if sendDone == nil {
+				b.producerStates.releaseSequence(recordBatch.ProducerId, recordBatch.ProducerEpoch, partitionedTopic, recordBatch.BaseSequence)
+				return
+			}
+			// the messages may still be stored, keep the retries of the batch out until pulsar answered them
+			go func() {
+				<-sendDone
+				b.producerStates.releaseSequence(recordBatch.ProducerId, recordBatch.ProducerEpoch, partitionedTopic, recordBatch.BaseSequence)
+			}()
+		}()
+		completeSequence = func(offset int64) {
+			sequenceReserved = false
+			b.producerStates.completeSequence(recordBatch.ProducerId, recordBatch.ProducerEpoch, partitionedTopic, lastSequence, offset)
+		}
+	}
+	deduplicate := idempotent && b.kafsarConfig.PulsarDeduplication
+	var producer pulsar.Producer
+	var err error
+	if deduplicate {
+		producer, err = b.getIdempotentProducer(addr, user.username, partitionedTopic, recordBatch.ProducerId, recordBatch.ProducerEpoch)
+	} else {
+		producer, err = b.getProducer(addr, user.username, kafkaTopic)
+	}
 	if err != nil {
-		logrus.Errorf("create producer failed. username: %s, kafkaTopic: %s", user.username, kafkaTopic)
+		b.logger.Errorf("create producer failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+		if errors.Is(err, errCircuitOpen) {
+			return &codec.ProducePartitionResp{
+				ErrorCode: codec.LEADER_NOT_AVAILABLE,
+			}
+		}
 		return &codec.ProducePartitionResp{
 			ErrorCode: codec.TOPIC_AUTHORIZATION_FAILED,
-		}, nil
+		}
+	}
+	timeout := constant.DefaultProduceTimeout
+	if timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
 	}
-	batch := req.RecordBatch.Records
+	flushTimeout := time.Duration(b.kafsarConfig.ProduceFlushTimeoutMs) * time.Millisecond
+	if flushTimeout > 0 && flushTimeout < timeout {
+		timeout = flushTimeout
+	}
+	batch := recordBatch.Records
+	traceProperties := b.traceProperties(span)
+	messages := b.outgoingMessages(recordBatch, timestamps, traceProperties, deduplicate)
+	if !b.pendingProduce.reserve(addr.String(), len(messages), b.kafsarConfig.MaxProducerRecordSize) {
+		b.logger.Warnf("too many pending messages, reject produce. addr: %s, kafkaTopic: %s, pending: %d",
+			addr.String(), kafkaTopic, b.pendingProduce.count(addr.String()))
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.REQUEST_TIMED_OUT,
+		}
+	}
+	limitUserBytes := b.kafsarConfig.PulsarClientPerUser
+	if limitUserBytes && !b.userPendingBytes.reserve(user.username, recordsBytes(batch), b.kafsarConfig.UserMaxPendingProduceBytes) {
+		b.pendingProduce.release(addr.String(), len(messages))
+		b.logger.Warnf("too many pending bytes of user, reject produce. username: %s, kafkaTopic: %s, pending: %d",
+			user.username, kafkaTopic, b.userPendingBytes.count(user.username))
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.REQUEST_TIMED_OUT,
+		}
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
 	count := int32(0)
-	producerChan := make(chan bool)
+	queueFull := int32(0)
+	var lastMessageId atomic.Value
+	var recordErrorsMutex sync.Mutex
+	var recordErrors []*codec.RecordError
+	// sendErr the first failure other than a schema violation, the batch is not stored completely
+	var sendErr error
+	var waitGroup sync.WaitGroup
+	for i, outgoing := range messages {
+		batchIndex := outgoing.recordIndex
+		messageBytes := outgoing.bytes
+		waitGroup.Add(1)
+		producer.SendAsync(ctx, outgoing.message, func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
+			defer waitGroup.Done()
+			defer b.pendingProduce.release(addr.String(), 1)
+			if limitUserBytes {
+				defer b.userPendingBytes.release(user.username, messageBytes)
+			}
+			if err != nil {
+				b.logger.Errorf("send msg failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+				if isProducerQueueFull(err) {
+					atomic.StoreInt32(&queueFull, 1)
+				}
+				if isSchemaError(err) {
+					errMsg := err.Error()
+					recordErrorsMutex.Lock()
+					recordErrors = append(recordErrors, &codec.RecordError{BatchIndex: batchIndex, BatchIndexErrorMessage: &errMsg})
+					recordErrorsMutex.Unlock()
+					return
+				}
+				recordErrorsMutex.Lock()
+				if sendErr == nil {
+					sendErr = err
+				}
+				recordErrorsMutex.Unlock()
+				return
+			}
+			if atomic.AddInt32(&count, 1) == int32(len(messages)) {
+				lastMessageId.Store(id)
+			}
+		})
+		if atomic.LoadInt32(&queueFull) == 1 {
+			// pulsar rejects synchronously when the queue is full, do not wait for the sent messages.
+			// the client retries the batch after backoff
+			b.pendingProduce.release(addr.String(), len(messages)-i-1)
+			if limitUserBytes {
+				b.userPendingBytes.release(user.username, outgoingBytes(messages[i+1:]))
+			}
+			b.logger.Warnf("pulsar producer queue is full, reject produce. username: %s, kafkaTopic: %s", user.username, kafkaTopic)
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   codec.REQUEST_TIMED_OUT,
+			}
+		}
+	}
+	sendDone = make(chan struct{})
+	go func() {
+		if b.flushOnProduce() {
+			if err := producer.Flush(); err != nil {
+				b.logger.Errorf("flush producer failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+			}
+		}
+		waitGroup.Wait()
+		close(sendDone)
+	}()
+	select {
+	case <-sendDone:
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			b.logger.Warnf("produce msg canceled. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, parent.Err())
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   codec.REQUEST_TIMED_OUT,
+			}
+		}
+		b.logger.Errorf("produce msg timeout. username: %s, kafkaTopic: %s, timeout: %s", user.username, kafkaTopic, timeout)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.REQUEST_TIMED_OUT,
+		}
+	}
+	if len(recordErrors) > 0 {
+		// the records not conforming to the topic schema are rejected by pulsar, the client must not retry them as is
+		sort.Slice(recordErrors, func(i, j int) bool {
+			return recordErrors[i].BatchIndex < recordErrors[j].BatchIndex
+		})
+		b.logger.Warnf("records rejected by topic schema. username: %s, kafkaTopic: %s, count: %d", user.username, kafkaTopic, len(recordErrors))
+		return &codec.ProducePartitionResp{
+			PartitionId:     partition,
+			ErrorCode:       codec.INVALID_RECORD,
+			RecordErrorList: recordErrors,
+		}
+	}
+	if sendErr != nil {
+		b.logger.Errorf("batch not stored completely. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, sendErr)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   sendErrorCode(sendErr),
+		}
+	}
 	var offset int64
-	for _, kafkaMsg := range batch {
+	appendTime := constant.UnknownTimestamp
+	logStartOffset := constant.DefaultOffset
+	id, sent := lastMessageId.Load().(pulsar.MessageID)
+	if deduplicate && sent && id.LedgerID() < 0 {
+		// pulsar acknowledges the messages it already has without their message id
+		b.logger.Warnf("batch deduplicated by pulsar. producerId: %d, sequence: %d, topic: %s", recordBatch.ProducerId, recordBatch.BaseSequence, partitionedTopic)
+		completeSequence(constant.UnknownOffset)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.DUPLICATE_SEQUENCE_NUMBER,
+			Offset:      constant.UnknownOffset,
+			Time:        constant.UnknownTimestamp,
+		}
+	}
+	if sent {
+		offset, appendTime, err = b.produceOffset(producer.Topic(), id)
+		if err != nil {
+			b.logger.Errorf("get produce offset failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+			if idempotent {
+				// the batch is stored, its retry is a duplicate
+				completeSequence(constant.UnknownOffset)
+			}
+			return &codec.ProducePartitionResp{
+				PartitionId: partition,
+				ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+			}
+		}
+		if b.kafsarConfig.ProduceLogStartOffset {
+			logStartOffset = b.logStartOffset(producer.Topic(), id)
+		}
+	}
+	if idempotent && sent {
+		completeSequence(offset)
+	}
+	return &codec.ProducePartitionResp{
+		PartitionId:     partition,
+		Offset:          offset,
+		Time:            appendTime,
+		RecordErrorList: nil,
+		LogStartOffset:  logStartOffset,
+	}
+}
+
+// outgoingMessage a pulsar message of a produced record, one of its chunks if the record is chunked
+type outgoingMessage struct {
+	message     *pulsar.ProducerMessage
+	recordIndex int32
+	// bytes the key and value bytes of the record carried by the message
+	bytes int
+}
+
+// outgoingMessages the pulsar messages of the records in order
+func (b *Broker) outgoingMessages(recordBatch *codec.RecordBatch, timestamps []int64, traceProperties map[string]string, deduplicate bool) []outgoingMessage {
+	messages := make([]outgoingMessage, 0, len(recordBatch.Records))
+	for i, kafkaMsg := range recordBatch.Records {
 		message := pulsar.ProducerMessage{}
 		message.Payload = kafkaMsg.Value
+		if timestamps[i] > 0 {
+			message.EventTime = time.UnixMilli(timestamps[i])
+		}
 		if kafkaMsg.Key != nil {
 			message.Key = string(kafkaMsg.Key)
 		}
-		producer.SendAsync(context.Background(), &message, func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
-			atomic.AddInt32(&count, 1)
-			if err != nil {
-				logrus.Errorf("send msg failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+		if b.kafsarConfig.TagSourceCluster {
+			tagSourceCluster(&message, b.kafsarConfig.ClusterId)
+		}
+		setProperties(&message, traceProperties)
+		sequence := int64(recordBatch.BaseSequence) + int64(i)
+		if !b.kafsarConfig.ChunkLargeMessage {
+			if deduplicate {
+				message.SequenceID = &sequence
 			}
-			if count == int32(len(batch)) {
-				offset = ConvertMsgId(id)
-				producerChan <- true
+			messages = append(messages, outgoingMessage{message: &message, recordIndex: int32(i), bytes: len(kafkaMsg.Key) + len(kafkaMsg.Value)})
+			continue
+		}
+		for j, chunk := range chunkMessages(&message, b.maxMessageBytes()) {
+			if deduplicate {
+				// the chunks of a retried batch are the same, sequence ids stay stable and increasing
+				chunkSequence := sequence<<constant.ChunkIndexBits + int64(j)
+				chunk.SequenceID = &chunkSequence
 			}
-		})
+			bytes := len(chunk.Payload)
+			if j == 0 {
+				bytes += len(kafkaMsg.Key)
+			}
+			messages = append(messages, outgoingMessage{message: chunk, recordIndex: int32(i), bytes: bytes})
+		}
+	}
+	return messages
+}
+
+func outgoingBytes(messages []outgoingMessage) int {
+	bytes := 0
+	for _, message := range messages {
+		bytes += message.bytes
+	}
+	return bytes
+}
+
+func (b *Broker) batchingDelay() time.Duration {
+	if b.kafsarConfig.BatchingMaxPublishDelayMs > 0 {
+		return time.Duration(b.kafsarConfig.BatchingMaxPublishDelayMs) * time.Millisecond
+	}
+	return constant.DefaultBatchingMaxPublishDelay
+}
+
+// flushOnProduce the produce would wait longer than the flush threshold for the batch to be published
+func (b *Broker) flushOnProduce() bool {
+	threshold := time.Duration(b.kafsarConfig.ProduceFlushThresholdMs) * time.Millisecond
+	return threshold > 0 && b.batchingDelay() > threshold
+}
+
+// isProducerQueueFull the error of SendAsync when the producer queue is full and DisableBlockIfQueueFull is set
+func isProducerQueueFull(err error) bool {
+	var pulsarErr *pulsar.Error
+	return errors.As(err, &pulsarErr) && pulsarErr.Result() == pulsar.ProducerQueueIsFull
+}
+
+// isSchemaError the error of SendAsync when the payload does not conform to the topic schema.
+// pulsar has no dedicated result for it, e.g. the broker answers IncompatibleSchema and the client fails the schema encode
+func isSchemaError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "schema")
+}
+
+// sendErrorCode the error code of a failed SendAsync, the client retries the batch after a timeout
+func sendErrorCode(err error) codec.ErrorCode {
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) && pulsarErr.Result() == pulsar.TimeoutError {
+		return codec.REQUEST_TIMED_OUT
+	}
+	return codec.UNKNOWN_SERVER_ERROR
+}
+
+// produceEmptyBatch answer a batch without records with the current end offset, nothing is sent to pulsar
+func (b *Broker) produceEmptyBatch(user *userInfo, kafkaTopic string, partition int) *codec.ProducePartitionResp {
+	partitionedTopic, err := b.partitionedTopic(user, kafkaTopic, partition)
+	if err != nil {
+		b.logger.Errorf("get partitioned topic failed. username: %s, kafkaTopic: %s, err: %s", user.username, kafkaTopic, err)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   partitionedTopicErrorCode(err),
+		}
+	}
+	offset, err := b.highWatermark(partitionedTopic)
+	if err != nil {
+		b.logger.Errorf("get end offset of empty produce failed. topic: %s, err: %s", partitionedTopic, err)
+		return &codec.ProducePartitionResp{
+			PartitionId: partition,
+			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+		}
 	}
-	<-producerChan
 	return &codec.ProducePartitionResp{
-		PartitionId:     partition,
-		Offset:          offset,
-		Time:            -1,
-		RecordErrorList: nil,
-		LogStartOffset:  0,
-	}, nil
+		PartitionId: partition,
+		Offset:      offset,
+		Time:        -1,
+	}
+}
+
+// produceOffset the offset FetchPartition reports for the produced message, and its append time, -1 unless LogAppendTime
+func (b *Broker) produceOffset(pulsarTopic string, messageId pulsar.MessageID) (int64, int64, error) {
+	offset, err := convertMsgId(messageId)
+	readIndex := b.kafsarConfig.ContinuousOffset || (err != nil && b.kafsarConfig.OffsetOverflowUseIndex)
+	if !readIndex && (err != nil || !b.kafsarConfig.LogAppendTime) {
+		return offset, constant.UnknownTimestamp, err
+	}
+	// the index and the publish time are known by pulsar broker, read the message back to get them
+	message, err := b.readProducedMessage(pulsarTopic, messageId)
+	if err != nil {
+		if readIndex {
+			return 0, constant.UnknownTimestamp, err
+		}
+		b.logger.Warnf("read produced message failed, append time is unknown. topic: %s, err: %s", pulsarTopic, err)
+		return offset, constant.UnknownTimestamp, nil
+	}
+	if readIndex {
+		offset, err = convOffset(message, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+		if err != nil {
+			return 0, constant.UnknownTimestamp, err
+		}
+	}
+	appendTime := constant.UnknownTimestamp
+	if b.kafsarConfig.LogAppendTime {
+		appendTime = message.PublishTime().UnixMilli()
+	}
+	return offset, appendTime, nil
+}
+
+// producedPartition the pulsar partition the producer routed the message to
+func (b *Broker) producedPartition(pulsarTopic string, messageId pulsar.MessageID) (string, error) {
+	partitions, err := b.pulsarCommonClient.TopicPartitions(pulsarTopic)
+	if err != nil {
+		return "", err
+	}
+	if idx := int(messageId.PartitionIdx()); idx >= 0 && idx < len(partitions) {
+		return partitions[idx], nil
+	}
+	return pulsarTopic, nil
+}
 
+func (b *Broker) readProducedMessage(pulsarTopic string, messageId pulsar.MessageID) (pulsar.Message, error) {
+	partitionedTopic, err := b.producedPartition(pulsarTopic, messageId)
+	if err != nil {
+		return nil, err
+	}
+	message, err := utils.ReadMsgById(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, messageId, b.pulsarCommonClient)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, errors.Errorf("message %s not found in %s", messageId, partitionedTopic)
+	}
+	return message, nil
+}
+
+// logStartOffset the earliest offset of the partition of the produced message, -1 if unknown
+func (b *Broker) logStartOffset(pulsarTopic string, messageId pulsar.MessageID) int64 {
+	key := fmt.Sprintf("%s-%d", pulsarTopic, messageId.PartitionIdx())
+	now := time.Now()
+	if offset, exist := b.logStartOffsets.get(key, now); exist {
+		return offset
+	}
+	partitionedTopic, err := b.producedPartition(pulsarTopic, messageId)
+	if err != nil {
+		b.logger.Warnf("get produced partition failed, log start offset is unknown. topic: %s, err: %s", pulsarTopic, err)
+		return constant.UnknownOffset
+	}
+	earliestMsg, err := utils.ReadEarliestMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, b.pulsarCommonClient)
+	if err != nil {
+		b.logger.Warnf("read earliest msg failed, log start offset is unknown. topic: %s, err: %s", partitionedTopic, err)
+		return constant.UnknownOffset
+	}
+	offset := constant.DefaultOffset
+	if earliestMsg != nil {
+		offset, err = convOffset(earliestMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+		if err != nil {
+			b.logger.Warnf("convert offset failed, log start offset is unknown. topic: %s, err: %s", partitionedTopic, err)
+			return constant.UnknownOffset
+		}
+	}
+	b.logStartOffsets.put(key, offset, now.Add(b.backlogCacheTime()))
+	return offset
 }
 
-func (b *Broker) Fetch(addr net.Addr, req *codec.FetchReq) ([]*codec.FetchTopicResp, error) {
-	traceSpan := b.tracer.NewSpan(context.Background(), "Fetch", "broker fetch action starting")
+// Fetch the partitions are read until ctx is canceled, e.g. the connection is closed
+func (b *Broker) Fetch(ctx context.Context, addr net.Addr, req *codec.FetchReq) ([]*codec.FetchTopicResp, error) {
+	traceSpan := b.tracer.NewSpan(ctx, "Fetch", "broker fetch action starting")
 	b.tracer.SetAttribute(traceSpan, "action", "Fetch")
+	if !b.inflight.acquire() {
+		b.tracer.EndSpan(traceSpan, "broker is closing")
+		b.logger.Warnf("broker is closing, reject fetch from %s", addr.String())
+		return closingFetchResp(req), nil
+	}
+	defer b.inflight.release()
 	var maxWaitTime int
 	if req.MaxWaitTime < b.kafsarConfig.MaxFetchWaitMs {
 		maxWaitTime = req.MaxWaitTime
 	} else {
 		maxWaitTime = b.kafsarConfig.MaxFetchWaitMs
 	}
+	concurrency := b.kafsarConfig.FetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// the partitions share the max bytes of the request, at most concurrency partitions are read at the same time
+	budget := newFetchBudget(req.MaxBytes)
+	workers := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
 	reqList := req.TopicReqList
 	result := make([]*codec.FetchTopicResp, len(reqList))
+	topicSpans := make([]LocalSpan, len(reqList))
 	for i, topicReq := range reqList {
-		topicSpan := b.tracer.NewSubSpan(traceSpan, "FetchPartition")
+		topicSpans[i] = b.tracer.NewSubSpan(traceSpan, "FetchPartition")
+		b.tracer.SetAttribute(topicSpans[i], spanTagTopic, topicReq.Topic)
 		f := &codec.FetchTopicResp{}
 		f.Topic = topicReq.Topic
 		f.PartitionRespList = make([]*codec.FetchPartitionResp, len(topicReq.PartitionReqList))
+		result[i] = f
+		if len(topicReq.PartitionReqList) == 0 {
+			continue
+		}
+		if b.kafsarConfig.MultiplexFetch && len(topicReq.PartitionReqList) > 1 {
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(topicReq *codec.FetchTopicReq, span LocalSpan) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+				b.fetchTopicMultiplexed(ctx, addr, topicReq.Topic, req.ClientId, topicReq.PartitionReqList, f.PartitionRespList,
+					budget, req.MinBytes, maxWaitTime, span)
+			}(topicReq, topicSpans[i])
+			continue
+		}
+		// the wait is split between the rounds the partitions of the topic are read in
+		rounds := (len(topicReq.PartitionReqList) + concurrency - 1) / concurrency
+		maxWaitMs := maxWaitTime / rounds
 		for j, partitionReq := range topicReq.PartitionReqList {
-			f.PartitionRespList[j] = b.FetchPartition(addr, topicReq.Topic, req.ClientId, partitionReq,
-				req.MaxBytes, req.MinBytes, maxWaitTime/len(topicReq.PartitionReqList), topicSpan)
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(topic string, span LocalSpan, j int, partitionReq *codec.FetchPartitionReq) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+				f.PartitionRespList[j] = b.fetchPartition(ctx, addr, topic, req.ClientId, partitionReq,
+					budget, req.MinBytes, maxWaitMs, span)
+			}(topicReq.Topic, topicSpans[i], j, partitionReq)
+		}
+	}
+	wg.Wait()
+	if !b.tracer.IsDisabled() {
+		for i, topicReq := range reqList {
+			b.tracer.EndSpan(topicSpans[i], fmt.Sprintf("topic: %s fetched", topicReq.Topic))
 		}
-		result[i] = f
-		b.tracer.EndSpan(topicSpan, fmt.Sprintf("topic: %s fetched", topicReq.Topic))
 	}
+	b.recordFetchBytes(addr, result)
 	b.tracer.EndSpan(traceSpan, "fetch action done")
 	return result, nil
 }
 
-// FetchPartition visible for testing
+// FetchPartition visible for testing. The fetch returns as soon as MaxFetchRecord records are read, the records
+// reach maxBytes or the fetch quota granted by Server, the records exceed minBytes after the min fetch wait of the
+// topic, or maxWaitMs elapsed, whichever comes first. Bytes are checked after each record, so the last record may
+// cross maxBytes
 func (b *Broker) FetchPartition(addr net.Addr, kafkaTopic, clientID string, req *codec.FetchPartitionReq, maxBytes int, minBytes int, maxWaitMs int, span LocalSpan) *codec.FetchPartitionResp {
-	fetchSpan := b.tracer.NewSubSpan(span, fmt.Sprintf("fetching partition %s:%d", kafkaTopic, req.PartitionId))
-	defer b.tracer.EndSpan(fetchSpan, fmt.Sprintf("fetched partition %s:%d", kafkaTopic, req.PartitionId))
+	return b.fetchPartition(context.Background(), addr, kafkaTopic, clientID, req, newFetchBudget(maxBytes), minBytes, maxWaitMs, span)
+}
+
+// fetchPartition the bytes are taken from the budget shared with the other partitions of the fetch request
+func (b *Broker) fetchPartition(parent context.Context, addr net.Addr, kafkaTopic, clientID string, req *codec.FetchPartitionReq, budget *fetchBudget, minBytes int, maxWaitMs int, span LocalSpan) (resp *codec.FetchPartitionResp) {
+	// span names are formatted only when tracing, fetch partition is the hot path
+	var fetchSpan LocalSpan
+	if !b.tracer.IsDisabled() {
+		fetchSpan = b.tracer.NewSubSpan(span, fmt.Sprintf("fetching partition %s:%d", kafkaTopic, req.PartitionId))
+		defer b.tracer.EndSpan(fetchSpan, fmt.Sprintf("fetched partition %s:%d", kafkaTopic, req.PartitionId))
+		b.tagPartition(fetchSpan, kafkaTopic, req.PartitionId)
+	}
+	defer func() {
+		b.metrics.FetchRequest(kafkaTopic, recordBatchBytes(resp.RecordBatch), resp.ErrorCode)
+	}()
+	p, resp := b.prepareFetchPartition(addr, kafkaTopic, clientID, req, budget, minBytes, fetchSpan)
+	if resp != nil {
+		return resp
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(maxWaitMs)*time.Millisecond)
+	defer cancel()
+	b.readPartition(ctx, p, maxWaitMs)
+	return p.resp()
+}
+
+// partitionFetch the state of a partition being read by a fetch
+type partitionFetch struct {
+	user             *userInfo
+	kafkaTopic       string
+	partitionedTopic string
+	req              *codec.FetchPartitionReq
+	readerMetadata   *ReaderMetadata
+	recordBatch      codec.RecordBatch
+	budget           *fetchBudget
+	// fetchQuota the bytes granted by Server.ReserveFetchQuota
+	fetchQuota     int
+	minBytes       int
+	minFetchWaitMs int
+	start          time.Time
+	byteLength     int
+	errorCode      codec.ErrorCode
+	baseOffset     int64
+	firstMessage   bool
+	committed      MessageIdPair
+	hasCommitted   bool
+	sought         bool
+}
+
+// prepareFetchPartition find the reader of the partition, resp is returned instead if the partition can not be read
+func (b *Broker) prepareFetchPartition(addr net.Addr, kafkaTopic, clientID string, req *codec.FetchPartitionReq, budget *fetchBudget, minBytes int, fetchSpan LocalSpan) (*partitionFetch, *codec.FetchPartitionResp) {
 	start := time.Now()
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
-	records := make([]*codec.Record, 0)
+	records := make([]*codec.Record, 0, fetchRecordsCapacity(b.kafsarConfig.MaxFetchRecord))
 	recordBatch := codec.RecordBatch{Records: records}
 	if !exist {
-		logrus.Errorf("fetch partition failed when get userinfo by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
-		return &codec.FetchPartitionResp{
+		b.logger.Errorf("fetch partition failed when get userinfo by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
+		return nil, &codec.FetchPartitionResp{
 			PartitionIndex: req.PartitionId,
 			ErrorCode:      codec.UNKNOWN_SERVER_ERROR,
 			RecordBatch:    &recordBatch,
 		}
 	}
-	logrus.Infof("%s fetch topic: %s partition %d", addr.String(), kafkaTopic, req.PartitionId)
+	b.logger.Infof("%s fetch topic: %s partition %d", addr.String(), kafkaTopic, req.PartitionId)
 	partitionedTopic, err := b.partitionedTopic(user, kafkaTopic, req.PartitionId)
 	if err != nil {
-		logrus.Errorf("fetch partition failed when get pulsar topic %s, kafka topic: %s", addr.String(), kafkaTopic)
-		return &codec.FetchPartitionResp{
+		b.logger.Errorf("fetch partition failed when get pulsar topic %s, kafka topic: %s", addr.String(), kafkaTopic)
+		return nil, &codec.FetchPartitionResp{
 			PartitionIndex: req.PartitionId,
-			ErrorCode:      codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode:      partitionedTopicErrorCode(err),
 			RecordBatch:    &recordBatch,
 		}
 	}
+	if b.kafsarConfig.LazyCreateReader {
+		if errorCode := b.activatePendingReader(user.username, partitionedTopic, clientID); errorCode != codec.NONE {
+			return nil, &codec.FetchPartitionResp{
+				PartitionIndex: req.PartitionId,
+				ErrorCode:      errorCode,
+				RecordBatch:    &recordBatch,
+			}
+		}
+	}
 	b.mutex.RLock()
-	readerMetadata, exist := b.readerManager[partitionedTopic+clientID]
+	readerMetadata, exist := b.readerManager[readerKey(user.username, partitionedTopic, clientID)]
 	if !exist {
-		groupId, exist := b.topicGroupManager[partitionedTopic]
+		groupId, exist := b.topicGroupManager[user.username+partitionedTopic]
+		memberInfo, memberExist := b.memberManager[addr.String()]
 		b.mutex.RUnlock()
 		if exist {
 			group, err := b.groupCoordinator.GetGroup(user.username, groupId)
 			if err == nil && group.groupStatus != Stable {
-				logrus.Infof("group is preparing rebalance. grouId: %s, topic: %s", groupId, partitionedTopic)
-				return &codec.FetchPartitionResp{
+				b.logger.Infof("group is preparing rebalance. grouId: %s, topic: %s", groupId, partitionedTopic)
+				return nil, &codec.FetchPartitionResp{
 					LastStableOffset: 0,
 					ErrorCode:        codec.NONE,
 					LogStartOffset:   0,
@@ -254,118 +984,308 @@ func (b *Broker) FetchPartition(addr net.Addr, kafkaTopic, clientID string, req
 				}
 			}
 		}
-		// Maybe this partition-topic is already assigned to another member
-		logrus.Warnf("can not find reader for topic: %s when fetch partition %s", partitionedTopic, partitionedTopic+clientID)
-		return &codec.FetchPartitionResp{
-			LastStableOffset: 0,
-			ErrorCode:        codec.NONE,
-			LogStartOffset:   0,
-			RecordBatch:      &recordBatch,
-			PartitionIndex:   req.PartitionId,
+		if b.kafsarConfig.RecoverReaderOnFetch && memberExist {
+			var errorCode codec.ErrorCode
+			readerMetadata, errorCode = b.recoverReader(user, kafkaTopic, partitionedTopic, clientID, memberInfo.groupId, req.PartitionId)
+			if errorCode != codec.NONE {
+				return nil, &codec.FetchPartitionResp{
+					PartitionIndex: req.PartitionId,
+					ErrorCode:      errorCode,
+					RecordBatch:    &recordBatch,
+				}
+			}
+		}
+		if readerMetadata == nil {
+			// Maybe this partition-topic is already assigned to another member
+			b.logger.Warnf("can not find reader for topic: %s when fetch partition %s", partitionedTopic, readerKey(user.username, partitionedTopic, clientID))
+			return nil, &codec.FetchPartitionResp{
+				LastStableOffset: 0,
+				ErrorCode:        codec.NONE,
+				LogStartOffset:   0,
+				RecordBatch:      &recordBatch,
+				PartitionIndex:   req.PartitionId,
+			}
 		}
+	} else {
+		b.mutex.RUnlock()
 	}
-	b.mutex.RUnlock()
-	byteLength := 0
-	var baseOffset int64
-	fistMessage := true
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(maxWaitMs)*time.Millisecond)
-	defer cancel()
-OUT:
-	for {
-		if time.Since(start).Milliseconds() >= int64(maxWaitMs) || len(recordBatch.Records) >= b.kafsarConfig.MaxFetchRecord {
-			break OUT
+	b.tracer.SetAttribute(fetchSpan, spanTagGroup, readerMetadata.groupId)
+	if b.kafsarConfig.ContinuousOffset && b.kafsarConfig.CheckFetchOffsetRange {
+		if outOfRange := b.checkFetchOffsetRange(partitionedTopic, req, readerMetadata); outOfRange != nil {
+			outOfRange.RecordBatch = &recordBatch
+			return nil, outOfRange
 		}
-		flowControl := b.server.HasFlowQuota(user.username, partitionedTopic)
-		if !flowControl {
-			break
+	}
+	if b.kafsarConfig.SeekToFetchOffset {
+		b.seekToFetchOffset(user.username, kafkaTopic, req.PartitionId, readerMetadata, req.FetchOffset)
+	}
+	p := &partitionFetch{
+		user:             user,
+		kafkaTopic:       kafkaTopic,
+		partitionedTopic: partitionedTopic,
+		req:              req,
+		readerMetadata:   readerMetadata,
+		recordBatch:      recordBatch,
+		budget:           budget,
+		minBytes:         minBytes,
+		minFetchWaitMs:   b.minFetchWaitMs(user.username, kafkaTopic),
+		start:            start,
+		errorCode:        codec.NONE,
+		firstMessage:     true,
+	}
+	if b.kafsarConfig.SeekToCommittedOnFetch {
+		p.committed, p.hasCommitted = b.offsetManager.AcquireOffset(user.username, kafkaTopic, readerMetadata.groupId, req.PartitionId)
+	}
+	p.fetchQuota = b.server.ReserveFetchQuota(user.username, partitionedTopic, budget.available())
+	return p, nil
+}
+
+// readPartition read the partition until the fetch is complete or ctx is done. the messages prefetched to the channel
+// of the reader are drained, readers without channel are read by Next
+func (b *Broker) readPartition(ctx context.Context, p *partitionFetch, maxWaitMs int) {
+	for !b.partitionFull(p, maxWaitMs) {
+		var message pulsar.Message
+		if p.readerMetadata.channel != nil {
+			select {
+			case readerMessage := <-p.readerMetadata.channel:
+				message = readerMessage.Message
+			case <-ctx.Done():
+				return
+			}
+		} else {
+			var err error
+			message, err = p.readerMetadata.reader.Next(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.logger.Errorf("read msg failed. err: %s", err)
+				continue
+			}
 		}
-		message, err := readerMetadata.reader.Next(ctx)
-		if err != nil {
-			if ctx.Err() != nil {
-				break OUT
+		appended, more := b.addFetchedMessage(p, message)
+		if !more {
+			return
+		}
+		if appended && p.byteLength > p.minBytes && time.Since(p.start).Milliseconds() >= int64(p.minFetchWaitMs) {
+			return
+		}
+	}
+}
+
+// partitionFull whether the fetch of the partition must stop before reading another message
+func (b *Broker) partitionFull(p *partitionFetch, maxWaitMs int) bool {
+	if time.Since(p.start).Milliseconds() >= int64(maxWaitMs) || len(p.recordBatch.Records) >= b.kafsarConfig.MaxFetchRecord {
+		return true
+	}
+	// the granted budget is used up, the last record may cross it like maxBytes
+	if p.byteLength >= p.fetchQuota {
+		return true
+	}
+	// the other partitions of the request used up the max bytes
+	return p.budget.exhausted()
+}
+
+// addFetchedMessage append the message to the records of the partition if it is returned to the client.
+// more is false if the fetch of the partition must stop
+func (b *Broker) addFetchedMessage(p *partitionFetch, message pulsar.Message) (appended bool, more bool) {
+	readerMetadata, partitionedTopic, kafkaTopic := p.readerMetadata, p.partitionedTopic, p.kafkaTopic
+	if !sameTopic(message.Topic(), partitionedTopic) {
+		// never hand another partition's data to the client, the reader is mapped to the wrong topic
+		b.logger.Errorf("drop msg: %s from topic %s, expected topic: %s", message.ID(), message.Topic(), partitionedTopic)
+		b.metrics.TopicMismatch(kafkaTopic)
+		return false, true
+	}
+	if b.kafsarConfig.FilterSourceCluster && isFromSourceCluster(message, b.kafsarConfig.ClusterId) {
+		b.logger.Debugf("skip msg: %s from source cluster %s", message.ID(), b.kafsarConfig.ClusterId)
+		return false, true
+	}
+	b.logger.Infof("receive msg: %s from %s", message.ID(), message.Topic())
+	offset, err := convOffset(message, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+	if err != nil {
+		b.logger.Errorf("convert offset failed. topic: %s, err: %s", partitionedTopic, err)
+		if len(p.recordBatch.Records) == 0 {
+			p.errorCode = codec.UNKNOWN_SERVER_ERROR
+		}
+		// the message is not returned, read it again by the next fetch instead of dropping it
+		if err := readerMetadata.reader.Seek(message.ID()); err != nil {
+			b.logger.Errorf("seek reader back to msg %s failed. topic: %s, err: %s", message.ID(), partitionedTopic, err)
+		}
+		return false, false
+	}
+	if skipCommitted(readerMetadata, message, offset) {
+		return false, true
+	}
+	if p.hasCommitted && offset <= p.committed.Offset {
+		// the reader is behind the committed offset, e.g. another member committed after the reader created
+		if !p.sought {
+			p.sought = true
+			b.logger.Infof("reader is behind committed offset, seek to %s. topic: %s, offset: %d, committed: %d",
+				p.committed.MessageId, partitionedTopic, offset, p.committed.Offset)
+			if err := seekToCommitted(readerMetadata, p.committed); err != nil {
+				b.logger.Errorf("seek reader to committed offset failed. topic: %s, err: %s", partitionedTopic, err)
 			}
-			logrus.Errorf("read msg failed. err: %s", err)
-			continue
 		}
-		byteLength = byteLength + utils.CalculateMsgLength(message)
-		logrus.Infof("receive msg: %s from %s", message.ID(), message.Topic())
-		offset := convOffset(message, b.kafsarConfig.ContinuousOffset)
-		if fistMessage {
-			fistMessage = false
-			baseOffset = offset
-		}
-		relativeOffset := offset - baseOffset
-		record := codec.Record{
-			Value:          message.Payload(),
-			RelativeOffset: int(relativeOffset),
-		}
-		recordBatch.Records = append(recordBatch.Records, &record)
-		readerMetadata.mutex.Lock()
-		readerMetadata.messageIds.PushBack(MessageIdPair{
-			MessageId: message.ID(),
-			Offset:    offset,
-		})
-		readerMetadata.mutex.Unlock()
-		if byteLength > minBytes && time.Since(start).Milliseconds() >= int64(b.kafsarConfig.MinFetchWaitMs) {
-			break
+		return false, true
+	}
+	payload := message.Payload()
+	if b.kafsarConfig.ChunkLargeMessage && isChunk(message) {
+		value, complete, err := readerMetadata.appendChunk(message)
+		if err != nil {
+			b.logger.Warnf("drop chunk %s. topic: %s, err: %s", message.ID(), partitionedTopic, err)
+			return false, true
 		}
-		if byteLength > maxBytes {
-			break
+		if !complete {
+			return false, true
+		}
+		// the record takes the offset of its last chunk, committing it covers all chunks
+		payload = value
+	}
+	if p.firstMessage {
+		p.firstMessage = false
+		p.baseOffset = offset
+		if b.kafsarConfig.ContinuousOffset {
+			readerMetadata.mutex.RLock()
+			if readerMetadata.nextOffset != constant.UnknownOffset {
+				// continue the offsets presented by the previous fetches, hide the gaps of compacted messages
+				p.baseOffset = readerMetadata.nextOffset
+			}
+			readerMetadata.mutex.RUnlock()
 		}
 	}
-	recordBatch.Offset = baseOffset
+	// pulsar offsets may be non-contiguous, kafka clients expect contiguous offsets inside the batch,
+	// so the commit bookkeeping maps the offset the client sees to the message id
+	relativeOffset := len(p.recordBatch.Records)
+	record := codec.Record{
+		Value:          payload,
+		RelativeOffset: relativeOffset,
+	}
+	p.recordBatch.Records = append(p.recordBatch.Records, &record)
+	p.byteLength += recordBytes(&record)
+	budgetLeft := p.budget.consume(recordBytes(&record))
+	readerMetadata.mutex.Lock()
+	readerMetadata.messageIds = append(readerMetadata.messageIds, MessageIdPair{
+		MessageId: message.ID(),
+		Offset:    p.baseOffset + int64(relativeOffset),
+	})
+	readerMetadata.nextOffset = p.baseOffset + int64(relativeOffset) + 1
+	readerMetadata.mutex.Unlock()
+	// stop right at the cap, a message read beyond it could not be returned
+	return true, len(p.recordBatch.Records) < b.kafsarConfig.MaxFetchRecord && budgetLeft
+}
+
+func (p *partitionFetch) resp() *codec.FetchPartitionResp {
+	p.recordBatch.Offset = p.baseOffset
 	return &codec.FetchPartitionResp{
-		ErrorCode:        codec.NONE,
-		PartitionIndex:   req.PartitionId,
+		ErrorCode:        p.errorCode,
+		PartitionIndex:   p.req.PartitionId,
 		LastStableOffset: 0,
 		LogStartOffset:   0,
-		RecordBatch:      &recordBatch,
+		RecordBatch:      &p.recordBatch,
 	}
 }
 
+// producerCreation a producer being created outside the broker mutex, concurrent produces of the connection wait on done
+type producerCreation struct {
+	done     chan struct{}
+	producer pulsar.Producer
+	err      error
+}
+
 func (b *Broker) getProducer(addr net.Addr, username string, topic string) (pulsar.Producer, error) {
-	pulsarTopic, err := b.server.PulsarTopic(username, topic)
+	pulsarTopic, err := b.pulsarTopic(username, topic)
 	if err != nil {
-		logrus.Errorf("get pulsar topic failed. username: %s, topic: %s", username, topic)
+		b.logger.Errorf("get pulsar topic failed. username: %s, topic: %s", username, topic)
 		return nil, err
 	}
+	return b.loadProducer(addr.String(), username, b.producerOptions(pulsarTopic))
+}
+
+// getIdempotentProducer the producer of the partition named after the kafka producer id and epoch, so that pulsar
+// deduplicates the retried batches of the kafka producer by their sequences, even after the producer is recreated
+func (b *Broker) getIdempotentProducer(addr net.Addr, username, partitionedTopic string, producerId int64, producerEpoch int16) (pulsar.Producer, error) {
+	options := b.producerOptions(partitionedTopic)
+	options.Name = fmt.Sprintf("kafsar-%d-%d-%d", b.kafsarConfig.NodeId, producerId, producerEpoch)
+	return b.loadProducer(idempotentProducerKey(addr, partitionedTopic, options.Name), username, options)
+}
+
+// idempotentProducerKey prefixed by the connection address, closed on disconnect together with the producer of the connection
+func idempotentProducerKey(addr net.Addr, partitionedTopic, producerName string) string {
+	return addr.String() + "/" + partitionedTopic + "/" + producerName
+}
+
+func (b *Broker) producerOptions(pulsarTopic string) pulsar.ProducerOptions {
+	options := pulsar.ProducerOptions{}
+	options.Topic = pulsarTopic
+	options.MaxPendingMessages = b.kafsarConfig.MaxProducerRecordSize
+	options.BatchingMaxSize = uint(b.kafsarConfig.MaxBatchSize)
+	options.DisableBlockIfQueueFull = b.kafsarConfig.ProducerQueueFullFailFast
+	options.BatchingMaxPublishDelay = b.batchingDelay()
+	return options
+}
+
+// loadProducer the producer of the key in producerManager, created with the options if absent
+func (b *Broker) loadProducer(key, username string, options pulsar.ProducerOptions) (pulsar.Producer, error) {
 	b.mutex.Lock()
-	producer, exist := b.producerManager[addr.String()]
-	if !exist {
-		options := pulsar.ProducerOptions{}
-		options.Topic = pulsarTopic
-		options.MaxPendingMessages = b.kafsarConfig.MaxProducerRecordSize
-		options.BatchingMaxSize = uint(b.kafsarConfig.MaxBatchSize)
-		producer, err = b.pulsarCommonClient.CreateProducer(options)
-		if err != nil {
-			b.mutex.Unlock()
-			logrus.Errorf("crate producer failed. topic: %s, err: %s", pulsarTopic, err)
-			return nil, err
+	producer, exist := b.producerManager[key]
+	if exist {
+		b.mutex.Unlock()
+		return producer, nil
+	}
+	creation, creating := b.producerCreating[key]
+	if !creating {
+		creation = &producerCreation{done: make(chan struct{})}
+		b.producerCreating[key] = creation
+	}
+	b.mutex.Unlock()
+	if creating {
+		<-creation.done
+		return creation.producer, creation.err
+	}
+	// creating may be slow on a cold pulsar connection, do not block other requests on the broker mutex
+	creation.err = b.producerBreaker.allow()
+	if creation.err == nil {
+		var client pulsar.Client
+		client, creation.err = b.userClient(username)
+		if creation.err == nil {
+			creation.producer, creation.err = client.CreateProducer(options)
+			b.producerBreaker.report(creation.err)
 		}
-		logrus.Infof("create producer success. addr: %s", addr.String())
-		b.producerManager[addr.String()] = producer
+	}
+	b.mutex.Lock()
+	delete(b.producerCreating, key)
+	if creation.err == nil {
+		b.producerManager[key] = creation.producer
+		b.metrics.ProducerCount(len(b.producerManager))
 	}
 	b.mutex.Unlock()
-	return producer, nil
+	close(creation.done)
+	if creation.err != nil {
+		b.logger.Errorf("crate producer failed. topic: %s, err: %s", options.Topic, creation.err)
+		return nil, creation.err
+	}
+	b.logger.Infof("create producer success. key: %s", key)
+	return creation.producer, nil
 }
 
 func (b *Broker) GroupJoin(addr net.Addr, req *codec.JoinGroupReq) (*codec.JoinGroupResp, error) {
+	logger := b.groupLogger(addr, req.GroupId)
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("username not found in join group: %s", req.GroupId)
+		logger.Errorf("username not found in join group: %s", req.GroupId)
 		return &codec.JoinGroupResp{
 			ErrorCode:    codec.UNKNOWN_SERVER_ERROR,
 			MemberId:     req.MemberId,
 			GenerationId: -1,
 		}, nil
 	}
-	logrus.Infof("%s joining to group: %s, memberId: %s", addr.String(), req.GroupId, req.MemberId)
+	logger.Infof("%s joining to group: %s, memberId: %s", addr.String(), req.GroupId, req.MemberId)
 	joinGroupResp, err := b.groupCoordinator.HandleJoinGroup(user.username, req.GroupId, req.MemberId, req.ClientId, req.ProtocolType,
 		req.SessionTimeout, req.GroupProtocols)
 	if err != nil {
-		logrus.Errorf("unexpected exception in join group: %s, error: %s", req.GroupId, err)
+		logger.Errorf("unexpected exception in join group: %s, error: %s", req.GroupId, err)
 		return &codec.JoinGroupResp{
 			ErrorCode:    codec.UNKNOWN_SERVER_ERROR,
 			MemberId:     req.MemberId,
@@ -385,103 +1305,157 @@ func (b *Broker) GroupJoin(addr net.Addr, req *codec.JoinGroupReq) (*codec.JoinG
 }
 
 func (b *Broker) GroupLeave(addr net.Addr, req *codec.LeaveGroupReq) (*codec.LeaveGroupResp, error) {
+	logger := b.groupLogger(addr, req.GroupId)
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("username not found in leave group: %s", req.GroupId)
+		logger.Errorf("username not found in leave group: %s", req.GroupId)
 		return &codec.LeaveGroupResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	logrus.Infof("%s leaving group: %s, members: %+v", addr.String(), req.GroupId, req.Members)
+	logger.Infof("%s leaving group: %s, members: %+v", addr.String(), req.GroupId, req.Members)
 	leaveGroupResp, err := b.groupCoordinator.HandleLeaveGroup(user.username, req.GroupId, req.Members)
 	if err != nil {
-		logrus.Errorf("unexpected exception in leaving group: %s, error: %s", req.GroupId, err)
+		logger.Errorf("unexpected exception in leaving group: %s, error: %s", req.GroupId, err)
 		return &codec.LeaveGroupResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
 	group, err := b.groupCoordinator.GetGroup(user.username, req.GroupId)
 	if err != nil {
-		logrus.Errorf("get group %s failed, error: %s", req.GroupId, err)
+		logger.Errorf("get group %s failed, error: %s", req.GroupId, err)
 		return &codec.LeaveGroupResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	for _, topic := range group.partitionedTopic {
-		b.mutex.Lock()
-		readerMetadata, exist := b.readerManager[topic+req.ClientId]
-		if exist {
-			readerMetadata.reader.Close()
-			logrus.Infof("success close reader topic: %s", group.partitionedTopic)
-			delete(b.readerManager, topic+req.ClientId)
-			readerMetadata = nil
+	b.mutex.Lock()
+	if memberInfo, exist := b.memberManager[addr.String()]; exist && memberInfo.groupId == req.GroupId && leaving(req.Members, memberInfo.memberId) {
+		delete(b.memberManager, addr.String())
+	}
+	b.mutex.Unlock()
+	// readers are per client id, keep the ones of a remaining member with the same client id
+	remaining := group.memberClientIds()
+	for _, topic := range group.subscribedTopics() {
+		if !remaining[req.ClientId] {
+			b.closeReader(user.username, topic, req.ClientId)
+			logger.Infof("success close reader topic: %s, clientId: %s", topic, req.ClientId)
 		}
-		client, exist := b.pulsarClientManage[topic+req.ClientId]
-		if exist {
-			client.Close()
-			delete(b.pulsarClientManage, topic+req.ClientId)
-			client = nil
+		if b.readByClients(user.username, topic, remaining) {
+			continue
 		}
-		delete(b.topicGroupManager, topic)
-		b.mutex.Unlock()
+		b.releasePartitionedTopic(user.username, group, topic)
 	}
 	return leaveGroupResp, nil
 }
 
+func leaving(members []*codec.LeaveGroupMember, memberId string) bool {
+	for _, member := range members {
+		if member.MemberId == memberId {
+			return true
+		}
+	}
+	return false
+}
+
+// releasePartitionedTopic no member of the group reads the partition anymore. the group keeps the partition, its
+// committed offset is found by it until the group is deleted
+func (b *Broker) releasePartitionedTopic(username string, group *Group, partitionedTopic string) {
+	b.mutex.Lock()
+	if b.topicGroupManager[username+partitionedTopic] == group.groupId {
+		delete(b.topicGroupManager, username+partitionedTopic)
+	}
+	b.mutex.Unlock()
+}
+
 func (b *Broker) GroupSync(addr net.Addr, req *codec.SyncGroupReq) (*codec.SyncGroupResp, error) {
+	logger := b.groupLogger(addr, req.GroupId)
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("username not found in sync group: %s", req.GroupId)
+		logger.Errorf("username not found in sync group: %s", req.GroupId)
 		return &codec.SyncGroupResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	logrus.Infof("%s syncing group: %s, memberId: %s", addr.String(), req.GroupId, req.MemberId)
+	logger.Infof("%s syncing group: %s, memberId: %s", addr.String(), req.GroupId, req.MemberId)
+	if b.kafsarConfig.VerifyMemberIdentity {
+		errorCode := b.checkMemberIdentity(addr, req.GroupId, req.MemberId, req.GroupInstanceId)
+		if errorCode != codec.NONE {
+			return &codec.SyncGroupResp{
+				ErrorCode: errorCode,
+			}, nil
+		}
+	}
 	syncGroupResp, err := b.groupCoordinator.HandleSyncGroup(user.username, req.GroupId, req.MemberId, req.GenerationId, req.GroupAssignments)
 	if err != nil {
-		logrus.Errorf("unexpected exception in sync group: %s, error: %s", req.GroupId, err)
+		logger.Errorf("unexpected exception in sync group: %s, error: %s", req.GroupId, err)
 		return &codec.SyncGroupResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
+	if syncGroupResp.ErrorCode == codec.NONE {
+		b.closeRevokedReaders(user, req.GroupId, req.MemberId, req.ClientId)
+	}
 	syncGroupResp.ProtocolName = req.ProtocolName
 	syncGroupResp.ProtocolType = req.ProtocolType
 	return syncGroupResp, nil
 }
 
+// checkMemberIdentity member id must be joined by the same connection, avoid a client hijack other member's assignment
+func (b *Broker) checkMemberIdentity(addr net.Addr, groupId, memberId string, groupInstanceId *string) codec.ErrorCode {
+	b.mutex.RLock()
+	memberInfo, exist := b.memberManager[addr.String()]
+	b.mutex.RUnlock()
+	if exist && memberInfo.groupId == groupId && memberInfo.memberId == memberId {
+		return codec.NONE
+	}
+	b.logger.Errorf("member identity mismatch. addr: %s, groupId: %s, memberId: %s", addr.String(), groupId, memberId)
+	if groupInstanceId != nil {
+		return codec.FENCED_INSTANCE_ID
+	}
+	return codec.UNKNOWN_MEMBER_ID
+}
+
 func (b *Broker) OffsetListPartition(addr net.Addr, kafkaTopic, clientID string, req *codec.ListOffsetsPartition) (*codec.ListOffsetsPartitionResp, error) {
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("offset list failed when get username by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
+		b.logger.Errorf("offset list failed when get username by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
 		return &codec.ListOffsetsPartitionResp{
 			PartitionId: req.PartitionId,
 			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	logrus.Infof("%s offset list topic: %s, partition: %d", addr.String(), kafkaTopic, req.PartitionId)
+	b.logger.Infof("%s offset list topic: %s, partition: %d", addr.String(), kafkaTopic, req.PartitionId)
 	partitionedTopic, err := b.partitionedTopic(user, kafkaTopic, req.PartitionId)
 	if err != nil {
-		logrus.Errorf("get topic failed. err: %s", err)
+		b.logger.Errorf("get topic failed. err: %s", err)
 		return &codec.ListOffsetsPartitionResp{
 			PartitionId: req.PartitionId,
-			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode:   partitionedTopicErrorCode(err),
 		}, nil
 	}
+	if b.kafsarConfig.LazyCreateReader {
+		if errorCode := b.activatePendingReader(user.username, partitionedTopic, clientID); errorCode != codec.NONE {
+			return &codec.ListOffsetsPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   errorCode,
+			}, nil
+		}
+	}
 	b.mutex.RLock()
-	client, exist := b.pulsarClientManage[partitionedTopic+clientID]
+	client, exist := b.pulsarClientManage[readerKey(user.username, partitionedTopic, clientID)]
 	if !exist {
-		groupId, exist := b.topicGroupManager[partitionedTopic]
+		groupId, exist := b.topicGroupManager[user.username+partitionedTopic]
 		b.mutex.RUnlock()
 		if exist {
 			group, err := b.groupCoordinator.GetGroup(user.username, groupId)
 			if err == nil && group.groupStatus != Stable {
-				logrus.Infof("group is preparing rebalance. grouId: %s, topic: %s", groupId, partitionedTopic)
+				b.logger.Infof("group is preparing rebalance. grouId: %s, topic: %s", groupId, partitionedTopic)
 				return &codec.ListOffsetsPartitionResp{
 					PartitionId: req.PartitionId,
 					ErrorCode:   codec.LEADER_NOT_AVAILABLE,
@@ -489,65 +1463,205 @@ func (b *Broker) OffsetListPartition(addr net.Addr, kafkaTopic, clientID string,
 				}, nil
 			}
 		}
-		logrus.Errorf("get pulsar client failed. err: %v", err)
+		// the reader is not created yet or moved to another member, the client retries after refreshing metadata
+		b.logger.Errorf("get pulsar client failed. err: %v", err)
 		return &codec.ListOffsetsPartitionResp{
 			PartitionId: req.PartitionId,
-			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
 			Timestamp:   constant.TimeEarliest,
 		}, nil
 	}
-	readerMessages, exist := b.readerManager[partitionedTopic+clientID]
+	readerMessages, exist := b.readerManager[readerKey(user.username, partitionedTopic, clientID)]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("offset list failed, topic: %s, does not exist", partitionedTopic)
+		b.logger.Errorf("offset list failed, topic: %s, does not exist", partitionedTopic)
 		return &codec.ListOffsetsPartitionResp{
 			PartitionId: req.PartitionId,
-			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
 		}, nil
 	}
 	offset := constant.DefaultOffset
-	if req.Time == constant.TimeLasted {
-		msg, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
+	timestamp := constant.TimeEarliest
+	switch {
+	case req.Time == constant.TimeEarliest:
+		earliestMsg, err := utils.ReadEarliestMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, client)
 		if err != nil {
-			logrus.Errorf("get topic %s latest offset failed %s\n", kafkaTopic, err)
+			b.logger.Errorf("read earliest msg failed. topic: %s, err: %s", kafkaTopic, err)
+			return &codec.ListOffsetsPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+			}, nil
+		}
+		if !b.kafsarConfig.ListOffsetsKeepReaderPosition {
+			err = readerMessages.reader.Seek(pulsar.EarliestMessageID())
+			if err != nil {
+				b.logger.Errorf("offset list failed, topic: %s, err: %s", partitionedTopic, err)
+				return &codec.ListOffsetsPartitionResp{
+					PartitionId: req.PartitionId,
+					ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+				}, nil
+			}
+			resetMessageIds(readerMessages)
+		}
+		if earliestMsg != nil {
+			offset, err = convOffset(earliestMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+			if err != nil {
+				b.logger.Errorf("convert offset failed. topic: %s, err: %s", partitionedTopic, err)
+				return &codec.ListOffsetsPartitionResp{
+					PartitionId: req.PartitionId,
+					ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+				}, nil
+			}
+		}
+	case req.Time >= 0:
+		timeMsg, err := utils.ReadMsgByTime(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, req.Time, client)
+		if err != nil {
+			b.logger.Errorf("read msg by time failed. topic: %s, time: %d, err: %s", kafkaTopic, req.Time, err)
+			return &codec.ListOffsetsPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+			}, nil
+		}
+		if timeMsg == nil {
+			// no message at or after the requested timestamp, same as kafka
+			return &codec.ListOffsetsPartitionResp{
+				PartitionId: req.PartitionId,
+				Offset:      constant.UnknownOffset,
+				Timestamp:   constant.UnknownTimestamp,
+			}, nil
+		}
+		if !b.kafsarConfig.ListOffsetsKeepReaderPosition {
+			err = readerMessages.reader.SeekByTime(time.UnixMilli(req.Time))
+			if err != nil {
+				b.logger.Errorf("offset list failed, topic: %s, err: %s", partitionedTopic, err)
+				return &codec.ListOffsetsPartitionResp{
+					PartitionId: req.PartitionId,
+					ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+				}, nil
+			}
+			resetMessageIds(readerMessages)
+		}
+		offset, err = convOffset(timeMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+		if err != nil {
+			b.logger.Errorf("convert offset failed. topic: %s, err: %s", partitionedTopic, err)
 			return &codec.ListOffsetsPartitionResp{
 				PartitionId: req.PartitionId,
 				ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
 			}, nil
 		}
+		timestamp = timeMsg.PublishTime().UnixMilli()
+	case req.Time == constant.TimeLasted:
+		msg, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
+		if err != nil {
+			b.logger.Errorf("get topic %s latest offset failed %s\n", kafkaTopic, err)
+			return &codec.ListOffsetsPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+			}, nil
+		}
 		lastedMsg, err := utils.ReadLastedMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, msg, client)
 		if err != nil {
-			logrus.Errorf("read lasted msg failed. topic: %s, err: %s", kafkaTopic, err)
+			b.logger.Errorf("read lasted msg failed. topic: %s, err: %s", kafkaTopic, err)
 			return &codec.ListOffsetsPartitionResp{
 				PartitionId: req.PartitionId,
-				ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+				ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
 			}, nil
 		}
 		if lastedMsg != nil {
-			err := readerMessages.reader.Seek(lastedMsg.ID())
+			if !b.kafsarConfig.ListOffsetsKeepReaderPosition {
+				err := readerMessages.reader.Seek(lastedMsg.ID())
+				if err != nil {
+					b.logger.Errorf("offset list failed, topic: %s, err: %s", partitionedTopic, err)
+					return &codec.ListOffsetsPartitionResp{
+						PartitionId: req.PartitionId,
+						ErrorCode:   codec.NOT_LEADER_OR_FOLLOWER,
+					}, nil
+				}
+				resetMessageIds(readerMessages)
+			}
+			offset, err = convOffset(lastedMsg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
 			if err != nil {
-				logrus.Errorf("offset list failed, topic: %s, err: %s", partitionedTopic, err)
+				b.logger.Errorf("convert offset failed. topic: %s, err: %s", partitionedTopic, err)
 				return &codec.ListOffsetsPartitionResp{
 					PartitionId: req.PartitionId,
 					ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
 				}, nil
 			}
-			offset = convOffset(lastedMsg, b.kafsarConfig.ContinuousOffset)
 		}
 	}
-	return &codec.ListOffsetsPartitionResp{
-		PartitionId: req.PartitionId,
-		Offset:      offset,
-		Timestamp:   constant.TimeEarliest,
-	}, nil
+	return &codec.ListOffsetsPartitionResp{
+		PartitionId: req.PartitionId,
+		Offset:      offset,
+		Timestamp:   timestamp,
+	}, nil
+}
+
+// resetMessageIds drop fetched but uncommitted message ids, they are out of order after the reader seek
+func resetMessageIds(readerMessages *ReaderMetadata) {
+	readerMessages.mutex.Lock()
+	readerMessages.messageIds = make([]MessageIdPair, 0)
+	readerMessages.nextOffset = constant.UnknownOffset
+	readerMessages.skipId = nil
+	readerMessages.mutex.Unlock()
+}
+
+// seekToCommitted move the reader back or forward to the committed message, the next fetch starts after it
+func seekToCommitted(readerMetadata *ReaderMetadata, committed MessageIdPair) error {
+	if err := readerMetadata.reader.Seek(committed.MessageId); err != nil {
+		return err
+	}
+	resetMessageIds(readerMetadata)
+	readerMetadata.mutex.Lock()
+	readerMetadata.nextOffset = committed.Offset + 1
+	readerMetadata.skipId = committed.MessageId
+	readerMetadata.skipOffset = committed.Offset
+	readerMetadata.mutex.Unlock()
+	return nil
+}
+
+// readerDiverged report whether the reader is not right after the committed offset, e.g. it fetched beyond it before
+// the member rejoined. a reader that has not fetched yet catches up with the committed offset when it fetches
+func readerDiverged(readerMetadata *ReaderMetadata, committed MessageIdPair) bool {
+	readerMetadata.mutex.RLock()
+	defer readerMetadata.mutex.RUnlock()
+	return readerMetadata.nextOffset != constant.UnknownOffset && readerMetadata.nextOffset != committed.Offset+1
+}
+
+// skipCommitted report whether the message is the committed message redelivered after seekToCommitted, or a message
+// up to the committed offset when the committed message is one before it
+func skipCommitted(readerMetadata *ReaderMetadata, message pulsar.Message, offset int64) bool {
+	readerMetadata.mutex.Lock()
+	defer readerMetadata.mutex.Unlock()
+	if readerMetadata.skipId == nil {
+		return false
+	}
+	if sameMessageId(message.ID(), readerMetadata.skipId) || offset <= readerMetadata.skipOffset {
+		return true
+	}
+	readerMetadata.skipId = nil
+	return false
+}
+
+// resetMessageId the start message of a reader whose group has no committed offset
+func (b *Broker) resetMessageId() pulsar.MessageID {
+	if b.kafsarConfig.AutoOffsetReset == constant.OffsetResetLatest {
+		return pulsar.LatestMessageID()
+	}
+	return pulsar.EarliestMessageID()
 }
 
-func (b *Broker) OffsetCommitPartition(addr net.Addr, kafkaTopic, clientID string, req *codec.OffsetCommitPartitionReq) (*codec.OffsetCommitPartitionResp, error) {
+func (b *Broker) OffsetCommitPartition(addr net.Addr, kafkaTopic, clientID string, req *codec.OffsetCommitPartitionReq) (resp *codec.OffsetCommitPartitionResp, err error) {
+	start := time.Now()
+	defer func() {
+		if resp != nil {
+			b.metrics.OffsetCommit(kafkaTopic, time.Since(start), resp.ErrorCode)
+		}
+	}()
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("offset commit failed when get userinfo by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
+		b.logger.Errorf("offset commit failed when get userinfo by addr %s, kafka topic: %s", addr.String(), kafkaTopic)
 		return &codec.OffsetCommitPartitionResp{
 			PartitionId: req.PartitionId,
 			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
@@ -555,183 +1669,368 @@ func (b *Broker) OffsetCommitPartition(addr net.Addr, kafkaTopic, clientID strin
 	}
 	partitionedTopic, err := b.partitionedTopic(user, kafkaTopic, req.PartitionId)
 	if err != nil {
-		logrus.Errorf("offset commit failed when get pulsar topic %s, kafka topic: %s", addr.String(), kafkaTopic)
+		b.logger.Errorf("offset commit failed when get pulsar topic %s, kafka topic: %s", addr.String(), kafkaTopic)
 		return &codec.OffsetCommitPartitionResp{
 			PartitionId: req.PartitionId,
-			ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode:   partitionedTopicErrorCode(err),
 		}, nil
 	}
 	b.mutex.RLock()
-	readerMessages, exist := b.readerManager[partitionedTopic+clientID]
+	readerMessages, exist := b.readerManager[readerKey(user.username, partitionedTopic, clientID)]
 	if !exist {
-		groupId, exist := b.topicGroupManager[partitionedTopic]
+		// the reader is not created yet or closed by a rebalance, commit to the group of the member
+		groupId, exist := b.topicGroupManager[user.username+partitionedTopic]
+		memberInfo, memberExist := b.memberManager[addr.String()]
 		b.mutex.RUnlock()
-		if exist {
-			group, err := b.groupCoordinator.GetGroup(user.username, groupId)
-			if err == nil && group.groupStatus != Stable {
-				logrus.Warnf("group is preparing rebalance. groupId: %s, topic: %s", groupId, partitionedTopic)
-				return &codec.OffsetCommitPartitionResp{ErrorCode: codec.REBALANCE_IN_PROGRESS}, nil
-			}
+		if memberExist {
+			groupId, exist = memberInfo.groupId, true
 		}
-		logrus.Warnf("commit offset failed, topic: %s, does not exist", partitionedTopic)
-		return &codec.OffsetCommitPartitionResp{ErrorCode: codec.REBALANCE_IN_PROGRESS}, nil
+		if !exist {
+			b.logger.Warnf("commit offset failed, topic: %s is not read by any group of %s", partitionedTopic, addr.String())
+			return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.REBALANCE_IN_PROGRESS}, nil
+		}
+		return b.commitOffsetWithoutReader(user, kafkaTopic, partitionedTopic, groupId, req), nil
 	}
 	b.mutex.RUnlock()
+	if b.kafsarConfig.ContinuousOffset && b.kafsarConfig.CheckCommitOffsetRange {
+		if errorCode := b.checkCommitOffsetRange(partitionedTopic, req.Offset, readerMessages); errorCode != codec.NONE {
+			return &codec.OffsetCommitPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   errorCode,
+			}, nil
+		}
+	}
 	readerMessages.mutex.RLock()
-	length := readerMessages.messageIds.Len()
+	// kafka clients commit the position after the last consumed record, it maps to the fetched message before it
+	index := searchMessageIdPair(readerMessages.messageIds, req.Offset)
+	var messageIdPair MessageIdPair
+	fetched := false
+	if index >= 0 {
+		messageIdPair = readerMessages.messageIds[index]
+		fetched = messageIdPair.Offset == req.Offset || messageIdPair.Offset+1 == req.Offset
+	}
 	readerMessages.mutex.RUnlock()
-	for i := 0; i < length; i++ {
-		readerMessages.mutex.RLock()
-		front := readerMessages.messageIds.Front()
-		readerMessages.mutex.RUnlock()
-		if front == nil {
-			break
-		}
-		messageIdPair := front.Value.(MessageIdPair)
-		// kafka commit offset maybe greater than current offset
-		if messageIdPair.Offset == req.Offset || ((messageIdPair.Offset < req.Offset) && (i == length-1)) {
-			err := b.offsetManager.CommitOffset(user.username, kafkaTopic, readerMessages.groupId, req.PartitionId, messageIdPair)
-			if err != nil {
-				logrus.Errorf("commit offset failed. topic: %s, err: %s", kafkaTopic, err)
-				return &codec.OffsetCommitPartitionResp{
-					PartitionId: req.PartitionId,
-					ErrorCode:   codec.UNKNOWN_SERVER_ERROR,
-				}, nil
-			}
-			logrus.Infof("ack pulsar %s for %s", partitionedTopic, messageIdPair.MessageId)
-			readerMessages.mutex.Lock()
-			readerMessages.messageIds.Remove(front)
-			readerMessages.mutex.Unlock()
-			break
-		}
-		if messageIdPair.Offset > req.Offset {
-			break
+	if !fetched {
+		// the offset is not fetched by the reader, e.g. the reader is recreated or the client seeks
+		pair, changed := b.resolveCommitOffset(user.username, kafkaTopic, readerMessages.groupId, req, messageIdPair)
+		if !changed {
+			return &codec.OffsetCommitPartitionResp{
+				PartitionId: req.PartitionId,
+				ErrorCode:   codec.NONE,
+			}, nil
 		}
-		readerMessages.mutex.Lock()
-		readerMessages.messageIds.Remove(front)
-		readerMessages.mutex.Unlock()
+		messageIdPair = pair
+	}
+	messageIdPair.Metadata = req.Metadata
+	err = b.offsetManager.CommitOffset(user.username, kafkaTopic, readerMessages.groupId, req.PartitionId, messageIdPair)
+	if err != nil {
+		b.logger.Errorf("commit offset failed. topic: %s, err: %s", kafkaTopic, err)
+		// the offset topic is not writable for now, the client retries the commit
+		return &codec.OffsetCommitPartitionResp{
+			PartitionId: req.PartitionId,
+			ErrorCode:   codec.COORDINATOR_NOT_AVAILABLE,
+		}, nil
+	}
+	b.logger.Infof("ack pulsar %s for %s", partitionedTopic, messageIdPair.MessageId)
+	readerMessages.mutex.Lock()
+	committed := searchMessageIdPair(readerMessages.messageIds, messageIdPair.Offset)
+	if reader, ok := readerMessages.reader.(*failoverReader); ok {
+		reader.ack(readerMessages.messageIds[:committed+1])
 	}
+	readerMessages.messageIds = readerMessages.messageIds[committed+1:]
+	readerMessages.mutex.Unlock()
 	return &codec.OffsetCommitPartitionResp{
 		PartitionId: req.PartitionId,
 		ErrorCode:   codec.NONE,
 	}, nil
 }
 
+// resolveCommitOffset map a position the reader has not fetched to the closest known message before it, the fetched
+// message, the committed message or the earliest message. the offset before the position is committed, a reader
+// starting from the message skips the messages up to it, so the topic is not scanned. the committed offset is reused
+// when the client commits it again or the position after it. changed false means the offset and metadata are already
+// committed
+func (b *Broker) resolveCommitOffset(username, kafkaTopic, groupId string, req *codec.OffsetCommitPartitionReq,
+	fetched MessageIdPair) (MessageIdPair, bool) {
+	committed, exist := b.offsetManager.AcquireOffset(username, kafkaTopic, groupId, req.PartitionId)
+	if exist && (committed.Offset == req.Offset || committed.Offset+1 == req.Offset) {
+		return committed, committed.Metadata != req.Metadata
+	}
+	messageId := fetched.MessageId
+	if exist && committed.Offset < req.Offset && (messageId == nil || committed.Offset > fetched.Offset) {
+		messageId = committed.MessageId
+	}
+	if messageId == nil {
+		messageId = pulsar.EarliestMessageID()
+	}
+	return MessageIdPair{MessageId: messageId, Offset: req.Offset - 1}, true
+}
+
+// commitOffsetWithoutReader commit the offset of a member whose reader is not created yet or closed by a rebalance,
+// unless the group is rebalancing. the offset is resolved like an offset the reader has not fetched
+func (b *Broker) commitOffsetWithoutReader(user *userInfo, kafkaTopic, partitionedTopic, groupId string, req *codec.OffsetCommitPartitionReq) *codec.OffsetCommitPartitionResp {
+	group, err := b.groupCoordinator.GetGroup(user.username, groupId)
+	if err != nil || group.groupStatus == Dead {
+		b.logger.Warnf("group does not exist, can not commit offset without reader. groupId: %s, topic: %s", groupId, partitionedTopic)
+		return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.UNKNOWN_MEMBER_ID}
+	}
+	if group.groupStatus == PreparingRebalance || group.groupStatus == CompletingRebalance {
+		b.logger.Warnf("group is rebalancing, can not commit offset without reader. groupId: %s, topic: %s", groupId, partitionedTopic)
+		return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.REBALANCE_IN_PROGRESS}
+	}
+	pair, changed := b.resolveCommitOffset(user.username, kafkaTopic, groupId, req, MessageIdPair{})
+	if !changed {
+		return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.NONE}
+	}
+	pair.Metadata = req.Metadata
+	err = b.offsetManager.CommitOffset(user.username, kafkaTopic, groupId, req.PartitionId, pair)
+	if err != nil {
+		b.logger.Errorf("commit offset failed. topic: %s, err: %s", kafkaTopic, err)
+		return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.COORDINATOR_NOT_AVAILABLE}
+	}
+	b.logger.Infof("commit offset without reader %s for %s", partitionedTopic, pair.MessageId)
+	return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.NONE}
+}
+
+// recoverReader create the reader from the committed offset when a stable member fetch before offset fetch,
+// e.g. the broker restarted and lost the readers. return nil if the group is not stable, with the error code if creation failed
+func (b *Broker) recoverReader(user *userInfo, kafkaTopic, partitionedTopic, clientId, groupId string, partitionId int) (*ReaderMetadata, codec.ErrorCode) {
+	group, err := b.groupCoordinator.GetGroup(user.username, groupId)
+	if err != nil || group.groupStatus != Stable {
+		b.logger.Warnf("group is not stable, can not recover reader. groupId: %s, topic: %s", groupId, partitionedTopic)
+		return nil, codec.NONE
+	}
+	subscriptionName, err := b.server.SubscriptionName(user.username, groupId)
+	if err != nil {
+		b.logger.Errorf("get subscription name of group %s failed when recover reader, error: %s", groupId, err)
+		return nil, codec.NONE
+	}
+	messageId := b.resetMessageId()
+	nextOffset := constant.UnknownOffset
+	committed, exist := b.offsetManager.AcquireOffset(user.username, kafkaTopic, groupId, partitionId)
+	if exist {
+		messageId = committed.MessageId
+		nextOffset = committed.Offset + 1
+	}
+	b.mutex.RLock()
+	_, exist = b.readerManager[readerKey(user.username, partitionedTopic, clientId)]
+	b.mutex.RUnlock()
+	if !exist {
+		err = b.createReaderMetadata(user.username, partitionedTopic, subscriptionName, groupId, messageId, nextOffset, clientId)
+		if err != nil {
+			b.logger.Errorf("recover reader failed. topic: %s, err: %s", partitionedTopic, err)
+			return nil, readerErrorCode(err)
+		}
+		b.logger.Infof("recover reader from committed message %s. topic: %s, groupId: %s", messageId, partitionedTopic, groupId)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	readerMetadata, exist := b.readerManager[readerKey(user.username, partitionedTopic, clientId)]
+	if !exist {
+		// closed by a rebalance right after it was created
+		return nil, codec.NONE
+	}
+	b.topicGroupManager[user.username+partitionedTopic] = groupId
+	b.kafkaPartitions[user.username+partitionedTopic] = kafkaPartition{topic: kafkaTopic, partition: partitionId}
+	group.addPartitionedTopic(partitionedTopic)
+	return readerMetadata, codec.NONE
+}
+
 func (b *Broker) OffsetFetch(addr net.Addr, topic, clientID, groupID string, req *codec.OffsetFetchPartitionReq) (*codec.OffsetFetchPartitionResp, error) {
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("offset fetch failed when get userinfo by addr %s, kafka topic: %s", addr.String(), topic)
+		b.logger.Errorf("offset fetch failed when get userinfo by addr %s, kafka topic: %s", addr.String(), topic)
 		return &codec.OffsetFetchPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	logrus.Infof("%s fetch topic: %s offset, partition: %d", addr.String(), topic, req.PartitionId)
+	b.logger.Infof("%s fetch topic: %s offset, partition: %d", addr.String(), topic, req.PartitionId)
 	partitionedTopic, err := b.partitionedTopic(user, topic, req.PartitionId)
 	if err != nil {
-		logrus.Errorf("offset fetch failed when get pulsar topic %s, kafka topic: %s", addr.String(), topic)
+		b.logger.Errorf("offset fetch failed when get pulsar topic %s, kafka topic: %s", addr.String(), topic)
 		return &codec.OffsetFetchPartitionResp{
-			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode: partitionedTopicErrorCode(err),
 		}, nil
 	}
-	subscriptionName, err := b.server.SubscriptionName(groupID)
+	subscriptionName, err := b.server.SubscriptionName(user.username, groupID)
 	if err != nil {
-		logrus.Errorf("sync group %s failed when offset fetch, error: %s", groupID, err)
+		b.logger.Errorf("sync group %s failed when offset fetch, error: %s", groupID, err)
 		return &codec.OffsetFetchPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
 	messagePair, flag := b.offsetManager.AcquireOffset(user.username, topic, groupID, req.PartitionId)
-	messageId := pulsar.EarliestMessageID()
+	messageId := b.resetMessageId()
 	kafkaOffset := constant.UnknownOffset
+	nextOffset := constant.UnknownOffset
+	var metadata *string
 	if flag {
+		metadata = &messagePair.Metadata
 		kafkaOffset = messagePair.Offset
 		messageId = messagePair.MessageId
+		nextOffset = messagePair.Offset + 1
 	}
 	b.mutex.RLock()
-	_, exist = b.readerManager[partitionedTopic+clientID]
+	readerMetadata, exist := b.readerManager[readerKey(user.username, partitionedTopic, clientID)]
 	b.mutex.RUnlock()
-	if !exist {
+	if exist && flag && readerDiverged(readerMetadata, messagePair) {
+		// the member rejoined, it continues from the committed position instead of where the reader stopped
+		if err := seekToCommitted(readerMetadata, messagePair); err != nil {
+			b.logger.Errorf("seek reader to committed offset failed. topic: %s, err: %s", partitionedTopic, err)
+			return &codec.OffsetFetchPartitionResp{
+				ErrorCode: codec.COORDINATOR_LOAD_IN_PROGRESS,
+			}, nil
+		}
+	} else if !exist && b.kafsarConfig.LazyCreateReader {
 		b.mutex.Lock()
-		metadata := ReaderMetadata{groupId: groupID, messageIds: list.New()}
-		channel, reader, err := b.createReader(partitionedTopic, subscriptionName, messageId, clientID)
+		b.pendingReaders[readerKey(user.username, partitionedTopic, clientID)] = &pendingReaderMetadata{
+			groupId:          groupID,
+			subscriptionName: subscriptionName,
+			messageId:        messageId,
+			nextOffset:       nextOffset,
+		}
+		b.mutex.Unlock()
+	} else if !exist {
+		err := b.createReaderMetadata(user.username, partitionedTopic, subscriptionName, groupID, messageId, nextOffset, clientID)
 		if err != nil {
-			b.mutex.Unlock()
-			logrus.Errorf("%s, create channel failed, error: %s", topic, err)
+			b.logger.Errorf("%s, create channel failed, error: %s", topic, err)
 			return &codec.OffsetFetchPartitionResp{
-				ErrorCode: codec.UNKNOWN_SERVER_ERROR,
+				ErrorCode: readerErrorCode(err),
 			}, nil
 		}
-		metadata.reader = reader
-		metadata.channel = channel
-		b.readerManager[partitionedTopic+clientID] = &metadata
-		b.mutex.Unlock()
 	}
 	group, err := b.groupCoordinator.GetGroup(user.username, groupID)
 	if err != nil {
-		logrus.Errorf("get group %s failed, error: %s", groupID, err)
+		b.logger.Errorf("get group %s failed, error: %s", groupID, err)
 		return &codec.OffsetFetchPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	if !b.checkPartitionTopicExist(group.partitionedTopic, partitionedTopic) {
-		group.partitionedTopic = append(group.partitionedTopic, partitionedTopic)
-	}
+	group.addPartitionedTopic(partitionedTopic)
 	b.mutex.Lock()
-	b.topicGroupManager[partitionedTopic] = group.groupId
+	b.topicGroupManager[user.username+partitionedTopic] = group.groupId
+	b.kafkaPartitions[user.username+partitionedTopic] = kafkaPartition{topic: topic, partition: req.PartitionId}
 	b.mutex.Unlock()
 
 	return &codec.OffsetFetchPartitionResp{
 		PartitionId: req.PartitionId,
 		Offset:      kafkaOffset,
 		LeaderEpoch: -1,
-		Metadata:    nil,
+		Metadata:    metadata,
 		ErrorCode:   codec.NONE,
 	}, nil
 }
 
+func (b *Broker) minFetchWaitMs(username, kafkaTopic string) int {
+	if resolver, ok := b.server.(FetchWaitResolver); ok {
+		if minFetchWaitMs, ok := resolver.MinFetchWaitMs(username, kafkaTopic); ok {
+			return minFetchWaitMs
+		}
+	}
+	return b.kafsarConfig.MinFetchWaitMs
+}
+
 func (b *Broker) partitionedTopic(user *userInfo, kafkaTopic string, partitionId int) (string, error) {
-	pulsarTopic, err := b.server.PulsarTopic(user.username, kafkaTopic)
+	if mapper, ok := b.server.(TopicMapper); ok {
+		return mapper.PartitionedPulsarTopic(user.username, kafkaTopic, partitionId)
+	}
+	pulsarTopic, err := b.pulsarTopic(user.username, kafkaTopic)
 	if err != nil {
 		return "", err
 	}
+	if b.kafsarConfig.DetectNonPartitionedTopic && b.isNonPartitionedTopic(pulsarTopic) {
+		if partitionId != 0 {
+			return "", errors.Wrapf(errUnknownPartition, "non-partitioned topic %s partition %d", pulsarTopic, partitionId)
+		}
+		return pulsarTopic, nil
+	}
 	return pulsarTopic + fmt.Sprintf(constant.PartitionSuffixFormat, partitionId), nil
 }
 
+// sameTopic compare pulsar topic names, short names are completed to persistent://public/default as pulsar does
+func sameTopic(a, b string) bool {
+	return fullTopicName(a) == fullTopicName(b)
+}
+
+func fullTopicName(topic string) string {
+	if strings.Contains(topic, "://") {
+		return topic
+	}
+	if !strings.Contains(topic, "/") {
+		return "persistent://public/default/" + topic
+	}
+	return "persistent://" + topic
+}
+
+// isNonPartitionedTopic the determination is cached once the admin api answers, fall back to partitioned on failure
+func (b *Broker) isNonPartitionedTopic(pulsarTopic string) bool {
+	b.mutex.RLock()
+	nonPartitioned, exist := b.nonPartitioned[pulsarTopic]
+	b.mutex.RUnlock()
+	if exist {
+		return nonPartitioned
+	}
+	nonPartitioned, err := utils.IsNonPartitionedTopic(pulsarTopic, b.getPulsarHttpUrl())
+	if err != nil {
+		b.logger.Warnf("check non-partitioned topic %s failed, treat as partitioned. err: %s", pulsarTopic, err)
+		return false
+	}
+	b.mutex.Lock()
+	b.nonPartitioned[pulsarTopic] = nonPartitioned
+	b.mutex.Unlock()
+	return nonPartitioned
+}
+
+// partitionedTopicErrorCode the error code responded when partitionedTopic failed
+func partitionedTopicErrorCode(err error) codec.ErrorCode {
+	if errors.Is(err, errUnknownPartition) {
+		return codec.UNKNOWN_TOPIC_OR_PARTITION
+	}
+	// the topic lookup fails while the topic is being created or its bundle is unloading, let the client retry
+	return codec.NOT_LEADER_OR_FOLLOWER
+}
+
 func (b *Broker) OffsetLeaderEpoch(addr net.Addr, topic string, req *codec.OffsetLeaderEpochPartitionReq) (*codec.OffsetForLeaderEpochPartitionResp, error) {
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("offset fetch failed when get userinfo by addr %s, kafka topic: %s", addr.String(), topic)
+		b.logger.Errorf("offset fetch failed when get userinfo by addr %s, kafka topic: %s", addr.String(), topic)
 		return &codec.OffsetForLeaderEpochPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	logrus.Infof("%s offset leader epoch topic: %s, partition: %d", addr.String(), topic, req.PartitionId)
+	b.logger.Infof("%s offset leader epoch topic: %s, partition: %d", addr.String(), topic, req.PartitionId)
 	partitionedTopic, err := b.partitionedTopic(user, topic, req.PartitionId)
 	if err != nil {
-		logrus.Errorf("get partitioned topic failed. topic: %s", topic)
+		b.logger.Errorf("get partitioned topic failed. topic: %s", topic)
 		return &codec.OffsetForLeaderEpochPartitionResp{
-			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
+			ErrorCode: partitionedTopicErrorCode(err),
 		}, nil
 	}
 	msgByte, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
 	if err != nil {
-		logrus.Errorf("get last msgId failed. topic: %s", topic)
+		b.logger.Errorf("get last msgId failed. topic: %s", topic)
 		return &codec.OffsetForLeaderEpochPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
 	msg, err := utils.ReadLastedMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, msgByte, b.pulsarCommonClient)
 	if err != nil {
-		logrus.Errorf("get last msgId failed. topic: %s", topic)
+		b.logger.Errorf("get last msgId failed. topic: %s", topic)
+		return &codec.OffsetForLeaderEpochPartitionResp{
+			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
+		}, nil
+	}
+	offset, err := convOffset(msg, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+	if err != nil {
+		b.logger.Errorf("convert offset failed. topic: %s, err: %s", topic, err)
 		return &codec.OffsetForLeaderEpochPartitionResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}, nil
 	}
-	offset := convOffset(msg, b.kafsarConfig.ContinuousOffset)
 	return &codec.OffsetForLeaderEpochPartitionResp{
 		ErrorCode:   codec.NONE,
 		PartitionId: req.PartitionId,
@@ -752,6 +2051,7 @@ func (b *Broker) SaslAuth(addr net.Addr, req codec.SaslAuthenticateReq) (bool, c
 		b.mutex.Lock()
 		b.userInfoManager[addr.String()] = &userInfo{
 			username: req.Username,
+			password: req.Password,
 			clientId: req.ClientId,
 		}
 		b.mutex.Unlock()
@@ -760,36 +2060,26 @@ func (b *Broker) SaslAuth(addr net.Addr, req codec.SaslAuthenticateReq) (bool, c
 }
 
 func (b *Broker) SaslAuthTopic(addr net.Addr, req codec.SaslAuthenticateReq, topic, permissionType string) (bool, codec.ErrorCode) {
-	auth, err := b.server.AuthTopic(req.Username, req.Password, req.ClientId, topic, permissionType)
-	if err != nil || !auth {
-		return false, codec.SASL_AUTHENTICATION_FAILED
-	}
-	return true, codec.NONE
+	principal := Principal{Username: req.Username, Password: req.Password, ClientId: req.ClientId}
+	code := b.authorize(principal, permissionOperation(permissionType), Resource{Type: ResourceTopic, Name: topic})
+	return code == codec.NONE, code
 }
 
 func (b *Broker) SaslAuthConsumerGroup(addr net.Addr, req codec.SaslAuthenticateReq, consumerGroup string) (bool, codec.ErrorCode) {
-	auth, err := b.server.AuthTopicGroup(req.Username, req.Password, req.ClientId, consumerGroup)
-	if err != nil || !auth {
-		return false, codec.SASL_AUTHENTICATION_FAILED
-	}
-	return true, codec.NONE
+	principal := Principal{Username: req.Username, Password: req.Password, ClientId: req.ClientId}
+	code := b.authorize(principal, OperationRead, Resource{Type: ResourceGroup, Name: consumerGroup})
+	return code == codec.NONE, code
 }
 
 func (b *Broker) Disconnect(addr net.Addr) {
-	logrus.Infof("lost connection: %s", addr)
+	b.logger.Infof("lost connection: %s", addr)
 	if addr == nil {
 		return
 	}
 	b.mutex.RLock()
 	memberInfo, exist := b.memberManager[addr.String()]
-	producer, producerExist := b.producerManager[addr.String()]
 	b.mutex.RUnlock()
-	if producerExist {
-		producer.Close()
-		b.mutex.Lock()
-		delete(b.producerManager, addr.String())
-		b.mutex.Unlock()
-	}
+	b.closeProducers(addr)
 	if !exist {
 		b.mutex.Lock()
 		delete(b.userInfoManager, addr.String())
@@ -809,16 +2099,65 @@ func (b *Broker) Disconnect(addr net.Addr) {
 	}
 	_, err := b.GroupLeave(addr, &req)
 	if err != nil {
-		logrus.Errorf("leave group failed. err: %s", err)
+		b.logger.Errorf("leave group failed. err: %s", err)
 	}
 	// leave group will use user information
 	b.mutex.Lock()
+	delete(b.memberManager, addr.String())
 	delete(b.userInfoManager, addr.String())
 	b.mutex.Unlock()
 }
 
+// closeProducers close the producer of the connection and its idempotent producers
+func (b *Broker) closeProducers(addr net.Addr) {
+	prefix := addr.String() + "/"
+	producers := make([]pulsar.Producer, 0)
+	b.mutex.Lock()
+	for key, producer := range b.producerManager {
+		if key == addr.String() || strings.HasPrefix(key, prefix) {
+			producers = append(producers, producer)
+			delete(b.producerManager, key)
+		}
+	}
+	b.metrics.ProducerCount(len(b.producerManager))
+	b.mutex.Unlock()
+	for _, producer := range producers {
+		producer.Close()
+	}
+}
+
+// Close the broker, waiting up to KafsarConfig.CloseGracePeriodMs for in-flight requests to drain
 func (b *Broker) Close() {
+	gracePeriod := constant.DefaultCloseGracePeriod
+	if b.kafsarConfig.CloseGracePeriodMs > 0 {
+		gracePeriod = time.Duration(b.kafsarConfig.CloseGracePeriodMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := b.CloseContext(ctx); err != nil {
+		b.logger.Warnf("close broker before in-flight requests drained: %s", err)
+	}
+}
+
+// CloseContext stop accepting new connections, wait for in-flight produce and fetch handlers and producer
+// flushes until ctx done, then close the kafka server, offset manager, clients and producers.
+// the broker is always closed, the returned error tells whether the drain completed
+func (b *Broker) CloseContext(ctx context.Context) error {
+	b.kafkaServer.StopAccept()
+	if b.stopCh != nil {
+		b.stopOnce.Do(func() {
+			close(b.stopCh)
+		})
+	}
+	err := b.inflight.drain(ctx)
+	if err == nil {
+		err = b.flushProducers(ctx)
+	}
+	if drainErr := b.drainReaders(); drainErr != nil {
+		b.logger.Warnf("close readers before fetched messages committed: %s", drainErr)
+	}
 	b.kafkaServer.Close(context.Background())
+	b.closeReaders()
 	b.offsetManager.Close()
 	b.mutex.Lock()
 	for key, value := range b.pulsarClientManage {
@@ -829,24 +2168,227 @@ func (b *Broker) Close() {
 		value.Close()
 		delete(b.producerManager, key)
 	}
+	b.metrics.ProducerCount(len(b.producerManager))
 	b.mutex.Unlock()
+	b.userClients.close()
+	return err
+}
+
+// flushProducers flush pending messages of all producers until ctx done
+func (b *Broker) flushProducers(ctx context.Context) error {
+	b.mutex.RLock()
+	producers := make([]pulsar.Producer, 0, len(b.producerManager))
+	for _, producer := range b.producerManager {
+		producers = append(producers, producer)
+	}
+	b.mutex.RUnlock()
+	flushed := make(chan struct{})
+	go func() {
+		for _, producer := range producers {
+			if err := producer.Flush(); err != nil {
+				b.logger.Errorf("flush producer %s failed: %s", producer.Topic(), err)
+			}
+		}
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainReaders wait up to ReaderDrainTimeoutMs for the messages already fetched by clients to be committed,
+// otherwise they are delivered again after restart
+func (b *Broker) drainReaders() error {
+	if b.kafsarConfig.ReaderDrainTimeoutMs <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(b.kafsarConfig.ReaderDrainTimeoutMs)*time.Millisecond)
+	defer cancel()
+	ticker := time.NewTicker(constant.ReaderDrainCheckInterval)
+	defer ticker.Stop()
+	for {
+		uncommitted := b.uncommittedMessages()
+		if uncommitted == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "%d fetched messages not committed", uncommitted)
+		}
+	}
+}
+
+func (b *Broker) uncommittedMessages() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	count := 0
+	for _, readerMetadata := range b.readerManager {
+		readerMetadata.mutex.RLock()
+		count += len(readerMetadata.messageIds)
+		readerMetadata.mutex.RUnlock()
+	}
+	return count
+}
+
+func (b *Broker) closeReaders() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for key, readerMetadata := range b.readerManager {
+		readerMetadata.reader.Close()
+		delete(b.readerManager, key)
+	}
+	b.metrics.ReaderCount(len(b.readerManager))
+}
+
+// closingFetchResp answer every partition with NOT_LEADER_OR_FOLLOWER, so that clients refresh metadata and retry
+func closingFetchResp(req *codec.FetchReq) []*codec.FetchTopicResp {
+	result := make([]*codec.FetchTopicResp, len(req.TopicReqList))
+	for i, topicReq := range req.TopicReqList {
+		f := &codec.FetchTopicResp{Topic: topicReq.Topic}
+		f.PartitionRespList = make([]*codec.FetchPartitionResp, len(topicReq.PartitionReqList))
+		for j, partitionReq := range topicReq.PartitionReqList {
+			f.PartitionRespList[j] = &codec.FetchPartitionResp{
+				PartitionIndex: partitionReq.PartitionId,
+				ErrorCode:      codec.NOT_LEADER_OR_FOLLOWER,
+				RecordBatch:    &codec.RecordBatch{Records: make([]*codec.Record, 0)},
+			}
+		}
+		result[i] = f
+	}
+	return result
 }
 
 func (b *Broker) GetOffsetManager() OffsetManager {
 	return b.offsetManager
 }
 
-func (b *Broker) createReader(partitionedTopic string, subscriptionName string, messageId pulsar.MessageID, clientId string) (chan pulsar.ReaderMessage, pulsar.Reader, error) {
-	client, exist := b.pulsarClientManage[partitionedTopic+clientId]
+// fetchRecordsCapacity preallocate the records of a partition fetch, bounded to avoid over allocating for a large
+// MaxFetchRecord when the fetch returns early
+func fetchRecordsCapacity(maxFetchRecord int) int {
+	if maxFetchRecord <= 0 {
+		return 0
+	}
+	if maxFetchRecord > constant.MaxPreallocatedFetchRecords {
+		return constant.MaxPreallocatedFetchRecords
+	}
+	return maxFetchRecord
+}
+
+// readerKey readers and their pulsar clients are per user, tenants may share the same pulsar topic
+func readerKey(username, partitionedTopic, clientId string) string {
+	return username + partitionedTopic + clientId
+}
+
+// readerCreation a reader being created outside the broker mutex, concurrent requests of the reader wait on done
+type readerCreation struct {
+	done chan struct{}
+	err  error
+}
+
+// createReaderMetadata create the reader and add it to readerManager, the caller must not hold the broker mutex.
+// the creation is retried for seconds while pulsar is unavailable, other requests on the broker must not wait for it
+func (b *Broker) createReaderMetadata(username, partitionedTopic, subscriptionName, groupId string, messageId pulsar.MessageID, nextOffset int64, clientId string) error {
+	key := readerKey(username, partitionedTopic, clientId)
+	b.mutex.Lock()
+	if _, exist := b.readerManager[key]; exist {
+		b.mutex.Unlock()
+		return nil
+	}
+	creation, creating := b.readerCreating[key]
+	if !creating {
+		creation = &readerCreation{done: make(chan struct{})}
+		b.readerCreating[key] = creation
+	}
+	b.mutex.Unlock()
+	if creating {
+		<-creation.done
+		return creation.err
+	}
+	metadata := &ReaderMetadata{groupId: groupId, messageIds: make([]MessageIdPair, 0), nextOffset: nextOffset}
+	if nextOffset != constant.UnknownOffset {
+		// the committed message may be one before the committed offset
+		metadata.skipId, metadata.skipOffset = messageId, nextOffset-1
+	}
+	creation.err = b.readerBreaker.allow()
+	if creation.err == nil {
+		creation.err = b.retryReaderCreation(partitionedTopic, func() error {
+			var err error
+			if b.isFailoverGroup(groupId) {
+				metadata.reader, err = b.createFailoverReader(username, partitionedTopic, subscriptionName, messageId, clientId)
+			} else {
+				metadata.channel, metadata.reader, err = b.createReader(username, partitionedTopic, subscriptionName, messageId, clientId)
+			}
+			return err
+		})
+		b.readerBreaker.report(creation.err)
+	}
+	b.mutex.Lock()
+	delete(b.readerCreating, key)
+	if creation.err == nil {
+		b.readerManager[key] = metadata
+		b.metrics.ReaderCount(len(b.readerManager))
+	}
+	b.mutex.Unlock()
+	close(creation.done)
+	return creation.err
+}
+
+// activatePendingReader create the reader deferred by OffsetFetch, the pending reader is kept if creation failed
+func (b *Broker) activatePendingReader(username, partitionedTopic, clientId string) codec.ErrorCode {
+	key := readerKey(username, partitionedTopic, clientId)
+	b.mutex.RLock()
+	pending, exist := b.pendingReaders[key]
+	b.mutex.RUnlock()
+	if !exist {
+		return codec.NONE
+	}
+	err := b.createReaderMetadata(username, partitionedTopic, pending.subscriptionName, pending.groupId, pending.messageId, pending.nextOffset, clientId)
+	if err != nil {
+		b.logger.Errorf("create pending reader failed. topic: %s, err: %s", partitionedTopic, err)
+		return readerErrorCode(err)
+	}
+	b.mutex.Lock()
+	if b.pendingReaders[key] != pending {
+		b.mutex.Unlock()
+		// closed by a rebalance while it was created
+		b.closeReader(username, partitionedTopic, clientId)
+		return codec.NONE
+	}
+	delete(b.pendingReaders, key)
+	b.mutex.Unlock()
+	b.logger.Infof("create pending reader success. topic: %s", partitionedTopic)
+	return codec.NONE
+}
+
+// readerClient get or create the pulsar client of the reader
+func (b *Broker) readerClient(username, partitionedTopic, clientId string) (pulsar.Client, error) {
+	if b.kafsarConfig.PulsarClientPerUser {
+		// shared by the readers of the user, not closed with the reader
+		return b.userClient(username)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	client, exist := b.pulsarClientManage[readerKey(username, partitionedTopic, clientId)]
 	if !exist {
 		var err error
-		pulsarUrl := fmt.Sprintf("pulsar://%s:%d", b.pulsarConfig.Host, b.pulsarConfig.TcpPort)
-		client, err = pulsar.NewClient(pulsar.ClientOptions{URL: pulsarUrl})
+		client, err = pulsar.NewClient(b.pulsarClientOptions())
 		if err != nil {
-			logrus.Errorf("create pulsar client failed.")
-			return nil, nil, err
+			b.logger.Errorf("create pulsar client failed.")
+			return nil, err
 		}
-		b.pulsarClientManage[partitionedTopic+clientId] = client
+		b.pulsarClientManage[readerKey(username, partitionedTopic, clientId)] = client
+	}
+	return client, nil
+}
+
+func (b *Broker) createReader(username, partitionedTopic string, subscriptionName string, messageId pulsar.MessageID, clientId string) (chan pulsar.ReaderMessage, pulsar.Reader, error) {
+	client, err := b.readerClient(username, partitionedTopic, clientId)
+	if err != nil {
+		return nil, nil, err
 	}
 	channel := make(chan pulsar.ReaderMessage, b.kafsarConfig.ConsumerReceiveQueueSize)
 	options := pulsar.ReaderOptions{
@@ -861,15 +2403,16 @@ func (b *Broker) createReader(partitionedTopic string, subscriptionName string,
 	if err != nil {
 		return nil, nil, err
 	}
-	return channel, reader, nil
+	return channel, newChannelReader(reader, channel), nil
 }
 
 func (b *Broker) HeartBeat(addr net.Addr, req codec.HeartbeatReq) *codec.HeartbeatResp {
+	logger := b.groupLogger(addr, req.GroupId)
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("HeartBeat failed when get userinfo by addr %s", addr.String())
+		logger.Errorf("HeartBeat failed when get userinfo by addr %s", addr.String())
 		return &codec.HeartbeatResp{
 			ErrorCode: codec.UNKNOWN_SERVER_ERROR,
 		}
@@ -878,41 +2421,140 @@ func (b *Broker) HeartBeat(addr net.Addr, req codec.HeartbeatReq) *codec.Heartbe
 	if resp.ErrorCode == codec.REBALANCE_IN_PROGRESS {
 		group, err := b.groupCoordinator.GetGroup(user.username, req.GroupId)
 		if err != nil {
-			logrus.Errorf("HeartBeat failed when get group by addr %s", addr.String())
+			logger.Errorf("HeartBeat failed when get group by addr %s", addr.String())
 			return resp
 		}
-		for _, topic := range group.partitionedTopic {
-			b.mutex.Lock()
-			readerMetadata, exist := b.readerManager[topic+req.ClientId]
-			if exist {
-				readerMetadata.reader.Close()
-				logrus.Infof("success close reader topic by heartbeat rebalance: %s", group.partitionedTopic)
-				delete(b.readerManager, topic+req.ClientId)
-				readerMetadata = nil
-			}
-			client, exist := b.pulsarClientManage[topic+req.ClientId]
-			if exist {
-				client.Close()
-				delete(b.pulsarClientManage, topic+req.ClientId)
-				client = nil
-			}
-			b.mutex.Unlock()
+		if isCooperative(group) {
+			// members keep reading their partitions, the revoked ones are closed at sync
+			return resp
+		}
+		for _, topic := range group.subscribedTopics() {
+			b.closeReader(user.username, topic, req.ClientId)
+			logger.Infof("success close reader topic by heartbeat rebalance: %s", topic)
 		}
 	}
 	return resp
 }
 
+// readByClients report whether any of the clients has a reader or pending reader of the partition
+func (b *Broker) readByClients(username, partitionedTopic string, clientIds map[string]bool) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for clientId := range clientIds {
+		key := readerKey(username, partitionedTopic, clientId)
+		if _, exist := b.readerManager[key]; exist {
+			return true
+		}
+		if _, exist := b.pendingReaders[key]; exist {
+			return true
+		}
+	}
+	return false
+}
+
+// closeReader close the reader of the partition and its pulsar client
+func (b *Broker) closeReader(username, partitionedTopic, clientId string) {
+	key := readerKey(username, partitionedTopic, clientId)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	readerMetadata, exist := b.readerManager[key]
+	if exist {
+		readerMetadata.reader.Close()
+		delete(b.readerManager, key)
+		b.metrics.ReaderCount(len(b.readerManager))
+	}
+	delete(b.pendingReaders, key)
+	client, exist := b.pulsarClientManage[key]
+	if exist {
+		client.Close()
+		delete(b.pulsarClientManage, key)
+	}
+}
+
+// closeRevokedReaders close the readers of the partitions the cooperative rebalance revoked from the member
+func (b *Broker) closeRevokedReaders(user *userInfo, groupId, memberId, clientId string) {
+	group, err := b.groupCoordinator.GetGroup(user.username, groupId)
+	if err != nil || !isCooperative(group) {
+		return
+	}
+	group.groupMemberLock.Lock()
+	var revoked map[string][]int32
+	if member, exist := group.members[memberId]; exist {
+		revoked = member.revoked
+		member.revoked = nil
+	}
+	group.groupMemberLock.Unlock()
+	for topic, partitions := range revoked {
+		for _, partition := range partitions {
+			partitionedTopic, err := b.partitionedTopic(user, topic, int(partition))
+			if err != nil {
+				b.logger.Errorf("get revoked partition %s-%d failed. err: %s", topic, partition, err)
+				continue
+			}
+			b.closeReader(user.username, partitionedTopic, clientId)
+			b.logger.Infof("close reader of revoked partition %s, member: %s", partitionedTopic, memberId)
+		}
+	}
+}
+
+func (b *Broker) FindCoordinator(addr net.Addr, req *codec.FindCoordinatorReq) (*codec.FindCoordinatorResp, error) {
+	var username string
+	b.mutex.RLock()
+	user, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if exist {
+		username = user.username
+	}
+	resp, err := b.groupCoordinator.FindCoordinator(username, req.Key, req.KeyType)
+	if err != nil {
+		b.logger.Errorf("find coordinator failed. key: %s, keyType: %d, err: %s", req.Key, req.KeyType, err)
+		return nil, err
+	}
+	b.logger.Infof("%s find coordinator key: %s, coordinator: %s:%d", addr.String(), req.Key, resp.Host, resp.Port)
+	return resp, nil
+}
+
+// InitProducerId assign producer id and epoch for idempotent producers, Produce dedup batches by their sequence
+func (b *Broker) InitProducerId(addr net.Addr, req *InitProducerIdReq) (*InitProducerIdResp, error) {
+	b.mutex.RLock()
+	_, exist := b.userInfoManager[addr.String()]
+	b.mutex.RUnlock()
+	if !exist {
+		b.logger.Errorf("init producer id failed when get userinfo by addr %s", addr.String())
+		return &InitProducerIdResp{
+			ErrorCode:     codec.UNKNOWN_SERVER_ERROR,
+			ProducerId:    constant.NoProducerId,
+			ProducerEpoch: constant.NoProducerEpoch,
+		}, nil
+	}
+	if req.TransactionalId != nil && !b.kafsarConfig.AcceptTransactionalProduce {
+		b.logger.Errorf("transactional producer is not supported. addr: %s, transactionalId: %s", addr.String(), *req.TransactionalId)
+		return &InitProducerIdResp{
+			ErrorCode:     codec.INVALID_TXN_STATE,
+			ProducerId:    constant.NoProducerId,
+			ProducerEpoch: constant.NoProducerEpoch,
+		}, nil
+	}
+	producerId, producerEpoch, errorCode := b.producerStates.initProducer(req.ProducerId, req.ProducerEpoch)
+	b.logger.Infof("%s init producer id: %d, epoch: %d, errorCode: %d", addr.String(), producerId, producerEpoch, errorCode)
+	return &InitProducerIdResp{
+		ErrorCode:     errorCode,
+		ProducerId:    producerId,
+		ProducerEpoch: producerEpoch,
+	}, nil
+}
+
 func (b *Broker) PartitionNum(addr net.Addr, kafkaTopic string) (int, error) {
 	b.mutex.RLock()
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("get partitionNum failed. user is not found. topic: %s", kafkaTopic)
+		b.logger.Errorf("get partitionNum failed. user is not found. topic: %s", kafkaTopic)
 		return 0, errors.New("user not found.")
 	}
-	num, err := b.server.PartitionNum(user.username, kafkaTopic)
+	num, err := b.partitionNum(user.username, kafkaTopic)
 	if err != nil {
-		logrus.Errorf("get partition num failed. topic: %s, err: %s", kafkaTopic, err)
+		b.logger.Errorf("get partition num failed. topic: %s, err: %s", kafkaTopic, err)
 		return 0, errors.New("get partition num failed.")
 	}
 	return num, nil
@@ -923,26 +2565,58 @@ func (b *Broker) TopicList(addr net.Addr) ([]string, error) {
 	user, exist := b.userInfoManager[addr.String()]
 	b.mutex.RUnlock()
 	if !exist {
-		logrus.Errorf("get topics list failed. user not found. addr: %s", addr.String())
+		b.logger.Errorf("get topics list failed. user not found. addr: %s", addr.String())
 		return nil, errors.New("user not found")
 	}
 	topic, err := b.server.ListTopic(user.username)
 	if err != nil {
-		logrus.Errorf("get topic list failed. err: %s", err)
+		b.logger.Errorf("get topic list failed. err: %s", err)
 		return nil, err
 	}
+	if topic == nil {
+		// authorized to see nothing is not an error
+		return make([]string, 0), nil
+	}
 	return topic, nil
 }
 
+// validRecordBatch a malformed produce request may carry a nil batch or nil records
+func validRecordBatch(recordBatch *codec.RecordBatch) bool {
+	if recordBatch == nil {
+		return false
+	}
+	for _, record := range recordBatch.Records {
+		if record == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isTransactionalBatch pulsar topics used by kafsar are not transactional, transactional batches and control markers can not be produced
+func isTransactionalBatch(recordBatch *codec.RecordBatch) bool {
+	return recordBatch.Flags&(constant.RecordBatchTransactionalFlag|constant.RecordBatchControlFlag) != 0
+}
+
 func (b *Broker) getPulsarHttpUrl() string {
 	return fmt.Sprintf("http://%s:%d", b.pulsarConfig.Host, b.pulsarConfig.HttpPort)
 }
 
-func (b *Broker) checkPartitionTopicExist(topics []string, partitionTopic string) bool {
-	for _, topic := range topics {
-		if strings.EqualFold(topic, partitionTopic) {
-			return true
-		}
+// pulsarClientOptions the options of every pulsar client created by the broker
+// checkKeepAliveInterval the pulsar client in use has no ClientOptions.KeepAliveInterval, only its fixed interval is accepted
+func checkKeepAliveInterval(config PulsarConfig) error {
+	interval := time.Duration(config.KeepAliveIntervalMs) * time.Millisecond
+	if interval != 0 && interval != constant.PulsarKeepAliveInterval {
+		return errors.Errorf("KeepAliveIntervalMs %d is not supported, the pulsar client pings every %s",
+			config.KeepAliveIntervalMs, constant.PulsarKeepAliveInterval)
+	}
+	return nil
+}
+
+func (b *Broker) pulsarClientOptions() pulsar.ClientOptions {
+	return pulsar.ClientOptions{
+		URL:               fmt.Sprintf("pulsar://%s:%d", b.pulsarConfig.Host, b.pulsarConfig.TcpPort),
+		OperationTimeout:  time.Duration(b.pulsarConfig.OperationTimeoutMs) * time.Millisecond,
+		ConnectionTimeout: time.Duration(b.pulsarConfig.ConnectionTimeoutMs) * time.Millisecond,
 	}
-	return false
 }
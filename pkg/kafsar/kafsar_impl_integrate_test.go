@@ -441,6 +441,7 @@ func TestEarliestMsg(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(t, codec.NONE, listPartition.ErrorCode)
+	assert.Equal(t, ConvertMsgId(earliestMessageId), listPartition.Offset)
 
 	// fetch partition
 	fetchPartitionReq := codec.FetchPartitionReq{
@@ -675,6 +676,7 @@ func TestEarliestTypeWithNoMsg(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(t, codec.NONE, listPartition.ErrorCode)
+	assert.Equal(t, constant.DefaultOffset, listPartition.Offset)
 }
 
 func TestMinBytesMsg(t *testing.T) {
@@ -1155,3 +1157,534 @@ func TestMsgWithFlowQuota(t *testing.T) {
 	assert.Equal(t, codec.NONE, fetchPartitionResp.ErrorCode)
 	assert.Equal(t, 0, len(fetchPartitionResp.RecordBatch.Records))
 }
+
+func TestCommitOffsetWithoutReader(t *testing.T) {
+	topic := uuid.New().String()
+	groupId := uuid.New().String()
+	pulsarTopic := utils.PartitionedTopic(test.DefaultTopicType+test.TopicPrefix+topic, partition)
+	test.SetupPulsar()
+	k, err := NewKafsar(kafsarServer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	pulsarClient := test.NewPulsarClient()
+	defer pulsarClient.Close()
+	producer, err := pulsarClient.CreateProducer(pulsar.ProducerOptions{Topic: pulsarTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := pulsar.ProducerMessage{Payload: []byte(testContent)}
+	messageId, err := producer.Send(context.TODO(), &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logrus.Infof("send msg to pulsar %s", messageId)
+
+	// sasl auth
+	saslReq := codec.SaslAuthenticateReq{
+		Username: username,
+		Password: password,
+		BaseReq:  codec.BaseReq{ClientId: clientId},
+	}
+	auth, errorCode := k.SaslAuth(&addr, saslReq)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.True(t, true, auth)
+
+	// join group
+	joinGroupReq := codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	}
+	joinGroupResp, err := k.GroupJoin(&addr, &joinGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+	// sync group
+	syncReq := codec.SyncGroupReq{
+		BaseReq:      codec.BaseReq{ClientId: clientId},
+		GroupId:      groupId,
+		GenerationId: joinGroupResp.GenerationId,
+		MemberId:     joinGroupResp.MemberId,
+		GroupAssignments: []*codec.GroupAssignment{{
+			MemberId:         joinGroupResp.MemberId,
+			MemberAssignment: []byte("testAssignment: " + joinGroupResp.MemberId),
+		}},
+	}
+	syncGroupResp, err := k.GroupSync(&addr, &syncReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+
+	// offset commit before fetch
+	offset := ConvertMsgId(messageId)
+	offsetCommitPartitionReq := codec.OffsetCommitPartitionReq{
+		PartitionId: partition,
+		Offset:      offset,
+	}
+	commitPartitionResp, err := k.OffsetCommitPartition(&addr, topic, clientId, &offsetCommitPartitionReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, commitPartitionResp.ErrorCode)
+	// acquire offset
+	time.Sleep(5 * time.Second)
+	acquireOffset, b := k.GetOffsetManager().AcquireOffset(username, topic, groupId, partition)
+	assert.True(t, b)
+	// the committed position is after the consumed message
+	assert.Equal(t, offset-1, acquireOffset.Offset)
+}
+
+func TestOffsetFetchWithoutFetchLazyCreateReader(t *testing.T) {
+	topic := uuid.New().String()
+	groupId := uuid.New().String()
+	pulsarTopic := utils.PartitionedTopic(test.DefaultTopicType+test.TopicPrefix+topic, partition)
+	test.SetupPulsar()
+	lazyConfig := *config
+	lazyConfig.KafsarConfig.LazyCreateReader = true
+	k, err := NewKafsar(kafsarServer, &lazyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// sasl auth
+	saslReq := codec.SaslAuthenticateReq{
+		Username: username,
+		Password: password,
+		BaseReq:  codec.BaseReq{ClientId: clientId},
+	}
+	auth, errorCode := k.SaslAuth(&addr, saslReq)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.True(t, true, auth)
+
+	// join group
+	joinGroupReq := codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	}
+	joinGroupResp, err := k.GroupJoin(&addr, &joinGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+	// offset fetch
+	offsetFetchReq := codec.OffsetFetchPartitionReq{
+		PartitionId: partition,
+	}
+	offsetFetchPartitionResp, err := k.OffsetFetch(&addr, topic, clientId, groupId, &offsetFetchReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, offsetFetchPartitionResp.ErrorCode)
+	assert.Equal(t, 0, len(k.readerManager))
+	assert.Equal(t, 0, len(k.pulsarClientManage))
+
+	// fetch partition create the reader
+	fetchPartitionReq := codec.FetchPartitionReq{
+		PartitionId: partition,
+		FetchOffset: offsetFetchPartitionResp.Offset,
+	}
+	fetchPartitionResp := k.FetchPartition(&addr, topic, clientId, &fetchPartitionReq, maxBytes, minBytes, 500, LocalSpan{})
+	assert.Equal(t, codec.NONE, fetchPartitionResp.ErrorCode)
+	_, exist := k.readerManager[readerKey(username, pulsarTopic, clientId)]
+	assert.True(t, exist)
+	assert.Equal(t, 0, len(k.pendingReaders))
+}
+
+func TestTimestampOffsetList(t *testing.T) {
+	topic := uuid.New().String()
+	groupId := uuid.New().String()
+	pulsarTopic := utils.PartitionedTopic(test.DefaultTopicType+test.TopicPrefix+topic, partition)
+	test.SetupPulsar()
+	k, err := NewKafsar(kafsarServer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulsarClient := test.NewPulsarClient()
+	defer pulsarClient.Close()
+	producer, err := pulsarClient.CreateProducer(pulsar.ProducerOptions{Topic: pulsarTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := pulsar.ProducerMessage{Value: testContent}
+	_, err = producer.Send(context.TODO(), &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	searchTime := time.Now().UnixMilli()
+	time.Sleep(100 * time.Millisecond)
+	message = pulsar.ProducerMessage{Value: []byte("after timestamp")}
+	afterMessageId, err := producer.Send(context.TODO(), &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sasl auth
+	saslReq := codec.SaslAuthenticateReq{
+		Username: username,
+		Password: password,
+		BaseReq:  codec.BaseReq{ClientId: clientId},
+	}
+	auth, errorCode := k.SaslAuth(&addr, saslReq)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.True(t, true, auth)
+
+	// join group
+	joinGroupReq := codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	}
+	joinGroupResp, err := k.GroupJoin(&addr, &joinGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+	// offset fetch
+	offsetFetchReq := codec.OffsetFetchPartitionReq{
+		PartitionId: partition,
+	}
+	offsetFetchPartitionResp, err := k.OffsetFetch(&addr, topic, clientId, groupId, &offsetFetchReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, offsetFetchPartitionResp.ErrorCode)
+
+	// offset list by timestamp
+	listOffset := codec.ListOffsetsPartition{
+		Time:        searchTime,
+		PartitionId: partition,
+	}
+	listPartition, err := k.OffsetListPartition(&addr, topic, clientId, &listOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, listPartition.ErrorCode)
+	assert.Equal(t, ConvertMsgId(afterMessageId), listPartition.Offset)
+	assert.GreaterOrEqual(t, listPartition.Timestamp, searchTime)
+
+	// fetch partition
+	fetchPartitionReq := codec.FetchPartitionReq{
+		PartitionId: partition,
+		FetchOffset: listPartition.Offset,
+	}
+	fetchPartitionResp := k.FetchPartition(&addr, topic, clientId, &fetchPartitionReq, maxBytes, minBytes, 2000, LocalSpan{})
+	assert.Equal(t, codec.NONE, fetchPartitionResp.ErrorCode)
+	assert.Equal(t, string(message.Payload), string(fetchPartitionResp.RecordBatch.Records[0].Value))
+
+	// no message after the timestamp
+	listOffset.Time = time.Now().Add(time.Hour).UnixMilli()
+	listPartition, err = k.OffsetListPartition(&addr, topic, clientId, &listOffset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, listPartition.ErrorCode)
+	assert.Equal(t, constant.UnknownOffset, listPartition.Offset)
+	assert.Equal(t, constant.UnknownTimestamp, listPartition.Timestamp)
+}
+
+func TestProduceOffsetMatchFetch(t *testing.T) {
+	test.SetupPulsar()
+	for _, continuousOffset := range []bool{false, true} {
+		topic := uuid.New().String()
+		groupId := uuid.New().String()
+		x := *config
+		x.KafsarConfig.ContinuousOffset = continuousOffset
+		k, err := NewKafsar(kafsarServer, &x)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// sasl auth
+		saslReq := codec.SaslAuthenticateReq{
+			Username: username,
+			Password: password,
+			BaseReq:  codec.BaseReq{ClientId: clientId},
+		}
+		auth, errorCode := k.SaslAuth(&addr, saslReq)
+		assert.Equal(t, codec.NONE, errorCode)
+		assert.True(t, true, auth)
+
+		// produce
+		produceReq := codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId: constant.NoProducerId,
+				Records:    []*codec.Record{{Value: []byte(testContent)}},
+			},
+		}
+		produceResp, err := k.Produce(context.Background(), &addr, topic, partition, 0, &produceReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, produceResp.ErrorCode)
+
+		// join group
+		joinGroupReq := codec.JoinGroupReq{
+			BaseReq:        codec.BaseReq{ClientId: clientId},
+			GroupId:        groupId,
+			SessionTimeout: sessionTimeoutMs,
+			ProtocolType:   protocolType,
+			GroupProtocols: protocols,
+		}
+		joinGroupResp, err := k.GroupJoin(&addr, &joinGroupReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+		// offset fetch
+		offsetFetchReq := codec.OffsetFetchPartitionReq{
+			PartitionId: partition,
+		}
+		offsetFetchPartitionResp, err := k.OffsetFetch(&addr, topic, clientId, groupId, &offsetFetchReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, offsetFetchPartitionResp.ErrorCode)
+
+		// fetch partition
+		fetchPartitionReq := codec.FetchPartitionReq{
+			PartitionId: partition,
+			FetchOffset: offsetFetchPartitionResp.Offset,
+		}
+		fetchPartitionResp := k.FetchPartition(&addr, topic, clientId, &fetchPartitionReq, maxBytes, minBytes, 2000, LocalSpan{})
+		assert.Equal(t, codec.NONE, fetchPartitionResp.ErrorCode)
+		assert.Equal(t, 1, len(fetchPartitionResp.RecordBatch.Records))
+		offset := int64(fetchPartitionResp.RecordBatch.Records[0].RelativeOffset) + fetchPartitionResp.RecordBatch.Offset
+		assert.Equal(t, produceResp.Offset, offset)
+		k.Close()
+	}
+}
+
+func TestDescribeTopicConfigs(t *testing.T) {
+	topic := uuid.New().String()
+	pulsarTopic := test.DefaultTopicType + test.TopicPrefix + topic
+	test.SetupPulsar()
+	k, err := NewKafsar(kafsarServer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulsarClient := test.NewPulsarClient()
+	defer pulsarClient.Close()
+	producer, err := pulsarClient.CreateProducer(pulsar.ProducerOptions{Topic: pulsarTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer.Close()
+	saslReq := codec.SaslAuthenticateReq{
+		Username: username,
+		Password: password,
+		BaseReq:  codec.BaseReq{ClientId: clientId},
+	}
+	_, errorCode := k.SaslAuth(&addr, saslReq)
+	assert.Equal(t, codec.NONE, errorCode)
+	results, err := k.DescribeConfigs(&addr, []*DescribeConfigsResource{{ResourceType: ConfigResourceTopic, ResourceName: topic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, results[0].ErrorCode)
+	assert.Equal(t, 3, len(results[0].Configs))
+	assert.Equal(t, ConfigNumPartitions, results[0].Configs[2].Name)
+	assert.Equal(t, "1", results[0].Configs[2].Value)
+}
+
+func TestFetchBeforeOffsetFetchAfterRestart(t *testing.T) {
+	topic := uuid.New().String()
+	groupId := uuid.New().String()
+	pulsarTopic := utils.PartitionedTopic(test.DefaultTopicType+test.TopicPrefix+topic, partition)
+	test.SetupPulsar()
+	recoverConfig := *config
+	recoverConfig.KafsarConfig.RecoverReaderOnFetch = true
+	k, err := NewKafsar(kafsarServer, &recoverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulsarClient := test.NewPulsarClient()
+	defer pulsarClient.Close()
+	producer, err := pulsarClient.CreateProducer(pulsar.ProducerOptions{Topic: pulsarTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	firstMessage := pulsar.ProducerMessage{Payload: []byte(testContent)}
+	_, err = producer.Send(context.TODO(), &firstMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondMessage := pulsar.ProducerMessage{Payload: []byte("second-content")}
+	_, err = producer.Send(context.TODO(), &secondMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joinAndSync := func(k *Broker) {
+		saslReq := codec.SaslAuthenticateReq{
+			Username: username,
+			Password: password,
+			BaseReq:  codec.BaseReq{ClientId: clientId},
+		}
+		_, errorCode := k.SaslAuth(&addr, saslReq)
+		assert.Equal(t, codec.NONE, errorCode)
+		joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+			BaseReq:        codec.BaseReq{ClientId: clientId},
+			GroupId:        groupId,
+			SessionTimeout: sessionTimeoutMs,
+			ProtocolType:   protocolType,
+			GroupProtocols: protocols,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+		syncGroupResp, err := k.GroupSync(&addr, &codec.SyncGroupReq{
+			BaseReq:      codec.BaseReq{ClientId: clientId},
+			GroupId:      groupId,
+			GenerationId: joinGroupResp.GenerationId,
+			MemberId:     joinGroupResp.MemberId,
+			GroupAssignments: []*codec.GroupAssignment{{
+				MemberId:         joinGroupResp.MemberId,
+				MemberAssignment: []byte("testAssignment: " + joinGroupResp.MemberId),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+	}
+
+	// consume and commit the first message
+	joinAndSync(k)
+	offsetFetchResp, err := k.OffsetFetch(&addr, topic, clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchPartitionResp := k.FetchPartition(&addr, topic, clientId, &codec.FetchPartitionReq{
+		PartitionId: partition,
+		FetchOffset: offsetFetchResp.Offset,
+	}, maxBytes, minBytes, 2000, LocalSpan{})
+	assert.Equal(t, maxFetchRecord, len(fetchPartitionResp.RecordBatch.Records))
+	offset := int64(fetchPartitionResp.RecordBatch.Records[0].RelativeOffset) + fetchPartitionResp.RecordBatch.Offset
+	commitResp, err := k.OffsetCommitPartition(&addr, topic, clientId, &codec.OffsetCommitPartitionReq{
+		PartitionId: partition,
+		Offset:      offset,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	time.Sleep(5 * time.Second)
+	k.Close()
+
+	// restart, fetch without offset fetch
+	k, err = NewKafsar(kafsarServer, &recoverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	joinAndSync(k)
+	fetchPartitionResp = k.FetchPartition(&addr, topic, clientId, &codec.FetchPartitionReq{
+		PartitionId: partition,
+		FetchOffset: offset + 1,
+	}, maxBytes, minBytes, 2000, LocalSpan{})
+	assert.Equal(t, codec.NONE, fetchPartitionResp.ErrorCode)
+	assert.Equal(t, maxFetchRecord, len(fetchPartitionResp.RecordBatch.Records))
+	assert.Equal(t, string(secondMessage.Payload), string(fetchPartitionResp.RecordBatch.Records[0].Value))
+}
+
+func TestGroupLag(t *testing.T) {
+	topic := uuid.New().String()
+	groupId := uuid.New().String()
+	pulsarTopic := utils.PartitionedTopic(test.DefaultTopicType+test.TopicPrefix+topic, partition)
+	test.SetupPulsar()
+	k, err := NewKafsar(kafsarServer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	pulsarClient := test.NewPulsarClient()
+	defer pulsarClient.Close()
+	producer, err := pulsarClient.CreateProducer(pulsar.ProducerOptions{Topic: pulsarTopic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	var lastMessageId pulsar.MessageID
+	for i := 0; i < 3; i++ {
+		lastMessageId, err = producer.Send(context.TODO(), &pulsar.ProducerMessage{Payload: []byte(testContent)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saslReq := codec.SaslAuthenticateReq{
+		Username: username,
+		Password: password,
+		BaseReq:  codec.BaseReq{ClientId: clientId},
+	}
+	_, errorCode := k.SaslAuth(&addr, saslReq)
+	assert.Equal(t, codec.NONE, errorCode)
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+	offsetFetchResp, err := k.OffsetFetch(&addr, topic, clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no offset committed
+	lags, err := k.GroupLag(username, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(lags))
+	assert.Equal(t, topic, lags[0].Topic)
+	assert.Equal(t, partition, lags[0].Partition)
+	assert.Equal(t, constant.UnknownOffset, lags[0].CommittedOffset)
+	assert.Equal(t, ConvertMsgId(lastMessageId), lags[0].HighWatermark)
+
+	fetchPartitionResp := k.FetchPartition(&addr, topic, clientId, &codec.FetchPartitionReq{
+		PartitionId: partition,
+		FetchOffset: offsetFetchResp.Offset,
+	}, maxBytes, minBytes, 2000, LocalSpan{})
+	assert.Equal(t, maxFetchRecord, len(fetchPartitionResp.RecordBatch.Records))
+	offset := int64(fetchPartitionResp.RecordBatch.Records[0].RelativeOffset) + fetchPartitionResp.RecordBatch.Offset
+	commitResp, err := k.OffsetCommitPartition(&addr, topic, clientId, &codec.OffsetCommitPartitionReq{
+		PartitionId: partition,
+		Offset:      offset,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	time.Sleep(5 * time.Second)
+
+	lags, err = k.GroupLag(username, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(lags))
+	assert.Equal(t, offset, lags[0].CommittedOffset)
+	assert.Equal(t, ConvertMsgId(lastMessageId)-offset, lags[0].Lag)
+}
@@ -0,0 +1,2051 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/paashzj/kafka_go_pulsar/pkg/test"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func newTestBroker(kafsarConfig KafsarConfig) *Broker {
+	broker := &Broker{
+		server:            kafsarServer,
+		kafsarConfig:      kafsarConfig,
+		readerManager:     make(map[string]*ReaderMetadata),
+		pendingReaders:    make(map[string]*pendingReaderMetadata),
+		userInfoManager:   make(map[string]*userInfo),
+		memberManager:     make(map[string]*MemberInfo),
+		topicGroupManager: make(map[string]string),
+		kafkaPartitions:   make(map[string]kafkaPartition),
+		nonPartitioned:    make(map[string]bool),
+		pulsarTopicCache:  make(map[string]cachedPulsarTopic),
+		partitionNumCache: make(map[string]cachedPartitionNum),
+		producerManager:   make(map[string]pulsar.Producer),
+		producerCreating:  make(map[string]*producerCreation),
+		readerCreating:    make(map[string]*readerCreation),
+		pendingProduce:    newPendingProduce(),
+		producerStates:    newProducerStateManager(),
+		backlogCache:      newBacklogCache(),
+		logStartOffsets:   newBacklogCache(),
+		produceQuota:      newByteRateQuota(),
+		fetchQuota:        newByteRateQuota(),
+		userClients:       newUserClients(),
+		userPendingBytes:  newPendingProduce(),
+		readerBreaker:     newCreationBreaker(kafsarConfig),
+		producerBreaker:   newCreationBreaker(kafsarConfig),
+		metrics:           noopMetrics{},
+		logger:            NewLogrusLogger(nil),
+		tracer:            NoopTracer{},
+		groupCoordinator:  NewGroupCoordinatorStandalone(PulsarConfig{}, kafsarConfig, nil),
+	}
+	broker.userInfoManager[addr.String()] = &userInfo{username: username, clientId: clientId}
+	return broker
+}
+
+func TestProduceTransactionalBatch(t *testing.T) {
+	broker := newTestBroker(KafsarConfig{})
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			Flags:   constant.RecordBatchTransactionalFlag,
+			Records: []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	resp, err := broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_TXN_STATE, resp.ErrorCode)
+
+	req.RecordBatch.Flags = constant.RecordBatchControlFlag
+	resp, err = broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_TXN_STATE, resp.ErrorCode)
+}
+
+func TestProduceWithoutUser(t *testing.T) {
+	broker := newTestBroker(KafsarConfig{})
+	otherAddr := net.TCPAddr{IP: net.ParseIP("::2"), Port: 9092}
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			Records: []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	resp, err := broker.Produce(context.Background(), &otherAddr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, resp.ErrorCode)
+}
+
+func TestIsTransactionalBatch(t *testing.T) {
+	assert.False(t, isTransactionalBatch(&codec.RecordBatch{}))
+	assert.True(t, isTransactionalBatch(&codec.RecordBatch{Flags: constant.RecordBatchTransactionalFlag}))
+	assert.True(t, isTransactionalBatch(&codec.RecordBatch{Flags: constant.RecordBatchControlFlag}))
+}
+
+func TestResetMessageIds(t *testing.T) {
+	readerMetadata := &ReaderMetadata{messageIds: []MessageIdPair{{Offset: 5}, {Offset: 6}}}
+	resetMessageIds(readerMetadata)
+	assert.Equal(t, 0, len(readerMetadata.messageIds))
+	assert.Equal(t, -1, searchMessageIdPair(readerMetadata.messageIds, 6))
+}
+
+func TestFindCoordinator(t *testing.T) {
+	k := newTestBroker(KafsarConfig{NodeId: 1, AdvertiseHost: "kafsar-1", AdvertisePort: 9092})
+	resp, err := k.FindCoordinator(&addr, &codec.FindCoordinatorReq{Key: groupId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, int32(1), resp.NodeId)
+	assert.Equal(t, "kafsar-1", resp.Host)
+	assert.Equal(t, 9092, resp.Port)
+}
+
+func TestFindCoordinatorCluster(t *testing.T) {
+	nodes := []ClusterNode{
+		{NodeId: 1, Host: "kafsar-1", Port: 9092},
+		{NodeId: 2, Host: "kafsar-2", Port: 9092},
+		{NodeId: 3, Host: "kafsar-3", Port: 9092},
+	}
+	var coordinators []*codec.FindCoordinatorResp
+	for _, node := range nodes {
+		k := newTestBroker(KafsarConfig{NodeId: node.NodeId, AdvertiseHost: node.Host, AdvertisePort: node.Port,
+			GroupCoordinatorType: Cluster, ClusterNodes: nodes})
+		k.groupCoordinator = NewGroupCoordinatorCluster(k.clusterNodes())
+		resp, err := k.FindCoordinator(&addr, &codec.FindCoordinatorReq{Key: groupId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		coordinators = append(coordinators, resp)
+
+		results, err := k.DeleteGroups(&addr, []string{groupId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, codec.COORDINATOR_NOT_AVAILABLE, results[0].ErrorCode)
+		_, err = k.groupCoordinator.GetAwaitingMetrics(username, groupId)
+		assert.NotNil(t, err)
+	}
+	// every node answers the node owning the group
+	assert.Equal(t, coordinators[0], coordinators[1])
+	assert.Equal(t, coordinators[0], coordinators[2])
+	assert.Contains(t, []string{"kafsar-1", "kafsar-2", "kafsar-3"}, coordinators[0].Host)
+}
+
+func TestSyncGroupWithOtherMemberId(t *testing.T) {
+	config := kafsarConfig
+	config.VerifyMemberIdentity = true
+	k := newTestBroker(config)
+	otherAddr := net.TCPAddr{IP: net.ParseIP("::2"), Port: 9092}
+	k.userInfoManager[otherAddr.String()] = &userInfo{username: username, clientId: clientId}
+
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+	syncGroupReq := codec.SyncGroupReq{
+		BaseReq:          codec.BaseReq{ClientId: clientId},
+		GroupId:          groupId,
+		GenerationId:     joinGroupResp.GenerationId,
+		MemberId:         joinGroupResp.MemberId,
+		GroupAssignments: []*codec.GroupAssignment{{MemberId: joinGroupResp.MemberId}},
+	}
+	syncGroupResp, err := k.GroupSync(&otherAddr, &syncGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.UNKNOWN_MEMBER_ID, syncGroupResp.ErrorCode)
+
+	groupInstanceId := "test-instance-id"
+	syncGroupReq.GroupInstanceId = &groupInstanceId
+	syncGroupResp, err = k.GroupSync(&otherAddr, &syncGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.FENCED_INSTANCE_ID, syncGroupResp.ErrorCode)
+
+	syncGroupReq.GroupInstanceId = nil
+	syncGroupResp, err = k.GroupSync(&addr, &syncGroupReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+}
+
+type startFailedOffsetManager struct {
+	OffsetManager
+	startErr error
+	closed   bool
+}
+
+func (o *startFailedOffsetManager) Start() (chan bool, chan error) {
+	errChannel := make(chan error, 1)
+	if o.startErr != nil {
+		errChannel <- o.startErr
+	}
+	return make(chan bool), errChannel
+}
+
+func (o *startFailedOffsetManager) Close() {
+	o.closed = true
+}
+
+func TestNewKafsarOffsetManagerStartFailed(t *testing.T) {
+	offsetManager := &startFailedOffsetManager{OffsetManager: NewOffsetManagerMemory(), startErr: errors.New("replay failed")}
+	config := &Config{
+		PulsarConfig:  PulsarConfig{Host: "localhost", HttpPort: 8080, TcpPort: 6650},
+		OffsetManager: offsetManager,
+	}
+	k, err := NewKafsar(kafsarServer, config)
+	assert.Nil(t, k)
+	assert.True(t, errors.Is(err, ErrPulsarUnreachable))
+	assert.True(t, offsetManager.closed)
+}
+
+func TestNewKafsarOffsetManagerStartTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpPort, _ := strconv.Atoi(port)
+	offsetManager := &startFailedOffsetManager{OffsetManager: NewOffsetManagerMemory()}
+	config := &Config{
+		PulsarConfig:  PulsarConfig{Host: host, HttpPort: httpPort, TcpPort: 6650},
+		KafsarConfig:  KafsarConfig{OffsetManagerStartTimeoutMs: 100},
+		OffsetManager: offsetManager,
+	}
+	k, err := NewKafsar(kafsarServer, config)
+	assert.Nil(t, k)
+	assert.True(t, errors.Is(err, ErrOffsetTopicInit))
+	assert.True(t, offsetManager.closed)
+}
+
+func TestProduceDuplicateSequence(t *testing.T) {
+	broker := newTestBroker(KafsarConfig{})
+	initResp, err := broker.InitProducerId(&addr, &InitProducerIdReq{ProducerId: constant.NoProducerId, ProducerEpoch: constant.NoProducerEpoch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, initResp.ErrorCode)
+	partitionedTopic, err := broker.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker.producerStates.completeSequence(initResp.ProducerId, initResp.ProducerEpoch, partitionedTopic, 0, 100)
+
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId:    initResp.ProducerId,
+			ProducerEpoch: initResp.ProducerEpoch,
+			BaseSequence:  0,
+			Records:       []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	resp, err := broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, int64(100), resp.Offset)
+
+	req.RecordBatch.BaseSequence = 2
+	resp, err = broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.OUT_OF_ORDER_SEQUENCE_NUMBER, resp.ErrorCode)
+}
+
+func TestInitTransactionalProducerId(t *testing.T) {
+	broker := newTestBroker(KafsarConfig{})
+	transactionalId := "test-transactional-id"
+	resp, err := broker.InitProducerId(&addr, &InitProducerIdReq{TransactionalId: &transactionalId, ProducerId: constant.NoProducerId})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_TXN_STATE, resp.ErrorCode)
+}
+
+func TestProduceFetchWhenClosing(t *testing.T) {
+	broker := newTestBroker(KafsarConfig{})
+	assert.Nil(t, broker.inflight.drain(context.Background()))
+	produceResp, err := broker.Produce(context.Background(), &addr, "topic", partition, 0, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{Records: []*codec.Record{{Value: []byte(testContent)}}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NOT_LEADER_OR_FOLLOWER, produceResp.ErrorCode)
+	fetchResp, err := broker.Fetch(context.Background(), &addr, &codec.FetchReq{
+		TopicReqList: []*codec.FetchTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: partition}},
+		}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NOT_LEADER_OR_FOLLOWER, fetchResp[0].PartitionRespList[0].ErrorCode)
+}
+
+func TestRecoverReaderGroupNotStable(t *testing.T) {
+	config := kafsarConfig
+	config.RecoverReaderOnFetch = true
+	k := newTestBroker(config)
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+
+	// group is completing rebalance before sync, the reader must not be recovered
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 10, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Empty(t, resp.RecordBatch.Records)
+	assert.Empty(t, k.readerManager)
+}
+
+func TestFetchReaderBehindCommittedOffset(t *testing.T) {
+	config := kafsarConfig
+	config.SeekToCommittedOnFetch = true
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 5)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	reader := &testReader{messages: messages}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	// another member committed the third message
+	committedOffset := ConvertMsgId(messages[2].ID())
+	err = k.offsetManager.CommitOffset(username, "topic", groupId, partition, MessageIdPair{MessageId: messages[2].ID(), Offset: committedOffset})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 1, reader.seeks)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	assert.Equal(t, string(messages[3].Payload()), string(resp.RecordBatch.Records[0].Value))
+	assert.Equal(t, string(messages[4].Payload()), string(resp.RecordBatch.Records[1].Value))
+	assert.Equal(t, ConvertMsgId(messages[3].ID()), resp.RecordBatch.Offset)
+}
+
+// latestReaderClient create readers starting at the last message
+type latestReaderClient struct {
+	pulsar.Client
+	messages []pulsar.Message
+}
+
+func (c *latestReaderClient) CreateReader(options pulsar.ReaderOptions) (pulsar.Reader, error) {
+	return &testReader{messages: c.messages, position: len(c.messages) - 1}, nil
+}
+
+func TestListLatestOffsetKeepReaderPosition(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 2
+	config.MaxFetchWaitMs = 100
+	config.ListOffsetsKeepReaderPosition = true
+	k := newTestBroker(config)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 5)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"LedgerID":1,"EntryID":4,"BatchIdx":-1,"PartitionIdx":-1}`)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+	reader := &testReader{messages: messages}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	k.pulsarClientManage = map[string]pulsar.Client{readerKey(username, partitionedTopic, clientId): &latestReaderClient{messages: messages}}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+
+	listResp, err := k.OffsetListPartition(&addr, "topic", clientId, &codec.ListOffsetsPartition{PartitionId: partition, Time: constant.TimeLasted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, listResp.ErrorCode)
+	assert.Equal(t, ConvertMsgId(messages[4].ID()), listResp.Offset)
+	assert.Equal(t, 0, reader.seeks)
+
+	// the unread messages are still delivered in order
+	fetched := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		resp = k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		for _, record := range resp.RecordBatch.Records {
+			fetched = append(fetched, string(record.Value))
+		}
+	}
+	assert.Equal(t, []string{string(messages[2].Payload()), string(messages[3].Payload()), string(messages[4].Payload())}, fetched)
+}
+
+func TestTenantsShareReaderTopic(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	otherAddr := net.TCPAddr{IP: net.ParseIP("::2"), Port: 9092}
+	otherUser := "other-username"
+	k.userInfoManager[otherAddr.String()] = &userInfo{username: otherUser, clientId: clientId}
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPartitionedTopic, err := k.partitionedTopic(&userInfo{username: otherUser}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both users map to the same pulsar topic with the same client id
+	assert.Equal(t, partitionedTopic, otherPartitionedTopic)
+	newReader := func(entries int) *testReader {
+		messages := make([]pulsar.Message, entries)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+			}
+		}
+		return &testReader{messages: messages}
+	}
+	reader := newReader(3)
+	otherReader := newReader(1)
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	k.readerManager[readerKey(otherUser, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: otherReader, messageIds: make([]MessageIdPair, 0)}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	otherResp := k.FetchPartition(&otherAddr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, otherResp.ErrorCode)
+	assert.Equal(t, 1, len(otherResp.RecordBatch.Records))
+
+	commitOffset := resp.RecordBatch.Offset + 2
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: commitOffset})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, commitOffset, committed.Offset)
+	_, exist = k.offsetManager.AcquireOffset(otherUser, "topic", groupId, partition)
+	assert.False(t, exist)
+}
+
+func TestFetchPartitionRecordAndByteLimit(t *testing.T) {
+	fetch := func(maxFetchRecord, maxBytes int) *codec.FetchPartitionResp {
+		config := kafsarConfig
+		config.MaxFetchRecord = maxFetchRecord
+		k := newTestBroker(config)
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := make([]pulsar.Message, 10)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: make([]byte, 10),
+			}
+		}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+		fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+		return k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	}
+	// count limit reached well under max bytes
+	resp := fetch(2, 1000)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	assert.Equal(t, 2, cap(resp.RecordBatch.Records))
+	// byte limit reached before the count limit, the last record crosses max bytes
+	resp = fetch(8, 25)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	// byte limit reached exactly
+	resp = fetch(8, 30)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+}
+
+// fetchQuotaServer grant a fixed fetch budget and count the reservations
+type fetchQuotaServer struct {
+	test.KafsarImpl
+	granted      int
+	reservations *int
+}
+
+func (f fetchQuotaServer) ReserveFetchQuota(username, topic string, bytes int) int {
+	*f.reservations++
+	if bytes < f.granted {
+		return bytes
+	}
+	return f.granted
+}
+
+func TestFetchPartitionGrantedQuota(t *testing.T) {
+	fetch := func(granted int) (*codec.FetchPartitionResp, *testReader, int) {
+		config := kafsarConfig
+		config.MaxFetchRecord = 8
+		k := newTestBroker(config)
+		reservations := 0
+		k.server = fetchQuotaServer{granted: granted, reservations: &reservations}
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := make([]pulsar.Message, 10)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: make([]byte, 10),
+			}
+		}
+		reader := &testReader{messages: messages}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+		fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+		return k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, 1000, 1000, 100, LocalSpan{}), reader, reservations
+	}
+	// partial grant, the last record crosses the budget
+	resp, reader, reservations := fetch(25)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	assert.Equal(t, 3, reader.position)
+	assert.Equal(t, 1, reservations)
+	// no quota left, nothing is read
+	resp, reader, reservations = fetch(0)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 0, len(resp.RecordBatch.Records))
+	assert.Equal(t, 0, reader.position)
+	assert.Equal(t, 1, reservations)
+}
+
+func TestFetchPartitionReadNoMessageBeyondCap(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 2
+	k := newTestBroker(config)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 5)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	reader := &testReader{messages: messages}
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	for fetched := 2; fetched <= 4; fetched += 2 {
+		resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		assert.Equal(t, 2, len(resp.RecordBatch.Records))
+		// every message read is returned and tracked
+		assert.Equal(t, fetched, reader.position)
+		assert.Equal(t, fetched, len(readerMetadata.messageIds))
+	}
+}
+
+func TestFetchPartitionRereadUnconvertedMessage(t *testing.T) {
+	config := kafsarConfig
+	config.ContinuousOffset = true
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := []uint64{0, 1}
+	messages := make([]pulsar.Message, 3)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+		if i < len(indexes) {
+			messages[i].(*testMessage).index = &indexes[i]
+		}
+	}
+	reader := &testReader{messages: messages}
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0), nextOffset: constant.UnknownOffset}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	// the message without index is not dropped, the next fetch reads it again
+	assert.Equal(t, 2, reader.position)
+	resp = k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.UNKNOWN_SERVER_ERROR, resp.ErrorCode)
+	assert.Equal(t, 2, reader.position)
+}
+
+func TestFetchNonContiguousOffsets(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 3
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 3)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i * 5)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	baseOffset := ConvertMsgId(messages[0].ID())
+	assert.Equal(t, baseOffset, resp.RecordBatch.Offset)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	for i, record := range resp.RecordBatch.Records {
+		assert.Equal(t, i, record.RelativeOffset)
+		assert.Equal(t, baseOffset+int64(i), readerMetadata.messageIds[i].Offset)
+		assert.Equal(t, messages[i].ID(), readerMetadata.messageIds[i].MessageId)
+	}
+
+	// the client commits the offset after the second record it saw
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: baseOffset + 1})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, messages[1].ID(), committed.MessageId)
+}
+
+func TestFetchContinuousOffsetAfterCompaction(t *testing.T) {
+	config := kafsarConfig
+	config.ContinuousOffset = true
+	config.MaxFetchRecord = 2
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// messages of index 2, 3, 4, 7, 8 were compacted
+	indexes := []uint64{0, 1, 5, 6, 9}
+	messages := make([]pulsar.Message, len(indexes))
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(indexes[i])},
+			index:   &indexes[i],
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	readerMetadata := &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0), nextOffset: constant.UnknownOffset}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = readerMetadata
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	for _, expected := range []int64{0, 2, 4} {
+		resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		assert.Equal(t, expected, resp.RecordBatch.Offset)
+	}
+	assert.Equal(t, int64(5), readerMetadata.nextOffset)
+
+	// the continuous offset 3 is the message of index 6
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, int64(3), committed.Offset)
+	assert.Equal(t, messages[3].ID(), committed.MessageId)
+
+	resetMessageIds(readerMetadata)
+	assert.Equal(t, constant.UnknownOffset, readerMetadata.nextOffset)
+}
+
+func TestFetchRecordsCapacity(t *testing.T) {
+	assert.Equal(t, 0, fetchRecordsCapacity(0))
+	assert.Equal(t, 10, fetchRecordsCapacity(10))
+	assert.Equal(t, constant.MaxPreallocatedFetchRecords, fetchRecordsCapacity(constant.MaxPreallocatedFetchRecords+1))
+}
+
+func TestGroupLagUnknownGroup(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	_, err := k.GroupLag(username, "unknown-group")
+	assert.NotNil(t, err)
+}
+
+type singleTopicServer struct {
+	test.KafsarImpl
+}
+
+func (s singleTopicServer) PartitionedPulsarTopic(username, kafkaTopic string, partition int) (string, error) {
+	return "persistent://public/default/" + kafkaTopic, nil
+}
+
+func TestPartitionedTopicMapper(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	user := &userInfo{username: username}
+	partitionedTopic, err := k.partitionedTopic(user, "topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, test.DefaultTopicType+test.TopicPrefix+"topic-partition-1", partitionedTopic)
+
+	k.server = singleTopicServer{}
+	partitionedTopic, err = k.partitionedTopic(user, "topic", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "persistent://public/default/topic", partitionedTopic)
+}
+
+func TestNonPartitionedTopic(t *testing.T) {
+	config := kafsarConfig
+	config.DetectNonPartitionedTopic = true
+	k := newTestBroker(config)
+	pulsarTopic := test.DefaultTopicType + test.TopicPrefix + "topic"
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = fmt.Fprintf(w, `["%s"]`, pulsarTopic)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+
+	user := &userInfo{username: username}
+	partitionedTopic, err := k.partitionedTopic(user, "topic", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, pulsarTopic, partitionedTopic)
+	_, err = k.partitionedTopic(user, "topic", 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: 1}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 10, LocalSpan{})
+	assert.Equal(t, codec.UNKNOWN_TOPIC_OR_PARTITION, resp.ErrorCode)
+
+	// topic not in the namespace keeps the partition suffix
+	partitionedTopic, err = k.partitionedTopic(user, "other-topic", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, test.DefaultTopicType+test.TopicPrefix+"other-topic-partition-0", partitionedTopic)
+}
+
+func TestTopicListNoTopics(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	topics, err := k.TopicList(&addr)
+	assert.Nil(t, err)
+	assert.NotNil(t, topics)
+	assert.Empty(t, topics)
+}
+
+type slowProducerClient struct {
+	pulsar.Client
+	creations int32
+	release   chan struct{}
+}
+
+func (c *slowProducerClient) CreateProducer(options pulsar.ProducerOptions) (pulsar.Producer, error) {
+	if atomic.AddInt32(&c.creations, 1) == 1 {
+		<-c.release
+	}
+	return slowClientProducer{}, nil
+}
+
+type slowClientProducer struct {
+	pulsar.Producer
+}
+
+func TestSlowProducerCreation(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	client := &slowProducerClient{release: make(chan struct{})}
+	k.pulsarCommonClient = client
+	otherAddr := net.TCPAddr{IP: net.ParseIP("::2"), Port: 9092}
+	k.userInfoManager[otherAddr.String()] = &userInfo{username: username, clientId: clientId}
+
+	var wg sync.WaitGroup
+	producers := make([]pulsar.Producer, 2)
+	for i := range producers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			producer, err := k.getProducer(&addr, username, "topic")
+			assert.Nil(t, err)
+			producers[i] = producer
+		}(i)
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&client.creations) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// other connections are not blocked by the slow creation
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := k.getProducer(&otherAddr, username, "topic")
+		assert.Nil(t, err)
+		_, err = k.PartitionNum(&otherAddr, "topic")
+		assert.Nil(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by the slow producer creation")
+	}
+
+	close(client.release)
+	wg.Wait()
+	// the concurrent produces of the connection share one creation
+	assert.Equal(t, int32(2), atomic.LoadInt32(&client.creations))
+	assert.Equal(t, producers[0], producers[1])
+	assert.Equal(t, 2, len(k.producerManager))
+	assert.Empty(t, k.producerCreating)
+}
+
+func TestDrainReadersOnClose(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	config.MaxFetchWaitMs = 500
+	config.ReaderDrainTimeoutMs = 2000
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 3)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	reader := &testReader{messages: messages}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+
+	fetched := make(chan []*codec.FetchTopicResp)
+	go func() {
+		fetchResp, err := k.Fetch(context.Background(), &addr, &codec.FetchReq{
+			BaseReq:     codec.BaseReq{ClientId: clientId},
+			MaxWaitTime: 200,
+			MinBytes:    maxBytes,
+			MaxBytes:    maxBytes,
+			TopicReqList: []*codec.FetchTopicReq{{
+				Topic:            "topic",
+				PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: partition}},
+			}},
+		})
+		assert.Nil(t, err)
+		fetched <- fetchResp
+	}()
+	assert.Eventually(t, func() bool {
+		return k.uncommittedMessages() == len(messages)
+	}, time.Second, 10*time.Millisecond)
+
+	// the client commits after the in-flight fetch completes
+	go func() {
+		fetchResp := <-fetched
+		recordBatch := fetchResp[0].PartitionRespList[0].RecordBatch
+		offset := recordBatch.Offset + int64(len(recordBatch.Records)) - 1
+		_, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: offset})
+		assert.Nil(t, err)
+	}()
+	assert.Nil(t, k.inflight.drain(context.Background()))
+	assert.Nil(t, k.drainReaders())
+	assert.False(t, reader.closed)
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, messages[2].ID(), committed.MessageId)
+
+	k.closeReaders()
+	assert.True(t, reader.closed)
+	assert.Empty(t, k.readerManager)
+}
+
+func TestDrainReadersTimeout(t *testing.T) {
+	config := kafsarConfig
+	config.ReaderDrainTimeoutMs = 50
+	k := newTestBroker(config)
+	k.readerManager[readerKey(username, "topic", clientId)] = &ReaderMetadata{
+		groupId:    groupId,
+		reader:     &testReader{},
+		messageIds: []MessageIdPair{{MessageId: &testMessageID{ledgerID: 1}, Offset: 1}},
+	}
+	assert.NotNil(t, k.drainReaders())
+
+	k.kafsarConfig.ReaderDrainTimeoutMs = 0
+	assert.Nil(t, k.drainReaders())
+}
+
+type fetchWaitServer struct {
+	test.KafsarImpl
+}
+
+func (s fetchWaitServer) MinFetchWaitMs(username, kafkaTopic string) (int, bool) {
+	switch kafkaTopic {
+	case "control":
+		return 0, true
+	case "bulk":
+		return 5000, true
+	}
+	return 0, false
+}
+
+func TestFetchPartitionMinFetchWaitPerTopic(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 5
+	config.MaxFetchWaitMs = 500
+	config.MinFetchWaitMs = 5000
+	k := newTestBroker(config)
+	k.server = fetchWaitServer{}
+	fetch := func(topic string) *codec.FetchPartitionResp {
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, topic, partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := make([]pulsar.Message, 5)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+			}
+		}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+		fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+		return k.FetchPartition(&addr, topic, clientId, &fetchPartitionReq, maxBytes, 0, 500, LocalSpan{})
+	}
+	// the control topic returns as soon as min bytes are reached
+	resp := fetch("control")
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 1, len(resp.RecordBatch.Records))
+	// the bulk topic and the topic falling back to the global min fetch wait keep batching
+	resp = fetch("bulk")
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 5, len(resp.RecordBatch.Records))
+	resp = fetch("other")
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 5, len(resp.RecordBatch.Records))
+}
+
+type lastMessageClient struct {
+	pulsar.Client
+	lastMessage pulsar.Message
+}
+
+func (c *lastMessageClient) CreateReader(options pulsar.ReaderOptions) (pulsar.Reader, error) {
+	return &testReader{messages: []pulsar.Message{c.lastMessage}}, nil
+}
+
+// producedMessageClient read the produced messages of a non-partitioned topic
+type producedMessageClient struct {
+	pulsar.Client
+	messages []pulsar.Message
+	readers  int
+}
+
+func (c *producedMessageClient) TopicPartitions(topic string) ([]string, error) {
+	return []string{topic}, nil
+}
+
+func (c *producedMessageClient) Close() {
+}
+
+func (c *producedMessageClient) CreateReader(options pulsar.ReaderOptions) (pulsar.Reader, error) {
+	c.readers++
+	reader := &testReader{messages: c.messages}
+	if options.StartMessageID.LedgerID() >= 0 {
+		_ = reader.Seek(options.StartMessageID)
+	}
+	return reader, nil
+}
+
+func TestProduceLogAppendTime(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 100
+	config.LogAppendTime = true
+	config.ProduceLogStartOffset = true
+	k := newTestBroker(config)
+	publishTime := time.UnixMilli(1650000000000)
+	client := &producedMessageClient{messages: []pulsar.Message{
+		&testMessage{id: &testMessageID{ledgerID: 1, entryID: 0}, publishTime: publishTime.Add(-time.Hour)},
+		&testMessage{id: &testMessageID{ledgerID: 1, entryID: 1}, publishTime: publishTime},
+	}}
+	k.pulsarCommonClient = client
+	producer := &stalledProducer{}
+	k.producerManager[addr.String()] = producer
+	produce := func() *codec.ProducePartitionResp {
+		done := make(chan *codec.ProducePartitionResp)
+		go func() {
+			resp, err := k.Produce(context.Background(), &addr, "topic", partition, 1000, &codec.ProducePartitionReq{
+				PartitionId: partition,
+				RecordBatch: &codec.RecordBatch{ProducerId: constant.NoProducerId, Records: []*codec.Record{{Value: []byte(testContent)}}},
+			})
+			assert.Nil(t, err)
+			done <- resp
+		}()
+		assert.Eventually(t, func() bool {
+			return producer.sent() == 1
+		}, time.Second, 5*time.Millisecond)
+		// acknowledged as the second message
+		producer.mutex.Lock()
+		callback := producer.callbacks[0]
+		producer.callbacks = nil
+		producer.mutex.Unlock()
+		callback(client.messages[1].ID(), nil, nil)
+		return <-done
+	}
+	resp := produce()
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, ConvertMsgId(client.messages[1].ID()), resp.Offset)
+	assert.Equal(t, publishTime.UnixMilli(), resp.Time)
+	assert.Equal(t, ConvertMsgId(client.messages[0].ID()), resp.LogStartOffset)
+	// the log start offset is cached
+	readers := client.readers
+	resp = produce()
+	assert.Equal(t, publishTime.UnixMilli(), resp.Time)
+	assert.Equal(t, ConvertMsgId(client.messages[0].ID()), resp.LogStartOffset)
+	assert.Equal(t, readers+1, client.readers)
+
+	// the append time is unknown without LogAppendTime
+	k.kafsarConfig.LogAppendTime = false
+	resp = produce()
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, constant.UnknownTimestamp, resp.Time)
+}
+
+func TestProduceEmptyBatch(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 500
+	k := newTestBroker(config)
+	lastMessage := &testMessage{id: &testMessageID{ledgerID: 3, entryID: 7}}
+	k.pulsarCommonClient = &lastMessageClient{lastMessage: lastMessage}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"ledgerId":3,"entryId":7,"partitionIdx":-1,"batchIdx":-1}`)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+
+	done := make(chan *codec.ProducePartitionResp)
+	go func() {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 1000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{Records: []*codec.Record{}},
+		})
+		assert.Nil(t, err)
+		done <- resp
+	}()
+	select {
+	case resp := <-done:
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+		assert.Equal(t, ConvertMsgId(lastMessage.ID()), resp.Offset)
+		assert.Empty(t, k.producerManager)
+	case <-time.After(2 * time.Second):
+		t.Fatal("empty produce hangs")
+	}
+}
+
+type stalledProducer struct {
+	pulsar.Producer
+	mutex     sync.Mutex
+	callbacks []func(pulsar.MessageID, *pulsar.ProducerMessage, error)
+}
+
+func (p *stalledProducer) Topic() string {
+	return "persistent://public/default/topic"
+}
+
+func (p *stalledProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.callbacks = append(p.callbacks, callback)
+}
+
+func (p *stalledProducer) ack() {
+	p.mutex.Lock()
+	callbacks := p.callbacks
+	p.callbacks = nil
+	p.mutex.Unlock()
+	for i, callback := range callbacks {
+		callback(&testMessageID{ledgerID: 1, entryID: int64(i)}, nil, nil)
+	}
+}
+
+func (p *stalledProducer) sent() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.callbacks)
+}
+
+func TestProducePendingLimit(t *testing.T) {
+	config := kafsarConfig
+	config.MaxProducerRecordSize = 4
+	config.ProduceFlushTimeoutMs = 50
+	k := newTestBroker(config)
+	producer := &stalledProducer{}
+	k.producerManager[addr.String()] = producer
+	produce := func(records int) *codec.ProducePartitionResp {
+		batch := make([]*codec.Record, records)
+		for i := range batch {
+			batch[i] = &codec.Record{Value: []byte(testContent)}
+		}
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{ProducerId: constant.NoProducerId, Records: batch},
+		})
+		assert.Nil(t, err)
+		return resp
+	}
+	// pulsar does not acknowledge, the flush timeout caps the request timeout
+	start := time.Now()
+	resp := produce(3)
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, resp.ErrorCode)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 3, k.pendingProduce.count(addr.String()))
+	// exceed the pending limit, rejected without sending
+	resp = produce(2)
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, resp.ErrorCode)
+	assert.Equal(t, 3, producer.sent())
+
+	producer.ack()
+	assert.Equal(t, 0, k.pendingProduce.count(addr.String()))
+	done := make(chan *codec.ProducePartitionResp)
+	go func() {
+		done <- produce(2)
+	}()
+	assert.Eventually(t, func() bool {
+		return producer.sent() == 2
+	}, time.Second, 5*time.Millisecond)
+	producer.ack()
+	resp = <-done
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+}
+
+// batchingProducer acknowledges the messages after the batching delay or on flush
+type batchingProducer struct {
+	stalledProducer
+	delay time.Duration
+}
+
+func (p *batchingProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	if p.sent() == 0 {
+		time.AfterFunc(p.delay, p.ack)
+	}
+	p.stalledProducer.SendAsync(ctx, message, callback)
+}
+
+func (p *batchingProducer) Flush() error {
+	p.ack()
+	return nil
+}
+
+func TestProduceFlushOnBatchingDelay(t *testing.T) {
+	produce := func(k *Broker) (*codec.ProducePartitionResp, time.Duration) {
+		start := time.Now()
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId: constant.NoProducerId,
+				Records:    []*codec.Record{{Value: []byte(testContent)}, {Value: []byte(testContent)}},
+			},
+		})
+		assert.Nil(t, err)
+		return resp, time.Since(start)
+	}
+	config := kafsarConfig
+	config.BatchingMaxPublishDelayMs = 500
+	k := newTestBroker(config)
+	k.producerManager[addr.String()] = &batchingProducer{delay: 500 * time.Millisecond}
+	resp, latency := produce(k)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.GreaterOrEqual(t, latency, 500*time.Millisecond)
+
+	config.ProduceFlushThresholdMs = 100
+	k = newTestBroker(config)
+	k.producerManager[addr.String()] = &batchingProducer{delay: 500 * time.Millisecond}
+	resp, latency = produce(k)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Less(t, latency, 100*time.Millisecond)
+}
+
+func TestFlushOnProduce(t *testing.T) {
+	k := newTestBroker(KafsarConfig{ProduceFlushThresholdMs: 5})
+	assert.True(t, k.flushOnProduce())
+	k = newTestBroker(KafsarConfig{ProduceFlushThresholdMs: 20})
+	assert.False(t, k.flushOnProduce())
+	k = newTestBroker(KafsarConfig{ProduceFlushThresholdMs: 20, BatchingMaxPublishDelayMs: 50})
+	assert.True(t, k.flushOnProduce())
+	k = newTestBroker(KafsarConfig{BatchingMaxPublishDelayMs: 50})
+	assert.False(t, k.flushOnProduce())
+}
+
+func TestProduceMalformedRecordBatch(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	resp, err := k.Produce(context.Background(), &addr, "topic", partition, 0, &codec.ProducePartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.CORRUPT_MESSAGE, resp.ErrorCode)
+	assert.Equal(t, partition, resp.PartitionId)
+
+	resp, err = k.Produce(context.Background(), &addr, "topic", partition, 0, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{Records: []*codec.Record{{Value: []byte(testContent)}, nil}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.CORRUPT_MESSAGE, resp.ErrorCode)
+	assert.Empty(t, k.producerManager)
+}
+
+func TestOffsetFetchSeekReaderToCommitted(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 4)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	reader := &testReader{messages: messages[:3]}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: resp.RecordBatch.Offset})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+
+	// the member rejoins before committing the other fetched messages
+	reader.messages = messages
+	offsetFetchResp, err := k.OffsetFetch(&addr, "topic", clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, offsetFetchResp.ErrorCode)
+	assert.Equal(t, resp.RecordBatch.Offset, offsetFetchResp.Offset)
+	assert.Equal(t, 1, reader.seeks)
+
+	resp = k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 3, len(resp.RecordBatch.Records))
+	assert.Equal(t, string(messages[1].Payload()), string(resp.RecordBatch.Records[0].Value))
+	assert.Equal(t, string(messages[3].Payload()), string(resp.RecordBatch.Records[2].Value))
+
+	// the reader is right after the committed offset, another offset fetch keeps its prefetched position
+	commitResp, err = k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: resp.RecordBatch.Offset + 2})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	offsetFetchResp, err = k.OffsetFetch(&addr, "topic", clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, offsetFetchResp.ErrorCode)
+	assert.Equal(t, 1, reader.seeks)
+}
+
+func TestResetMessageId(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	assert.Equal(t, pulsar.EarliestMessageID(), k.resetMessageId())
+	k.kafsarConfig.AutoOffsetReset = constant.OffsetResetLatest
+	assert.Equal(t, pulsar.LatestMessageID(), k.resetMessageId())
+}
+
+func TestFetchDropOtherTopicMessage(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	metrics, err := NewPrometheusMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.metrics = metrics
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 3)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	// the reader is wrongly associated with another partition for the second message
+	messages[1].(*testMessage).topic = partitionedTopic + "-other"
+	reader := &testReader{messages: messages}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, minBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	assert.Equal(t, string(messages[0].Payload()), string(resp.RecordBatch.Records[0].Value))
+	assert.Equal(t, string(messages[2].Payload()), string(resp.RecordBatch.Records[1].Value))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.topicMismatches.WithLabelValues("topic")))
+}
+
+func TestSameTopic(t *testing.T) {
+	assert.True(t, sameTopic("persistent://public/default/topic-partition-0", "topic-partition-0"))
+	assert.True(t, sameTopic("persistent://public/default/topic-partition-0", "public/default/topic-partition-0"))
+	assert.False(t, sameTopic("persistent://public/default/topic-partition-0", "persistent://public/default/topic-partition-1"))
+	assert.False(t, sameTopic("persistent://public/default/topic", "non-persistent://public/default/topic"))
+}
+
+// queueFullError the error pulsar calls back with when the queue is full and DisableBlockIfQueueFull is set
+func queueFullError() error {
+	return pulsarResultError(pulsar.ProducerQueueIsFull)
+}
+
+// pulsarResultError the errors of the pulsar client can not be created outside of it
+func pulsarResultError(res pulsar.Result) error {
+	err := &pulsar.Error{}
+	result := reflect.ValueOf(err).Elem().FieldByName("result")
+	reflect.NewAt(result.Type(), unsafe.Pointer(result.UnsafeAddr())).Elem().SetInt(int64(res))
+	return err
+}
+
+// boundedProducer reject messages beyond the capacity synchronously like a non-blocking pulsar producer
+type boundedProducer struct {
+	stalledProducer
+	capacity int
+}
+
+func (p *boundedProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	if p.sent() >= p.capacity {
+		callback(nil, message, queueFullError())
+		return
+	}
+	p.stalledProducer.SendAsync(ctx, message, callback)
+}
+
+type producerOptionsClient struct {
+	pulsar.Client
+	options pulsar.ProducerOptions
+}
+
+func (c *producerOptionsClient) CreateProducer(options pulsar.ProducerOptions) (pulsar.Producer, error) {
+	c.options = options
+	return slowClientProducer{}, nil
+}
+
+func TestProducerQueueFullFailFast(t *testing.T) {
+	config := kafsarConfig
+	config.MaxProducerRecordSize = 10
+	config.ProducerQueueFullFailFast = true
+	k := newTestBroker(config)
+	client := &producerOptionsClient{}
+	k.pulsarCommonClient = client
+	_, err := k.getProducer(&addr, username, "topic")
+	assert.Nil(t, err)
+	assert.True(t, client.options.DisableBlockIfQueueFull)
+
+	producer := &boundedProducer{capacity: 2}
+	k.producerManager[addr.String()] = producer
+	batch := make([]*codec.Record, 4)
+	for i := range batch {
+		batch[i] = &codec.Record{Value: []byte(testContent)}
+	}
+	start := time.Now()
+	resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{ProducerId: constant.NoProducerId, Records: batch},
+	})
+	assert.Nil(t, err)
+	// rejected as soon as the queue is full, neither waiting for the queued messages nor sending the rest
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, resp.ErrorCode)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 2, producer.sent())
+	assert.Equal(t, 2, k.pendingProduce.count(addr.String()))
+	producer.ack()
+	assert.Equal(t, 0, k.pendingProduce.count(addr.String()))
+}
+
+func TestProducerQueueFullBlock(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	client := &producerOptionsClient{}
+	k.pulsarCommonClient = client
+	_, err := k.getProducer(&addr, username, "topic")
+	assert.Nil(t, err)
+	assert.False(t, client.options.DisableBlockIfQueueFull)
+}
+
+func TestIsProducerQueueFull(t *testing.T) {
+	assert.True(t, isProducerQueueFull(queueFullError()))
+	assert.True(t, isProducerQueueFull(errors.Wrap(queueFullError(), "send")))
+	assert.False(t, isProducerQueueFull(&pulsar.Error{}))
+	assert.False(t, isProducerQueueFull(errors.New("send failed")))
+}
+
+// dedupProducer acknowledge the messages of sequences already persisted like pulsar deduplication, without message id
+type dedupProducer struct {
+	stalledProducer
+	lastSequence int64
+	sequences    []int64
+	closed       bool
+}
+
+func (p *dedupProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	sequence := *message.SequenceID
+	p.sequences = append(p.sequences, sequence)
+	if sequence <= p.lastSequence {
+		callback(&testMessageID{ledgerID: -1, entryID: -1}, message, nil)
+		return
+	}
+	p.lastSequence = sequence
+	callback(&testMessageID{ledgerID: 1, entryID: sequence}, message, nil)
+}
+
+func (p *dedupProducer) Close() {
+	p.closed = true
+}
+
+func TestIdempotentProducerOptions(t *testing.T) {
+	config := kafsarConfig
+	config.NodeId = 3
+	k := newTestBroker(config)
+	client := &producerOptionsClient{}
+	k.pulsarCommonClient = client
+	_, err := k.getIdempotentProducer(&addr, username, "persistent://public/default/topic-partition-0", 100, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "persistent://public/default/topic-partition-0", client.options.Topic)
+	assert.Equal(t, "kafsar-3-100-2", client.options.Name)
+	assert.Contains(t, k.producerManager, idempotentProducerKey(&addr, "persistent://public/default/topic-partition-0", "kafsar-3-100-2"))
+}
+
+func TestProducePulsarDeduplication(t *testing.T) {
+	config := kafsarConfig
+	config.PulsarDeduplication = true
+	k := newTestBroker(config)
+	producerId, epoch, _ := k.producerStates.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pulsar persisted the first batch, but the produce timed out before kafsar tracked its sequence
+	producer := &dedupProducer{lastSequence: 1}
+	name := fmt.Sprintf("kafsar-%d-%d-%d", config.NodeId, producerId, epoch)
+	k.producerManager[idempotentProducerKey(&addr, partitionedTopic, name)] = producer
+	produce := func(baseSequence int32) *codec.ProducePartitionResp {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId:    producerId,
+				ProducerEpoch: epoch,
+				BaseSequence:  baseSequence,
+				Records:       []*codec.Record{{Value: []byte(testContent)}, {Value: []byte(testContent)}},
+			},
+		})
+		assert.Nil(t, err)
+		return resp
+	}
+	resp := produce(0)
+	assert.Equal(t, codec.DUPLICATE_SEQUENCE_NUMBER, resp.ErrorCode)
+	assert.Equal(t, []int64{0, 1}, producer.sequences)
+	// retried again, answered by kafsar without sending
+	resp = produce(0)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, []int64{0, 1}, producer.sequences)
+
+	resp = produce(2)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, []int64{0, 1, 2, 3}, producer.sequences)
+
+	k.Disconnect(&addr)
+	assert.True(t, producer.closed)
+	assert.Empty(t, k.producerManager)
+}
+
+func TestProduceRetryWhileSending(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	producerId, epoch, _ := k.producerStates.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	producer := &stalledProducer{}
+	k.producerManager[addr.String()] = producer
+	produce := func() *codec.ProducePartitionResp {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId:    producerId,
+				ProducerEpoch: epoch,
+				Records:       []*codec.Record{{Value: []byte(testContent)}, {Value: []byte(testContent)}},
+			},
+		})
+		assert.Nil(t, err)
+		return resp
+	}
+	respCh := make(chan *codec.ProducePartitionResp)
+	go func() {
+		respCh <- produce()
+	}()
+	assert.Eventually(t, func() bool { return producer.sent() == 2 }, time.Second, 10*time.Millisecond)
+
+	// the client retries while the batch is being sent
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, produce().ErrorCode)
+	producer.ack()
+	assert.Equal(t, codec.NONE, (<-respCh).ErrorCode)
+
+	// the batch is stored once
+	assert.Equal(t, codec.NONE, produce().ErrorCode)
+	assert.Equal(t, 0, producer.sent())
+}
+
+func TestCooperativeRebalanceKeepReaders(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	leaderId := "test-leader"
+	member := &memberMetadata{
+		memberId:         leaderId,
+		joinGenerationId: 2,
+		assignment:       encodeAssignment(map[string][]int32{"topic": {0, 1}}),
+	}
+	group := &Group{
+		groupId:           groupId,
+		groupStatus:       PreparingRebalance,
+		supportedProtocol: constant.CooperativeStickyProtocol,
+		generationId:      2,
+		leader:            leaderId,
+		members:           map[string]*memberMetadata{leaderId: member},
+		sessionTimeoutMs:  sessionTimeoutMs,
+
+		awaitingJoinMembers: make(map[string]time.Time),
+		awaitingSyncMembers: make(map[string]time.Time),
+	}
+	readers := make([]*testReader, 2)
+	for i := range readers {
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		group.addPartitionedTopic(partitionedTopic)
+		readers[i] = &testReader{}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: readers[i]}
+	}
+	groupCoordinator.groupManager[username+groupId] = group
+
+	// members keep reading during the rebalance
+	resp := k.HeartBeat(&addr, codec.HeartbeatReq{BaseReq: codec.BaseReq{ClientId: clientId}, GroupId: groupId, MemberId: leaderId})
+	assert.Equal(t, codec.REBALANCE_IN_PROGRESS, resp.ErrorCode)
+	assert.Len(t, k.readerManager, 2)
+
+	// partition 0 moves to another member, only its reader is closed
+	group.groupStatus = CompletingRebalance
+	assignment := encodeAssignment(map[string][]int32{"topic": {1}})
+	syncResp, err := k.GroupSync(&addr, &codec.SyncGroupReq{
+		BaseReq:          codec.BaseReq{ClientId: clientId},
+		GroupId:          groupId,
+		GenerationId:     2,
+		MemberId:         leaderId,
+		GroupAssignments: []*codec.GroupAssignment{{MemberId: leaderId, MemberAssignment: assignment}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, syncResp.ErrorCode)
+	assert.Equal(t, assignment, syncResp.MemberAssignment)
+	assert.True(t, readers[0].closed)
+	assert.False(t, readers[1].closed)
+	assert.Len(t, k.readerManager, 1)
+}
+
+func TestGroupLeaveOverlappingSubscriptions(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	members := map[string]*memberMetadata{
+		"member-1": {memberId: "member-1", clientId: "client-1"},
+		"member-2": {memberId: "member-2", clientId: "client-2"},
+		// shares the readers of member-2
+		"member-3": {memberId: "member-3", clientId: "client-2"},
+	}
+	group := &Group{
+		groupId:             groupId,
+		groupStatus:         Stable,
+		members:             members,
+		sessionTimeoutMs:    sessionTimeoutMs,
+		awaitingJoinMembers: make(map[string]time.Time),
+		awaitingSyncMembers: make(map[string]time.Time),
+	}
+	groupCoordinator.groupManager[username+groupId] = group
+	topics := make([]string, 3)
+	for i := range topics {
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics[i] = partitionedTopic
+		group.addPartitionedTopic(partitionedTopic)
+		// subscribing again does not duplicate the partition
+		group.addPartitionedTopic(partitionedTopic)
+		k.topicGroupManager[username+partitionedTopic] = groupId
+	}
+	readers := make(map[string]*testReader)
+	addReader := func(clientId, partitionedTopic string) {
+		reader := &testReader{}
+		readers[clientId+partitionedTopic] = reader
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader}
+	}
+	// client-1 reads partition 0 and 1, client-2 reads partition 1 and 2
+	addReader("client-1", topics[0])
+	addReader("client-1", topics[1])
+	addReader("client-2", topics[1])
+	addReader("client-2", topics[2])
+	leave := func(clientId, memberId string) {
+		resp, err := k.GroupLeave(&addr, &codec.LeaveGroupReq{
+			BaseReq: codec.BaseReq{ClientId: clientId},
+			GroupId: groupId,
+			Members: []*codec.LeaveGroupMember{{MemberId: memberId}},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, codec.NONE, resp.ErrorCode)
+	}
+
+	leave("client-1", "member-1")
+	assert.True(t, readers["client-1"+topics[0]].closed)
+	assert.True(t, readers["client-1"+topics[1]].closed)
+	assert.False(t, readers["client-2"+topics[1]].closed)
+	// the group keeps the released partition for its committed offset
+	assert.Equal(t, topics, group.subscribedTopics())
+	assert.NotContains(t, k.topicGroupManager, username+topics[0])
+	assert.Equal(t, groupId, k.topicGroupManager[username+topics[1]])
+
+	// member-3 still reads with the readers of client-2
+	leave("client-2", "member-2")
+	assert.False(t, readers["client-2"+topics[1]].closed)
+	assert.False(t, readers["client-2"+topics[2]].closed)
+	assert.Equal(t, groupId, k.topicGroupManager[username+topics[2]])
+
+	leave("client-2", "member-3")
+	assert.True(t, readers["client-2"+topics[1]].closed)
+	assert.True(t, readers["client-2"+topics[2]].closed)
+	assert.Equal(t, topics, group.subscribedTopics())
+	assert.Empty(t, k.topicGroupManager)
+	assert.Empty(t, k.readerManager)
+}
+
+func TestGroupLeaveSurvivingMemberKeepsFetching(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	group := &Group{
+		groupId:     groupId,
+		groupStatus: Stable,
+		members: map[string]*memberMetadata{
+			"member-1": {memberId: "member-1", clientId: "client-1"},
+			"member-2": {memberId: "member-2", clientId: "client-2"},
+		},
+		sessionTimeoutMs:    sessionTimeoutMs,
+		awaitingJoinMembers: make(map[string]time.Time),
+		awaitingSyncMembers: make(map[string]time.Time),
+	}
+	groupCoordinator.groupManager[username+groupId] = group
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.addPartitionedTopic(partitionedTopic)
+	k.topicGroupManager[username+partitionedTopic] = groupId
+	newReader := func(clientId string) *testReader {
+		messages := make([]pulsar.Message, 2)
+		for i := range messages {
+			messages[i] = &testMessage{
+				id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+				topic:   partitionedTopic,
+				payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+			}
+		}
+		reader := &testReader{messages: messages}
+		k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{
+			groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0),
+		}
+		return reader
+	}
+	leavingReader := newReader("client-1")
+	survivingReader := newReader("client-2")
+
+	resp, err := k.GroupLeave(&addr, &codec.LeaveGroupReq{
+		BaseReq: codec.BaseReq{ClientId: "client-1"},
+		GroupId: groupId,
+		Members: []*codec.LeaveGroupMember{{MemberId: "member-1"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.True(t, leavingReader.closed)
+	assert.False(t, survivingReader.closed)
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	fetchResp := k.FetchPartition(&addr, "topic", "client-2", &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, codec.NONE, fetchResp.ErrorCode)
+	assert.Equal(t, 2, len(fetchResp.RecordBatch.Records))
+	assert.Equal(t, 2, survivingReader.position)
+}
+
+func TestDisconnectReleaseTopicGroups(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	group := &Group{
+		groupId:     groupId,
+		groupStatus: Stable,
+		members: map[string]*memberMetadata{
+			"member-1": {memberId: "member-1", clientId: "client-1"},
+			"member-2": {memberId: "member-2", clientId: "client-2"},
+		},
+		sessionTimeoutMs:    sessionTimeoutMs,
+		awaitingJoinMembers: make(map[string]time.Time),
+		awaitingSyncMembers: make(map[string]time.Time),
+	}
+	groupCoordinator.groupManager[username+groupId] = group
+	addrs := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("::1"), Port: 9092},
+		&net.TCPAddr{IP: net.ParseIP("::2"), Port: 9092},
+	}
+	for i, memberAddr := range addrs {
+		clientId := fmt.Sprintf("client-%d", i+1)
+		k.userInfoManager[memberAddr.String()] = &userInfo{username: username, clientId: clientId}
+		k.memberManager[memberAddr.String()] = &MemberInfo{memberId: fmt.Sprintf("member-%d", i+1), groupId: groupId, clientId: clientId}
+	}
+	readers := make([]*testReader, 3)
+	partitionedTopics := make([]string, len(readers))
+	for i := range readers {
+		partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partitionedTopics[i] = partitionedTopic
+		group.addPartitionedTopic(partitionedTopic)
+		k.topicGroupManager[username+partitionedTopic] = groupId
+		readers[i] = &testReader{}
+		k.readerManager[readerKey(username, partitionedTopic, fmt.Sprintf("client-%d", i+1))] = &ReaderMetadata{groupId: groupId, reader: readers[i]}
+	}
+
+	// client-3 was an expired member, its reader does not keep the partition of the group
+	k.Disconnect(addrs[0])
+	assert.True(t, readers[0].closed)
+	assert.Equal(t, 1, len(k.topicGroupManager))
+	assert.Equal(t, groupId, k.topicGroupManager[username+partitionedTopics[1]])
+
+	k.Disconnect(addrs[1])
+	assert.True(t, readers[1].closed)
+	assert.Equal(t, Empty, group.groupStatus)
+	// the group keeps its partitions until it is deleted
+	assert.Equal(t, partitionedTopics, group.subscribedTopics())
+	assert.Empty(t, k.topicGroupManager)
+	assert.Empty(t, k.memberManager)
+	for _, memberAddr := range addrs {
+		_, exist := k.userInfoManager[memberAddr.String()]
+		assert.False(t, exist)
+	}
+}
+
+// schemaProducer rejects the payloads not conforming to the topic schema like a schema enforced pulsar topic
+type schemaProducer struct {
+	stalledProducer
+}
+
+func (p *schemaProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	if !json.Valid(message.Payload) {
+		callback(nil, message, errors.New("server error: IncompatibleSchema"))
+		return
+	}
+	callback(&testMessageID{ledgerID: 1, entryID: int64(p.sent())}, message, nil)
+}
+
+func TestProduceSchemaViolation(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	k.producerManager[addr.String()] = &schemaProducer{}
+	resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId: constant.NoProducerId,
+			Records: []*codec.Record{
+				{Value: []byte(`{"name":"kafsar"}`)},
+				{Value: []byte("not json")},
+				{Value: []byte(`{"name":"pulsar"}`)},
+				{Value: []byte("{")},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_RECORD, resp.ErrorCode)
+	assert.Len(t, resp.RecordErrorList, 2)
+	assert.Equal(t, int32(1), resp.RecordErrorList[0].BatchIndex)
+	assert.Equal(t, int32(3), resp.RecordErrorList[1].BatchIndex)
+	assert.Contains(t, *resp.RecordErrorList[0].BatchIndexErrorMessage, "IncompatibleSchema")
+	assert.Equal(t, 0, k.pendingProduce.count(addr.String()))
+}
+
+func TestIsSchemaError(t *testing.T) {
+	assert.True(t, isSchemaError(errors.New("server error: IncompatibleSchema")))
+	assert.True(t, isSchemaError(errors.Wrap(errors.New("Schema encode message failed"), "send")))
+	assert.False(t, isSchemaError(queueFullError()))
+	assert.False(t, isSchemaError(errors.New("send failed")))
+}
+
+// failingProducer fail the messages of the payload with err
+type failingProducer struct {
+	stalledProducer
+	payload string
+	err     error
+}
+
+func (p *failingProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	if string(message.Payload) == p.payload {
+		callback(nil, message, p.err)
+		return
+	}
+	callback(&testMessageID{ledgerID: 1, entryID: int64(len(message.Payload))}, message, nil)
+}
+
+func TestProduceSendFailed(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	produce := func(err error) *codec.ProducePartitionResp {
+		k.producerManager[addr.String()] = &failingProducer{payload: "failed", err: err}
+		resp, produceErr := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId: constant.NoProducerId,
+				Records:    []*codec.Record{{Value: []byte(testContent)}, {Value: []byte("failed")}},
+			},
+		})
+		assert.Nil(t, produceErr)
+		return resp
+	}
+	// part of the batch is not stored, the batch must not be acknowledged
+	assert.Equal(t, codec.UNKNOWN_SERVER_ERROR, produce(errors.New("connection closed")).ErrorCode)
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, produce(pulsarResultError(pulsar.TimeoutError)).ErrorCode)
+	assert.Equal(t, 0, k.pendingProduce.count(addr.String()))
+}
+
+// recordingProducer acknowledges the messages with increasing entry ids and remembers their payloads
+type recordingProducer struct {
+	stalledProducer
+	payloads []string
+}
+
+func (p *recordingProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	p.mutex.Lock()
+	entryId := int64(len(p.payloads))
+	p.payloads = append(p.payloads, string(message.Payload))
+	p.mutex.Unlock()
+	callback(&testMessageID{ledgerID: 1, entryID: entryId}, message, nil)
+}
+
+func TestProduceMultipleBatches(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	producer := &recordingProducer{}
+	k.producerManager[addr.String()] = producer
+	user := k.userInfoManager[addr.String()]
+	batches := []*codec.RecordBatch{
+		{ProducerId: constant.NoProducerId, Records: []*codec.Record{{Value: []byte("a")}, {Value: []byte("b")}}},
+		{ProducerId: constant.NoProducerId, Records: []*codec.Record{{Value: []byte("c")}}},
+	}
+	resp, err := k.ProduceBatches(context.Background(), &addr, "topic", partition, 30000, batches)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	assert.Equal(t, []string{"a", "b", "c"}, producer.payloads)
+	lastOffset, err := convertMsgId(&testMessageID{ledgerID: 1, entryID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lastOffset, resp.Offset)
+
+	k.producerManager[addr.String()] = &schemaProducer{}
+	batches = []*codec.RecordBatch{
+		{ProducerId: constant.NoProducerId, Records: []*codec.Record{{Value: []byte(`{"name":"kafsar"}`)}, {Value: []byte(`{"name":"pulsar"}`)}}},
+		{ProducerId: constant.NoProducerId, Records: []*codec.Record{{Value: []byte(`{"name":"kafka"}`)}, {Value: []byte("not json")}}},
+	}
+	resp = k.produceBatches(context.Background(), NoopTracer{}.NewSpan(context.Background(), "Produce"), &addr, user, "topic", partition, 30000, batches)
+	assert.Equal(t, codec.INVALID_RECORD, resp.ErrorCode)
+	assert.Len(t, resp.RecordErrorList, 1)
+	assert.Equal(t, int32(3), resp.RecordErrorList[0].BatchIndex)
+}
+
+func TestPartitionedTopicErrorCode(t *testing.T) {
+	assert.Equal(t, codec.UNKNOWN_TOPIC_OR_PARTITION, partitionedTopicErrorCode(errors.Wrap(errUnknownPartition, "partition 3")))
+	assert.Equal(t, codec.NOT_LEADER_OR_FOLLOWER, partitionedTopicErrorCode(errors.New("bundle unloading")))
+}
+
+func TestOffsetListWithoutReaderRetryable(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	resp, err := k.OffsetListPartition(&addr, "topic", clientId, &codec.ListOffsetsPartition{PartitionId: partition, Time: constant.TimeEarliest})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NOT_LEADER_OR_FOLLOWER, resp.ErrorCode)
+}
+
+func TestFetchCanceled(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchWaitMs = 5000
+	config.MaxFetchRecord = 100
+	k := newPartitionsBroker(t, config, 1)
+	req := fetchPartitionsReq(1, 1024)
+	req.MaxWaitTime = 5000
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	resp, err := k.Fetch(ctx, &addr, req)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	// the records read before the cancel are returned
+	assert.Equal(t, 5, len(resp[0].PartitionRespList[0].RecordBatch.Records))
+}
+
+func TestOffsetCommitMetadata(t *testing.T) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, joinGroupResp.ErrorCode)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]pulsar.Message, 2)
+	for i := range messages {
+		messages[i] = &testMessage{
+			id:      &testMessageID{ledgerID: 1, entryID: int64(i)},
+			topic:   partitionedTopic,
+			payload: []byte(fmt.Sprintf("%s-%d", testContent, i)),
+		}
+	}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: &testReader{messages: messages}, messageIds: make([]MessageIdPair, 0)}
+
+	// nothing committed yet
+	offsetFetchResp, err := k.OffsetFetch(&addr, "topic", clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, offsetFetchResp.ErrorCode)
+	assert.Nil(t, offsetFetchResp.Metadata)
+
+	fetchPartitionReq := codec.FetchPartitionReq{PartitionId: partition}
+	resp := k.FetchPartition(&addr, "topic", clientId, &fetchPartitionReq, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, 2, len(resp.RecordBatch.Records))
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{
+		PartitionId: partition,
+		Offset:      resp.RecordBatch.Offset,
+		Metadata:    "connect-state",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, "connect-state", committed.Metadata)
+
+	offsetFetchResp, err = k.OffsetFetch(&addr, "topic", clientId, groupId, &codec.OffsetFetchPartitionReq{PartitionId: partition})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, offsetFetchResp.ErrorCode)
+	assert.Equal(t, resp.RecordBatch.Offset, offsetFetchResp.Offset)
+	if assert.NotNil(t, offsetFetchResp.Metadata) {
+		assert.Equal(t, "connect-state", *offsetFetchResp.Metadata)
+	}
+}
@@ -0,0 +1,100 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsartest
+
+import (
+	"fmt"
+	"github.com/paashzj/kafka_go_pulsar/pkg/kafsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/test"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/kgnet"
+	"net"
+	"time"
+)
+
+const listenTimeout = 5 * time.Second
+
+// DefaultPulsarConfig the standalone pulsar started by test.SetupPulsar
+func DefaultPulsarConfig() kafsar.PulsarConfig {
+	return kafsar.PulsarConfig{
+		Host:     "localhost",
+		HttpPort: 8080,
+		TcpPort:  6650,
+	}
+}
+
+// NewConfig a broker config listening on localhost port, offsets are stored in memory
+func NewConfig(port int, pulsarConfig kafsar.PulsarConfig) *kafsar.Config {
+	config := &kafsar.Config{}
+	config.PulsarConfig = pulsarConfig
+	config.KafsarConfig.GnetConfig = kgnet.GnetServerConfig{
+		ListenHost: "localhost",
+		ListenPort: port,
+	}
+	config.KafsarConfig.AdvertiseHost = "localhost"
+	config.KafsarConfig.AdvertisePort = port
+	config.KafsarConfig.MaxConsumersPerGroup = 100
+	config.KafsarConfig.GroupMaxSessionTimeoutMs = 60000
+	config.KafsarConfig.GroupMinSessionTimeoutMs = 0
+	config.KafsarConfig.MaxFetchRecord = 10
+	config.KafsarConfig.MinFetchWaitMs = 10
+	config.KafsarConfig.MaxFetchWaitMs = 100
+	config.KafsarConfig.PulsarTenant = "public"
+	config.KafsarConfig.PulsarNamespace = "default"
+	config.KafsarConfig.OffsetTopic = "kafka_offset"
+	config.KafsarConfig.OffsetStoreType = kafsar.OffsetStoreMemory
+	return config
+}
+
+// StartBroker run a broker serving server on an unused port against the pulsar of pulsarConfig, e.g.
+// DefaultPulsarConfig or a mock pulsar. produce and fetch need a reachable pulsar, the other apis do not.
+// the caller should close the broker
+func StartBroker(server kafsar.Server, pulsarConfig kafsar.PulsarConfig) (*kafsar.Broker, int, error) {
+	port, err := test.AcquireUnusedPort()
+	if err != nil {
+		return nil, 0, err
+	}
+	broker, err := kafsar.NewKafsar(server, NewConfig(port, pulsarConfig))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := broker.Run(); err != nil {
+		return nil, 0, err
+	}
+	if err := waitListening(port); err != nil {
+		broker.Close()
+		return nil, 0, err
+	}
+	return broker, port, nil
+}
+
+// waitListening the broker listens asynchronously, wait until it accepts connections
+func waitListening(port int) error {
+	addr := fmt.Sprintf("localhost:%d", port)
+	deadline := time.Now().Add(listenTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, listenTimeout)
+		if err == nil {
+			return conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return errors.Wrapf(err, "broker not listening on %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,198 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package kafsartest provide an in-memory kafsar.Server and helpers to start a Broker in tests
+package kafsartest
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultPulsarTopicPrefix  = "persistent://public/default/"
+	DefaultSubscriptionPrefix = "kafsar_sub_"
+	permissionAll             = "ALL"
+)
+
+var errUnknownTopic = errors.New("unknown topic")
+
+type topic struct {
+	pulsarTopic string
+	partitions  int
+}
+
+// Server an in-memory kafsar.Server. without users everyone is authenticated, without topics every topic is
+// mapped to DefaultPulsarTopicPrefix with 1 partition. everything is allowed unless denied
+type Server struct {
+	mutex sync.RWMutex
+	// users username to password
+	users  map[string]string
+	topics map[string]topic
+	// strictTopics unknown topics are rejected instead of mapped to the default pulsar topic
+	strictTopics bool
+	// deniedTopics username to topic to denied permission types
+	deniedTopics map[string]map[string]map[string]bool
+	// deniedGroups username to denied groups
+	deniedGroups map[string]map[string]bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		users:        make(map[string]string),
+		topics:       make(map[string]topic),
+		deniedTopics: make(map[string]map[string]map[string]bool),
+		deniedGroups: make(map[string]map[string]bool),
+	}
+}
+
+// AddUser once a user is added, only the added users with the right password are authenticated
+func (s *Server) AddUser(username, password string) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users[username] = password
+	return s
+}
+
+// AddTopic map the kafka topic to the pulsar topic with the number of partitions
+func (s *Server) AddTopic(kafkaTopic, pulsarTopic string, partitions int) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topics[kafkaTopic] = topic{pulsarTopic: pulsarTopic, partitions: partitions}
+	return s
+}
+
+// StrictTopics reject the topics not added by AddTopic instead of mapping them to the default pulsar topic
+func (s *Server) StrictTopics() *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.strictTopics = true
+	return s
+}
+
+// DenyTopic deny the user the permission type of the topic, "R", "W" or "ALL". "ALL" deny every permission type
+func (s *Server) DenyTopic(username, kafkaTopic, permissionType string) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	topics, ok := s.deniedTopics[username]
+	if !ok {
+		topics = make(map[string]map[string]bool)
+		s.deniedTopics[username] = topics
+	}
+	permissions, ok := topics[kafkaTopic]
+	if !ok {
+		permissions = make(map[string]bool)
+		topics[kafkaTopic] = permissions
+	}
+	permissions[permissionType] = true
+	return s
+}
+
+// DenyGroup deny the user to join or commit offsets of the group
+func (s *Server) DenyGroup(username, consumerGroup string) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	groups, ok := s.deniedGroups[username]
+	if !ok {
+		groups = make(map[string]bool)
+		s.deniedGroups[username] = groups
+	}
+	groups[consumerGroup] = true
+	return s
+}
+
+func (s *Server) Auth(username string, password string, clientId string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.users) == 0 {
+		return true, nil
+	}
+	expected, ok := s.users[username]
+	return ok && expected == password, nil
+}
+
+func (s *Server) AuthTopic(username string, password, clientId, topic, permissionType string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.topicAllowed(username, topic, permissionType), nil
+}
+
+// topicAllowed an "ALL" request is denied if any permission type is denied
+func (s *Server) topicAllowed(username, topic, permissionType string) bool {
+	permissions := s.deniedTopics[username][topic]
+	if permissions[permissionAll] || permissions[permissionType] {
+		return false
+	}
+	return permissionType != permissionAll || len(permissions) == 0
+}
+
+func (s *Server) AuthTopicGroup(username string, password, clientId, consumerGroup string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !s.deniedGroups[username][consumerGroup], nil
+}
+
+func (s *Server) SubscriptionName(username, groupId string) (string, error) {
+	return DefaultSubscriptionPrefix + groupId, nil
+}
+
+func (s *Server) PulsarTopic(username, kafkaTopic string) (string, error) {
+	t, err := s.topic(kafkaTopic)
+	if err != nil {
+		return "", err
+	}
+	return t.pulsarTopic, nil
+}
+
+func (s *Server) PartitionNum(username, kafkaTopic string) (int, error) {
+	t, err := s.topic(kafkaTopic)
+	if err != nil {
+		return 0, err
+	}
+	return t.partitions, nil
+}
+
+func (s *Server) topic(kafkaTopic string) (topic, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if t, ok := s.topics[kafkaTopic]; ok {
+		return t, nil
+	}
+	if s.strictTopics {
+		return topic{}, errors.Wrap(errUnknownTopic, kafkaTopic)
+	}
+	return topic{pulsarTopic: DefaultPulsarTopicPrefix + kafkaTopic, partitions: 1}, nil
+}
+
+// ListTopic the added topics the user may describe, sorted by name
+func (s *Server) ListTopic(username string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	topics := make([]string, 0, len(s.topics))
+	for kafkaTopic := range s.topics {
+		if s.topicAllowed(username, kafkaTopic, "R") {
+			topics = append(topics, kafkaTopic)
+		}
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (s *Server) ReserveFetchQuota(username, topic string, bytes int) int {
+	return bytes
+}
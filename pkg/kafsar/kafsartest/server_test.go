@@ -0,0 +1,109 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsartest
+
+import (
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestServerAuth(t *testing.T) {
+	server := NewServer()
+	ok, err := server.Auth("alice", "any", "client")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	server.AddUser("alice", "secret")
+	ok, _ = server.Auth("alice", "secret", "client")
+	assert.True(t, ok)
+	ok, _ = server.Auth("alice", "wrong", "client")
+	assert.False(t, ok)
+	ok, _ = server.Auth("bob", "secret", "client")
+	assert.False(t, ok)
+}
+
+func TestServerTopicMapping(t *testing.T) {
+	server := NewServer().AddTopic("orders", "persistent://tenant/ns/orders", 3)
+	pulsarTopic, err := server.PulsarTopic("alice", "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, "persistent://tenant/ns/orders", pulsarTopic)
+	partitionNum, err := server.PartitionNum("alice", "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, partitionNum)
+	pulsarTopic, err = server.PulsarTopic("alice", "other")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultPulsarTopicPrefix+"other", pulsarTopic)
+	partitionNum, err = server.PartitionNum("alice", "other")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, partitionNum)
+	server.StrictTopics()
+	_, err = server.PulsarTopic("alice", "other")
+	assert.NotNil(t, err)
+	_, err = server.PartitionNum("alice", "other")
+	assert.NotNil(t, err)
+}
+
+func TestServerDenyTopic(t *testing.T) {
+	server := NewServer().AddTopic("orders", "persistent://public/default/orders", 1).
+		AddTopic("audit", "persistent://public/default/audit", 1).
+		DenyTopic("alice", "orders", "W").
+		DenyTopic("alice", "audit", "ALL")
+	ok, _ := server.AuthTopic("alice", "", "client", "orders", "R")
+	assert.True(t, ok)
+	ok, _ = server.AuthTopic("alice", "", "client", "orders", "W")
+	assert.False(t, ok)
+	ok, _ = server.AuthTopic("alice", "", "client", "orders", "ALL")
+	assert.False(t, ok)
+	ok, _ = server.AuthTopic("alice", "", "client", "audit", "R")
+	assert.False(t, ok)
+	ok, _ = server.AuthTopic("bob", "", "client", "audit", "ALL")
+	assert.True(t, ok)
+	topics, err := server.ListTopic("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders"}, topics)
+	topics, err = server.ListTopic("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"audit", "orders"}, topics)
+}
+
+func TestServerDenyGroup(t *testing.T) {
+	server := NewServer().DenyGroup("alice", "group")
+	ok, _ := server.AuthTopicGroup("alice", "", "client", "group")
+	assert.False(t, ok)
+	ok, _ = server.AuthTopicGroup("alice", "", "client", "other")
+	assert.True(t, ok)
+	ok, _ = server.AuthTopicGroup("bob", "", "client", "group")
+	assert.True(t, ok)
+}
+
+func TestStartBrokerMetadata(t *testing.T) {
+	server := NewServer().AddTopic("orders", DefaultPulsarTopicPrefix+"orders", 3)
+	broker, port, err := StartBroker(server, DefaultPulsarConfig())
+	require.Nil(t, err)
+	defer broker.Close()
+	dialer := &kafka.Dialer{SASLMechanism: plain.Mechanism{Username: "alice", Password: "secret"}}
+	conn, err := dialer.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	require.Nil(t, err)
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions("orders")
+	require.Nil(t, err)
+	assert.Len(t, partitions, 3)
+}
@@ -0,0 +1,71 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/sirupsen/logrus"
+	"net"
+)
+
+// Logger leveled logger of kafsar, implement it to route the logs to zap, slog, etc.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// WithFields return a logger attaching the fields to every log, e.g. remote address and group id
+	WithFields(fields map[string]interface{}) Logger
+}
+
+// logrusLogger adapt a logrus entry to Logger
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+// NewLogrusLogger adapt the logrus logger, the logrus standard logger if nil
+func NewLogrusLogger(logger *logrus.Logger) Logger {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	return &logrusLogger{entry: logrus.NewEntry(logger)}
+}
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) {
+	l.entry.Debugf(format, args...)
+}
+
+func (l *logrusLogger) Infof(format string, args ...interface{}) {
+	l.entry.Infof(format, args...)
+}
+
+func (l *logrusLogger) Warnf(format string, args ...interface{}) {
+	l.entry.Warnf(format, args...)
+}
+
+func (l *logrusLogger) Errorf(format string, args ...interface{}) {
+	l.entry.Errorf(format, args...)
+}
+
+func (l *logrusLogger) WithFields(fields map[string]interface{}) Logger {
+	return &logrusLogger{entry: l.entry.WithFields(fields)}
+}
+
+// groupLogger attach the remote address and the group id to the logs of a group request
+func (b *Broker) groupLogger(addr net.Addr, groupId string) Logger {
+	return b.logger.WithFields(map[string]interface{}{"addr": addr.String(), "group": groupId})
+}
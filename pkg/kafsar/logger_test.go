@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+)
+
+// recordLogger keep the logs with their fields
+type recordLogger struct {
+	fields map[string]interface{}
+	logs   *[]string
+	mutex  *sync.Mutex
+}
+
+func newRecordLogger() *recordLogger {
+	return &recordLogger{logs: &[]string{}, mutex: &sync.Mutex{}}
+}
+
+func (r *recordLogger) record(level, format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	*r.logs = append(*r.logs, fmt.Sprintf("%s %v %s", level, r.fields, fmt.Sprintf(format, args...)))
+}
+
+func (r *recordLogger) Debugf(format string, args ...interface{}) {
+	r.record("debug", format, args...)
+}
+
+func (r *recordLogger) Infof(format string, args ...interface{}) {
+	r.record("info", format, args...)
+}
+
+func (r *recordLogger) Warnf(format string, args ...interface{}) {
+	r.record("warn", format, args...)
+}
+
+func (r *recordLogger) Errorf(format string, args ...interface{}) {
+	r.record("error", format, args...)
+}
+
+func (r *recordLogger) WithFields(fields map[string]interface{}) Logger {
+	merged := make(map[string]interface{}, len(r.fields)+len(fields))
+	for k, v := range r.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &recordLogger{fields: merged, logs: r.logs, mutex: r.mutex}
+}
+
+func TestLogrusLoggerWithFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buf)
+	logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	NewLogrusLogger(logger).WithFields(map[string]interface{}{"group": groupId}).Warnf("member %s left", "member-1")
+	assert.Equal(t, fmt.Sprintf("level=warning msg=\"member member-1 left\" group=%s\n", groupId), buf.String())
+}
+
+func TestBrokerLogsWithInjectedLogger(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	logger := newRecordLogger()
+	k.logger = logger
+	unknownAddr := net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 9092}
+	resp, err := k.GroupJoin(&unknownAddr, &codec.JoinGroupReq{GroupId: groupId})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.UNKNOWN_SERVER_ERROR, resp.ErrorCode)
+	assert.Equal(t, []string{
+		fmt.Sprintf("error map[addr:%s group:%s] username not found in join group: %s", unknownAddr.String(), groupId, groupId),
+	}, *logger.logs)
+}
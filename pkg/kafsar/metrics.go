@@ -0,0 +1,196 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"strconv"
+	"time"
+)
+
+type Metrics interface {
+	// ProduceRequest record a produce request, bytes is the size of the record batch
+	ProduceRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode)
+	// FetchRequest record a partition fetch, bytes is the size of the returned records
+	FetchRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode)
+	// OffsetCommit record an offset commit and its latency
+	OffsetCommit(kafkaTopic string, latency time.Duration, errorCode codec.ErrorCode)
+	// Rebalance record a completed rebalance of the group
+	Rebalance(groupId string)
+	// ReaderCount set the size of the reader pool
+	ReaderCount(count int)
+	// ProducerCount set the size of the producer pool
+	ProducerCount(count int)
+	// TopicMismatch record a fetched message dropped because it is not from the partition of the reader
+	TopicMismatch(kafkaTopic string)
+}
+
+// noopMetrics used when no metrics configured
+type noopMetrics struct {
+}
+
+func (n noopMetrics) ProduceRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode) {
+}
+
+func (n noopMetrics) FetchRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode) {
+}
+
+func (n noopMetrics) OffsetCommit(kafkaTopic string, latency time.Duration, errorCode codec.ErrorCode) {
+}
+
+func (n noopMetrics) Rebalance(groupId string) {
+}
+
+func (n noopMetrics) ReaderCount(count int) {
+}
+
+func (n noopMetrics) ProducerCount(count int) {
+}
+
+func (n noopMetrics) TopicMismatch(kafkaTopic string) {
+}
+
+type PrometheusMetrics struct {
+	produceRequests     *prometheus.CounterVec
+	produceBytes        *prometheus.CounterVec
+	fetchRequests       *prometheus.CounterVec
+	fetchBytes          *prometheus.CounterVec
+	offsetCommitLatency *prometheus.HistogramVec
+	rebalances          *prometheus.CounterVec
+	readers             prometheus.Gauge
+	producers           prometheus.Gauge
+	topicMismatches     *prometheus.CounterVec
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+
+// NewPrometheusMetrics create the collectors and register them to the registerer
+func NewPrometheusMetrics(registerer prometheus.Registerer) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		produceRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "produce_requests_total",
+			Help:      "Number of produce partition requests.",
+		}, []string{"topic", "error_code"}),
+		produceBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "produce_bytes_total",
+			Help:      "Bytes of records produced successfully.",
+		}, []string{"topic"}),
+		fetchRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "fetch_requests_total",
+			Help:      "Number of fetch partition requests.",
+		}, []string{"topic", "error_code"}),
+		fetchBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "fetch_bytes_total",
+			Help:      "Bytes of records fetched.",
+		}, []string{"topic"}),
+		offsetCommitLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "kafsar",
+			Name:      "offset_commit_latency_seconds",
+			Help:      "Latency of offset commit partition requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "error_code"}),
+		rebalances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "group_rebalances_total",
+			Help:      "Number of completed rebalances per group.",
+		}, []string{"group"}),
+		readers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kafsar",
+			Name:      "readers",
+			Help:      "Number of pulsar readers.",
+		}),
+		producers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "kafsar",
+			Name:      "producers",
+			Help:      "Number of pulsar producers.",
+		}),
+		topicMismatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kafsar",
+			Name:      "fetch_topic_mismatches_total",
+			Help:      "Fetched messages dropped because they are from another topic than the reader partition.",
+		}, []string{"topic"}),
+	}
+	collectors := []prometheus.Collector{m.produceRequests, m.produceBytes, m.fetchRequests, m.fetchBytes,
+		m.offsetCommitLatency, m.rebalances, m.readers, m.producers, m.topicMismatches}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (p *PrometheusMetrics) ProduceRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode) {
+	p.produceRequests.WithLabelValues(kafkaTopic, errorCodeLabel(errorCode)).Inc()
+	if errorCode == codec.NONE {
+		p.produceBytes.WithLabelValues(kafkaTopic).Add(float64(bytes))
+	}
+}
+
+func (p *PrometheusMetrics) FetchRequest(kafkaTopic string, bytes int, errorCode codec.ErrorCode) {
+	p.fetchRequests.WithLabelValues(kafkaTopic, errorCodeLabel(errorCode)).Inc()
+	p.fetchBytes.WithLabelValues(kafkaTopic).Add(float64(bytes))
+}
+
+func (p *PrometheusMetrics) OffsetCommit(kafkaTopic string, latency time.Duration, errorCode codec.ErrorCode) {
+	p.offsetCommitLatency.WithLabelValues(kafkaTopic, errorCodeLabel(errorCode)).Observe(latency.Seconds())
+}
+
+func (p *PrometheusMetrics) Rebalance(groupId string) {
+	p.rebalances.WithLabelValues(groupId).Inc()
+}
+
+func (p *PrometheusMetrics) ReaderCount(count int) {
+	p.readers.Set(float64(count))
+}
+
+func (p *PrometheusMetrics) ProducerCount(count int) {
+	p.producers.Set(float64(count))
+}
+
+func (p *PrometheusMetrics) TopicMismatch(kafkaTopic string) {
+	p.topicMismatches.WithLabelValues(kafkaTopic).Inc()
+}
+
+func errorCodeLabel(errorCode codec.ErrorCode) string {
+	return strconv.Itoa(int(errorCode))
+}
+
+// recordBatchBytes the size of keys, values and headers in the record batch
+func recordBatchBytes(recordBatch *codec.RecordBatch) int {
+	if recordBatch == nil {
+		return 0
+	}
+	bytes := 0
+	for _, record := range recordBatch.Records {
+		bytes += recordBytes(record)
+	}
+	return bytes
+}
+
+func recordBytes(record *codec.Record) int {
+	if record == nil {
+		return 0
+	}
+	return len(record.Key) + len(record.Value) + len(record.Headers)
+}
@@ -0,0 +1,78 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.ProduceRequest("topic", 10, codec.NONE)
+	metrics.ProduceRequest("topic", 10, codec.REQUEST_TIMED_OUT)
+	metrics.FetchRequest("topic", 20, codec.NONE)
+	metrics.OffsetCommit("topic", 10*time.Millisecond, codec.NONE)
+	metrics.Rebalance("group")
+	metrics.ReaderCount(3)
+	metrics.ProducerCount(2)
+	metrics.TopicMismatch("topic")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.produceRequests.WithLabelValues("topic", errorCodeLabel(codec.NONE))))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.produceRequests.WithLabelValues("topic", errorCodeLabel(codec.REQUEST_TIMED_OUT))))
+	assert.Equal(t, float64(10), testutil.ToFloat64(metrics.produceBytes.WithLabelValues("topic")))
+	assert.Equal(t, float64(20), testutil.ToFloat64(metrics.fetchBytes.WithLabelValues("topic")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.rebalances.WithLabelValues("group")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.readers))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.producers))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.topicMismatches.WithLabelValues("topic")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.offsetCommitLatency))
+
+	// register twice to the same registry should fail
+	_, err = NewPrometheusMetrics(registry)
+	assert.NotNil(t, err)
+}
+
+func TestProduceMetrics(t *testing.T) {
+	metrics, err := NewPrometheusMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := newTestBroker(KafsarConfig{})
+	broker.metrics = metrics
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			Flags:   constant.RecordBatchTransactionalFlag,
+			Records: []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	_, err = broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.produceRequests.WithLabelValues("topic", errorCodeLabel(codec.INVALID_TXN_STATE))))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.produceBytes.WithLabelValues("topic")))
+}
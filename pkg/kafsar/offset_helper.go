@@ -20,21 +20,52 @@ package kafsar
 import (
 	"fmt"
 	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sort"
 	"strconv"
 )
 
-func convOffset(message pulsar.Message, continuousOffset bool) int64 {
+// convOffset convert pulsar message to kafka offset, when the packed message id overflow int64,
+// fall back to the broker entry index if overflowUseIndex is set
+func convOffset(message pulsar.Message, continuousOffset bool, overflowUseIndex bool) (int64, error) {
 	if continuousOffset {
 		index := message.Index()
 		if index == nil {
-			panic("continuous offset mode, index field must be set")
+			return 0, errors.Errorf("continuous offset mode, index of message %s must be set by the pulsar broker", message.ID())
 		}
-		return int64(*index)
+		return int64(*index), nil
 	}
-	return ConvertMsgId(message.ID())
+	offset, err := convertMsgId(message.ID())
+	if err != nil && overflowUseIndex && message.Index() != nil {
+		logrus.Warnf("message id %s overflow, use index %d as offset", message.ID(), *message.Index())
+		return int64(*message.Index()), nil
+	}
+	return offset, err
 }
 
 func ConvertMsgId(messageId pulsar.MessageID) int64 {
-	offset, _ := strconv.Atoi(fmt.Sprint(messageId.LedgerID()) + fmt.Sprint(messageId.EntryID()) + fmt.Sprint(messageId.PartitionIdx()))
-	return int64(offset)
+	offset, err := convertMsgId(messageId)
+	if err != nil {
+		logrus.Errorf("convert message id failed. err: %s", err)
+	}
+	return offset
+}
+
+func convertMsgId(messageId pulsar.MessageID) (int64, error) {
+	packed := fmt.Sprint(messageId.LedgerID()) + fmt.Sprint(messageId.EntryID()) + fmt.Sprint(messageId.PartitionIdx())
+	offset, err := strconv.ParseInt(packed, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "message id %d:%d:%d overflow kafka offset",
+			messageId.LedgerID(), messageId.EntryID(), messageId.PartitionIdx())
+	}
+	return offset, nil
+}
+
+// searchMessageIdPair return the index of the last pair whose offset is not greater than offset,
+// messageIds must be ordered by offset, return -1 if not found
+func searchMessageIdPair(messageIds []MessageIdPair, offset int64) int {
+	return sort.Search(len(messageIds), func(i int) bool {
+		return messageIds[i].Offset > offset
+	}) - 1
 }
@@ -0,0 +1,82 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestConvOffset(t *testing.T) {
+	message := &testMessage{id: &testMessageID{ledgerID: 12, entryID: 34, partitionIdx: 0}}
+	offset, err := convOffset(message, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12340), offset)
+}
+
+func TestConvOffsetOverflow(t *testing.T) {
+	index := uint64(100)
+	message := &testMessage{
+		id:    &testMessageID{ledgerID: math.MaxInt64, entryID: 1, partitionIdx: 0},
+		index: &index,
+	}
+	_, err := convOffset(message, false, false)
+	assert.NotNil(t, err)
+
+	offset, err := convOffset(message, false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(index), offset)
+
+	message.index = nil
+	_, err = convOffset(message, false, true)
+	assert.NotNil(t, err)
+}
+
+func TestConvOffsetContinuous(t *testing.T) {
+	index := uint64(7)
+	message := &testMessage{id: &testMessageID{ledgerID: 12, entryID: 34}, index: &index}
+	offset, err := convOffset(message, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(index), offset)
+
+	message.index = nil
+	_, err = convOffset(message, true, false)
+	assert.NotNil(t, err)
+}
+
+func TestConvertMsgIdLargeLedgerId(t *testing.T) {
+	offset, err := convertMsgId(&testMessageID{ledgerID: 922337203685, entryID: 477580, partitionIdx: 7})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MaxInt64), offset)
+
+	offset, err = convertMsgId(&testMessageID{ledgerID: 922337203685, entryID: 477580, partitionIdx: 8})
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(0), offset)
+	assert.Equal(t, int64(0), ConvertMsgId(&testMessageID{ledgerID: math.MaxInt64, entryID: math.MaxInt64}))
+}
+
+func TestSearchMessageIdPair(t *testing.T) {
+	messageIds := []MessageIdPair{{Offset: 10}, {Offset: 20}, {Offset: 30}}
+	assert.Equal(t, -1, searchMessageIdPair(messageIds, 5))
+	assert.Equal(t, 0, searchMessageIdPair(messageIds, 10))
+	assert.Equal(t, 1, searchMessageIdPair(messageIds, 25))
+	assert.Equal(t, 2, searchMessageIdPair(messageIds, 30))
+	assert.Equal(t, 2, searchMessageIdPair(messageIds, 100))
+	assert.Equal(t, -1, searchMessageIdPair(nil, 100))
+}
@@ -17,16 +17,47 @@
 
 package kafsar
 
+import (
+	"time"
+)
+
 type OffsetManager interface {
-	Start() chan bool
+	// Start replay the stored offsets, ready is signaled on the first channel and startup failure on the second
+	Start() (chan bool, chan error)
 
 	CommitOffset(username, kafkaTopic, groupId string, partition int, pair MessageIdPair) error
 
+	// CommitOffsets commit a batch of offsets with a single flush to the offset topic
+	CommitOffsets(batch []OffsetCommit) error
+
 	AcquireOffset(username, kafkaTopic, groupId string, partition int) (MessageIdPair, bool)
 
 	RemoveOffset(username, kafkaTopic, groupId string, partition int) bool
 
 	GenerateKey(username, kafkaTopic, groupId string, partition int) string
 
+	// ExpiredOffsets the offsets last committed before the deadline
+	ExpiredOffsets(deadline time.Time) []OffsetCommit
+
+	// GroupOffsets the offsets committed by the group
+	GroupOffsets(username, groupId string) []OffsetCommit
+
 	Close()
 }
+
+type OffsetCommit struct {
+	Username   string
+	KafkaTopic string
+	GroupId    string
+	Partition  int
+	Pair       MessageIdPair
+}
+
+type OffsetStoreType int
+
+const (
+	// OffsetStorePulsar store offset in a compacted pulsar topic
+	OffsetStorePulsar OffsetStoreType = 0 + iota
+	// OffsetStoreMemory store offset in memory, offsets are lost when the broker restart
+	OffsetStoreMemory
+)
@@ -41,6 +41,7 @@ type OffsetManagerImpl struct {
 	offsetTopic    string
 	pulsarHttpAddr string
 	startFlag      bool
+	commitTimes    *offsetCommitTimes
 }
 
 func NewOffsetManager(client pulsar.Client, config KafsarConfig, pulsarHttpAddr string) (OffsetManager, error) {
@@ -60,26 +61,33 @@ func NewOffsetManager(client pulsar.Client, config KafsarConfig, pulsarHttpAddr
 		offsetTopic:    getOffsetTopic(config),
 		pulsarHttpAddr: pulsarHttpAddr,
 		offsetMap:      make(map[string]MessageIdPair),
+		commitTimes:    newOffsetCommitTimes(),
 	}
 	return &impl, nil
 }
 
-func (o *OffsetManagerImpl) Start() chan bool {
-	offsetChannel := make(chan bool)
-	o.startOffsetConsumer(offsetChannel)
-	return offsetChannel
+func (o *OffsetManagerImpl) Start() (chan bool, chan error) {
+	offsetChannel := make(chan bool, 1)
+	errChannel := make(chan error, 1)
+	o.startOffsetConsumer(offsetChannel, errChannel)
+	return offsetChannel, errChannel
 }
 
-func (o *OffsetManagerImpl) startOffsetConsumer(c chan bool) {
+func (o *OffsetManagerImpl) startOffsetConsumer(c chan bool, errChannel chan error) {
 	go func() {
 		var msg pulsar.Message
-		for {
-			var err error
+		var err error
+		for i := 0; i < constant.OffsetManagerStartMaxRetries; i++ {
 			msg, err = o.getCurrentLatestMsg()
-			if err != nil {
-				continue
+			if err == nil {
+				break
 			}
-			break
+			time.Sleep(constant.OffsetManagerStartRetryInterval)
+		}
+		if err != nil {
+			logrus.Errorf("start offset manager failed. topic: %s, err: %s", o.offsetTopic, err)
+			errChannel <- err
+			return
 		}
 		if msg == nil {
 			o.startFlag = true
@@ -97,6 +105,7 @@ func (o *OffsetManagerImpl) startOffsetConsumer(c chan bool) {
 				o.mutex.Lock()
 				delete(o.offsetMap, receive.Key())
 				o.mutex.Unlock()
+				o.commitTimes.remove(receive.Key())
 				continue
 			}
 			var msgIdData model.MessageIdData
@@ -116,10 +125,22 @@ func (o *OffsetManagerImpl) startOffsetConsumer(c chan bool) {
 			pair := MessageIdPair{
 				MessageId: msgId,
 				Offset:    msgIdData.Offset,
+				Metadata:  msgIdData.Metadata,
 			}
 			o.mutex.Lock()
 			o.offsetMap[receive.Key()] = pair
 			o.mutex.Unlock()
+			// offsets committed by old versions do not tell the group, they never expire
+			if msgIdData.GroupId != "" {
+				commit := OffsetCommit{
+					Username:   msgIdData.Username,
+					KafkaTopic: msgIdData.KafkaTopic,
+					GroupId:    msgIdData.GroupId,
+					Partition:  msgIdData.Partition,
+					Pair:       pair,
+				}
+				o.commitTimes.record(receive.Key(), commit, publishTime)
+			}
 			o.checkTime(msg, publishTime, c)
 		}
 	}()
@@ -148,25 +169,59 @@ func (o *OffsetManagerImpl) checkTime(lastMsg pulsar.Message, currentTime time.T
 }
 
 func (o *OffsetManagerImpl) CommitOffset(username, kafkaTopic, groupId string, partition int, pair MessageIdPair) error {
-	key := o.GenerateKey(username, kafkaTopic, groupId, partition)
-	data := model.MessageIdData{}
-	data.MessageId = pair.MessageId.Serialize()
-	data.Offset = pair.Offset
-	marshal, err := json.Marshal(data)
-	if err != nil {
-		logrus.Errorf("convert msg to bytes failed. kafkaTopic: %s, err: %s", kafkaTopic, err)
-		return err
+	return o.CommitOffsets([]OffsetCommit{{
+		Username:   username,
+		KafkaTopic: kafkaTopic,
+		GroupId:    groupId,
+		Partition:  partition,
+		Pair:       pair,
+	}})
+}
+
+func (o *OffsetManagerImpl) CommitOffsets(batch []OffsetCommit) error {
+	messages := make([]*pulsar.ProducerMessage, len(batch))
+	for i, commit := range batch {
+		data := model.MessageIdData{}
+		data.MessageId = commit.Pair.MessageId.Serialize()
+		data.Offset = commit.Pair.Offset
+		data.Username = commit.Username
+		data.KafkaTopic = commit.KafkaTopic
+		data.GroupId = commit.GroupId
+		data.Partition = commit.Partition
+		data.Metadata = commit.Pair.Metadata
+		marshal, err := json.Marshal(data)
+		if err != nil {
+			logrus.Errorf("convert msg to bytes failed. kafkaTopic: %s, err: %s", commit.KafkaTopic, err)
+			return err
+		}
+		messages[i] = &pulsar.ProducerMessage{
+			Payload: marshal,
+			Key:     o.GenerateKey(commit.Username, commit.KafkaTopic, commit.GroupId, commit.Partition),
+		}
 	}
-	message := pulsar.ProducerMessage{}
-	message.Payload = marshal
-	message.Key = key
-	_, err = o.producer.Send(context.TODO(), &message)
-	if err != nil {
-		logrus.Errorf("commit offset failed. kafkaTopic: %s, offset: %d, err: %s", kafkaTopic, pair.Offset, err)
-		return err
+	var sendErr error
+	var errMutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(len(messages))
+	for i := range messages {
+		commit := batch[i]
+		o.producer.SendAsync(context.TODO(), messages[i], func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
+			defer waitGroup.Done()
+			if err != nil {
+				logrus.Errorf("commit offset failed. kafkaTopic: %s, offset: %d, err: %s", commit.KafkaTopic, commit.Pair.Offset, err)
+				errMutex.Lock()
+				sendErr = err
+				errMutex.Unlock()
+				return
+			}
+			logrus.Infof("kafkaTopic: %s commit offset %d success", commit.KafkaTopic, commit.Pair.Offset)
+		})
+	}
+	if err := o.producer.Flush(); err != nil {
+		logrus.Errorf("flush offset producer failed. err: %s", err)
 	}
-	logrus.Infof("kafkaTopic: %s commit offset %d success", kafkaTopic, pair.Offset)
-	return nil
+	waitGroup.Wait()
+	return sendErr
 }
 
 func (o *OffsetManagerImpl) AcquireOffset(username, kafkaTopic, groupId string, partition int) (MessageIdPair, bool) {
@@ -174,6 +229,9 @@ func (o *OffsetManagerImpl) AcquireOffset(username, kafkaTopic, groupId string,
 	o.mutex.RLock()
 	pair, exist := o.offsetMap[key]
 	o.mutex.RUnlock()
+	if exist && o.commitTimes.expired(key, time.Now()) {
+		return MessageIdPair{}, false
+	}
 	return pair, exist
 }
 
@@ -187,16 +245,37 @@ func (o *OffsetManagerImpl) RemoveOffset(username, kafkaTopic, groupId string, p
 		logrus.Errorf("send msg failed. kafkaTopic: %s, err: %s", kafkaTopic, err)
 		return false
 	}
+	// do not wait for the consumer to receive the tombstone, acquire must not return the removed offset
+	o.mutex.Lock()
+	delete(o.offsetMap, key)
+	o.mutex.Unlock()
+	o.commitTimes.remove(key)
 	logrus.Infof("kafkaTopic: %s remove offset success", kafkaTopic)
 	return true
 }
 
+func (o *OffsetManagerImpl) ExpiredOffsets(deadline time.Time) []OffsetCommit {
+	return o.commitTimes.committedBefore(deadline)
+}
+
+func (o *OffsetManagerImpl) setRetention(retention time.Duration, inUse func(username, groupId string) bool) {
+	o.commitTimes.setRetention(retention, inUse)
+}
+
+func (o *OffsetManagerImpl) GroupOffsets(username, groupId string) []OffsetCommit {
+	return o.commitTimes.committedBy(username, groupId)
+}
+
 func (o *OffsetManagerImpl) Close() {
 	o.producer.Close()
 	o.consumer.Close()
 }
 
 func (o *OffsetManagerImpl) GenerateKey(username, kafkaTopic, groupId string, partition int) string {
+	return generateOffsetKey(username, kafkaTopic, groupId, partition)
+}
+
+func generateOffsetKey(username, kafkaTopic, groupId string, partition int) string {
 	return username + kafkaTopic + groupId + strconv.Itoa(partition)
 }
 
@@ -52,7 +52,7 @@ func TestReadNewOffset(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	offsetChannel := manager.Start()
+	offsetChannel, _ := manager.Start()
 	for {
 		if <-offsetChannel {
 			break
@@ -152,7 +152,7 @@ func TestReadOldOffset(t *testing.T) {
 	assert.Nil(t, err)
 	logrus.Infof("send offset msg to pulsar %s", msgId)
 
-	offsetChannel := manager.Start()
+	offsetChannel, _ := manager.Start()
 	for {
 		if <-offsetChannel {
 			break
@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"sync"
+	"time"
+)
+
+type OffsetManagerMemory struct {
+	offsetMap   map[string]MessageIdPair
+	mutex       sync.RWMutex
+	commitTimes *offsetCommitTimes
+}
+
+func NewOffsetManagerMemory() OffsetManager {
+	return &OffsetManagerMemory{offsetMap: make(map[string]MessageIdPair), commitTimes: newOffsetCommitTimes()}
+}
+
+func (o *OffsetManagerMemory) Start() (chan bool, chan error) {
+	offsetChannel := make(chan bool, 1)
+	offsetChannel <- true
+	return offsetChannel, make(chan error, 1)
+}
+
+func (o *OffsetManagerMemory) CommitOffset(username, kafkaTopic, groupId string, partition int, pair MessageIdPair) error {
+	key := o.GenerateKey(username, kafkaTopic, groupId, partition)
+	o.mutex.Lock()
+	o.offsetMap[key] = pair
+	o.mutex.Unlock()
+	commit := OffsetCommit{Username: username, KafkaTopic: kafkaTopic, GroupId: groupId, Partition: partition, Pair: pair}
+	o.commitTimes.record(key, commit, time.Now())
+	return nil
+}
+
+func (o *OffsetManagerMemory) CommitOffsets(batch []OffsetCommit) error {
+	for _, commit := range batch {
+		_ = o.CommitOffset(commit.Username, commit.KafkaTopic, commit.GroupId, commit.Partition, commit.Pair)
+	}
+	return nil
+}
+
+func (o *OffsetManagerMemory) AcquireOffset(username, kafkaTopic, groupId string, partition int) (MessageIdPair, bool) {
+	key := o.GenerateKey(username, kafkaTopic, groupId, partition)
+	o.mutex.RLock()
+	pair, exist := o.offsetMap[key]
+	o.mutex.RUnlock()
+	if exist && o.commitTimes.expired(key, time.Now()) {
+		return MessageIdPair{}, false
+	}
+	return pair, exist
+}
+
+func (o *OffsetManagerMemory) RemoveOffset(username, kafkaTopic, groupId string, partition int) bool {
+	key := o.GenerateKey(username, kafkaTopic, groupId, partition)
+	o.mutex.Lock()
+	delete(o.offsetMap, key)
+	o.mutex.Unlock()
+	o.commitTimes.remove(key)
+	return true
+}
+
+func (o *OffsetManagerMemory) GenerateKey(username, kafkaTopic, groupId string, partition int) string {
+	return generateOffsetKey(username, kafkaTopic, groupId, partition)
+}
+
+func (o *OffsetManagerMemory) ExpiredOffsets(deadline time.Time) []OffsetCommit {
+	return o.commitTimes.committedBefore(deadline)
+}
+
+func (o *OffsetManagerMemory) setRetention(retention time.Duration, inUse func(username, groupId string) bool) {
+	o.commitTimes.setRetention(retention, inUse)
+}
+
+func (o *OffsetManagerMemory) GroupOffsets(username, groupId string) []OffsetCommit {
+	return o.commitTimes.committedBy(username, groupId)
+}
+
+func (o *OffsetManagerMemory) Close() {
+}
@@ -0,0 +1,54 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOffsetManagerMemory(t *testing.T) {
+	offsetManager := NewOffsetManagerMemory()
+	defer offsetManager.Close()
+	offsetChannel, _ := offsetManager.Start()
+	assert.True(t, <-offsetChannel)
+
+	_, exist := offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+
+	pair := MessageIdPair{MessageId: &testMessageID{ledgerID: 1, entryID: 2}, Offset: 120}
+	err := offsetManager.CommitOffset(username, "topic", groupId, partition, pair)
+	assert.Nil(t, err)
+	acquired, exist := offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, pair, acquired)
+
+	err = offsetManager.CommitOffsets([]OffsetCommit{
+		{Username: username, KafkaTopic: "topic", GroupId: groupId, Partition: partition, Pair: MessageIdPair{Offset: 130}},
+		{Username: username, KafkaTopic: "topic", GroupId: groupId, Partition: partition + 1, Pair: MessageIdPair{Offset: 10}},
+	})
+	assert.Nil(t, err)
+	acquired, _ = offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(130), acquired.Offset)
+	acquired, _ = offsetManager.AcquireOffset(username, "topic", groupId, partition+1)
+	assert.Equal(t, int64(10), acquired.Offset)
+
+	assert.True(t, offsetManager.RemoveOffset(username, "topic", groupId, partition))
+	_, exist = offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+}
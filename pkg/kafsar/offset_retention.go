@@ -0,0 +1,180 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"sort"
+	"sync"
+	"time"
+)
+
+type committedOffset struct {
+	commit     OffsetCommit
+	commitTime time.Time
+}
+
+// offsetCommitTimes track when the offsets were committed, so that the expired ones can be found
+type offsetCommitTimes struct {
+	mutex   sync.Mutex
+	commits map[string]committedOffset
+	// retention the offsets of groups not in use expire once the commit is older, 0 means never
+	retention time.Duration
+	inUse     func(username, groupId string) bool
+}
+
+func newOffsetCommitTimes() *offsetCommitTimes {
+	return &offsetCommitTimes{commits: make(map[string]committedOffset)}
+}
+
+func (o *offsetCommitTimes) record(key string, commit OffsetCommit, commitTime time.Time) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.commits[key] = committedOffset{commit: commit, commitTime: commitTime}
+}
+
+func (o *offsetCommitTimes) setRetention(retention time.Duration, inUse func(username, groupId string) bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.retention = retention
+	o.inUse = inUse
+}
+
+// expired whether the offset is past the retention, it is not committed any more though the retention check has not
+// removed it yet
+func (o *offsetCommitTimes) expired(key string, now time.Time) bool {
+	o.mutex.Lock()
+	committed, exist := o.commits[key]
+	retention, inUse := o.retention, o.inUse
+	o.mutex.Unlock()
+	if !exist || retention <= 0 || !committed.commitTime.Before(now.Add(-retention)) {
+		return false
+	}
+	return inUse == nil || !inUse(committed.commit.Username, committed.commit.GroupId)
+}
+
+func (o *offsetCommitTimes) remove(key string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.commits, key)
+}
+
+// committedBefore the offsets last committed before the deadline
+func (o *offsetCommitTimes) committedBefore(deadline time.Time) []OffsetCommit {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	commits := make([]OffsetCommit, 0)
+	for _, committed := range o.commits {
+		if committed.commitTime.Before(deadline) {
+			commits = append(commits, committed.commit)
+		}
+	}
+	return commits
+}
+
+// committedBy the offsets committed by the group, in topic and partition order
+func (o *offsetCommitTimes) committedBy(username, groupId string) []OffsetCommit {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	commits := make([]OffsetCommit, 0)
+	for _, committed := range o.commits {
+		if committed.commit.Username == username && committed.commit.GroupId == groupId {
+			commits = append(commits, committed.commit)
+		}
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		if commits[i].KafkaTopic != commits[j].KafkaTopic {
+			return commits[i].KafkaTopic < commits[j].KafkaTopic
+		}
+		return commits[i].Partition < commits[j].Partition
+	})
+	return commits
+}
+
+// offsetRetention an offset manager whose AcquireOffset honors the retention
+type offsetRetention interface {
+	setRetention(retention time.Duration, inUse func(username, groupId string) bool)
+}
+
+// offsetRetentionEnabled the offsets expire only if the groups without members are known, which the cluster
+// coordinator does not tell
+func (b *Broker) offsetRetentionEnabled() bool {
+	if b.kafsarConfig.OffsetRetentionMs <= 0 {
+		return false
+	}
+	_, standalone := b.groupCoordinator.(*GroupCoordinatorStandalone)
+	return standalone
+}
+
+// applyOffsetRetention let the offset manager treat the expired offsets as not committed before they are removed
+func (b *Broker) applyOffsetRetention() {
+	if !b.offsetRetentionEnabled() {
+		if b.kafsarConfig.OffsetRetentionMs > 0 {
+			b.logger.Warnf("offset retention needs the standalone group coordinator, offsets never expire")
+		}
+		return
+	}
+	manager, ok := b.offsetManager.(offsetRetention)
+	if !ok {
+		return
+	}
+	manager.setRetention(time.Duration(b.kafsarConfig.OffsetRetentionMs)*time.Millisecond, b.groupInUse)
+}
+
+// groupInUse whether the group has members, its offsets never expire. groups the coordinator can not tell about are
+// in use
+func (b *Broker) groupInUse(username, groupId string) bool {
+	if _, standalone := b.groupCoordinator.(*GroupCoordinatorStandalone); !standalone {
+		return true
+	}
+	group, err := b.groupCoordinator.GetGroup(username, groupId)
+	return err == nil && len(group.memberClientIds()) > 0
+}
+
+// runOffsetRetention remove the expired offsets periodically until the broker closes
+func (b *Broker) runOffsetRetention() {
+	interval := constant.DefaultOffsetRetentionCheckInterval
+	if b.kafsarConfig.OffsetRetentionCheckIntervalMs > 0 {
+		interval = time.Duration(b.kafsarConfig.OffsetRetentionCheckIntervalMs) * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stopCh:
+			return
+		case now := <-ticker.C:
+			b.expireOffsets(now)
+		}
+	}
+}
+
+// expireOffsets remove the offsets committed before the retention period by groups without members
+func (b *Broker) expireOffsets(now time.Time) {
+	deadline := now.Add(-time.Duration(b.kafsarConfig.OffsetRetentionMs) * time.Millisecond)
+	for _, commit := range b.offsetManager.ExpiredOffsets(deadline) {
+		if b.groupInUse(commit.Username, commit.GroupId) {
+			continue
+		}
+		if !b.offsetManager.RemoveOffset(commit.Username, commit.KafkaTopic, commit.GroupId, commit.Partition) {
+			b.logger.Errorf("expire offset of group %s failed. topic: %s, partition: %d", commit.GroupId, commit.KafkaTopic, commit.Partition)
+			continue
+		}
+		b.logger.Infof("expire offset of group %s. topic: %s, partition: %d", commit.GroupId, commit.KafkaTopic, commit.Partition)
+	}
+}
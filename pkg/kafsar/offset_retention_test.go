@@ -0,0 +1,143 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOffsetCommitTimes(t *testing.T) {
+	commitTimes := newOffsetCommitTimes()
+	now := time.Now()
+	commitTimes.record("old", OffsetCommit{GroupId: "old"}, now.Add(-time.Hour))
+	commitTimes.record("new", OffsetCommit{GroupId: "new"}, now)
+	assert.Equal(t, []OffsetCommit{{GroupId: "old"}}, commitTimes.committedBefore(now.Add(-time.Minute)))
+
+	// a new commit renews the offset
+	commitTimes.record("old", OffsetCommit{GroupId: "old"}, now)
+	assert.Empty(t, commitTimes.committedBefore(now.Add(-time.Minute)))
+
+	commitTimes.remove("new")
+	assert.Equal(t, 1, len(commitTimes.committedBefore(now.Add(time.Minute))))
+}
+
+func TestExpireOffsets(t *testing.T) {
+	config := kafsarConfig
+	config.OffsetRetentionMs = 60000
+	k := newTestBroker(config)
+	k.offsetManager = NewOffsetManagerMemory()
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	resp, err := groupCoordinator.HandleJoinGroup(username, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := MessageIdPair{MessageId: &testMessageID{ledgerID: 1}, Offset: 10}
+	for _, group := range []string{groupId, "inactive-group"} {
+		if err := k.offsetManager.CommitOffset(username, "topic", group, partition, pair); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// not expired yet
+	k.expireOffsets(time.Now())
+	_, exist := k.offsetManager.AcquireOffset(username, "topic", "inactive-group", partition)
+	assert.True(t, exist)
+
+	expiredTime := time.Now().Add(2 * time.Minute)
+	k.expireOffsets(expiredTime)
+	_, exist = k.offsetManager.AcquireOffset(username, "topic", "inactive-group", partition)
+	assert.False(t, exist)
+	// the group still has members
+	_, exist = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+
+	_, err = groupCoordinator.HandleLeaveGroup(username, groupId, []*codec.LeaveGroupMember{{MemberId: resp.MemberId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.expireOffsets(expiredTime)
+	_, exist = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.False(t, exist)
+}
+
+func TestAcquireExpiredOffset(t *testing.T) {
+	config := kafsarConfig
+	config.OffsetRetentionMs = 60000
+	k := newTestBroker(config)
+	groupCoordinator := k.groupCoordinator.(*GroupCoordinatorStandalone)
+	_, err := groupCoordinator.HandleJoinGroup(username, groupId, "", clientId, protocolType, sessionTimeoutMs, protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := MessageIdPair{MessageId: &testMessageID{ledgerID: 1}, Offset: 10}
+	expiredTime := time.Now().Add(-2 * time.Minute)
+	memory := NewOffsetManagerMemory().(*OffsetManagerMemory)
+	impl := &OffsetManagerImpl{offsetMap: make(map[string]MessageIdPair), commitTimes: newOffsetCommitTimes()}
+	managers := []struct {
+		manager     OffsetManager
+		offsetMap   map[string]MessageIdPair
+		commitTimes *offsetCommitTimes
+	}{
+		{manager: memory, offsetMap: memory.offsetMap, commitTimes: memory.commitTimes},
+		{manager: impl, offsetMap: impl.offsetMap, commitTimes: impl.commitTimes},
+	}
+	for _, m := range managers {
+		k.offsetManager = m.manager
+		k.applyOffsetRetention()
+		for _, group := range []string{groupId, "inactive-group"} {
+			key := m.manager.GenerateKey(username, "topic", group, partition)
+			m.offsetMap[key] = pair
+			commit := OffsetCommit{Username: username, KafkaTopic: "topic", GroupId: group, Partition: partition, Pair: pair}
+			m.commitTimes.record(key, commit, expiredTime)
+		}
+		// expired but not removed yet
+		_, exist := m.manager.AcquireOffset(username, "topic", "inactive-group", partition)
+		assert.False(t, exist)
+		// the group still has members
+		committed, exist := m.manager.AcquireOffset(username, "topic", groupId, partition)
+		assert.True(t, exist)
+		assert.Equal(t, pair.Offset, committed.Offset)
+	}
+}
+
+func TestOffsetRetentionClusterCoordinator(t *testing.T) {
+	config := kafsarConfig
+	config.OffsetRetentionMs = 60000
+	config.GroupCoordinatorType = Cluster
+	k := newTestBroker(config)
+	k.groupCoordinator = NewGroupCoordinatorCluster(k.clusterNodes())
+	memory := NewOffsetManagerMemory().(*OffsetManagerMemory)
+	k.offsetManager = memory
+	k.applyOffsetRetention()
+	assert.False(t, k.offsetRetentionEnabled())
+
+	pair := MessageIdPair{MessageId: &testMessageID{ledgerID: 1}, Offset: 10}
+	key := memory.GenerateKey(username, "topic", groupId, partition)
+	memory.offsetMap[key] = pair
+	memory.commitTimes.record(key, OffsetCommit{Username: username, KafkaTopic: "topic", GroupId: groupId, Partition: partition, Pair: pair},
+		time.Now().Add(-2*time.Minute))
+	// the cluster coordinator does not tell the members, the offsets do not expire
+	_, exist := memory.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	k.expireOffsets(time.Now())
+	_, exist = memory.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+}
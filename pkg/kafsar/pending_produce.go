@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import "sync"
+
+// pendingProduce count the messages sent to pulsar but not acknowledged yet per connection, so that a slow pulsar
+// broker can not make the broker buffer produced batches without bound
+type pendingProduce struct {
+	mutex   sync.Mutex
+	pending map[string]int
+}
+
+func newPendingProduce() *pendingProduce {
+	return &pendingProduce{pending: make(map[string]int)}
+}
+
+// reserve return false if sending count more messages exceeds the limit. a batch larger than the limit is still
+// accepted when nothing is pending, otherwise it could never be sent. limit 0 means no limit
+func (p *pendingProduce) reserve(conn string, count, limit int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pending := p.pending[conn]
+	if limit > 0 && pending > 0 && pending+count > limit {
+		return false
+	}
+	p.pending[conn] = pending + count
+	return true
+}
+
+func (p *pendingProduce) release(conn string, count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pending := p.pending[conn] - count
+	if pending <= 0 {
+		delete(p.pending, conn)
+		return
+	}
+	p.pending[conn] = pending
+}
+
+func (p *pendingProduce) count(conn string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.pending[conn]
+}
@@ -0,0 +1,42 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPendingProduce(t *testing.T) {
+	p := newPendingProduce()
+	// a batch larger than the limit is accepted when nothing pending
+	assert.True(t, p.reserve("conn", 5, 4))
+	assert.False(t, p.reserve("conn", 1, 4))
+	// other connections have their own limit
+	assert.True(t, p.reserve("other", 4, 4))
+	p.release("conn", 3)
+	assert.Equal(t, 2, p.count("conn"))
+	assert.True(t, p.reserve("conn", 2, 4))
+	assert.False(t, p.reserve("conn", 1, 4))
+	p.release("conn", 4)
+	assert.Equal(t, 0, p.count("conn"))
+	assert.Empty(t, p.pending["conn"])
+	// no limit
+	assert.True(t, p.reserve("conn", 100, 0))
+	assert.True(t, p.reserve("conn", 100, 0))
+}
@@ -0,0 +1,213 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"math"
+	"sync"
+	"time"
+)
+
+type InitProducerIdReq struct {
+	TransactionalId      *string
+	TransactionTimeoutMs int
+	// ProducerId and ProducerEpoch are set by the producer to bump its epoch, -1 if it is a new producer
+	ProducerId    int64
+	ProducerEpoch int16
+}
+
+type InitProducerIdResp struct {
+	ErrorCode     codec.ErrorCode
+	ProducerId    int64
+	ProducerEpoch int16
+}
+
+type producerPartitionKey struct {
+	producerId       int64
+	partitionedTopic string
+}
+
+type producerSequence struct {
+	epoch        int16
+	lastSequence int32
+	lastOffset   int64
+}
+
+// sequenceReservation the sequences of the batches being sent, the retries of them must wait for the result
+type sequenceReservation struct {
+	epoch        int16
+	lastSequence int32
+	batches      int
+}
+
+type producerEntry struct {
+	epoch      int16
+	lastUpdate time.Time
+}
+
+// producerStateManager assign idempotent producer ids and track the last sequence per producer and partition
+type producerStateManager struct {
+	mutex          sync.Mutex
+	nextProducerId int64
+	producers      map[int64]*producerEntry
+	sequences      map[producerPartitionKey]*producerSequence
+	reservations   map[producerPartitionKey]*sequenceReservation
+}
+
+func newProducerStateManager() *producerStateManager {
+	return &producerStateManager{
+		// avoid reuse producer id after broker restart
+		nextProducerId: time.Now().UnixMilli(),
+		producers:      make(map[int64]*producerEntry),
+		sequences:      make(map[producerPartitionKey]*producerSequence),
+		reservations:   make(map[producerPartitionKey]*sequenceReservation),
+	}
+}
+
+// initProducer assign a new producer id, or bump the epoch of an existing producer
+func (p *producerStateManager) initProducer(producerId int64, producerEpoch int16) (int64, int16, codec.ErrorCode) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expireProducers(time.Now())
+	if producerId >= 0 {
+		entry, exist := p.producers[producerId]
+		if exist {
+			if entry.epoch != producerEpoch {
+				return constant.NoProducerId, constant.NoProducerEpoch, codec.INVALID_PRODUCER_EPOCH
+			}
+			if entry.epoch < math.MaxInt16 {
+				entry.epoch++
+				entry.lastUpdate = time.Now()
+				return producerId, entry.epoch, codec.NONE
+			}
+			// epoch exhausted, assign a new producer id
+		}
+	}
+	producerId = p.nextProducerId
+	p.nextProducerId++
+	p.producers[producerId] = &producerEntry{epoch: 0, lastUpdate: time.Now()}
+	return producerId, 0, codec.NONE
+}
+
+// reserveSequence reserve the sequences of the batch before it is sent, return whether the batch is a duplicate and
+// the offset of it, or an error code. a retry of a batch still being sent is answered REQUEST_TIMED_OUT, the client
+// retries it again after the send completed or failed
+func (p *producerStateManager) reserveSequence(producerId int64, producerEpoch int16, partitionedTopic string, baseSequence, lastSequence int32) (bool, int64, codec.ErrorCode) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, exist := p.producers[producerId]
+	if !exist {
+		return false, 0, codec.UNKNOWN_PRODUCER_ID
+	}
+	if producerEpoch < entry.epoch {
+		return false, 0, codec.INVALID_PRODUCER_EPOCH
+	}
+	key := producerPartitionKey{producerId: producerId, partitionedTopic: partitionedTopic}
+	sequence, exist := p.sequences[key]
+	if exist && producerEpoch == sequence.epoch && baseSequence <= sequence.lastSequence {
+		return true, sequence.lastOffset, codec.NONE
+	}
+	reservation, reserved := p.reservations[key]
+	reserved = reserved && reservation.epoch == producerEpoch
+	if reserved {
+		if baseSequence <= reservation.lastSequence {
+			return false, 0, codec.REQUEST_TIMED_OUT
+		}
+		if baseSequence != reservation.lastSequence+1 {
+			return false, 0, codec.OUT_OF_ORDER_SEQUENCE_NUMBER
+		}
+	} else if exist && producerEpoch == sequence.epoch && baseSequence != sequence.lastSequence+1 {
+		return false, 0, codec.OUT_OF_ORDER_SEQUENCE_NUMBER
+	}
+	if !reserved {
+		// first batch of the producer epoch being sent on this partition
+		reservation = &sequenceReservation{epoch: producerEpoch}
+		p.reservations[key] = reservation
+	}
+	reservation.lastSequence = lastSequence
+	reservation.batches++
+	return false, 0, codec.NONE
+}
+
+// completeSequence the reserved batch is sent, lastOffset is the offset of it
+func (p *producerStateManager) completeSequence(producerId int64, producerEpoch int16, partitionedTopic string, lastSequence int32, lastOffset int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	key := producerPartitionKey{producerId: producerId, partitionedTopic: partitionedTopic}
+	p.unreserve(key, producerEpoch, nil)
+	entry, exist := p.producers[producerId]
+	if !exist {
+		return
+	}
+	entry.lastUpdate = time.Now()
+	sequence, exist := p.sequences[key]
+	if exist && sequence.epoch == producerEpoch && sequence.lastSequence > lastSequence {
+		// a batch sent after the failure of this batch completed earlier
+		return
+	}
+	p.sequences[key] = &producerSequence{
+		epoch:        producerEpoch,
+		lastSequence: lastSequence,
+		lastOffset:   lastOffset,
+	}
+}
+
+// releaseSequence the reserved batch failed, so that its retry is accepted
+func (p *producerStateManager) releaseSequence(producerId int64, producerEpoch int16, partitionedTopic string, baseSequence int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.unreserve(producerPartitionKey{producerId: producerId, partitionedTopic: partitionedTopic}, producerEpoch, &baseSequence)
+}
+
+// unreserve caller must hold the mutex. a failed batch roll the reservation back to the sequence before it
+func (p *producerStateManager) unreserve(key producerPartitionKey, producerEpoch int16, failedSequence *int32) {
+	reservation, exist := p.reservations[key]
+	if !exist || reservation.epoch != producerEpoch {
+		return
+	}
+	reservation.batches--
+	if reservation.batches <= 0 {
+		delete(p.reservations, key)
+		return
+	}
+	if failedSequence != nil && *failedSequence <= reservation.lastSequence {
+		reservation.lastSequence = *failedSequence - 1
+	}
+}
+
+// expireProducers caller must hold the mutex
+func (p *producerStateManager) expireProducers(now time.Time) {
+	for producerId, entry := range p.producers {
+		if now.Sub(entry.lastUpdate) < constant.ProducerIdExpiration {
+			continue
+		}
+		delete(p.producers, producerId)
+		for key := range p.sequences {
+			if key.producerId == producerId {
+				delete(p.sequences, key)
+			}
+		}
+		for key := range p.reservations {
+			if key.producerId == producerId {
+				delete(p.reservations, key)
+			}
+		}
+	}
+}
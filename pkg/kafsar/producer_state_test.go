@@ -0,0 +1,108 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestInitProducer(t *testing.T) {
+	manager := newProducerStateManager()
+	producerId, epoch, errorCode := manager.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.Equal(t, int16(0), epoch)
+	otherProducerId, _, _ := manager.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	assert.NotEqual(t, producerId, otherProducerId)
+
+	// bump epoch
+	bumpedProducerId, epoch, errorCode := manager.initProducer(producerId, 0)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.Equal(t, producerId, bumpedProducerId)
+	assert.Equal(t, int16(1), epoch)
+
+	_, _, errorCode = manager.initProducer(producerId, 0)
+	assert.Equal(t, codec.INVALID_PRODUCER_EPOCH, errorCode)
+}
+
+func TestReserveSequence(t *testing.T) {
+	manager := newProducerStateManager()
+	topic := "persistent://public/default/topic-partition-0"
+	_, _, errorCode := manager.reserveSequence(1, 0, topic, 0, 4)
+	assert.Equal(t, codec.UNKNOWN_PRODUCER_ID, errorCode)
+
+	producerId, epoch, _ := manager.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	duplicate, _, errorCode := manager.reserveSequence(producerId, epoch, topic, 0, 4)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.False(t, duplicate)
+	manager.completeSequence(producerId, epoch, topic, 4, 100)
+
+	duplicate, offset, errorCode := manager.reserveSequence(producerId, epoch, topic, 0, 4)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.True(t, duplicate)
+	assert.Equal(t, int64(100), offset)
+
+	_, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 7, 9)
+	assert.Equal(t, codec.OUT_OF_ORDER_SEQUENCE_NUMBER, errorCode)
+
+	duplicate, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 5, 9)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.False(t, duplicate)
+	manager.completeSequence(producerId, epoch, topic, 9, 200)
+
+	// new epoch restart the sequence, old epoch is fenced
+	_, newEpoch, _ := manager.initProducer(producerId, epoch)
+	duplicate, _, errorCode = manager.reserveSequence(producerId, newEpoch, topic, 0, 4)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.False(t, duplicate)
+	_, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 10, 14)
+	assert.Equal(t, codec.INVALID_PRODUCER_EPOCH, errorCode)
+}
+
+func TestReserveSequenceInFlight(t *testing.T) {
+	manager := newProducerStateManager()
+	topic := "persistent://public/default/topic-partition-0"
+	producerId, epoch, _ := manager.initProducer(constant.NoProducerId, constant.NoProducerEpoch)
+	_, _, errorCode := manager.reserveSequence(producerId, epoch, topic, 0, 4)
+	assert.Equal(t, codec.NONE, errorCode)
+
+	// the retry of the batch being sent must not be sent again
+	_, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 0, 4)
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, errorCode)
+	// the next batch is pipelined
+	_, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 5, 9)
+	assert.Equal(t, codec.NONE, errorCode)
+	_, _, errorCode = manager.reserveSequence(producerId, epoch, topic, 12, 14)
+	assert.Equal(t, codec.OUT_OF_ORDER_SEQUENCE_NUMBER, errorCode)
+
+	// the failed batch is retried
+	manager.releaseSequence(producerId, epoch, topic, 0)
+	duplicate, _, errorCode := manager.reserveSequence(producerId, epoch, topic, 0, 4)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.False(t, duplicate)
+
+	manager.completeSequence(producerId, epoch, topic, 9, 200)
+	manager.completeSequence(producerId, epoch, topic, 4, 100)
+	assert.Empty(t, manager.reservations)
+	duplicate, offset, errorCode := manager.reserveSequence(producerId, epoch, topic, 5, 9)
+	assert.Equal(t, codec.NONE, errorCode)
+	assert.True(t, duplicate)
+	assert.Equal(t, int64(200), offset)
+}
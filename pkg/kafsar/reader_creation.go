@@ -0,0 +1,101 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"strings"
+	"time"
+)
+
+// fatalCreationResults the reader creation fails the same way however many times it is retried
+var fatalCreationResults = []pulsar.Result{
+	pulsar.TopicNotFound,
+	pulsar.AuthenticationError,
+	pulsar.AuthorizationError,
+	pulsar.InvalidConfiguration,
+	pulsar.InvalidTopicName,
+	pulsar.InvalidURL,
+}
+
+// isTopicNotFound the errors returned by the pulsar broker are not typed, match the server error name as well
+func isTopicNotFound(err error) bool {
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) {
+		return pulsarErr.Result() == pulsar.TopicNotFound
+	}
+	return strings.Contains(err.Error(), "TopicNotFound")
+}
+
+// isRetryableCreation whether the creation may succeed later, e.g. the topic bundle is being transferred
+func isRetryableCreation(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return false
+	}
+	var pulsarErr *pulsar.Error
+	if errors.As(err, &pulsarErr) {
+		for _, result := range fatalCreationResults {
+			if pulsarErr.Result() == result {
+				return false
+			}
+		}
+		return true
+	}
+	for _, name := range []string{"TopicNotFound", "AuthenticationError", "AuthorizationError", "InvalidTopicName"} {
+		if strings.Contains(err.Error(), name) {
+			return false
+		}
+	}
+	return true
+}
+
+// readerErrorCode the error code responded when the reader creation failed
+func readerErrorCode(err error) codec.ErrorCode {
+	if isTopicNotFound(err) {
+		return codec.UNKNOWN_TOPIC_OR_PARTITION
+	}
+	// pulsar is unavailable or the topic is moving between bundles, the client refreshes metadata and retries
+	return codec.LEADER_NOT_AVAILABLE
+}
+
+// retryReaderCreation retry the retryable failures of create with exponential backoff, return the last error
+func (b *Broker) retryReaderCreation(partitionedTopic string, create func() error) error {
+	maxRetries := b.kafsarConfig.ReaderCreateMaxRetries
+	if maxRetries == 0 {
+		maxRetries = constant.DefaultReaderCreateMaxRetries
+	}
+	backoff := constant.DefaultReaderCreateRetryBackoff
+	if b.kafsarConfig.ReaderCreateRetryBackoffMs > 0 {
+		backoff = time.Duration(b.kafsarConfig.ReaderCreateRetryBackoffMs) * time.Millisecond
+	}
+	err := create()
+	for i := 0; i < maxRetries && err != nil && isRetryableCreation(err); i++ {
+		b.logger.Warnf("create reader failed, retry after %s. topic: %s, err: %s", backoff, partitionedTopic, err)
+		select {
+		case <-b.stopCh:
+			return err
+		case <-time.After(backoff):
+		}
+		err = create()
+		backoff *= 2
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyReaderClient fail the first failures reader creations with err
+type flakyReaderClient struct {
+	pulsar.Client
+	failures  int
+	err       error
+	creations int
+}
+
+func (c *flakyReaderClient) CreateReader(options pulsar.ReaderOptions) (pulsar.Reader, error) {
+	c.creations++
+	if c.creations <= c.failures {
+		return nil, c.err
+	}
+	return &testReader{}, nil
+}
+
+func newFlakyReaderBroker(t *testing.T, client *flakyReaderClient) (*Broker, string) {
+	config := kafsarConfig
+	config.ReaderCreateMaxRetries = 2
+	config.ReaderCreateRetryBackoffMs = 1
+	k := newTestBroker(config)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarClientManage = map[string]pulsar.Client{
+		readerKey(username, partitionedTopic, clientId): client,
+	}
+	return k, partitionedTopic
+}
+
+func TestCreateReaderRetry(t *testing.T) {
+	client := &flakyReaderClient{failures: 2, err: errors.New("server error: ServiceNotReady: bundle is being unloaded")}
+	k, partitionedTopic := newFlakyReaderBroker(t, client)
+	err := k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, pulsar.EarliestMessageID(), constant.UnknownOffset, clientId)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, client.creations)
+	_, exist := k.readerManager[readerKey(username, partitionedTopic, clientId)]
+	assert.True(t, exist)
+}
+
+func TestCreateReaderRetryExhausted(t *testing.T) {
+	client := &flakyReaderClient{failures: 3, err: errors.New("server error: ServiceNotReady: bundle is being unloaded")}
+	k, partitionedTopic := newFlakyReaderBroker(t, client)
+	err := k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, pulsar.EarliestMessageID(), constant.UnknownOffset, clientId)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, client.creations)
+	assert.Equal(t, codec.LEADER_NOT_AVAILABLE, readerErrorCode(err))
+}
+
+func TestCreateReaderTopicNotFound(t *testing.T) {
+	client := &flakyReaderClient{failures: 3, err: errors.New("server error: TopicNotFound: topic does not exist")}
+	k, partitionedTopic := newFlakyReaderBroker(t, client)
+	err := k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, pulsar.EarliestMessageID(), constant.UnknownOffset, clientId)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, client.creations)
+	assert.Equal(t, codec.UNKNOWN_TOPIC_OR_PARTITION, readerErrorCode(err))
+}
+
+func TestCreateReaderNoRetry(t *testing.T) {
+	client := &flakyReaderClient{failures: 1, err: errors.New("server error: ServiceNotReady: bundle is being unloaded")}
+	k, partitionedTopic := newFlakyReaderBroker(t, client)
+	k.kafsarConfig.ReaderCreateMaxRetries = -1
+	err := k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, pulsar.EarliestMessageID(), constant.UnknownOffset, clientId)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, client.creations)
+}
+
+// blockingReaderClient block the reader creations until released, like a pulsar broker not answering
+type blockingReaderClient struct {
+	pulsar.Client
+	release   chan struct{}
+	creations int32
+}
+
+func (c *blockingReaderClient) CreateReader(options pulsar.ReaderOptions) (pulsar.Reader, error) {
+	atomic.AddInt32(&c.creations, 1)
+	<-c.release
+	return &testReader{}, nil
+}
+
+func TestCreateReaderOutsideMutex(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &blockingReaderClient{release: make(chan struct{})}
+	k.pulsarClientManage = map[string]pulsar.Client{
+		readerKey(username, partitionedTopic, clientId): client,
+	}
+	errCh := make(chan error, 2)
+	create := func() {
+		errCh <- k.createReaderMetadata(username, partitionedTopic, "subscription", groupId, pulsar.EarliestMessageID(), constant.UnknownOffset, clientId)
+	}
+	go create()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&client.creations) == 1 }, time.Second, 10*time.Millisecond)
+	go create()
+
+	// other requests on the broker are served while the reader is created
+	locked := make(chan struct{})
+	go func() {
+		k.mutex.Lock()
+		k.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("broker mutex held while creating the reader")
+	}
+
+	close(client.release)
+	assert.Nil(t, <-errCh)
+	assert.Nil(t, <-errCh)
+	// the concurrent request waited for the same creation
+	assert.Equal(t, int32(1), atomic.LoadInt32(&client.creations))
+	assert.Len(t, k.readerManager, 1)
+	assert.Empty(t, k.readerCreating)
+}
@@ -0,0 +1,35 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+)
+
+func tagSourceCluster(message *pulsar.ProducerMessage, clusterId string) {
+	if message.Properties == nil {
+		message.Properties = make(map[string]string)
+	}
+	message.Properties[constant.SourceClusterProperty] = clusterId
+}
+
+func isFromSourceCluster(message pulsar.Message, clusterId string) bool {
+	sourceCluster, exist := message.Properties()[constant.SourceClusterProperty]
+	return exist && sourceCluster == clusterId
+}
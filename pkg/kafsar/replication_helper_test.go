@@ -0,0 +1,54 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	testClusterId   = "test-cluster"
+	remoteClusterId = "remote-cluster"
+)
+
+func TestTagSourceCluster(t *testing.T) {
+	message := pulsar.ProducerMessage{Payload: []byte(testContent)}
+	tagSourceCluster(&message, testClusterId)
+	assert.Equal(t, testClusterId, message.Properties[constant.SourceClusterProperty])
+
+	message = pulsar.ProducerMessage{Properties: map[string]string{"key": "value"}}
+	tagSourceCluster(&message, testClusterId)
+	assert.Equal(t, testClusterId, message.Properties[constant.SourceClusterProperty])
+	assert.Equal(t, "value", message.Properties["key"])
+}
+
+func TestFilterSourceCluster(t *testing.T) {
+	message := pulsar.ProducerMessage{}
+	tagSourceCluster(&message, testClusterId)
+	local := &testMessage{properties: message.Properties}
+	assert.True(t, isFromSourceCluster(local, testClusterId))
+
+	remote := &testMessage{properties: map[string]string{constant.SourceClusterProperty: remoteClusterId}}
+	assert.False(t, isFromSourceCluster(remote, testClusterId))
+
+	untagged := &testMessage{}
+	assert.False(t, isFromSourceCluster(untagged, testClusterId))
+}
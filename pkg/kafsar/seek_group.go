@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	errGroupActive   = errors.New("group has active members")
+	errNothingToSeek = errors.New("group has no committed offset to reset")
+)
+
+// SeekGroupToTimestamp reset every offset committed by the group to the first message published at or after ts in
+// unix milliseconds, or the latest message if there is none. the message is committed the way OffsetCommit stores
+// the offset ListOffsets returns for the timestamp. refused while the group has members not rebalancing, their
+// commits would race with the reset
+func (b *Broker) SeekGroupToTimestamp(username, groupId string, ts int64) error {
+	group, err := b.groupCoordinator.GetGroup(username, groupId)
+	if err != nil {
+		return err
+	}
+	status := group.status()
+	if len(group.memberClientIds()) > 0 && status != PreparingRebalance && status != CompletingRebalance {
+		return errors.Wrapf(errGroupActive, "seek group %s to timestamp refused", groupId)
+	}
+	reset := 0
+	for _, commit := range b.offsetManager.GroupOffsets(username, groupId) {
+		partitionedTopic, err := b.partitionedTopic(&userInfo{username: username}, commit.KafkaTopic, commit.Partition)
+		if err != nil {
+			return errors.Wrapf(err, "get partitioned topic of %s partition %d failed", commit.KafkaTopic, commit.Partition)
+		}
+		pair, exist, err := b.messageAtTime(partitionedTopic, ts)
+		if err != nil {
+			return errors.Wrapf(err, "find message of %s at %d failed", partitionedTopic, ts)
+		}
+		if !exist {
+			b.logger.Infof("topic %s is empty, skip seek of group %s", partitionedTopic, groupId)
+			continue
+		}
+		err = b.offsetManager.CommitOffset(username, commit.KafkaTopic, groupId, commit.Partition, pair)
+		if err != nil {
+			return errors.Wrapf(err, "commit offset of %s failed", partitionedTopic)
+		}
+		reset++
+		b.logger.Infof("seek group %s to timestamp %d. topic: %s, offset: %d", groupId, ts, partitionedTopic, pair.Offset)
+	}
+	if reset == 0 {
+		return errors.Wrapf(errNothingToSeek, "seek group %s to timestamp", groupId)
+	}
+	return nil
+}
+
+// messageAtTime the first message published at or after ts found by SeekByTime, the latest message if there is none.
+// exist is false if the topic is empty
+func (b *Broker) messageAtTime(partitionedTopic string, ts int64) (pair MessageIdPair, exist bool, err error) {
+	message, err := utils.ReadMsgByTime(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, ts, b.pulsarCommonClient)
+	if err != nil {
+		return MessageIdPair{}, false, err
+	}
+	if message == nil {
+		msgIdBytes, err := utils.GetLatestMsgId(partitionedTopic, b.getPulsarHttpUrl())
+		if err != nil {
+			return MessageIdPair{}, false, err
+		}
+		message, err = utils.ReadLastedMsg(partitionedTopic, b.kafsarConfig.MaxFetchWaitMs, msgIdBytes, b.pulsarCommonClient)
+		if err != nil {
+			return MessageIdPair{}, false, err
+		}
+		if message == nil {
+			return MessageIdPair{}, false, nil
+		}
+	}
+	offset, err := convOffset(message, b.kafsarConfig.ContinuousOffset, b.kafsarConfig.OffsetOverflowUseIndex)
+	if err != nil {
+		return MessageIdPair{}, false, err
+	}
+	return MessageIdPair{MessageId: message.ID(), Offset: offset}, true, nil
+}
@@ -0,0 +1,124 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// newSeekGroupBroker a stable group of one member, which committed offset 4 of the partition
+func newSeekGroupBroker(t *testing.T) (*Broker, string, func()) {
+	config := kafsarConfig
+	config.MaxFetchRecord = 10
+	config.InitialDelayedJoinMs = 0
+	k, _, closeServer := newOffsetRangeBroker(t, config)
+	// the message of offset i is published at i seconds
+	for _, message := range k.pulsarCommonClient.(*producedMessageClient).messages {
+		testMsg := message.(*testMessage)
+		testMsg.publishTime = time.UnixMilli(int64(*testMsg.index) * 1000)
+	}
+	joinGroupResp, err := k.GroupJoin(&addr, &codec.JoinGroupReq{
+		BaseReq:        codec.BaseReq{ClientId: clientId},
+		GroupId:        groupId,
+		SessionTimeout: sessionTimeoutMs,
+		ProtocolType:   protocolType,
+		GroupProtocols: protocols,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncGroupResp, err := k.GroupSync(&addr, &codec.SyncGroupReq{
+		BaseReq:          codec.BaseReq{ClientId: clientId},
+		GroupId:          groupId,
+		GenerationId:     joinGroupResp.GenerationId,
+		MemberId:         joinGroupResp.MemberId,
+		GroupAssignments: []*codec.GroupAssignment{{MemberId: joinGroupResp.MemberId}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, codec.NONE, syncGroupResp.ErrorCode)
+	fetchResp := k.FetchPartition(&addr, "topic", clientId, &codec.FetchPartitionReq{PartitionId: partition}, maxBytes, maxBytes, 100, LocalSpan{})
+	assert.Equal(t, 5, len(fetchResp.RecordBatch.Records))
+	commitResp, err := k.OffsetCommitPartition(&addr, "topic", clientId, &codec.OffsetCommitPartitionReq{PartitionId: partition, Offset: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, commitResp.ErrorCode)
+	return k, joinGroupResp.MemberId, closeServer
+}
+
+func leaveSeekGroup(t *testing.T, k *Broker, memberId string) {
+	_, err := k.GroupLeave(&addr, &codec.LeaveGroupReq{
+		BaseReq: codec.BaseReq{ClientId: clientId},
+		GroupId: groupId,
+		Members: []*codec.LeaveGroupMember{{MemberId: memberId}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeekGroupToTimestamp(t *testing.T) {
+	k, memberId, closeServer := newSeekGroupBroker(t)
+	defer closeServer()
+	leaveSeekGroup(t, k, memberId)
+
+	assert.Nil(t, k.SeekGroupToTimestamp(username, groupId, 2500))
+	committed, exist := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.True(t, exist)
+	assert.Equal(t, int64(3), committed.Offset)
+	assert.Equal(t, int64(3), committed.MessageId.EntryID())
+
+	// no message after the timestamp, the group resumes at the end
+	assert.Nil(t, k.SeekGroupToTimestamp(username, groupId, 10000))
+	committed, _ = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(6), committed.Offset)
+}
+
+func TestSeekGroupToTimestampWithMembers(t *testing.T) {
+	k, _, closeServer := newSeekGroupBroker(t)
+	defer closeServer()
+
+	err := k.SeekGroupToTimestamp(username, groupId, 2500)
+	assert.True(t, errors.Is(err, errGroupActive))
+	committed, _ := k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(4), committed.Offset)
+
+	group, err := k.groupCoordinator.GetGroup(username, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.groupStatus = PreparingRebalance
+	assert.Nil(t, k.SeekGroupToTimestamp(username, groupId, 2500))
+	committed, _ = k.offsetManager.AcquireOffset(username, "topic", groupId, partition)
+	assert.Equal(t, int64(3), committed.Offset)
+}
+
+func TestSeekGroupWithoutOffsets(t *testing.T) {
+	k, memberId, closeServer := newSeekGroupBroker(t)
+	defer closeServer()
+	leaveSeekGroup(t, k, memberId)
+	k.offsetManager.RemoveOffset(username, "topic", groupId, partition)
+
+	err := k.SeekGroupToTimestamp(username, groupId, 2500)
+	assert.True(t, errors.Is(err, errNothingToSeek))
+	assert.NotNil(t, k.SeekGroupToTimestamp(username, "unknown-group", 2500))
+}
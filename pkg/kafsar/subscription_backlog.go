@@ -0,0 +1,88 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/paashzj/kafka_go_pulsar/pkg/utils"
+	"sync"
+	"time"
+)
+
+type backlogEntry struct {
+	backlog  int64
+	expireAt time.Time
+}
+
+// backlogCache avoid querying pulsar topic stats on every call
+type backlogCache struct {
+	mutex   sync.Mutex
+	entries map[string]backlogEntry
+}
+
+func newBacklogCache() *backlogCache {
+	return &backlogCache{entries: make(map[string]backlogEntry)}
+}
+
+func (c *backlogCache) get(key string, now time.Time) (int64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, exist := c.entries[key]
+	if !exist || now.After(entry.expireAt) {
+		delete(c.entries, key)
+		return 0, false
+	}
+	return entry.backlog, true
+}
+
+func (c *backlogCache) put(key string, backlog int64, expireAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = backlogEntry{backlog: backlog, expireAt: expireAt}
+}
+
+// SubscriptionBacklog the backlog of the group subscription on the pulsar topic of the kafka partition,
+// the value is cached for KafsarConfig.BacklogCacheMs
+func (b *Broker) SubscriptionBacklog(username, groupId, kafkaTopic string, partition int) (int64, error) {
+	partitionedTopic, err := b.partitionedTopic(&userInfo{username: username}, kafkaTopic, partition)
+	if err != nil {
+		return 0, err
+	}
+	subscriptionName, err := b.server.SubscriptionName(username, groupId)
+	if err != nil {
+		return 0, err
+	}
+	key := partitionedTopic + subscriptionName
+	now := time.Now()
+	if backlog, exist := b.backlogCache.get(key, now); exist {
+		return backlog, nil
+	}
+	backlog, err := utils.GetSubscriptionBacklog(partitionedTopic, subscriptionName, b.getPulsarHttpUrl())
+	if err != nil {
+		return 0, err
+	}
+	b.backlogCache.put(key, backlog, now.Add(b.backlogCacheTime()))
+	return backlog, nil
+}
+
+func (b *Broker) backlogCacheTime() time.Duration {
+	if b.kafsarConfig.BacklogCacheMs > 0 {
+		return time.Duration(b.kafsarConfig.BacklogCacheMs) * time.Millisecond
+	}
+	return constant.DefaultBacklogCacheTime
+}
@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSubscriptionBacklog(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	subscriptionName, err := k.server.SubscriptionName(username, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = fmt.Fprintf(w, `{"subscriptions":{"%s":{"msgBacklog":42}}}`, subscriptionName)
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.pulsarConfig.Host = host
+	k.pulsarConfig.HttpPort, _ = strconv.Atoi(port)
+
+	backlog, err := k.SubscriptionBacklog(username, groupId, "topic", partition)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), backlog)
+	// served from cache
+	backlog, err = k.SubscriptionBacklog(username, groupId, "topic", partition)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), backlog)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = k.SubscriptionBacklog(username, "other-group", "topic", partition)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,143 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"time"
+)
+
+type testMessage struct {
+	pulsar.Message
+	id          pulsar.MessageID
+	topic       string
+	key         string
+	index       *uint64
+	payload     []byte
+	properties  map[string]string
+	publishTime time.Time
+}
+
+func (m *testMessage) ID() pulsar.MessageID {
+	return m.id
+}
+
+func (m *testMessage) Topic() string {
+	return m.topic
+}
+
+func (m *testMessage) Key() string {
+	return m.key
+}
+
+func (m *testMessage) Index() *uint64 {
+	return m.index
+}
+
+func (m *testMessage) Payload() []byte {
+	return m.payload
+}
+
+func (m *testMessage) Properties() map[string]string {
+	return m.properties
+}
+
+func (m *testMessage) PublishTime() time.Time {
+	return m.publishTime
+}
+
+type testMessageID struct {
+	pulsar.MessageID
+	ledgerID     int64
+	entryID      int64
+	batchIdx     int32
+	partitionIdx int32
+}
+
+func (id *testMessageID) LedgerID() int64 {
+	return id.ledgerID
+}
+
+func (id *testMessageID) EntryID() int64 {
+	return id.entryID
+}
+
+func (id *testMessageID) BatchIdx() int32 {
+	return id.batchIdx
+}
+
+func (id *testMessageID) PartitionIdx() int32 {
+	return id.partitionIdx
+}
+
+// testReader read the messages in order, Seek move to the message of the id inclusive
+type testReader struct {
+	pulsar.Reader
+	messages []pulsar.Message
+	position int
+	seeks    int
+	closed   bool
+}
+
+func (r *testReader) Next(ctx context.Context) (pulsar.Message, error) {
+	if r.position >= len(r.messages) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	message := r.messages[r.position]
+	r.position++
+	return message, nil
+}
+
+func (r *testReader) Close() {
+	r.closed = true
+}
+
+func (r *testReader) Topic() string {
+	return ""
+}
+
+// SeekByTime move to the first message published at or after the time
+func (r *testReader) SeekByTime(time time.Time) error {
+	r.seeks++
+	for i, message := range r.messages {
+		if !message.PublishTime().Before(time) {
+			r.position = i
+			return nil
+		}
+	}
+	r.position = len(r.messages)
+	return nil
+}
+
+func (r *testReader) Seek(id pulsar.MessageID) error {
+	r.seeks++
+	if sameMessageId(id, pulsar.EarliestMessageID()) {
+		r.position = 0
+		return nil
+	}
+	for i, message := range r.messages {
+		if message.ID().LedgerID() == id.LedgerID() && message.ID().EntryID() == id.EntryID() {
+			r.position = i
+			return nil
+		}
+	}
+	r.position = len(r.messages)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"time"
+)
+
+// recordTimestamps the create time of each record in the batch, negative means no timestamp
+func recordTimestamps(recordBatch *codec.RecordBatch) []int64 {
+	timestamps := make([]int64, len(recordBatch.Records))
+	for i, record := range recordBatch.Records {
+		if recordBatch.FirstTimestamp < 0 {
+			timestamps[i] = constant.UnknownTimestamp
+			continue
+		}
+		timestamps[i] = recordBatch.FirstTimestamp + record.RelativeTimestamp
+	}
+	return timestamps
+}
+
+// validateTimestamps check the timestamps are within maxSkewMs of now, out of range timestamps are clamped to now
+// if clamp is set, otherwise INVALID_TIMESTAMP is returned
+func validateTimestamps(timestamps []int64, now time.Time, maxSkewMs int64, clamp bool) codec.ErrorCode {
+	nowMs := now.UnixMilli()
+	for i, timestamp := range timestamps {
+		if timestamp < 0 || (timestamp >= nowMs-maxSkewMs && timestamp <= nowMs+maxSkewMs) {
+			continue
+		}
+		if !clamp {
+			return codec.INVALID_TIMESTAMP
+		}
+		timestamps[i] = nowMs
+	}
+	return codec.NONE
+}
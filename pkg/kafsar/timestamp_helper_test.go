@@ -0,0 +1,68 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRecordTimestamps(t *testing.T) {
+	recordBatch := &codec.RecordBatch{
+		FirstTimestamp: 1000,
+		Records:        []*codec.Record{{RelativeTimestamp: 0}, {RelativeTimestamp: 5}},
+	}
+	assert.Equal(t, []int64{1000, 1005}, recordTimestamps(recordBatch))
+	recordBatch.FirstTimestamp = -1
+	assert.Equal(t, []int64{constant.UnknownTimestamp, constant.UnknownTimestamp}, recordTimestamps(recordBatch))
+}
+
+func TestValidateTimestamps(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour).UnixMilli()
+	timestamps := []int64{now.UnixMilli(), future, constant.UnknownTimestamp}
+	assert.Equal(t, codec.INVALID_TIMESTAMP, validateTimestamps(timestamps, now, 1000, false))
+
+	assert.Equal(t, codec.NONE, validateTimestamps(timestamps, now, 1000, true))
+	assert.Equal(t, []int64{now.UnixMilli(), now.UnixMilli(), constant.UnknownTimestamp}, timestamps)
+
+	timestamps = []int64{future}
+	assert.Equal(t, codec.NONE, validateTimestamps(timestamps, now, time.Hour.Milliseconds(), false))
+	assert.Equal(t, future, timestamps[0])
+}
+
+func TestProduceFarFutureTimestamp(t *testing.T) {
+	config := kafsarConfig
+	config.MaxTimestampSkewMs = time.Hour.Milliseconds()
+	broker := newTestBroker(config)
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId:     constant.NoProducerId,
+			FirstTimestamp: time.Now().Add(24 * time.Hour).UnixMilli(),
+			Records:        []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	resp, err := broker.Produce(context.Background(), &addr, "topic", partition, 0, req)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.INVALID_TIMESTAMP, resp.ErrorCode)
+}
@@ -0,0 +1,92 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"time"
+)
+
+type cachedPulsarTopic struct {
+	pulsarTopic string
+	expireAt    time.Time
+}
+
+type cachedPartitionNum struct {
+	partitionNum int
+	expireAt     time.Time
+}
+
+// pulsarTopic Server.PulsarTopic cached for KafsarConfig.TopicCacheTtlMs, the caller must not hold the broker mutex
+func (b *Broker) pulsarTopic(username, kafkaTopic string) (string, error) {
+	if b.kafsarConfig.TopicCacheTtlMs <= 0 {
+		return b.server.PulsarTopic(username, kafkaTopic)
+	}
+	key := username + kafkaTopic
+	now := time.Now()
+	b.mutex.RLock()
+	cached, exist := b.pulsarTopicCache[key]
+	b.mutex.RUnlock()
+	if exist && now.Before(cached.expireAt) {
+		return cached.pulsarTopic, nil
+	}
+	pulsarTopic, err := b.server.PulsarTopic(username, kafkaTopic)
+	if err != nil {
+		return "", err
+	}
+	b.mutex.Lock()
+	b.pulsarTopicCache[key] = cachedPulsarTopic{pulsarTopic: pulsarTopic, expireAt: now.Add(b.topicCacheTtl())}
+	b.mutex.Unlock()
+	return pulsarTopic, nil
+}
+
+// partitionNum Server.PartitionNum cached for KafsarConfig.TopicCacheTtlMs, the caller must not hold the broker mutex
+func (b *Broker) partitionNum(username, kafkaTopic string) (int, error) {
+	if b.kafsarConfig.TopicCacheTtlMs <= 0 {
+		return b.server.PartitionNum(username, kafkaTopic)
+	}
+	key := username + kafkaTopic
+	now := time.Now()
+	b.mutex.RLock()
+	cached, exist := b.partitionNumCache[key]
+	b.mutex.RUnlock()
+	if exist && now.Before(cached.expireAt) {
+		return cached.partitionNum, nil
+	}
+	partitionNum, err := b.server.PartitionNum(username, kafkaTopic)
+	if err != nil {
+		return 0, err
+	}
+	b.mutex.Lock()
+	b.partitionNumCache[key] = cachedPartitionNum{partitionNum: partitionNum, expireAt: now.Add(b.topicCacheTtl())}
+	b.mutex.Unlock()
+	return partitionNum, nil
+}
+
+// InvalidateTopicCache drop the cached pulsar topic and partition num, call it after the topic is created, deleted or
+// its partitions changed
+func (b *Broker) InvalidateTopicCache(username, kafkaTopic string) {
+	key := username + kafkaTopic
+	b.mutex.Lock()
+	delete(b.pulsarTopicCache, key)
+	delete(b.partitionNumCache, key)
+	b.mutex.Unlock()
+}
+
+func (b *Broker) topicCacheTtl() time.Duration {
+	return time.Duration(b.kafsarConfig.TopicCacheTtlMs) * time.Millisecond
+}
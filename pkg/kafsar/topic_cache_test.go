@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingServer struct {
+	test.KafsarImpl
+	pulsarTopicCalls  *int32
+	partitionNumCalls *int32
+}
+
+func (c countingServer) PulsarTopic(username, topic string) (string, error) {
+	atomic.AddInt32(c.pulsarTopicCalls, 1)
+	return c.KafsarImpl.PulsarTopic(username, topic)
+}
+
+func (c countingServer) PartitionNum(username, topic string) (int, error) {
+	atomic.AddInt32(c.partitionNumCalls, 1)
+	return c.KafsarImpl.PartitionNum(username, topic)
+}
+
+func TestTopicCache(t *testing.T) {
+	config := kafsarConfig
+	config.TopicCacheTtlMs = 200
+	k := newTestBroker(config)
+	server := countingServer{pulsarTopicCalls: new(int32), partitionNumCalls: new(int32)}
+	k.server = server
+	user := &userInfo{username: username}
+
+	for i := 0; i < 3; i++ {
+		_, err := k.partitionedTopic(user, "topic", i)
+		assert.Nil(t, err)
+		_, err = k.PartitionNum(&addr, "topic")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(server.pulsarTopicCalls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(server.partitionNumCalls))
+
+	k.InvalidateTopicCache(username, "topic")
+	_, err := k.partitionedTopic(user, "topic", 0)
+	assert.Nil(t, err)
+	_, err = k.PartitionNum(&addr, "topic")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(server.pulsarTopicCalls))
+	assert.Equal(t, int32(2), atomic.LoadInt32(server.partitionNumCalls))
+
+	// expired
+	time.Sleep(300 * time.Millisecond)
+	_, err = k.partitionedTopic(user, "topic", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(server.pulsarTopicCalls))
+}
+
+func TestTopicCacheDisabled(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	server := countingServer{pulsarTopicCalls: new(int32), partitionNumCalls: new(int32)}
+	k.server = server
+	for i := 0; i < 3; i++ {
+		_, err := k.PartitionNum(&addr, "topic")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(server.partitionNumCalls))
+}
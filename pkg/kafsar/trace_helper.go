@@ -0,0 +1,64 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"strconv"
+)
+
+// span attributes, the same for every tracer
+const (
+	spanTagTopic     = "topic"
+	spanTagPartition = "partition"
+	spanTagGroup     = "group"
+)
+
+func (b *Broker) tagPartition(span LocalSpan, kafkaTopic string, partition int) {
+	if b.tracer.IsDisabled() {
+		return
+	}
+	b.tracer.SetAttribute(span, spanTagTopic, kafkaTopic)
+	b.tracer.SetAttribute(span, spanTagPartition, strconv.Itoa(partition))
+}
+
+// traceProperties the pulsar message properties carrying the context of the span, nil if the tracer can not propagate
+func (b *Broker) traceProperties(span LocalSpan) map[string]string {
+	propagator, ok := b.tracer.(TracePropagator)
+	if !ok {
+		return nil
+	}
+	carrier := make(map[string]string)
+	propagator.Inject(span, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+func setProperties(message *pulsar.ProducerMessage, properties map[string]string) {
+	if len(properties) == 0 {
+		return
+	}
+	if message.Properties == nil {
+		message.Properties = make(map[string]string, len(properties))
+	}
+	for k, v := range properties {
+		message.Properties[k] = v
+	}
+}
@@ -0,0 +1,146 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"fmt"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"strconv"
+	"testing"
+)
+
+// propertiesProducer keep the properties of the sent messages
+type propertiesProducer struct {
+	stalledProducer
+	properties []map[string]string
+}
+
+func (p *propertiesProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	p.mutex.Lock()
+	p.properties = append(p.properties, message.Properties)
+	entryId := int64(len(p.properties))
+	p.mutex.Unlock()
+	callback(&testMessageID{ledgerID: 1, entryID: entryId}, message, nil)
+}
+
+func produceTraced(t *testing.T, k *Broker) *propertiesProducer {
+	producer := &propertiesProducer{}
+	k.producerManager[addr.String()] = producer
+	resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId: constant.NoProducerId,
+			Records:    []*codec.Record{{Value: []byte(testContent)}, {Value: []byte(testContent)}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.ErrorCode)
+	return producer
+}
+
+func TestProduceInjectTraceContext(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracesdk.NewTracerProvider())
+	defer otel.SetTracerProvider(previous)
+	config := kafsarConfig
+	config.TagSourceCluster = true
+	config.ClusterId = "cluster-a"
+	k := newTestBroker(config)
+	k.tracer = &OtelTracerConfig{Host: "localhost", Port: 14268, SampleRate: 1}
+	producer := produceTraced(t, k)
+	assert.Len(t, producer.properties, 2)
+	traceparent := producer.properties[0]["traceparent"]
+	assert.Regexp(t, "^00-[0-9a-f]{32}-[0-9a-f]{16}-01$", traceparent)
+	for _, properties := range producer.properties {
+		// every message of the batch is a child of the produce span, other properties are kept
+		assert.Equal(t, traceparent, properties["traceparent"])
+		assert.Equal(t, "cluster-a", properties[constant.SourceClusterProperty])
+	}
+}
+
+func TestProduceWithoutTracePropagator(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	producer := produceTraced(t, k)
+	assert.Len(t, producer.properties, 2)
+	for _, properties := range producer.properties {
+		assert.Nil(t, properties)
+	}
+}
+
+func spanAttributes(span tracesdk.ReadOnlySpan) map[string]string {
+	attributes := make(map[string]string)
+	for _, kv := range span.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsString()
+	}
+	return attributes
+}
+
+func TestOtlpTracerSpanAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+	config := kafsarConfig
+	config.MaxFetchRecord = 1
+	k := newTestBroker(config)
+	k.tracer = &OtlpTracerConfig{TraceConfig: TraceConfig{Host: "localhost", Port: 4318, SampleRate: 1}, Insecure: true}
+	producer := produceTraced(t, k)
+	assert.NotEmpty(t, producer.properties[0]["traceparent"])
+
+	partitionedTopic, err := k.partitionedTopic(&userInfo{username: username}, "topic", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &testReader{messages: []pulsar.Message{&testMessage{
+		id:      &testMessageID{ledgerID: 1, entryID: 0},
+		topic:   partitionedTopic,
+		payload: []byte(testContent),
+	}}}
+	k.readerManager[readerKey(username, partitionedTopic, clientId)] = &ReaderMetadata{groupId: groupId, reader: reader, messageIds: make([]MessageIdPair, 0)}
+	_, err = k.Fetch(context.Background(), &addr, &codec.FetchReq{
+		BaseReq:     codec.BaseReq{ClientId: clientId},
+		MaxWaitTime: 100,
+		MaxBytes:    maxBytes,
+		TopicReqList: []*codec.FetchTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: partition}},
+		}},
+	})
+	assert.Nil(t, err)
+
+	spans := make(map[string]map[string]string)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = spanAttributes(span)
+	}
+	partitionStr := strconv.Itoa(partition)
+	assert.Equal(t, "Produce", spans["Produce"]["action"])
+	assert.Equal(t, "topic", spans["Produce"][spanTagTopic])
+	assert.Equal(t, partitionStr, spans["Produce"][spanTagPartition])
+	assert.Equal(t, "topic", spans["FetchPartition"][spanTagTopic])
+	fetchSpan := spans[fmt.Sprintf("fetching partition topic:%d", partition)]
+	assert.Equal(t, "topic", fetchSpan[spanTagTopic])
+	assert.Equal(t, partitionStr, fetchSpan[spanTagPartition])
+	assert.Equal(t, groupId, fetchSpan[spanTagGroup])
+}
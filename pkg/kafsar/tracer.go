@@ -27,6 +27,8 @@ import (
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
@@ -60,12 +62,19 @@ type NoErrorTracer interface {
 	EndSpan(span LocalSpan, logs ...string)
 }
 
+// TracePropagator optional NoErrorTracer extension, carry the span context to pulsar messages
+type TracePropagator interface {
+	// Inject write the context of the span into the carrier, in the w3c trace context format
+	Inject(span LocalSpan, carrier map[string]string)
+}
+
 type OtelTracerConfig TraceConfig
 type SkywalkingTracerConfig TraceConfig
 
 // TraceConfig impl NoErrorTracer interface
 var _ NoErrorTracer = (*OtelTracerConfig)(nil)
 var _ NoErrorTracer = (*SkywalkingTracerConfig)(nil)
+var _ TracePropagator = (*OtelTracerConfig)(nil)
 
 func (ot *OtelTracerConfig) IsDisabled() bool {
 	return ot.DisableTracing || ot.Host == "" || ot.Port == 0
@@ -81,6 +90,10 @@ func (ot *OtelTracerConfig) NewProvider() {
 		logrus.Errorf("init jaeger failed: %s", err.Error())
 		return
 	}
+	ot.setProvider(exporter)
+}
+
+func (ot *OtelTracerConfig) setProvider(exporter tracesdk.SpanExporter) {
 	provider := tracesdk.NewTracerProvider(
 		// Always be sure to batch in production.
 		tracesdk.WithBatcher(exporter),
@@ -139,6 +152,68 @@ func (ot *OtelTracerConfig) SetAttribute(span LocalSpan, k, v string) {
 	span.otel.SetAttributes(attribute.String(k, v))
 }
 
+func (ot *OtelTracerConfig) Inject(span LocalSpan, carrier map[string]string) {
+	if ot.spanInvalid(span) {
+		return
+	}
+	propagation.TraceContext{}.Inject(span.ctx, propagation.MapCarrier(carrier))
+}
+
+// OtlpTracerConfig export the opentelemetry spans to an otlp http collector, e.g. the opentelemetry collector on port 4318
+type OtlpTracerConfig struct {
+	TraceConfig
+	// Insecure export without tls
+	Insecure bool
+}
+
+var _ NoErrorTracer = (*OtlpTracerConfig)(nil)
+var _ TracePropagator = (*OtlpTracerConfig)(nil)
+
+// otel spans are the same as OtelTracerConfig, only the exporter differs
+func (o *OtlpTracerConfig) otel() *OtelTracerConfig {
+	return (*OtelTracerConfig)(&o.TraceConfig)
+}
+
+func (o *OtlpTracerConfig) IsDisabled() bool {
+	return o.otel().IsDisabled()
+}
+
+func (o *OtlpTracerConfig) NewProvider() {
+	if o.IsDisabled() {
+		return
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(net.JoinHostPort(o.Host, strconv.Itoa(o.Port)))}
+	if o.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		logrus.Errorf("init otlp exporter failed: %s", err.Error())
+		return
+	}
+	o.otel().setProvider(exporter)
+}
+
+func (o *OtlpTracerConfig) NewSpan(ctx context.Context, operateName string, logs ...string) LocalSpan {
+	return o.otel().NewSpan(ctx, operateName, logs...)
+}
+
+func (o *OtlpTracerConfig) SetAttribute(span LocalSpan, k, v string) {
+	o.otel().SetAttribute(span, k, v)
+}
+
+func (o *OtlpTracerConfig) NewSubSpan(span LocalSpan, operateName string, logs ...string) LocalSpan {
+	return o.otel().NewSubSpan(span, operateName, logs...)
+}
+
+func (o *OtlpTracerConfig) EndSpan(span LocalSpan, logs ...string) {
+	o.otel().EndSpan(span, logs...)
+}
+
+func (o *OtlpTracerConfig) Inject(span LocalSpan, carrier map[string]string) {
+	o.otel().Inject(span, carrier)
+}
+
 func (st *SkywalkingTracerConfig) NewProvider() {
 	if st.IsDisabled() {
 		return
@@ -209,6 +284,33 @@ func (st *SkywalkingTracerConfig) IsDisabled() bool {
 	return st.DisableTracing || st.Host == "" || st.Port == 0
 }
 
+// NoopTracer the default tracer, no span is created
+type NoopTracer struct {
+}
+
+var _ NoErrorTracer = NoopTracer{}
+
+func (n NoopTracer) IsDisabled() bool {
+	return true
+}
+
+func (n NoopTracer) NewProvider() {
+}
+
+func (n NoopTracer) NewSpan(ctx context.Context, operateName string, logs ...string) LocalSpan {
+	return LocalSpan{}
+}
+
+func (n NoopTracer) SetAttribute(span LocalSpan, k, v string) {
+}
+
+func (n NoopTracer) NewSubSpan(span LocalSpan, operateName string, logs ...string) LocalSpan {
+	return LocalSpan{}
+}
+
+func (n NoopTracer) EndSpan(span LocalSpan, logs ...string) {
+}
+
 type TraceType int
 
 const (
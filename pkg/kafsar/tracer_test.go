@@ -0,0 +1,89 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"testing"
+)
+
+func TestNoopTracer(t *testing.T) {
+	var tracer NoErrorTracer = NoopTracer{}
+	assert.True(t, tracer.IsDisabled())
+	allocs := testing.AllocsPerRun(100, func() {
+		span := tracer.NewSpan(context.Background(), "Produce", "broker produce msg starting")
+		tracer.SetAttribute(span, spanTagTopic, "topic")
+		subSpan := tracer.NewSubSpan(span, "FetchPartition")
+		tracer.EndSpan(subSpan)
+		tracer.EndSpan(span, "done")
+	})
+	assert.Equal(t, float64(0), allocs)
+	assert.Equal(t, LocalSpan{}, tracer.NewSpan(context.Background(), "Produce"))
+}
+
+// ackProducer acknowledge every message at once
+type ackProducer struct {
+	stalledProducer
+}
+
+func (p *ackProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage, callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	callback(&testMessageID{ledgerID: 1, entryID: 1}, message, nil)
+}
+
+func benchmarkProduce(b *testing.B, tracer NoErrorTracer) {
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.WarnLevel)
+	defer logrus.SetLevel(level)
+	k := newTestBroker(kafsarConfig)
+	k.tracer = tracer
+	k.producerManager[addr.String()] = &ackProducer{}
+	req := &codec.ProducePartitionReq{
+		PartitionId: partition,
+		RecordBatch: &codec.RecordBatch{
+			ProducerId: constant.NoProducerId,
+			Records:    []*codec.Record{{Value: []byte(testContent)}},
+		},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, req)
+		if err != nil || resp.ErrorCode != codec.NONE {
+			b.Fatalf("produce failed, err: %v, resp: %+v", err, resp)
+		}
+	}
+}
+
+func BenchmarkProduceTracing(b *testing.B) {
+	b.Run("off", func(b *testing.B) {
+		benchmarkProduce(b, NoopTracer{})
+	})
+	b.Run("on", func(b *testing.B) {
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider())
+		defer otel.SetTracerProvider(previous)
+		benchmarkProduce(b, &OtelTracerConfig{Host: "localhost", Port: 14268, SampleRate: 1})
+	})
+}
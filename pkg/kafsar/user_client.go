@@ -0,0 +1,76 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"sync"
+)
+
+// userClients the pulsar clients isolated per user, created on first use and closed with the broker
+type userClients struct {
+	mutex   sync.Mutex
+	clients map[string]pulsar.Client
+}
+
+func newUserClients() *userClients {
+	return &userClients{clients: make(map[string]pulsar.Client)}
+}
+
+func (u *userClients) get(username string, options pulsar.ClientOptions) (pulsar.Client, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	client, exist := u.clients[username]
+	if exist {
+		return client, nil
+	}
+	client, err := pulsar.NewClient(options)
+	if err != nil {
+		return nil, err
+	}
+	u.clients[username] = client
+	return client, nil
+}
+
+func (u *userClients) close() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for username, client := range u.clients {
+		client.Close()
+		delete(u.clients, username)
+	}
+}
+
+// userClient the pulsar client for the produces and reads of the user, the shared client unless PulsarClientPerUser
+func (b *Broker) userClient(username string) (pulsar.Client, error) {
+	if !b.kafsarConfig.PulsarClientPerUser {
+		return b.pulsarCommonClient, nil
+	}
+	options := b.pulsarClientOptions()
+	options.MaxConnectionsPerBroker = b.kafsarConfig.UserMaxConnectionsPerBroker
+	return b.userClients.get(username, options)
+}
+
+func recordsBytes(records []*codec.Record) int {
+	bytes := 0
+	for _, record := range records {
+		bytes += len(record.Key) + len(record.Value)
+	}
+	return bytes
+}
@@ -0,0 +1,118 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafsar
+
+import (
+	"context"
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUserClientIsolation(t *testing.T) {
+	config := kafsarConfig
+	config.PulsarClientPerUser = true
+	config.UserMaxConnectionsPerBroker = 2
+	k := newTestBroker(config)
+	k.pulsarConfig = PulsarConfig{Host: "localhost", TcpPort: 6650}
+	k.pulsarClientManage = make(map[string]pulsar.Client)
+	defer k.userClients.close()
+	alice, err := k.userClient("alice")
+	assert.Nil(t, err)
+	bob, err := k.userClient("bob")
+	assert.Nil(t, err)
+	assert.NotSame(t, alice, bob)
+	client, err := k.userClient("alice")
+	assert.Nil(t, err)
+	assert.Same(t, alice, client)
+	// the readers of the user share the user's client
+	client, err = k.readerClient("alice", "persistent://public/default/topic-partition-0", clientId)
+	assert.Nil(t, err)
+	assert.Same(t, alice, client)
+	assert.Empty(t, k.pulsarClientManage)
+}
+
+func TestPulsarClientOptions(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	k.pulsarConfig = PulsarConfig{Host: "localhost", TcpPort: 6650, OperationTimeoutMs: 3000, ConnectionTimeoutMs: 500}
+	options := k.pulsarClientOptions()
+	assert.Equal(t, "pulsar://localhost:6650", options.URL)
+	assert.Equal(t, 3*time.Second, options.OperationTimeout)
+	assert.Equal(t, 500*time.Millisecond, options.ConnectionTimeout)
+}
+
+func TestCheckKeepAliveInterval(t *testing.T) {
+	assert.Nil(t, checkKeepAliveInterval(PulsarConfig{}))
+	assert.Nil(t, checkKeepAliveInterval(PulsarConfig{KeepAliveIntervalMs: 30000}))
+	assert.NotNil(t, checkKeepAliveInterval(PulsarConfig{KeepAliveIntervalMs: 5000}))
+}
+
+func TestUserClientShared(t *testing.T) {
+	k := newTestBroker(kafsarConfig)
+	k.pulsarCommonClient = &producerOptionsClient{}
+	alice, err := k.userClient("alice")
+	assert.Nil(t, err)
+	bob, err := k.userClient("bob")
+	assert.Nil(t, err)
+	assert.Same(t, k.pulsarCommonClient, alice)
+	assert.Same(t, k.pulsarCommonClient, bob)
+	assert.Empty(t, k.userClients.clients)
+}
+
+func TestUserMaxPendingProduceBytes(t *testing.T) {
+	config := kafsarConfig
+	config.PulsarClientPerUser = true
+	config.UserMaxPendingProduceBytes = 15
+	config.ProduceFlushTimeoutMs = 50
+	k := newTestBroker(config)
+	producer := &stalledProducer{}
+	k.producerManager[addr.String()] = producer
+	produce := func() *codec.ProducePartitionResp {
+		resp, err := k.Produce(context.Background(), &addr, "topic", partition, 30000, &codec.ProducePartitionReq{
+			PartitionId: partition,
+			RecordBatch: &codec.RecordBatch{
+				ProducerId: constant.NoProducerId,
+				Records:    []*codec.Record{{Key: []byte("key"), Value: make([]byte, 7)}},
+			},
+		})
+		assert.Nil(t, err)
+		return resp
+	}
+	// pulsar does not acknowledge, the bytes stay pending
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, produce().ErrorCode)
+	assert.Equal(t, 10, k.userPendingBytes.count(username))
+	// exceed the pending bytes of the user, rejected without sending
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, produce().ErrorCode)
+	assert.Equal(t, 1, producer.sent())
+	assert.Equal(t, 1, k.pendingProduce.count(addr.String()))
+
+	producer.ack()
+	assert.Equal(t, 0, k.userPendingBytes.count(username))
+	done := make(chan *codec.ProducePartitionResp)
+	go func() {
+		done <- produce()
+	}()
+	assert.Eventually(t, func() bool {
+		return producer.sent() == 1
+	}, time.Second, 5*time.Millisecond)
+	producer.ack()
+	assert.Equal(t, codec.NONE, (<-done).ErrorCode)
+}
@@ -27,4 +27,11 @@ type MessageID struct {
 type MessageIdData struct {
 	MessageId []byte
 	Offset    int64
+	// the committer of the offset, absent in offsets committed by old versions
+	Username   string
+	KafkaTopic string
+	GroupId    string
+	Partition  int
+	// Metadata the metadata of the offset commit
+	Metadata string
 }
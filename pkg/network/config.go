@@ -17,6 +17,8 @@
 
 package network
 
+import "github.com/protocol-laboratory/kafka-codec-go/codec"
+
 type KafkaProtocolConfig struct {
 	ClusterId     string
 	NodeId        int32
@@ -24,4 +26,15 @@ type KafkaProtocolConfig struct {
 	AdvertisePort int
 	NeedSasl      bool
 	MaxConn       int32
+	// MaxInflightRequestsPerConn limit concurrent produce and fetch requests of one connection, 0 means no limit
+	MaxInflightRequestsPerConn int32
+	// MaxApiVersions cap the max version advertised in ApiVersions per api key, down to a version kafsar supports
+	MaxApiVersions map[codec.ApiCode]int16
+	// ConnectionIdleTimeoutMs close connections receiving no request for the duration, 0 means never
+	ConnectionIdleTimeoutMs int
+	// ConnectionMaxLifetimeMs close connections opened for the duration so that clients reconnect, 0 means never
+	ConnectionMaxLifetimeMs int
+	// MaxDecompressedBytes max bytes of the zstd compressed record batches of a produce request after decompression,
+	// default constant.DefaultMaxMessageBytes
+	MaxDecompressedBytes int
 }
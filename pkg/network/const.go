@@ -17,6 +17,28 @@
 
 package network
 
+import (
+	"encoding/binary"
+	"github.com/panjf2000/gnet"
+	"time"
+)
+
+// connectionCheckInterval how often the idle and lifetime of connections are checked
+const connectionCheckInterval = time.Second
+
+// saslMechanismPlain the only supported sasl mechanism
+const saslMechanismPlain = "PLAIN"
+
+// kafkaCodec same as the codec of kgnet, kafka frames are prefixed by the 4 bytes length
+var kafkaCodec = gnet.NewLengthFieldBasedFrameCodec(gnet.EncoderConfig{
+	ByteOrder:         binary.BigEndian,
+	LengthFieldLength: 4,
+}, gnet.DecoderConfig{
+	ByteOrder:           binary.BigEndian,
+	LengthFieldLength:   4,
+	InitialBytesToStrip: 4,
+})
+
 var (
 	ALL_PERMISSION_TYPE      = "ALL"
 	PRODUCER_PERMISSION_TYPE = "W"
@@ -18,8 +18,12 @@
 package ctx
 
 import (
+	"context"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // NetworkContext
@@ -28,6 +32,51 @@ type NetworkContext struct {
 	ctxMutex sync.RWMutex
 	authed   bool
 	Addr     net.Addr
+	inflight int32
+	// openedAt the time the connection is opened
+	openedAt time.Time
+	// lastActive unix nano of the last request, atomic
+	lastActive int64
+	// saslTokenClientId the client id of the SaslHandshake v0 request, the next frame is a raw sasl token
+	saslTokenClientId *string
+	// produceBatches the record batches of the partitions of the produce frame being served
+	produceBatches [][][]*codec.RecordBatch
+	// ctx done when the connection is closed or the server is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewNetworkContext(parent context.Context, addr net.Addr, now time.Time) *NetworkContext {
+	connCtx, cancel := context.WithCancel(parent)
+	return &NetworkContext{Addr: addr, openedAt: now, lastActive: now.UnixNano(), ctx: connCtx, cancel: cancel}
+}
+
+// Context the lifetime of the connection, the requests of the connection are canceled when it is done
+func (n *NetworkContext) Context() context.Context {
+	if n.ctx == nil {
+		return context.Background()
+	}
+	return n.ctx
+}
+
+// Cancel cancel the in-flight requests of the connection
+func (n *NetworkContext) Cancel() {
+	if n.cancel != nil {
+		n.cancel()
+	}
+}
+
+// Touch record a request received on the connection
+func (n *NetworkContext) Touch(now time.Time) {
+	atomic.StoreInt64(&n.lastActive, now.UnixNano())
+}
+
+// Expired the connection received no request for idleTimeout or is opened for maxLifetime, 0 means no limit
+func (n *NetworkContext) Expired(now time.Time, idleTimeout, maxLifetime time.Duration) bool {
+	if idleTimeout > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&n.lastActive))) >= idleTimeout {
+		return true
+	}
+	return maxLifetime > 0 && !n.openedAt.IsZero() && now.Sub(n.openedAt) >= maxLifetime
 }
 
 func (n *NetworkContext) Authed(authed bool) {
@@ -41,3 +90,58 @@ func (n *NetworkContext) IsAuthed() bool {
 	defer n.ctxMutex.RUnlock()
 	return n.authed
 }
+
+// AcquireInflight try to acquire an in-flight request slot, limit <= 0 means no limit
+func (n *NetworkContext) AcquireInflight(limit int32) bool {
+	if limit <= 0 {
+		return true
+	}
+	if atomic.AddInt32(&n.inflight, 1) > limit {
+		atomic.AddInt32(&n.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (n *NetworkContext) ReleaseInflight(limit int32) {
+	if limit <= 0 {
+		return
+	}
+	atomic.AddInt32(&n.inflight, -1)
+}
+
+// ExpectSaslToken the client sent SaslHandshake v0, the next frame is a raw sasl token instead of a kafka request
+func (n *NetworkContext) ExpectSaslToken(clientId string) {
+	n.ctxMutex.Lock()
+	n.saslTokenClientId = &clientId
+	n.ctxMutex.Unlock()
+}
+
+// TakeSaslToken report whether the next frame is a raw sasl token and the client id of the handshake,
+// the expectation is cleared
+func (n *NetworkContext) TakeSaslToken() (string, bool) {
+	n.ctxMutex.Lock()
+	defer n.ctxMutex.Unlock()
+	clientId := n.saslTokenClientId
+	n.saslTokenClientId = nil
+	if clientId == nil {
+		return "", false
+	}
+	return *clientId, true
+}
+
+// SetProduceBatches keep the record batches of the partitions of the produce frame, by topic index and partition index
+func (n *NetworkContext) SetProduceBatches(batches [][][]*codec.RecordBatch) {
+	n.ctxMutex.Lock()
+	n.produceBatches = batches
+	n.ctxMutex.Unlock()
+}
+
+// TakeProduceBatches return the record batches kept for the produce frame, they are cleared
+func (n *NetworkContext) TakeProduceBatches() [][][]*codec.RecordBatch {
+	n.ctxMutex.Lock()
+	defer n.ctxMutex.Unlock()
+	batches := n.produceBatches
+	n.produceBatches = nil
+	return batches
+}
@@ -19,6 +19,7 @@ package network
 
 import (
 	"context"
+	"fmt"
 	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
 	"github.com/panjf2000/gnet"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
@@ -27,15 +28,18 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type KafsarServer interface {
 	PartitionNum(addr net.Addr, topic string) (int, error)
 
+	// TopicList an empty list means the user is authorized to see no topics, an error means the backend failed
 	TopicList(addr net.Addr) ([]string, error)
 
 	// Fetch method called this already authed
-	Fetch(addr net.Addr, req *codec.FetchReq) ([]*codec.FetchTopicResp, error)
+	// ctx is canceled when the connection or the server is closed
+	Fetch(ctx context.Context, addr net.Addr, req *codec.FetchReq) ([]*codec.FetchTopicResp, error)
 
 	// GroupJoin method called this already authed
 	GroupJoin(addr net.Addr, req *codec.JoinGroupReq) (*codec.JoinGroupResp, error)
@@ -58,8 +62,9 @@ type KafsarServer interface {
 	// OffsetLeaderEpoch method called this already authed
 	OffsetLeaderEpoch(addr net.Addr, topic string, req *codec.OffsetLeaderEpochPartitionReq) (*codec.OffsetForLeaderEpochPartitionResp, error)
 
-	// Produce method called this already authed
-	Produce(addr net.Addr, topic string, partition int, req *codec.ProducePartitionReq) (*codec.ProducePartitionResp, error)
+	// ProduceBatches method called this already authed, batches are the record batches of the partition in order,
+	// timeoutMs is the timeout of the produce request. ctx is canceled when the connection or the server is closed
+	ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error)
 
 	SaslAuth(addr net.Addr, req codec.SaslAuthenticateReq) (bool, codec.ErrorCode)
 
@@ -69,43 +74,150 @@ type KafsarServer interface {
 
 	HeartBeat(addr net.Addr, req codec.HeartbeatReq) *codec.HeartbeatResp
 
+	// ThrottleTimeMs the time the client should back off after the request, e.g. it exceeds the byte rate quota
+	ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int
+
+	// FindCoordinator method called this already authed
+	FindCoordinator(addr net.Addr, req *codec.FindCoordinatorReq) (*codec.FindCoordinatorResp, error)
+
 	Disconnect(addr net.Addr)
 }
 
 func NewServer(config *kgnet.GnetServerConfig, kfkProtocolConfig *KafkaProtocolConfig, impl KafsarServer) (*Server, error) {
 	server := &Server{
+		gnetConfig:          *config,
 		kafkaProtocolConfig: kfkProtocolConfig,
 		kafsarImpl:          impl,
+		stopCh:              make(chan struct{}),
+	}
+	decompressor, err := newZstdDecompressor(kfkProtocolConfig.MaxDecompressedBytes)
+	if err != nil {
+		return nil, err
 	}
+	server.decompressor = decompressor
+	server.ctx, server.cancel = context.WithCancel(context.Background())
 	server.kafkaServer = kgnet.NewKafkaServer(*config, server)
 	return server, nil
 }
 
+// eventHandler serve the kafka requests by kgnet, except the raw sasl token after SaslHandshake v0
+// which is not a kafka request. zstd compressed produce batches of authenticated connections are decompressed and the
+// record batches of the partitions are split before kgnet decodes them
+type eventHandler struct {
+	*kgnet.KafkaServer
+	server *Server
+}
+
+func (e *eventHandler) React(frame []byte, c gnet.Conn) ([]byte, gnet.Action) {
+	if frame != nil {
+		networkContext := e.server.getCtx(c)
+		if clientId, ok := networkContext.TakeSaslToken(); ok {
+			return e.server.ReactSaslToken(frame, clientId, networkContext)
+		}
+		if isProduce(frame) {
+			if !e.server.Authed(networkContext) {
+				// do not spend memory decompressing for unauthenticated clients
+				return e.server.AuthFailed()
+			}
+			prepared, batches, err := e.server.decompressor.prepareProduce(frame)
+			if err != nil {
+				logrus.Errorf("prepare produce from %s failed: %s", c.RemoteAddr(), err)
+				return nil, gnet.Close
+			}
+			frame = prepared
+			// the codec decodes only the first record batch of a partition, ReactProduce takes all of them
+			networkContext.SetProduceBatches(batches)
+		}
+	}
+	return e.KafkaServer.React(frame, c)
+}
+
 func (s *Server) Run() error {
 	go func() {
-		err := s.kafkaServer.Run()
+		handler := &eventHandler{KafkaServer: s.kafkaServer, server: s}
+		addr := fmt.Sprintf("tcp://%s:%d", s.gnetConfig.ListenHost, s.gnetConfig.ListenPort)
+		err := gnet.Serve(handler, addr, gnet.WithNumEventLoop(s.gnetConfig.EventLoopNum), gnet.WithCodec(kafkaCodec))
 		if err != nil {
 			logrus.Error("kafsar broker started error ", err)
 		}
 	}()
+	if s.kafkaProtocolConfig.ConnectionIdleTimeoutMs > 0 || s.kafkaProtocolConfig.ConnectionMaxLifetimeMs > 0 {
+		go s.checkConnections()
+	}
 	return nil
 }
 
 func (s *Server) Close(ctx context.Context) (err error) {
+	atomic.StoreInt32(&s.closing, 1)
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		s.decompressor.close()
+	})
+	if s.cancel != nil {
+		// cancel the in-flight requests of all connections
+		s.cancel()
+	}
 	return s.kafkaServer.Stop(ctx)
 }
 
+func (s *Server) checkConnections() {
+	ticker := time.NewTicker(connectionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.closeExpiredConnections(now)
+		}
+	}
+}
+
+// closeExpiredConnections close idle or too old connections, OnClosed then disconnects them
+func (s *Server) closeExpiredConnections(now time.Time) {
+	idleTimeout := time.Duration(s.kafkaProtocolConfig.ConnectionIdleTimeoutMs) * time.Millisecond
+	maxLifetime := time.Duration(s.kafkaProtocolConfig.ConnectionMaxLifetimeMs) * time.Millisecond
+	s.ConnMap.Range(func(key, value interface{}) bool {
+		c := value.(gnet.Conn)
+		networkContext, ok := c.Context().(*ctx.NetworkContext)
+		if !ok || !networkContext.Expired(now, idleTimeout, maxLifetime) {
+			return true
+		}
+		logrus.Infof("close expired connection %s", c.RemoteAddr())
+		if err := c.Close(); err != nil {
+			logrus.Errorf("close expired connection %s failed: %s", c.RemoteAddr(), err.Error())
+		}
+		return true
+	})
+}
+
+// StopAccept refuse new connections, existing connections keep being served
+func (s *Server) StopAccept() {
+	atomic.StoreInt32(&s.closing, 1)
+}
+
 func (s *Server) OnInitComplete(server gnet.Server) (action gnet.Action) {
 	logrus.Info("Kafka Server started")
+	atomic.StoreInt32(&s.started, 1)
 	return
 }
 
+// Accepting report whether the listener is started and not refusing new connections
+func (s *Server) Accepting() bool {
+	return atomic.LoadInt32(&s.started) == 1 && atomic.LoadInt32(&s.closing) == 0
+}
+
 func (s *Server) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	if atomic.LoadInt32(&s.closing) == 1 {
+		logrus.Warn("server is closing, refused to connect ", c.RemoteAddr())
+		return nil, gnet.Close
+	}
 	if atomic.LoadInt32(&s.connCount) > s.kafkaProtocolConfig.MaxConn {
 		logrus.Error("connection reach max, refused to connect ", c.RemoteAddr())
 		return nil, gnet.Close
 	}
 	connCount := atomic.AddInt32(&s.connCount, 1)
+	s.getCtx(c)
 	s.ConnMap.Store(c.RemoteAddr(), c)
 	logrus.Info("new connection connected ", connCount, " from ", c.RemoteAddr())
 	return
@@ -116,6 +228,9 @@ func (s *Server) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
 	if err != nil {
 		logrus.Errorf("abnormal connect, error: %v", err)
 	}
+	if networkContext, ok := c.Context().(*ctx.NetworkContext); ok {
+		networkContext.Cancel()
+	}
 	s.kafsarImpl.Disconnect(c.RemoteAddr())
 	if err := c.Close(); err != nil {
 		logrus.Errorf("close connection %s failed: %s", c.RemoteAddr(), err.Error())
@@ -139,8 +254,9 @@ func (s *Server) UnSupportedApi(c gnet.Conn, apiKey codec.ApiCode, apiVersion in
 }
 
 func (s *Server) ApiVersion(c gnet.Conn, req *codec.ApiReq) (*codec.ApiResp, gnet.Action) {
+	s.getCtx(c)
 	version := req.ApiVersion
-	if version <= 3 {
+	if supportsVersion(codec.ApiVersions, version) {
 		return s.ReactApiVersion(req)
 	}
 	logrus.Warnf("unsupported apiVersion version %d", version)
@@ -153,7 +269,7 @@ func (s *Server) Fetch(c gnet.Conn, req *codec.FetchReq) (*codec.FetchResp, gnet
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 10 || version == 11 {
+	if supportsVersion(codec.Fetch, version) {
 		return s.ReactFetch(networkContext, req)
 	}
 	return nil, gnet.Close
@@ -165,8 +281,8 @@ func (s *Server) FindCoordinator(c gnet.Conn, req *codec.FindCoordinatorReq) (*c
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 0 || version == 3 {
-		return s.ReactFindCoordinator(req, s.kafkaProtocolConfig)
+	if supportsVersion(codec.FindCoordinator, version) {
+		return s.ReactFindCoordinator(networkContext, req)
 	}
 	return nil, gnet.Close
 }
@@ -174,7 +290,7 @@ func (s *Server) FindCoordinator(c gnet.Conn, req *codec.FindCoordinatorReq) (*c
 func (s *Server) Heartbeat(c gnet.Conn, req *codec.HeartbeatReq) (*codec.HeartbeatResp, gnet.Action) {
 	networkContext := s.getCtx(c)
 	version := req.ApiVersion
-	if version == 4 {
+	if supportsVersion(codec.Heartbeat, version) {
 		return s.ReactHeartbeat(req, networkContext)
 	}
 	logrus.Warn("Unsupported heartbeat version", version)
@@ -187,7 +303,7 @@ func (s *Server) JoinGroup(c gnet.Conn, req *codec.JoinGroupReq) (*codec.JoinGro
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 1 || version == 6 {
+	if supportsVersion(codec.JoinGroup, version) {
 		return s.ReactJoinGroup(networkContext, req)
 	}
 	logrus.Warn("Unsupported joinGroup version", version)
@@ -200,7 +316,7 @@ func (s *Server) LeaveGroup(c gnet.Conn, req *codec.LeaveGroupReq) (*codec.Leave
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 0 || version == 4 {
+	if supportsVersion(codec.LeaveGroup, version) {
 		return s.ReactLeaveGroup(networkContext, req)
 	}
 	logrus.Warn("Unsupported leaveGroup version", version)
@@ -213,7 +329,7 @@ func (s *Server) ListOffsets(c gnet.Conn, req *codec.ListOffsetsReq) (*codec.Lis
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 1 || version == 5 || version == 6 {
+	if supportsVersion(codec.ListOffsets, version) {
 		return s.ListOffsetsVersion(networkContext, req)
 	}
 	logrus.Warn("Unsupported listOffsets version", version)
@@ -226,7 +342,7 @@ func (s *Server) Metadata(c gnet.Conn, req *codec.MetadataReq) (*codec.MetadataR
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version <= 9 {
+	if supportsVersion(codec.Metadata, version) {
 		return s.ReactMetadata(networkContext, req, s.kafkaProtocolConfig)
 	}
 	logrus.Warn("Unsupported metadata version", version)
@@ -239,7 +355,7 @@ func (s *Server) OffsetCommit(c gnet.Conn, req *codec.OffsetCommitReq) (*codec.O
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 2 || version == 8 {
+	if supportsVersion(codec.OffsetCommit, version) {
 		return s.OffsetCommitVersion(networkContext, req)
 	}
 	logrus.Warn("Unsupported offsetCommit version", version)
@@ -252,7 +368,7 @@ func (s *Server) OffsetFetch(c gnet.Conn, req *codec.OffsetFetchReq) (*codec.Off
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 1 || version == 6 || version == 7 {
+	if supportsVersion(codec.OffsetFetch, version) {
 		return s.OffsetFetchVersion(networkContext, req)
 	}
 	logrus.Warn("Unsupported offsetFetch version", version)
@@ -265,7 +381,7 @@ func (s *Server) OffsetForLeaderEpoch(c gnet.Conn, req *codec.OffsetForLeaderEpo
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 3 {
+	if supportsVersion(codec.OffsetForLeaderEpoch, version) {
 		return s.OffsetForLeaderEpochVersion(networkContext, req)
 	}
 	logrus.Warn("Unsupported offsetForLeaderEpoch version", version)
@@ -278,7 +394,7 @@ func (s *Server) Produce(c gnet.Conn, req *codec.ProduceReq) (*codec.ProduceResp
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 7 || version == 8 {
+	if supportsVersion(codec.Produce, version) {
 		return s.ReactProduce(networkContext, req, s.kafkaProtocolConfig)
 	}
 	logrus.Warn("Unsupported producer version", version)
@@ -288,19 +404,28 @@ func (s *Server) Produce(c gnet.Conn, req *codec.ProduceReq) (*codec.ProduceResp
 func (s *Server) SaslAuthenticate(c gnet.Conn, req *codec.SaslAuthenticateReq) (*codec.SaslAuthenticateResp, gnet.Action) {
 	networkContext := s.getCtx(c)
 	version := req.ApiVersion
-	if version == 1 || version == 2 {
+	if supportsVersion(codec.SaslAuthenticate, version) {
 		return s.ReactSaslHandshakeAuth(req, networkContext)
 	}
 	logrus.Warn("Unsupported saslAuthenticate version", version)
-	return nil, gnet.Close
+	return &codec.SaslAuthenticateResp{
+		BaseResp:  codec.BaseResp{CorrelationId: req.CorrelationId},
+		ErrorCode: codec.UNSUPPORTED_VERSION,
+	}, gnet.Close
 }
 
 func (s *Server) SaslHandshake(c gnet.Conn, req *codec.SaslHandshakeReq) (*codec.SaslHandshakeResp, gnet.Action) {
+	networkContext := s.getCtx(c)
 	version := req.ApiVersion
-	if version <= 1 {
-		return s.ReactSasl(req)
+	if supportsVersion(codec.SaslHandshake, version) {
+		return s.ReactSasl(req, networkContext)
 	}
-	return nil, gnet.Close
+	logrus.Warn("Unsupported saslHandshake version", version)
+	return &codec.SaslHandshakeResp{
+		BaseResp:         codec.BaseResp{CorrelationId: req.CorrelationId},
+		ErrorCode:        codec.UNSUPPORTED_VERSION,
+		EnableMechanisms: []*codec.EnableMechanism{{SaslMechanism: saslMechanismPlain}},
+	}, gnet.Close
 }
 
 func (s *Server) SyncGroup(c gnet.Conn, req *codec.SyncGroupReq) (*codec.SyncGroupResp, gnet.Action) {
@@ -309,30 +434,47 @@ func (s *Server) SyncGroup(c gnet.Conn, req *codec.SyncGroupReq) (*codec.SyncGro
 		return nil, gnet.Close
 	}
 	version := req.ApiVersion
-	if version == 1 || version == 4 || version == 5 {
+	if supportsVersion(codec.SyncGroup, version) {
 		return s.ReactSyncGroup(networkContext, req)
 	}
 	logrus.Warn("Unsupported syncGroup version", version)
 	return nil, gnet.Close
 }
 
+// getCtx the context of the connection, marks the connection active
 func (s *Server) getCtx(c gnet.Conn) *ctx.NetworkContext {
+	now := time.Now()
 	s.connMutex.Lock()
 	connCtx := c.Context()
 	if connCtx == nil {
 		addr := c.RemoteAddr()
-		c.SetContext(&ctx.NetworkContext{Addr: addr})
+		parent := s.ctx
+		if parent == nil {
+			parent = context.Background()
+		}
+		c.SetContext(ctx.NewNetworkContext(parent, addr, now))
 	}
 	s.connMutex.Unlock()
-	return c.Context().(*ctx.NetworkContext)
+	networkContext := c.Context().(*ctx.NetworkContext)
+	networkContext.Touch(now)
+	return networkContext
 }
 
 type Server struct {
+	gnetConfig          kgnet.GnetServerConfig
 	connCount           int32
+	started             int32
+	closing             int32
 	connMutex           sync.Mutex
 	ConnMap             sync.Map
 	SaslMap             sync.Map
 	kafkaProtocolConfig *KafkaProtocolConfig
 	kafsarImpl          KafsarServer
 	kafkaServer         *kgnet.KafkaServer
+	decompressor        *zstdDecompressor
+	stopCh              chan struct{}
+	stopOnce            sync.Once
+	// ctx parent of the connection contexts, canceled when the server is closed
+	ctx    context.Context
+	cancel context.CancelFunc
 }
@@ -23,6 +23,49 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// supportedApiVersion the versions of an api the handler decodes, in ascending order
+type supportedApiVersion struct {
+	apiKey   codec.ApiCode
+	versions []int16
+}
+
+// supportedApiVersions the versions of each api kafsar implements, the handlers in kafka.go accept only these.
+// the codec implements a few versions of some apis, the range between the lowest and the highest is advertised,
+// a client whose highest version falls in a gap of the range is rejected
+var supportedApiVersions = []supportedApiVersion{
+	{apiKey: codec.Produce, versions: []int16{7, 8}},
+	{apiKey: codec.Fetch, versions: []int16{10, 11}},
+	{apiKey: codec.ListOffsets, versions: []int16{1, 5, 6}},
+	{apiKey: codec.Metadata, versions: []int16{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	{apiKey: codec.OffsetCommit, versions: []int16{2, 8}},
+	{apiKey: codec.OffsetFetch, versions: []int16{1, 6, 7}},
+	{apiKey: codec.FindCoordinator, versions: []int16{0, 3}},
+	{apiKey: codec.JoinGroup, versions: []int16{1, 6}},
+	{apiKey: codec.Heartbeat, versions: []int16{4}},
+	{apiKey: codec.LeaveGroup, versions: []int16{0, 4}},
+	{apiKey: codec.SyncGroup, versions: []int16{1, 4, 5}},
+	{apiKey: codec.SaslHandshake, versions: []int16{0, 1}},
+	{apiKey: codec.ApiVersions, versions: []int16{0, 1, 2, 3}},
+	{apiKey: codec.OffsetForLeaderEpoch, versions: []int16{3}},
+	{apiKey: codec.SaslAuthenticate, versions: []int16{0, 1, 2}},
+}
+
+// supportsVersion whether the handler of the api decodes the version
+func supportsVersion(apiKey codec.ApiCode, version int16) bool {
+	for _, supported := range supportedApiVersions {
+		if supported.apiKey != apiKey {
+			continue
+		}
+		for _, v := range supported.versions {
+			if v == version {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
 func (s *Server) ReactApiVersion(apiRequest *codec.ApiReq) (*codec.ApiResp, gnet.Action) {
 	logrus.Debug("api request ", apiRequest)
 	resp := codec.ApiResp{
@@ -31,26 +74,31 @@ func (s *Server) ReactApiVersion(apiRequest *codec.ApiReq) (*codec.ApiResp, gnet
 		},
 	}
 	resp.ErrorCode = 0
-	apiRespVersions := make([]*codec.ApiRespVersion, 18)
-	apiRespVersions[0] = &codec.ApiRespVersion{ApiKey: codec.Produce, MinVersion: 0, MaxVersion: 8}
-	apiRespVersions[1] = &codec.ApiRespVersion{ApiKey: codec.Fetch, MinVersion: 0, MaxVersion: 10}
-	apiRespVersions[2] = &codec.ApiRespVersion{ApiKey: codec.ListOffsets, MinVersion: 0, MaxVersion: 6}
-	apiRespVersions[3] = &codec.ApiRespVersion{ApiKey: codec.Metadata, MinVersion: 0, MaxVersion: 9}
-	apiRespVersions[4] = &codec.ApiRespVersion{ApiKey: codec.OffsetCommit, MinVersion: 0, MaxVersion: 8}
-	apiRespVersions[5] = &codec.ApiRespVersion{ApiKey: codec.OffsetFetch, MinVersion: 0, MaxVersion: 7}
-	apiRespVersions[6] = &codec.ApiRespVersion{ApiKey: codec.FindCoordinator, MinVersion: 0, MaxVersion: 3}
-	apiRespVersions[7] = &codec.ApiRespVersion{ApiKey: codec.JoinGroup, MinVersion: 0, MaxVersion: 6}
-	apiRespVersions[8] = &codec.ApiRespVersion{ApiKey: codec.Heartbeat, MinVersion: 0, MaxVersion: 4}
-	apiRespVersions[9] = &codec.ApiRespVersion{ApiKey: codec.LeaveGroup, MinVersion: 0, MaxVersion: 4}
-	apiRespVersions[10] = &codec.ApiRespVersion{ApiKey: codec.SyncGroup, MinVersion: 0, MaxVersion: 5}
-	apiRespVersions[11] = &codec.ApiRespVersion{ApiKey: codec.DescribeGroups, MinVersion: 0, MaxVersion: 5}
-	apiRespVersions[12] = &codec.ApiRespVersion{ApiKey: codec.ListGroups, MinVersion: 0, MaxVersion: 4}
-	apiRespVersions[13] = &codec.ApiRespVersion{ApiKey: codec.SaslHandshake, MinVersion: 0, MaxVersion: 1}
-	apiRespVersions[14] = &codec.ApiRespVersion{ApiKey: codec.ApiVersions, MinVersion: 0, MaxVersion: 3}
-	apiRespVersions[15] = &codec.ApiRespVersion{ApiKey: codec.DeleteRecords, MinVersion: 0, MaxVersion: 2}
-	apiRespVersions[16] = &codec.ApiRespVersion{ApiKey: codec.OffsetForLeaderEpoch, MinVersion: 0, MaxVersion: 4}
-	apiRespVersions[17] = &codec.ApiRespVersion{ApiKey: codec.SaslAuthenticate, MinVersion: 0, MaxVersion: 2}
-	resp.ApiRespVersions = apiRespVersions
+	resp.ApiRespVersions = apiRespVersions(s.kafkaProtocolConfig.MaxApiVersions)
 	resp.ThrottleTime = 0
 	return &resp, gnet.None
 }
+
+// apiRespVersions cap the supported max version by maxApiVersions, snapped down to a version the handler decodes.
+// the api is not advertised if the cap is below its min version
+func apiRespVersions(maxApiVersions map[codec.ApiCode]int16) []*codec.ApiRespVersion {
+	apiRespVersions := make([]*codec.ApiRespVersion, 0, len(supportedApiVersions))
+	for _, supported := range supportedApiVersions {
+		versions := supported.versions
+		if maxVersion, exist := maxApiVersions[supported.apiKey]; exist {
+			for len(versions) > 0 && versions[len(versions)-1] > maxVersion {
+				versions = versions[:len(versions)-1]
+			}
+			if len(versions) == 0 {
+				logrus.Warnf("max version %d of api %d is lower than min version %d, skip it", maxVersion, supported.apiKey, supported.versions[0])
+				continue
+			}
+		}
+		apiRespVersions = append(apiRespVersions, &codec.ApiRespVersion{
+			ApiKey:     supported.apiKey,
+			MinVersion: versions[0],
+			MaxVersion: versions[len(versions)-1],
+		})
+	}
+	return apiRespVersions
+}
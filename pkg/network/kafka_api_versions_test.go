@@ -0,0 +1,73 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestApiRespVersions(t *testing.T) {
+	versions := apiRespVersions(nil)
+	assert.Equal(t, len(supportedApiVersions), len(versions))
+	for _, version := range versions {
+		if version.ApiKey == codec.Fetch {
+			assert.Equal(t, int16(10), version.MinVersion)
+			assert.Equal(t, int16(11), version.MaxVersion)
+		}
+	}
+}
+
+func TestApiRespVersionsWithMaxVersion(t *testing.T) {
+	versions := apiRespVersions(map[codec.ApiCode]int16{codec.Metadata: 5, codec.Fetch: 10, codec.Produce: 3})
+	assert.Equal(t, len(supportedApiVersions)-1, len(versions))
+	for _, version := range versions {
+		switch version.ApiKey {
+		case codec.Metadata:
+			assert.Equal(t, int16(5), version.MaxVersion)
+		case codec.Fetch:
+			assert.Equal(t, int16(10), version.MaxVersion)
+		case codec.Produce:
+			t.Fatal("produce max version is lower than min version, should not be advertised")
+		}
+	}
+	// the supported versions must not be changed
+	assert.Equal(t, int16(9), apiRespVersions(nil)[3].MaxVersion)
+}
+
+func TestApiRespVersionsSnapMaxVersion(t *testing.T) {
+	// the cap falls in a gap of the versions the handler decodes
+	versions := apiRespVersions(map[codec.ApiCode]int16{codec.JoinGroup: 4, codec.OffsetFetch: 5, codec.SyncGroup: 4})
+	for _, version := range versions {
+		switch version.ApiKey {
+		case codec.JoinGroup:
+			assert.Equal(t, int16(1), version.MaxVersion)
+		case codec.OffsetFetch:
+			assert.Equal(t, int16(1), version.MaxVersion)
+		case codec.SyncGroup:
+			assert.Equal(t, int16(4), version.MaxVersion)
+		}
+	}
+	// every advertised min and max version is accepted by the handler
+	for _, version := range apiRespVersions(nil) {
+		assert.True(t, supportsVersion(version.ApiKey, version.MinVersion))
+		assert.True(t, supportsVersion(version.ApiKey, version.MaxVersion))
+	}
+	assert.False(t, supportsVersion(codec.JoinGroup, 4))
+}
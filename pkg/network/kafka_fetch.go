@@ -29,16 +29,31 @@ func (s *Server) ReactFetch(ctx *ctx.NetworkContext, req *codec.FetchReq) (*code
 		return nil, gnet.Close
 	}
 	logrus.Debug("fetch req ", req)
+	var deniedTopics []*codec.FetchTopicReq
+	authorizedTopics := make([]*codec.FetchTopicReq, 0, len(req.TopicReqList))
 	for _, topicReq := range req.TopicReqList {
 		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
-			return nil, gnet.Close
+			deniedTopics = append(deniedTopics, topicReq)
+			continue
 		}
+		authorizedTopics = append(authorizedTopics, topicReq)
 	}
-	lowTopicRespList, err := s.kafsarImpl.Fetch(ctx.Addr, req)
+	if len(deniedTopics) > 0 {
+		authorizedReq := *req
+		authorizedReq.TopicReqList = authorizedTopics
+		req = &authorizedReq
+	}
+	if !ctx.AcquireInflight(s.kafkaProtocolConfig.MaxInflightRequestsPerConn) {
+		logrus.Warnf("%s reach max in-flight requests %d, reject fetch", ctx.Addr, s.kafkaProtocolConfig.MaxInflightRequestsPerConn)
+		return s.fetchErrorResp(req, codec.REQUEST_TIMED_OUT), gnet.None
+	}
+	defer ctx.ReleaseInflight(s.kafkaProtocolConfig.MaxInflightRequestsPerConn)
+	lowTopicRespList, err := s.kafsarImpl.Fetch(ctx.Context(), ctx.Addr, req)
 	if err != nil {
 		return nil, gnet.Close
 	}
 	resp := codec.NewFetchResp(req.CorrelationId)
+	resp.ThrottleTime = s.kafsarImpl.ThrottleTimeMs(ctx.Addr, codec.Fetch)
 	resp.TopicRespList = lowTopicRespList
 	for i, lowTopicResp := range lowTopicRespList {
 		for _, p := range lowTopicResp.PartitionRespList {
@@ -48,6 +63,10 @@ func (s *Server) ReactFetch(ctx *ctx.NetworkContext, req *codec.FetchReq) (*code
 		}
 		resp.TopicRespList[i] = lowTopicResp
 	}
+	if len(deniedTopics) > 0 {
+		deniedResp := s.fetchErrorResp(&codec.FetchReq{TopicReqList: deniedTopics}, codec.TOPIC_AUTHORIZATION_FAILED)
+		resp.TopicRespList = append(resp.TopicRespList, deniedResp.TopicRespList...)
+	}
 	return resp, gnet.None
 }
 
@@ -67,3 +86,25 @@ func (s *Server) convertRecordBatchResp(lowRecordBatch *codec.RecordBatch) *code
 		Records:         lowRecordBatch.Records,
 	}
 }
+
+func (s *Server) fetchErrorResp(req *codec.FetchReq, errorCode codec.ErrorCode) *codec.FetchResp {
+	resp := codec.NewFetchResp(req.CorrelationId)
+	resp.TopicRespList = make([]*codec.FetchTopicResp, len(req.TopicReqList))
+	for i, topicReq := range req.TopicReqList {
+		topicResp := &codec.FetchTopicResp{
+			Topic:             topicReq.Topic,
+			PartitionRespList: make([]*codec.FetchPartitionResp, len(topicReq.PartitionReqList)),
+		}
+		for j, partitionReq := range topicReq.PartitionReqList {
+			topicResp.PartitionRespList[j] = &codec.FetchPartitionResp{
+				PartitionIndex:   partitionReq.PartitionId,
+				ErrorCode:        errorCode,
+				HighWatermark:    -1,
+				LastStableOffset: -1,
+				LogStartOffset:   -1,
+			}
+		}
+		resp.TopicRespList[i] = topicResp
+	}
+	return resp
+}
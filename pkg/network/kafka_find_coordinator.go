@@ -18,21 +18,20 @@
 package network
 
 import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
 	"github.com/panjf2000/gnet"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
 	"github.com/sirupsen/logrus"
 )
 
-func (s *Server) ReactFindCoordinator(req *codec.FindCoordinatorReq, config *KafkaProtocolConfig) (*codec.FindCoordinatorResp, gnet.Action) {
+func (s *Server) ReactFindCoordinator(ctx *ctx.NetworkContext, req *codec.FindCoordinatorReq) (*codec.FindCoordinatorResp, gnet.Action) {
 	logrus.Debug("req ", req)
-	resp := &codec.FindCoordinatorResp{
-		BaseResp: codec.BaseResp{
-			CorrelationId: req.CorrelationId,
-		},
-		NodeId: config.NodeId,
-		Host:   config.AdvertiseHost,
-		Port:   config.AdvertisePort,
+	resp, err := s.kafsarImpl.FindCoordinator(ctx.Addr, req)
+	if err != nil {
+		logrus.Errorf("find coordinator failed. key: %s, err: %s", req.Key, err)
+		resp = &codec.FindCoordinatorResp{ErrorCode: codec.COORDINATOR_NOT_AVAILABLE}
 	}
+	resp.CorrelationId = req.CorrelationId
 	logrus.Debug("resp ", resp)
 	return resp, gnet.None
 }
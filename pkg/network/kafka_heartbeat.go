@@ -26,6 +26,14 @@ import (
 
 func (s *Server) ReactHeartbeat(heartbeatReqV4 *codec.HeartbeatReq, context *ctx.NetworkContext) (*codec.HeartbeatResp, gnet.Action) {
 	logrus.Debug("heart beat req ", heartbeatReqV4)
+	if !s.checkSaslGroup(context, heartbeatReqV4.GroupId) {
+		return &codec.HeartbeatResp{
+			BaseResp: codec.BaseResp{
+				CorrelationId: heartbeatReqV4.CorrelationId,
+			},
+			ErrorCode: codec.GROUP_AUTHORIZATION_FAILED,
+		}, gnet.None
+	}
 	beat := s.kafsarImpl.HeartBeat(context.Addr, *heartbeatReqV4)
 	return &codec.HeartbeatResp{
 		BaseResp: codec.BaseResp{
@@ -26,7 +26,12 @@ import (
 
 func (s *Server) ReactJoinGroup(ctx *ctx.NetworkContext, req *codec.JoinGroupReq) (*codec.JoinGroupResp, gnet.Action) {
 	if !s.checkSaslGroup(ctx, req.GroupId) {
-		return nil, gnet.Close
+		return &codec.JoinGroupResp{
+			BaseResp: codec.BaseResp{
+				CorrelationId: req.CorrelationId,
+			},
+			ErrorCode: codec.GROUP_AUTHORIZATION_FAILED,
+		}, gnet.None
 	}
 	logrus.Debug("join group req", req)
 	lowResp, err := s.kafsarImpl.GroupJoin(ctx.Addr, req)
@@ -26,7 +26,12 @@ import (
 
 func (s *Server) ReactLeaveGroup(ctx *ctx.NetworkContext, req *codec.LeaveGroupReq) (*codec.LeaveGroupResp, gnet.Action) {
 	if !s.checkSaslGroup(ctx, req.GroupId) {
-		return nil, gnet.Close
+		return &codec.LeaveGroupResp{
+			BaseResp: codec.BaseResp{
+				CorrelationId: req.CorrelationId,
+			},
+			ErrorCode: codec.GROUP_AUTHORIZATION_FAILED,
+		}, gnet.None
 	}
 	logrus.Debug("leave group req ", req)
 
@@ -31,13 +31,22 @@ func (s *Server) ListOffsetsVersion(ctx *ctx.NetworkContext, req *codec.ListOffs
 	logrus.Debug("list offset req ", req)
 	resp := make([]*codec.ListOffsetsTopicResp, len(req.TopicReqList))
 	for i, topicReq := range req.TopicReqList {
-		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
-			return nil, gnet.Close
-		}
 		f := &codec.ListOffsetsTopicResp{
 			Topic:             topicReq.Topic,
 			PartitionRespList: make([]*codec.ListOffsetsPartitionResp, len(topicReq.PartitionReqList)),
 		}
+		resp[i] = f
+		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
+			for j, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList[j] = &codec.ListOffsetsPartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					ErrorCode:   codec.TOPIC_AUTHORIZATION_FAILED,
+					Timestamp:   -1,
+					Offset:      -1,
+				}
+			}
+			continue
+		}
 		for j, partitionReq := range topicReq.PartitionReqList {
 			var err error
 			f.PartitionRespList[j], err = s.kafsarImpl.OffsetListPartition(ctx.Addr, f.Topic, req.ClientId, partitionReq)
@@ -45,7 +54,6 @@ func (s *Server) ListOffsetsVersion(ctx *ctx.NetworkContext, req *codec.ListOffs
 				return nil, gnet.Close
 			}
 		}
-		resp[i] = f
 	}
 	return &codec.ListOffsetsResp{
 		BaseResp: codec.BaseResp{
@@ -39,8 +39,13 @@ func (s *Server) ReactMetadata(ctx *ctx.NetworkContext, req *codec.MetadataReq,
 		logrus.Warn("request metadata topic length is 0", ctx.Addr)
 		list, err := s.kafsarImpl.TopicList(ctx.Addr)
 		if err != nil {
+			// backend failed, close the connection so that the client retries, instead of answering no topics
+			logrus.Errorf("list topics failed, close connection %s. err: %s", ctx.Addr, err)
 			return nil, gnet.Close
 		}
+		if len(list) == 0 {
+			logrus.Infof("no authorized topics for %s", ctx.Addr)
+		}
 		topicList = list
 	}
 
@@ -62,7 +67,6 @@ func (s *Server) ReactMetadata(ctx *ctx.NetworkContext, req *codec.MetadataReq,
 			topicMetadata.PartitionMetadataList = make([]*codec.PartitionMetadata, 0)
 			metadataResp.TopicMetadataList[index] = &topicMetadata
 		} else {
-			metadataResp.TopicMetadataList = make([]*codec.TopicMetadata, 1)
 			topicMetadata := codec.TopicMetadata{ErrorCode: 0, Topic: topic, IsInternal: false, TopicAuthorizedOperation: -2147483648}
 			topicMetadata.PartitionMetadataList = make([]*codec.PartitionMetadata, partitionNum)
 			for i := 0; i < partitionNum; i++ {
@@ -0,0 +1,67 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"errors"
+	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
+	"github.com/panjf2000/gnet"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+type topicListKafsarServer struct {
+	KafsarServer
+	topics []string
+	err    error
+}
+
+func (t *topicListKafsarServer) TopicList(addr net.Addr) ([]string, error) {
+	return t.topics, t.err
+}
+
+func (t *topicListKafsarServer) PartitionNum(addr net.Addr, topic string) (int, error) {
+	return 1, nil
+}
+
+func TestMetadataTopicList(t *testing.T) {
+	impl := &topicListKafsarServer{topics: make([]string, 0)}
+	config := &KafkaProtocolConfig{}
+	server := &Server{kafkaProtocolConfig: config, kafsarImpl: impl}
+	networkContext := &ctx.NetworkContext{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	metadataReq := &codec.MetadataReq{}
+
+	// authorized to see nothing
+	resp, action := server.ReactMetadata(networkContext, metadataReq, config)
+	assert.Equal(t, gnet.None, action)
+	assert.Empty(t, resp.TopicMetadataList)
+
+	impl.topics = []string{"topic-a", "topic-b"}
+	resp, action = server.ReactMetadata(networkContext, metadataReq, config)
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, 2, len(resp.TopicMetadataList))
+	assert.Equal(t, "topic-a", resp.TopicMetadataList[0].Topic)
+	assert.Equal(t, "topic-b", resp.TopicMetadataList[1].Topic)
+
+	// backend failed
+	impl.err = errors.New("backend failed")
+	_, action = server.ReactMetadata(networkContext, metadataReq, config)
+	assert.Equal(t, gnet.Close, action)
+}
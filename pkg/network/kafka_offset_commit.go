@@ -29,15 +29,29 @@ func (s *Server) OffsetCommitVersion(ctx *ctx.NetworkContext, req *codec.OffsetC
 		return nil, gnet.Close
 	}
 	logrus.Debug("offset commit req ", req)
+	groupAuthed := s.checkSaslGroup(ctx, req.GroupId)
 	resp := make([]*codec.OffsetCommitTopicResp, len(req.TopicReqList))
 	for i, topicReq := range req.TopicReqList {
-		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
-			return nil, gnet.Close
-		}
 		f := &codec.OffsetCommitTopicResp{
 			Topic:             topicReq.Topic,
 			PartitionRespList: make([]*codec.OffsetCommitPartitionResp, len(topicReq.PartitionReqList)),
 		}
+		resp[i] = f
+		errorCode := codec.NONE
+		if !groupAuthed {
+			errorCode = codec.GROUP_AUTHORIZATION_FAILED
+		} else if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
+			errorCode = codec.TOPIC_AUTHORIZATION_FAILED
+		}
+		if errorCode != codec.NONE {
+			for j, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList[j] = &codec.OffsetCommitPartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					ErrorCode:   errorCode,
+				}
+			}
+			continue
+		}
 		for j, partitionReq := range topicReq.PartitionReqList {
 			var err error
 			f.PartitionRespList[j], err = s.kafsarImpl.OffsetCommitPartition(ctx.Addr, topicReq.Topic, req.ClientId, partitionReq)
@@ -45,7 +59,6 @@ func (s *Server) OffsetCommitVersion(ctx *ctx.NetworkContext, req *codec.OffsetC
 				return nil, gnet.Close
 			}
 		}
-		resp[i] = f
 	}
 	return &codec.OffsetCommitResp{
 		BaseResp: codec.BaseResp{
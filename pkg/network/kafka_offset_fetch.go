@@ -35,14 +35,28 @@ func (s *Server) OffsetFetchVersion(ctx *ctx.NetworkContext, req *codec.OffsetFe
 		},
 		TopicRespList: make([]*codec.OffsetFetchTopicResp, len(req.TopicReqList)),
 	}
+	if !s.checkSaslGroup(ctx, req.GroupId) {
+		resp.ErrorCode = codec.GROUP_AUTHORIZATION_FAILED
+		resp.TopicRespList = resp.TopicRespList[:0]
+		return resp, gnet.None
+	}
 	for i, topicReq := range req.TopicReqList {
-		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
-			return nil, gnet.Close
-		}
 		f := &codec.OffsetFetchTopicResp{
 			Topic:             topicReq.Topic,
 			PartitionRespList: make([]*codec.OffsetFetchPartitionResp, 0),
 		}
+		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
+			for _, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList = append(f.PartitionRespList, &codec.OffsetFetchPartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					Offset:      -1,
+					LeaderEpoch: -1,
+					ErrorCode:   codec.TOPIC_AUTHORIZATION_FAILED,
+				})
+			}
+			resp.TopicRespList[i] = f
+			continue
+		}
 		for _, partitionReq := range topicReq.PartitionReqList {
 			partition, err := s.kafsarImpl.OffsetFetch(ctx.Addr, topicReq.Topic, req.ClientId, req.GroupId, partitionReq)
 			if err != nil {
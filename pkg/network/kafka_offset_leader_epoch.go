@@ -29,13 +29,22 @@ func (s *Server) OffsetForLeaderEpochVersion(ctx *ctx.NetworkContext, req *codec
 	}
 	resp := make([]*codec.OffsetForLeaderEpochTopicResp, len(req.TopicReqList))
 	for i, topicReq := range req.TopicReqList {
-		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
-			return nil, gnet.Close
-		}
 		f := &codec.OffsetForLeaderEpochTopicResp{
 			Topic:             topicReq.Topic,
 			PartitionRespList: make([]*codec.OffsetForLeaderEpochPartitionResp, 0),
 		}
+		if !s.checkSaslTopic(ctx, topicReq.Topic, CONSUMER_PERMISSION_TYPE) {
+			for _, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList = append(f.PartitionRespList, &codec.OffsetForLeaderEpochPartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					ErrorCode:   codec.TOPIC_AUTHORIZATION_FAILED,
+					LeaderEpoch: -1,
+					Offset:      -1,
+				})
+			}
+			resp[i] = f
+			continue
+		}
 		for _, partitionReq := range topicReq.PartitionReqList {
 			partition, err := s.kafsarImpl.OffsetLeaderEpoch(ctx.Addr, topicReq.Topic, partitionReq)
 			if err != nil {
@@ -25,6 +25,7 @@ import (
 )
 
 func (s *Server) ReactProduce(ctx *ctx.NetworkContext, req *codec.ProduceReq, config *KafkaProtocolConfig) (*codec.ProduceResp, gnet.Action) {
+	batches := ctx.TakeProduceBatches()
 	if !s.checkSasl(ctx) {
 		return nil, gnet.Close
 	}
@@ -35,16 +36,46 @@ func (s *Server) ReactProduce(ctx *ctx.NetworkContext, req *codec.ProduceReq, co
 		},
 		TopicRespList: make([]*codec.ProduceTopicResp, len(req.TopicReqList)),
 	}
-	for i, topicReq := range req.TopicReqList {
-		if !s.checkSaslTopic(ctx, topicReq.Topic, PRODUCER_PERMISSION_TYPE) {
-			return nil, gnet.Close
+	if !ctx.AcquireInflight(config.MaxInflightRequestsPerConn) {
+		logrus.Warnf("%s reach max in-flight requests %d, reject produce", ctx.Addr, config.MaxInflightRequestsPerConn)
+		for i, topicReq := range req.TopicReqList {
+			f := &codec.ProduceTopicResp{
+				Topic:             topicReq.Topic,
+				PartitionRespList: make([]*codec.ProducePartitionResp, len(topicReq.PartitionReqList)),
+			}
+			for j, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList[j] = &codec.ProducePartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					ErrorCode:   codec.REQUEST_TIMED_OUT,
+					Offset:      -1,
+					Time:        -1,
+				}
+			}
+			result.TopicRespList[i] = f
 		}
+		return result, gnet.None
+	}
+	defer ctx.ReleaseInflight(config.MaxInflightRequestsPerConn)
+	for i, topicReq := range req.TopicReqList {
 		f := &codec.ProduceTopicResp{
 			Topic:             topicReq.Topic,
 			PartitionRespList: make([]*codec.ProducePartitionResp, 0),
 		}
-		for _, partitionReq := range topicReq.PartitionReqList {
-			partition, err := s.kafsarImpl.Produce(ctx.Addr, topicReq.Topic, partitionReq.PartitionId, partitionReq)
+		if !s.checkSaslTopic(ctx, topicReq.Topic, PRODUCER_PERMISSION_TYPE) {
+			for _, partitionReq := range topicReq.PartitionReqList {
+				f.PartitionRespList = append(f.PartitionRespList, &codec.ProducePartitionResp{
+					PartitionId: partitionReq.PartitionId,
+					ErrorCode:   codec.TOPIC_AUTHORIZATION_FAILED,
+					Offset:      -1,
+					Time:        -1,
+				})
+			}
+			result.TopicRespList[i] = f
+			continue
+		}
+		for j, partitionReq := range topicReq.PartitionReqList {
+			partition, err := s.kafsarImpl.ProduceBatches(ctx.Context(), ctx.Addr, topicReq.Topic, partitionReq.PartitionId, req.Timeout,
+				partitionBatches(batches, i, j, partitionReq))
 			if err != nil {
 				return nil, gnet.Close
 			}
@@ -54,5 +85,15 @@ func (s *Server) ReactProduce(ctx *ctx.NetworkContext, req *codec.ProduceReq, co
 		}
 		result.TopicRespList[i] = f
 	}
+	result.ThrottleTime = s.kafsarImpl.ThrottleTimeMs(ctx.Addr, codec.Produce)
 	return result, gnet.None
 }
+
+// partitionBatches the record batches of the partition j of the topic i, batches holds them when the partition has more
+// than one, otherwise the codec decoded the only one
+func partitionBatches(batches [][][]*codec.RecordBatch, i, j int, partitionReq *codec.ProducePartitionReq) []*codec.RecordBatch {
+	if i < len(batches) && j < len(batches[i]) && batches[i][j] != nil {
+		return batches[i][j]
+	}
+	return []*codec.RecordBatch{partitionReq.RecordBatch}
+}
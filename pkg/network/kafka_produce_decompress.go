@@ -0,0 +1,260 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/klauspost/compress/zstd"
+	"github.com/paashzj/kafka_go_pulsar/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"hash/crc32"
+)
+
+const (
+	// recordBatchHeaderLength bytes from the base offset to the records count, the records follow
+	recordBatchHeaderLength = 61
+	// recordBatchAttributesIdx the index of the attributes in the record batch
+	recordBatchAttributesIdx = 21
+	// recordBatchCrcIdx the index of the crc in the record batch, the crc covers the attributes to the end
+	recordBatchCrcIdx = 17
+	// recordBatchLengthIdx the index of the batch length, the length counts the bytes after it
+	recordBatchLengthIdx = 8
+
+	compressionCodecMask = 0x07
+	compressionZstd      = 4
+)
+
+var (
+	errInvalidProduceFrame  = errors.New("invalid produce frame")
+	errDecompressedTooLarge = errors.New("decompressed record batches too large")
+	crc32cTable             = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// zstdDecompressor decompress the zstd compressed record batches of produce frames, at most maxBytes per frame
+type zstdDecompressor struct {
+	decoder  *zstd.Decoder
+	maxBytes int
+}
+
+func newZstdDecompressor(maxBytes int) (*zstdDecompressor, error) {
+	if maxBytes <= 0 {
+		maxBytes = constant.DefaultMaxMessageBytes
+	}
+	// the decoder refuses to allocate beyond the limit, a small frame may claim a huge decompressed size
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+	if err != nil {
+		return nil, errors.Wrap(err, "create zstd decoder failed")
+	}
+	return &zstdDecompressor{decoder: decoder, maxBytes: maxBytes}, nil
+}
+
+func (d *zstdDecompressor) close() {
+	d.decoder.Close()
+}
+
+// prepareProduce rewrite the zstd compressed record batches of a produce frame to uncompressed ones and split the
+// record batches of the partitions. the codec decodes the records as uncompressed and only the first batch of a
+// partition, so batches holds every batch of the partitions by topic index and partition index, it is nil if no
+// partition has more than one batch. frames without zstd batches are returned as is
+func (d *zstdDecompressor) prepareProduce(frame []byte) ([]byte, [][][]*codec.RecordBatch, error) {
+	version := int16(binary.BigEndian.Uint16(frame[2:]))
+	if version != 7 && version != 8 {
+		return frame, nil, nil
+	}
+	reader := &frameReader{frame: frame}
+	// api key, api version, correlation id
+	reader.skip(8)
+	// client id, transactional id
+	reader.skipString()
+	reader.skipString()
+	// acks, timeout
+	reader.skip(6)
+	buf := &bytes.Buffer{}
+	changed := false
+	remaining := d.maxBytes
+	var batches [][][]*codec.RecordBatch
+	topics := reader.int32()
+	for i := 0; i < int(topics) && reader.err == nil; i++ {
+		reader.skipString()
+		partitions := reader.int32()
+		for j := 0; j < int(partitions) && reader.err == nil; j++ {
+			// partition index
+			reader.skip(4)
+			recordsLengthIdx := reader.idx
+			records := reader.bytes()
+			if reader.err != nil || records == nil {
+				break
+			}
+			recordBatches, compressed, err := d.splitRecords(records, &remaining)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(recordBatches) > 1 {
+				decoded, err := decodeRecordBatches(recordBatches, version)
+				if err != nil {
+					return nil, nil, err
+				}
+				for len(batches) <= i {
+					batches = append(batches, nil)
+				}
+				for len(batches[i]) <= j {
+					batches[i] = append(batches[i], nil)
+				}
+				batches[i][j] = decoded
+			}
+			if !compressed {
+				continue
+			}
+			if !changed {
+				changed = true
+				buf.Grow(len(frame))
+			}
+			buf.Write(frame[reader.flushed:recordsLengthIdx])
+			decompressed := bytes.Join(recordBatches, nil)
+			_ = binary.Write(buf, binary.BigEndian, int32(len(decompressed)))
+			buf.Write(decompressed)
+			reader.flushed = reader.idx
+		}
+	}
+	if reader.err != nil {
+		return nil, nil, reader.err
+	}
+	if !changed {
+		return frame, batches, nil
+	}
+	buf.Write(frame[reader.flushed:])
+	return buf.Bytes(), batches, nil
+}
+
+// splitRecords split the record batches of a partition, the zstd ones are decompressed. compressed is false if none
+// is zstd compressed. remaining the bytes the frame may still decompress to
+func (d *zstdDecompressor) splitRecords(records []byte, remaining *int) (batches [][]byte, compressed bool, err error) {
+	idx := 0
+	for idx < len(records) {
+		if len(records)-idx < recordBatchHeaderLength {
+			return nil, false, errInvalidProduceFrame
+		}
+		batchLength := int(int32(binary.BigEndian.Uint32(records[idx+recordBatchLengthIdx:])))
+		end := idx + recordBatchLengthIdx + 4 + batchLength
+		if batchLength < recordBatchHeaderLength-recordBatchLengthIdx-4 || end > len(records) {
+			return nil, false, errInvalidProduceFrame
+		}
+		batch := records[idx:end]
+		idx = end
+		attributes := binary.BigEndian.Uint16(batch[recordBatchAttributesIdx:])
+		if attributes&compressionCodecMask != compressionZstd {
+			batches = append(batches, batch)
+			continue
+		}
+		value, err := d.decoder.DecodeAll(batch[recordBatchHeaderLength:], nil)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "decompress zstd record batch failed")
+		}
+		*remaining -= len(value)
+		if *remaining < 0 {
+			return nil, false, errors.Wrapf(errDecompressedTooLarge, "limit %d bytes", d.maxBytes)
+		}
+		compressed = true
+		rewritten := make([]byte, recordBatchHeaderLength+len(value))
+		copy(rewritten, batch[:recordBatchHeaderLength])
+		copy(rewritten[recordBatchHeaderLength:], value)
+		binary.BigEndian.PutUint32(rewritten[recordBatchLengthIdx:], uint32(len(rewritten)-recordBatchLengthIdx-4))
+		binary.BigEndian.PutUint16(rewritten[recordBatchAttributesIdx:], attributes&^compressionCodecMask)
+		binary.BigEndian.PutUint32(rewritten[recordBatchCrcIdx:], crc32.Checksum(rewritten[recordBatchAttributesIdx:], crc32cTable))
+		batches = append(batches, rewritten)
+	}
+	return batches, compressed, nil
+}
+
+// decodeRecordBatches decode the uncompressed record batches of a partition, the codec panics on malformed batches
+func decodeRecordBatches(batches [][]byte, version int16) (recordBatches []*codec.RecordBatch, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			recordBatches = nil
+			err = errInvalidProduceFrame
+		}
+	}()
+	recordBatches = make([]*codec.RecordBatch, len(batches))
+	for i, batch := range batches {
+		recordBatches[i] = codec.DecodeRecordBatch(batch, version)
+	}
+	return recordBatches, nil
+}
+
+// frameReader walk the fields of a kafka request frame, the first error stops reading
+type frameReader struct {
+	frame []byte
+	idx   int
+	// flushed the frame before it is copied to the rewritten frame
+	flushed int
+	err     error
+}
+
+func (f *frameReader) skip(n int) {
+	if f.err != nil {
+		return
+	}
+	if n < 0 || f.idx+n > len(f.frame) {
+		f.err = errInvalidProduceFrame
+		return
+	}
+	f.idx += n
+}
+
+func (f *frameReader) int32() int32 {
+	start := f.idx
+	f.skip(4)
+	if f.err != nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(f.frame[start:]))
+}
+
+// skipString skip a nullable string, its length is int16 and -1 means null
+func (f *frameReader) skipString() {
+	start := f.idx
+	f.skip(2)
+	if f.err != nil {
+		return
+	}
+	length := int16(binary.BigEndian.Uint16(f.frame[start:]))
+	if length > 0 {
+		f.skip(int(length))
+	}
+}
+
+// bytes read nullable bytes, its length is int32 and -1 means null
+func (f *frameReader) bytes() []byte {
+	length := f.int32()
+	if f.err != nil || length < 0 {
+		return nil
+	}
+	start := f.idx
+	f.skip(int(length))
+	if f.err != nil {
+		return nil
+	}
+	return f.frame[start:f.idx]
+}
+
+// isProduce the frame is a produce request
+func isProduce(frame []byte) bool {
+	return len(frame) >= 4 && codec.ApiCode(binary.BigEndian.Uint16(frame)) == codec.Produce
+}
@@ -0,0 +1,224 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/protocol-laboratory/kafka-codec-go/kgnet"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingKafsarServer record the values of the produced records
+type recordingKafsarServer struct {
+	KafsarServer
+	mutex  sync.Mutex
+	values []string
+}
+
+func (r *recordingKafsarServer) ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, batch := range batches {
+		for _, record := range batch.Records {
+			r.values = append(r.values, string(record.Value))
+		}
+	}
+	return &codec.ProducePartitionResp{PartitionId: partition, ErrorCode: codec.NONE}, nil
+}
+
+func (r *recordingKafsarServer) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	return 0
+}
+
+func (r *recordingKafsarServer) Disconnect(addr net.Addr) {
+}
+
+func TestProduceZstdCompressed(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	impl := &recordingKafsarServer{}
+	server, err := NewServer(&kgnet.GnetServerConfig{ListenHost: "localhost", ListenPort: port, EventLoopNum: 1},
+		&KafkaProtocolConfig{MaxConn: 10}, impl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, server.Run())
+	defer server.Close(context.Background())
+	assert.Eventually(t, server.Accepting, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := kafka.DefaultDialer.DialPartition(ctx, "tcp", "", kafka.Partition{
+		Topic:  "topic",
+		ID:     0,
+		Leader: kafka.Broker{Host: "localhost", Port: port},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	messages := make([]kafka.Message, 10)
+	expected := make([]string, len(messages))
+	for i := range messages {
+		expected[i] = fmt.Sprintf("zstd-compressed-value-%d", i)
+		messages[i] = kafka.Message{Value: []byte(expected[i])}
+	}
+	_, err = conn.WriteCompressedMessages(compress.Zstd.Codec(), messages...)
+	assert.Nil(t, err)
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+	assert.Equal(t, expected, impl.values)
+}
+
+func TestPrepareProduceUncompressed(t *testing.T) {
+	req := &codec.ProduceReq{
+		BaseReq:      codec.BaseReq{ApiVersion: 7},
+		ClientId:     "client",
+		RequiredAcks: -1,
+		Timeout:      3000,
+		TopicReqList: []*codec.ProduceTopicReq{{
+			Topic: "topic",
+			PartitionReqList: []*codec.ProducePartitionReq{{
+				RecordBatch: &codec.RecordBatch{Records: []*codec.Record{{Value: []byte("value")}}},
+			}},
+		}},
+	}
+	frame := req.Bytes(false, true)
+	decompressor, err := newZstdDecompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decompressor.close()
+	prepared, batches, err := decompressor.prepareProduce(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, prepared)
+	assert.Nil(t, batches)
+
+	_, _, err = decompressor.prepareProduce(frame[:len(frame)-10])
+	assert.NotNil(t, err)
+}
+
+func TestProduceZstdDecompressedTooLarge(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	impl := &recordingKafsarServer{}
+	server, err := NewServer(&kgnet.GnetServerConfig{ListenHost: "localhost", ListenPort: port, EventLoopNum: 1},
+		&KafkaProtocolConfig{MaxConn: 10, MaxDecompressedBytes: 64 * 1024}, impl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, server.Run())
+	defer server.Close(context.Background())
+	assert.Eventually(t, server.Accepting, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := kafka.DefaultDialer.DialPartition(ctx, "tcp", "", kafka.Partition{
+		Topic:  "topic",
+		ID:     0,
+		Leader: kafka.Broker{Host: "localhost", Port: port},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	// a megabyte of zeros compresses to a few bytes
+	_, err = conn.WriteCompressedMessages(compress.Zstd.Codec(), kafka.Message{Value: make([]byte, 1024*1024)})
+	assert.NotNil(t, err)
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+	assert.Empty(t, impl.values)
+}
+
+func TestProduceMultipleBatches(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	impl := &recordingKafsarServer{}
+	server, err := NewServer(&kgnet.GnetServerConfig{ListenHost: "localhost", ListenPort: port, EventLoopNum: 1},
+		&KafkaProtocolConfig{MaxConn: 10}, impl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, server.Run())
+	defer server.Close(context.Background())
+	assert.Eventually(t, server.Accepting, 5*time.Second, 10*time.Millisecond)
+
+	first := &codec.RecordBatch{MagicByte: 2, Records: []*codec.Record{{Value: []byte("a")}, {Value: []byte("b")}}}
+	second := &codec.RecordBatch{MagicByte: 2, Records: []*codec.Record{{Value: []byte("c")}}}
+	req := &codec.ProduceReq{
+		BaseReq:      codec.BaseReq{ApiVersion: 7, CorrelationId: 1},
+		ClientId:     "client",
+		RequiredAcks: -1,
+		Timeout:      3000,
+		TopicReqList: []*codec.ProduceTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.ProducePartitionReq{{RecordBatch: first}},
+		}},
+	}
+	// the records of the only partition end the frame, append the second batch to them
+	frame := req.Bytes(false, true)
+	secondBytes := second.Bytes()
+	recordsLengthIdx := len(frame) - first.BytesLength() - 4
+	binary.BigEndian.PutUint32(frame[recordsLengthIdx:], uint32(first.BytesLength()+len(secondBytes)))
+	frame = append(frame, secondBytes...)
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(frame)))
+	_, err = conn.Write(append(length, frame...))
+	assert.Nil(t, err)
+	_, err = io.ReadFull(conn, length)
+	assert.Nil(t, err)
+	respBytes := make([]byte, binary.BigEndian.Uint32(length))
+	_, err = io.ReadFull(conn, respBytes)
+	assert.Nil(t, err)
+	resp, err := codec.DecodeProduceResp(respBytes, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+	assert.Equal(t, []string{"a", "b", "c"}, impl.values)
+}
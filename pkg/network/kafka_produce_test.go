@@ -0,0 +1,157 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"context"
+	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blockingKafsarServer struct {
+	KafsarServer
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingKafsarServer) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	return 0
+}
+
+func (b *blockingKafsarServer) ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return &codec.ProducePartitionResp{PartitionId: partition, ErrorCode: codec.NONE}, nil
+}
+
+func TestProduceInflightLimit(t *testing.T) {
+	impl := &blockingKafsarServer{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	config := &KafkaProtocolConfig{MaxInflightRequestsPerConn: 2}
+	server := &Server{kafkaProtocolConfig: config, kafsarImpl: impl}
+	networkContext := &ctx.NetworkContext{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	produceReq := &codec.ProduceReq{
+		TopicReqList: []*codec.ProduceTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.ProducePartitionReq{{PartitionId: 0}},
+		}},
+	}
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			resp, _ := server.ReactProduce(networkContext, produceReq, config)
+			assert.Equal(t, codec.NONE, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+		}()
+	}
+	<-impl.entered
+	<-impl.entered
+
+	// flood the connection, every request exceed the limit is rejected
+	for i := 0; i < 10; i++ {
+		resp, _ := server.ReactProduce(networkContext, produceReq, config)
+		assert.Equal(t, codec.REQUEST_TIMED_OUT, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	}
+	fetchReq := &codec.FetchReq{
+		TopicReqList: []*codec.FetchTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: 0}},
+		}},
+	}
+	fetchResp, _ := server.ReactFetch(networkContext, fetchReq)
+	assert.Equal(t, codec.REQUEST_TIMED_OUT, fetchResp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+
+	close(impl.release)
+	waitGroup.Wait()
+	resp, _ := server.ReactProduce(networkContext, produceReq, config)
+	assert.Equal(t, codec.NONE, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+}
+
+type throttlingKafsarServer struct {
+	blockingKafsarServer
+	throttleTimeMs int
+}
+
+func (t *throttlingKafsarServer) ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error) {
+	return &codec.ProducePartitionResp{PartitionId: partition, ErrorCode: codec.NONE}, nil
+}
+
+func (t *throttlingKafsarServer) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	return t.throttleTimeMs
+}
+
+func TestProduceThrottleTime(t *testing.T) {
+	impl := &throttlingKafsarServer{throttleTimeMs: 500}
+	config := &KafkaProtocolConfig{}
+	server := &Server{kafkaProtocolConfig: config, kafsarImpl: impl}
+	networkContext := &ctx.NetworkContext{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	resp, _ := server.ReactProduce(networkContext, &codec.ProduceReq{
+		TopicReqList: []*codec.ProduceTopicReq{{
+			Topic:            "topic",
+			PartitionReqList: []*codec.ProducePartitionReq{{PartitionId: 0}},
+		}},
+	}, config)
+	assert.Equal(t, codec.NONE, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	assert.Equal(t, 500, resp.ThrottleTime)
+}
+
+type cancelableKafsarServer struct {
+	KafsarServer
+	entered chan struct{}
+}
+
+func (c *cancelableKafsarServer) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	return 0
+}
+
+func (c *cancelableKafsarServer) ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error) {
+	c.entered <- struct{}{}
+	<-ctx.Done()
+	return &codec.ProducePartitionResp{PartitionId: partition, ErrorCode: codec.REQUEST_TIMED_OUT}, nil
+}
+
+func TestProduceCanceledByConnectionClose(t *testing.T) {
+	impl := &cancelableKafsarServer{entered: make(chan struct{}, 1)}
+	config := &KafkaProtocolConfig{}
+	server := &Server{kafkaProtocolConfig: config, kafsarImpl: impl}
+	networkContext := ctx.NewNetworkContext(context.Background(), &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}, time.Now())
+	done := make(chan *codec.ProduceResp)
+	go func() {
+		resp, _ := server.ReactProduce(networkContext, &codec.ProduceReq{
+			TopicReqList: []*codec.ProduceTopicReq{{
+				Topic:            "topic",
+				PartitionReqList: []*codec.ProducePartitionReq{{PartitionId: 0}},
+			}},
+		}, config)
+		done <- resp
+	}()
+	<-impl.entered
+	networkContext.Cancel()
+	select {
+	case resp := <-done:
+		assert.Equal(t, codec.REQUEST_TIMED_OUT, resp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("produce is not canceled")
+	}
+}
@@ -18,6 +18,7 @@
 package network
 
 import (
+	"bytes"
 	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
 	"github.com/panjf2000/gnet"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
@@ -32,18 +33,39 @@ func (s *Server) ReactSaslHandshakeAuth(req *codec.SaslAuthenticateReq, context
 		},
 	}
 	saslReq := codec.SaslAuthenticateReq{Username: req.Username, Password: req.Password, BaseReq: codec.BaseReq{ClientId: req.ClientId}}
+	if !s.saslAuth(saslReq, context) {
+		return nil, gnet.Close
+	}
+	return saslHandshakeResp, gnet.None
+}
+
+// ReactSaslToken authenticate the raw PLAIN token sent after SaslHandshake v0, an empty token answers the success
+func (s *Server) ReactSaslToken(token []byte, clientId string, context *ctx.NetworkContext) ([]byte, gnet.Action) {
+	// authzid, username and password separated by NUL
+	fields := bytes.Split(token, []byte{0})
+	if len(fields) != 3 {
+		logrus.Errorf("invalid sasl plain token from %s", context.Addr)
+		return nil, gnet.Close
+	}
+	saslReq := codec.SaslAuthenticateReq{Username: string(fields[1]), Password: string(fields[2]), BaseReq: codec.BaseReq{ClientId: clientId}}
+	if !s.saslAuth(saslReq, context) {
+		return nil, gnet.Close
+	}
+	return []byte{}, gnet.None
+}
+
+func (s *Server) saslAuth(saslReq codec.SaslAuthenticateReq, context *ctx.NetworkContext) bool {
 	authResult, errorCode := s.kafsarImpl.SaslAuth(context.Addr, saslReq)
 	if errorCode != 0 {
 		logrus.Errorf("Sasl auth request failed, source name: %s:%s@%s, error code: %v",
-			req.Username, req.Password, context.Addr, errorCode)
-		return nil, gnet.Close
+			saslReq.Username, saslReq.Password, context.Addr, errorCode)
+		return false
 	}
-	if authResult {
-		context.Authed(true)
-		s.SaslMap.Store(context.Addr, saslReq)
-		return saslHandshakeResp, gnet.None
-	} else {
-		logrus.Errorf("Sasl auth failed, source name: %s:%s@%s", req.Username, req.Password, context.Addr)
-		return nil, gnet.Close
+	if !authResult {
+		logrus.Errorf("Sasl auth failed, source name: %s:%s@%s", saslReq.Username, saslReq.Password, context.Addr)
+		return false
 	}
+	context.Authed(true)
+	s.SaslMap.Store(context.Addr, saslReq)
+	return true
 }
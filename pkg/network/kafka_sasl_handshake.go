@@ -18,12 +18,14 @@
 package network
 
 import (
+	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
 	"github.com/panjf2000/gnet"
 	"github.com/protocol-laboratory/kafka-codec-go/codec"
 	"github.com/sirupsen/logrus"
 )
 
-func (s *Server) ReactSasl(req *codec.SaslHandshakeReq) (*codec.SaslHandshakeResp, gnet.Action) {
+// ReactSasl v0 clients send the raw sasl token next, v1 clients wrap it in a SaslAuthenticate request
+func (s *Server) ReactSasl(req *codec.SaslHandshakeReq, context *ctx.NetworkContext) (*codec.SaslHandshakeResp, gnet.Action) {
 	logrus.Debug("sasl handshake request ", req)
 	saslHandshakeResp := &codec.SaslHandshakeResp{
 		BaseResp: codec.BaseResp{
@@ -31,6 +33,14 @@ func (s *Server) ReactSasl(req *codec.SaslHandshakeReq) (*codec.SaslHandshakeRes
 		},
 	}
 	saslHandshakeResp.EnableMechanisms = make([]*codec.EnableMechanism, 1)
-	saslHandshakeResp.EnableMechanisms[0] = &codec.EnableMechanism{SaslMechanism: "PLAIN"}
+	saslHandshakeResp.EnableMechanisms[0] = &codec.EnableMechanism{SaslMechanism: saslMechanismPlain}
+	if req.SaslMechanism != saslMechanismPlain {
+		logrus.Errorf("unsupported sasl mechanism %s from %s", req.SaslMechanism, context.Addr)
+		saslHandshakeResp.ErrorCode = codec.UNSUPPORTED_SASL_MECHANISM
+		return saslHandshakeResp, gnet.None
+	}
+	if req.ApiVersion == 0 {
+		context.ExpectSaslToken(req.ClientId)
+	}
 	return saslHandshakeResp, gnet.None
 }
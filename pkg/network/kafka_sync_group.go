@@ -26,7 +26,12 @@ import (
 
 func (s *Server) ReactSyncGroup(ctx *ctx.NetworkContext, req *codec.SyncGroupReq) (*codec.SyncGroupResp, gnet.Action) {
 	if !s.checkSaslGroup(ctx, req.GroupId) {
-		return nil, gnet.Close
+		return &codec.SyncGroupResp{
+			BaseResp: codec.BaseResp{
+				CorrelationId: req.CorrelationId,
+			},
+			ErrorCode: codec.GROUP_AUTHORIZATION_FAILED,
+		}, gnet.None
 	}
 	logrus.Debug("sync group req", req)
 	lowResp, err := s.kafsarImpl.GroupSync(ctx.Addr, req)
@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"github.com/panjf2000/gnet"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	gnet.Conn
+	addr    net.Addr
+	context interface{}
+	closed  bool
+}
+
+func (t *testConn) RemoteAddr() net.Addr {
+	return t.addr
+}
+
+func (t *testConn) Context() interface{} {
+	return t.context
+}
+
+func (t *testConn) SetContext(ctx interface{}) {
+	t.context = ctx
+}
+
+func (t *testConn) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	config := &KafkaProtocolConfig{ConnectionIdleTimeoutMs: 1000}
+	server := &Server{kafkaProtocolConfig: config}
+	idle := &testConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	active := &testConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9093}}
+	server.getCtx(idle)
+	server.getCtx(active)
+	server.ConnMap.Store(idle.RemoteAddr(), idle)
+	server.ConnMap.Store(active.RemoteAddr(), active)
+
+	server.closeExpiredConnections(time.Now())
+	assert.False(t, idle.closed)
+	assert.False(t, active.closed)
+
+	server.getCtx(active).Touch(time.Now().Add(2 * time.Second))
+	server.closeExpiredConnections(time.Now().Add(2 * time.Second))
+	assert.True(t, idle.closed)
+	assert.False(t, active.closed)
+}
+
+func TestCloseConnectionsReachMaxLifetime(t *testing.T) {
+	config := &KafkaProtocolConfig{ConnectionMaxLifetimeMs: 1000}
+	server := &Server{kafkaProtocolConfig: config}
+	conn := &testConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	server.getCtx(conn)
+	server.ConnMap.Store(conn.RemoteAddr(), conn)
+
+	server.getCtx(conn).Touch(time.Now().Add(2 * time.Second))
+	server.closeExpiredConnections(time.Now().Add(500 * time.Millisecond))
+	assert.False(t, conn.closed)
+	server.closeExpiredConnections(time.Now().Add(2 * time.Second))
+	assert.True(t, conn.closed)
+}
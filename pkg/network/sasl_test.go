@@ -0,0 +1,214 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package network
+
+import (
+	"context"
+	"github.com/paashzj/kafka_go_pulsar/pkg/network/ctx"
+	"github.com/panjf2000/gnet"
+	"github.com/protocol-laboratory/kafka-codec-go/codec"
+	"github.com/protocol-laboratory/kafka-codec-go/kgnet"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+// deniedKafsarServer deny the denied topics and groups
+type deniedKafsarServer struct {
+	KafsarServer
+	denied map[string]bool
+}
+
+func (d *deniedKafsarServer) SaslAuthTopic(addr net.Addr, req codec.SaslAuthenticateReq, topic, permissionType string) (bool, codec.ErrorCode) {
+	if d.denied[topic] {
+		return false, codec.TOPIC_AUTHORIZATION_FAILED
+	}
+	return true, codec.NONE
+}
+
+func (d *deniedKafsarServer) SaslAuthConsumerGroup(addr net.Addr, req codec.SaslAuthenticateReq, consumerGroup string) (bool, codec.ErrorCode) {
+	if d.denied[consumerGroup] {
+		return false, codec.GROUP_AUTHORIZATION_FAILED
+	}
+	return true, codec.NONE
+}
+
+func (d *deniedKafsarServer) Fetch(ctx context.Context, addr net.Addr, req *codec.FetchReq) ([]*codec.FetchTopicResp, error) {
+	topicRespList := make([]*codec.FetchTopicResp, len(req.TopicReqList))
+	for i, topicReq := range req.TopicReqList {
+		topicRespList[i] = &codec.FetchTopicResp{
+			Topic:             topicReq.Topic,
+			PartitionRespList: []*codec.FetchPartitionResp{{PartitionIndex: 0, ErrorCode: codec.NONE}},
+		}
+	}
+	return topicRespList, nil
+}
+
+func (d *deniedKafsarServer) ThrottleTimeMs(addr net.Addr, apiKey codec.ApiCode) int {
+	return 0
+}
+
+func (d *deniedKafsarServer) ProduceBatches(ctx context.Context, addr net.Addr, topic string, partition int, timeoutMs int, batches []*codec.RecordBatch) (*codec.ProducePartitionResp, error) {
+	return &codec.ProducePartitionResp{PartitionId: partition, ErrorCode: codec.NONE}, nil
+}
+
+func (d *deniedKafsarServer) OffsetCommitPartition(addr net.Addr, topic, clientID string, req *codec.OffsetCommitPartitionReq) (*codec.OffsetCommitPartitionResp, error) {
+	return &codec.OffsetCommitPartitionResp{PartitionId: req.PartitionId, ErrorCode: codec.NONE}, nil
+}
+
+func TestAuthorizationFailed(t *testing.T) {
+	impl := &deniedKafsarServer{denied: map[string]bool{"denied-topic": true, "denied-group": true}}
+	config := &KafkaProtocolConfig{NeedSasl: true}
+	server := &Server{kafkaProtocolConfig: config, kafsarImpl: impl}
+	networkContext := &ctx.NetworkContext{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	server.SaslMap.Store(networkContext.Addr, codec.SaslAuthenticateReq{Username: "username"})
+
+	produceResp, action := server.ReactProduce(networkContext, &codec.ProduceReq{
+		TopicReqList: []*codec.ProduceTopicReq{
+			{Topic: "topic", PartitionReqList: []*codec.ProducePartitionReq{{PartitionId: 0}}},
+			{Topic: "denied-topic", PartitionReqList: []*codec.ProducePartitionReq{{PartitionId: 0}}},
+		},
+	}, config)
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, codec.NONE, produceResp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, produceResp.TopicRespList[1].PartitionRespList[0].ErrorCode)
+
+	fetchResp, action := server.ReactFetch(networkContext, &codec.FetchReq{
+		TopicReqList: []*codec.FetchTopicReq{
+			{Topic: "denied-topic", PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: 0}}},
+			{Topic: "topic", PartitionReqList: []*codec.FetchPartitionReq{{PartitionId: 0}}},
+		},
+	})
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, 2, len(fetchResp.TopicRespList))
+	assert.Equal(t, "topic", fetchResp.TopicRespList[0].Topic)
+	assert.Equal(t, codec.NONE, fetchResp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	assert.Equal(t, "denied-topic", fetchResp.TopicRespList[1].Topic)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, fetchResp.TopicRespList[1].PartitionRespList[0].ErrorCode)
+
+	commitReq := &codec.OffsetCommitReq{
+		GroupId: "group",
+		TopicReqList: []*codec.OffsetCommitTopicReq{
+			{Topic: "topic", PartitionReqList: []*codec.OffsetCommitPartitionReq{{PartitionId: 0}}},
+			{Topic: "denied-topic", PartitionReqList: []*codec.OffsetCommitPartitionReq{{PartitionId: 0}}},
+		},
+	}
+	commitResp, _ := server.OffsetCommitVersion(networkContext, commitReq)
+	assert.Equal(t, codec.NONE, commitResp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	assert.Equal(t, codec.TOPIC_AUTHORIZATION_FAILED, commitResp.TopicRespList[1].PartitionRespList[0].ErrorCode)
+	commitReq.GroupId = "denied-group"
+	commitResp, _ = server.OffsetCommitVersion(networkContext, commitReq)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, commitResp.TopicRespList[0].PartitionRespList[0].ErrorCode)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, commitResp.TopicRespList[1].PartitionRespList[0].ErrorCode)
+
+	joinResp, action := server.ReactJoinGroup(networkContext, &codec.JoinGroupReq{GroupId: "denied-group"})
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, joinResp.ErrorCode)
+	heartbeatResp, _ := server.ReactHeartbeat(&codec.HeartbeatReq{GroupId: "denied-group"}, networkContext)
+	assert.Equal(t, codec.GROUP_AUTHORIZATION_FAILED, heartbeatResp.ErrorCode)
+}
+
+// plainKafsarServer accept the sasl plain user with the password
+type plainKafsarServer struct {
+	KafsarServer
+	username string
+	password string
+}
+
+func (p *plainKafsarServer) SaslAuth(addr net.Addr, req codec.SaslAuthenticateReq) (bool, codec.ErrorCode) {
+	return req.Username == p.username && req.Password == p.password, codec.NONE
+}
+
+func newSaslServer() (*eventHandler, *testConn) {
+	server := &Server{kafkaProtocolConfig: &KafkaProtocolConfig{NeedSasl: true}, kafsarImpl: &plainKafsarServer{username: "username", password: "password"}}
+	server.kafkaServer = kgnet.NewKafkaServer(kgnet.GnetServerConfig{}, server)
+	conn := &testConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9092}}
+	return &eventHandler{KafkaServer: server.kafkaServer, server: server}, conn
+}
+
+func TestSaslHandshakeV1WrappedAuthenticate(t *testing.T) {
+	handler, conn := newSaslServer()
+	handshakeReq := &codec.SaslHandshakeReq{BaseReq: codec.BaseReq{ApiVersion: 1, CorrelationId: 1, ClientId: "client"}, SaslMechanism: "PLAIN"}
+	out, action := handler.React(handshakeReq.Bytes(false, true), conn)
+	assert.Equal(t, gnet.None, action)
+	handshakeResp, err := codec.DecodeSaslHandshakeResp(out, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, codec.NONE, handshakeResp.ErrorCode)
+	assert.Equal(t, "PLAIN", handshakeResp.EnableMechanisms[0].SaslMechanism)
+
+	authReq := &codec.SaslAuthenticateReq{BaseReq: codec.BaseReq{ApiVersion: 1, CorrelationId: 2, ClientId: "client"}, Username: "username", Password: "password"}
+	out, action = handler.React(authReq.Bytes(false, true), conn)
+	assert.Equal(t, gnet.None, action)
+	authResp, err := codec.DecodeSaslAuthenticateResp(out, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, authResp.CorrelationId)
+	assert.Equal(t, codec.NONE, authResp.ErrorCode)
+	assert.True(t, handler.server.getCtx(conn).IsAuthed())
+}
+
+func TestSaslHandshakeV0RawToken(t *testing.T) {
+	handler, conn := newSaslServer()
+	handshakeReq := &codec.SaslHandshakeReq{BaseReq: codec.BaseReq{ApiVersion: 0, CorrelationId: 1, ClientId: "client"}, SaslMechanism: "PLAIN"}
+	_, action := handler.React(handshakeReq.Bytes(false, true), conn)
+	assert.Equal(t, gnet.None, action)
+	out, action := handler.React([]byte("\x00username\x00password"), conn)
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, []byte{}, out)
+	assert.True(t, handler.server.getCtx(conn).IsAuthed())
+	saslReq, ok := handler.server.SaslMap.Load(conn.RemoteAddr())
+	assert.True(t, ok)
+	assert.Equal(t, "client", saslReq.(codec.SaslAuthenticateReq).ClientId)
+
+	handler, conn = newSaslServer()
+	_, _ = handler.React(handshakeReq.Bytes(false, true), conn)
+	_, action = handler.React([]byte("\x00username\x00wrong"), conn)
+	assert.Equal(t, gnet.Close, action)
+	assert.False(t, handler.server.getCtx(conn).IsAuthed())
+}
+
+func TestSaslUnsupportedVersion(t *testing.T) {
+	handler, conn := newSaslServer()
+	handshakeResp, action := handler.server.SaslHandshake(conn, &codec.SaslHandshakeReq{BaseReq: codec.BaseReq{ApiVersion: 2}, SaslMechanism: "PLAIN"})
+	assert.Equal(t, gnet.Close, action)
+	assert.Equal(t, codec.UNSUPPORTED_VERSION, handshakeResp.ErrorCode)
+	authResp, action := handler.server.SaslAuthenticate(conn, &codec.SaslAuthenticateReq{BaseReq: codec.BaseReq{ApiVersion: 3}})
+	assert.Equal(t, gnet.Close, action)
+	assert.Equal(t, codec.UNSUPPORTED_VERSION, authResp.ErrorCode)
+
+	handshakeResp, action = handler.server.SaslHandshake(conn, &codec.SaslHandshakeReq{BaseReq: codec.BaseReq{ApiVersion: 1}, SaslMechanism: "SCRAM-SHA-256"})
+	assert.Equal(t, gnet.None, action)
+	assert.Equal(t, codec.UNSUPPORTED_SASL_MECHANISM, handshakeResp.ErrorCode)
+}
+
+func TestSaslUnauthedProduceClosed(t *testing.T) {
+	handler, conn := newSaslServer()
+	produceReq := &codec.ProduceReq{
+		BaseReq:      codec.BaseReq{ApiVersion: 7, CorrelationId: 1, ClientId: "client"},
+		RequiredAcks: -1,
+		Timeout:      3000,
+		TopicReqList: []*codec.ProduceTopicReq{{
+			Topic: "topic",
+			PartitionReqList: []*codec.ProducePartitionReq{{
+				RecordBatch: &codec.RecordBatch{Records: []*codec.Record{{Value: []byte("value")}}},
+			}},
+		}},
+	}
+	out, action := handler.React(produceReq.Bytes(false, true), conn)
+	assert.Equal(t, gnet.Close, action)
+	assert.Nil(t, out)
+}
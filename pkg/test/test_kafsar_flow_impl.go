@@ -32,7 +32,7 @@ func (k FlowKafsarImpl) AuthTopicGroup(username string, password, clientId, cons
 	return true, nil
 }
 
-func (k FlowKafsarImpl) SubscriptionName(groupId string) (string, error) {
+func (k FlowKafsarImpl) SubscriptionName(username, groupId string) (string, error) {
 	return SubscriptionPrefix + groupId, nil
 }
 
@@ -48,6 +48,6 @@ func (k FlowKafsarImpl) ListTopic(username string) ([]string, error) {
 	return nil, nil
 }
 
-func (k FlowKafsarImpl) HasFlowQuota(username, topic string) bool {
-	return false
+func (k FlowKafsarImpl) ReserveFetchQuota(username, topic string, bytes int) int {
+	return 0
 }
@@ -38,7 +38,7 @@ func (k KafsarImpl) AuthTopicGroup(username string, password, clientId, consumer
 	return true, nil
 }
 
-func (k KafsarImpl) SubscriptionName(groupId string) (string, error) {
+func (k KafsarImpl) SubscriptionName(username, groupId string) (string, error) {
 	return SubscriptionPrefix + groupId, nil
 }
 
@@ -54,6 +54,6 @@ func (k KafsarImpl) ListTopic(username string) ([]string, error) {
 	return nil, nil
 }
 
-func (k KafsarImpl) HasFlowQuota(username, topic string) bool {
-	return true
+func (k KafsarImpl) ReserveFetchQuota(username, topic string, bytes int) int {
+	return bytes
 }
@@ -18,6 +18,7 @@
 package utils
 
 import (
+	"bytes"
 	"errors"
 	"github.com/sirupsen/logrus"
 	"io"
@@ -47,11 +48,24 @@ func HttpGet(url string, params map[string]string, header map[string]string) (re
 	for key, value := range params {
 		query.Add(key, value)
 	}
+	request.URL.RawQuery = query.Encode()
+	return send(request, header)
+}
+
+func HttpPost(url string, body []byte, header map[string]string) (resp []byte, err error) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		logrus.Errorf("new request failed. err: %s", err)
+		return nil, err
+	}
+	return send(request, header)
+}
+
+func send(request *http.Request, header map[string]string) ([]byte, error) {
 	request.Header.Set("Content-Type", "application/json")
 	for key, value := range header {
 		request.Header.Set(key, value)
 	}
-	request.URL.RawQuery = query.Encode()
 	response, err := client.Do(request)
 	if err != nil {
 		logrus.Errorf("send request failed. err: %s", err)
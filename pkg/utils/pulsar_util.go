@@ -46,6 +46,38 @@ func ReadEarliestMsg(partitionedTopic string, maxWaitMs int, pulsarClient pulsar
 	return readNextMsg(readerOptions, maxWaitMs, pulsarClient)
 }
 
+// ReadMsgByTime read the first message whose publish time is at or after timestamp(ms), nil if there is none
+func ReadMsgByTime(partitionedTopic string, maxWaitMs int, timestamp int64, pulsarClient pulsar.Client) (pulsar.Message, error) {
+	readerOptions := pulsar.ReaderOptions{
+		Topic:          partitionedTopic,
+		Name:           constant.OffsetReaderTimestampName,
+		StartMessageID: pulsar.EarliestMessageID(),
+	}
+	reader, err := pulsarClient.CreateReader(readerOptions)
+	if err != nil {
+		logrus.Warnf("create pulsar timestamp read failed. topic: %s, err: %s", partitionedTopic, err)
+		return nil, err
+	}
+	defer reader.Close()
+	err = reader.SeekByTime(time.UnixMilli(timestamp))
+	if err != nil {
+		logrus.Errorf("seek by time failed. topic: %s, time: %d, err: %s", partitionedTopic, timestamp, err)
+		return nil, err
+	}
+	return nextMsg(reader, maxWaitMs)
+}
+
+// ReadMsgById read the message of msgId, nil if it can not be read in maxWaitMs
+func ReadMsgById(partitionedTopic string, maxWaitMs int, msgId pulsar.MessageID, pulsarClient pulsar.Client) (pulsar.Message, error) {
+	readerOptions := pulsar.ReaderOptions{
+		Topic:                   partitionedTopic,
+		Name:                    constant.ProduceOffsetReaderName,
+		StartMessageID:          msgId,
+		StartMessageIDInclusive: true,
+	}
+	return readNextMsg(readerOptions, maxWaitMs, pulsarClient)
+}
+
 func GetLatestMsgId(partitionedTopic, addr string) (msg []byte, err error) {
 	tenant, namespace, shortPartitionedTopic, err := getTenantNamespaceTopicFromPartitionedTopic(partitionedTopic)
 	if err != nil {
@@ -62,6 +94,115 @@ func GetLatestMsgId(partitionedTopic, addr string) (msg []byte, err error) {
 	return msg, nil
 }
 
+// GetTopicRetention get the retention policies applied on the topic, include namespace and broker level
+func GetTopicRetention(pulsarTopic, addr string) (*model.RetentionPolicies, error) {
+	tenant, namespace, shortTopic, err := getTenantNamespaceTopicFromPartitionedTopic(pulsarTopic)
+	if err != nil {
+		logrus.Errorf("get tenant and namespace failed. topic: %s, err: %s", pulsarTopic, err)
+		return nil, err
+	}
+	url := fmt.Sprintf(addr+constant.RetentionUrl, tenant, namespace, shortTopic)
+	resp, err := HttpGet(url, map[string]string{"applied": "true"}, nil)
+	if err != nil {
+		logrus.Errorf("get retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return nil, err
+	}
+	retention := &model.RetentionPolicies{}
+	if len(resp) == 0 {
+		return retention, nil
+	}
+	err = json.Unmarshal(resp, retention)
+	if err != nil {
+		logrus.Errorf("unmarshal retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return nil, err
+	}
+	return retention, nil
+}
+
+// SetTopicRetention set the topic level retention policies, they override the namespace and broker level
+func SetTopicRetention(pulsarTopic, addr string, retention *model.RetentionPolicies) error {
+	tenant, namespace, shortTopic, err := getTenantNamespaceTopicFromPartitionedTopic(pulsarTopic)
+	if err != nil {
+		logrus.Errorf("get tenant and namespace failed. topic: %s, err: %s", pulsarTopic, err)
+		return err
+	}
+	body, err := json.Marshal(retention)
+	if err != nil {
+		logrus.Errorf("marshal retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return err
+	}
+	url := fmt.Sprintf(addr+constant.RetentionUrl, tenant, namespace, shortTopic)
+	_, err = HttpPost(url, body, nil)
+	if err != nil {
+		logrus.Errorf("set retention failed. topic: %s, err: %s", pulsarTopic, err)
+		return err
+	}
+	return nil
+}
+
+// GetSubscriptionBacklog get the message backlog of the subscription on the partitioned topic
+func GetSubscriptionBacklog(partitionedTopic, subscriptionName, addr string) (int64, error) {
+	tenant, namespace, shortPartitionedTopic, err := getTenantNamespaceTopicFromPartitionedTopic(partitionedTopic)
+	if err != nil {
+		logrus.Errorf("get tenant and namespace failed. topic: %s, err: %s", partitionedTopic, err)
+		return 0, err
+	}
+	url := fmt.Sprintf(addr+constant.TopicStatsUrl, tenant, namespace, shortPartitionedTopic)
+	resp, err := HttpGet(url, nil, nil)
+	if err != nil {
+		logrus.Errorf("get topic stats failed. topic: %s, err: %s", partitionedTopic, err)
+		return 0, err
+	}
+	stats := &model.TopicStats{}
+	err = json.Unmarshal(resp, stats)
+	if err != nil {
+		logrus.Errorf("unmarshal topic stats failed. topic: %s, err: %s", partitionedTopic, err)
+		return 0, err
+	}
+	subscription, exist := stats.Subscriptions[subscriptionName]
+	if !exist {
+		return 0, fmt.Errorf("subscription %s not found on topic %s", subscriptionName, partitionedTopic)
+	}
+	return subscription.MsgBacklog, nil
+}
+
+// IsNonPartitionedTopic check whether the pulsar topic exists as a non-partitioned topic in its namespace
+func IsNonPartitionedTopic(pulsarTopic, addr string) (bool, error) {
+	tenant, namespace, _, err := getTenantNamespaceTopicFromPartitionedTopic(pulsarTopic)
+	if err != nil {
+		logrus.Errorf("get tenant and namespace failed. topic: %s, err: %s", pulsarTopic, err)
+		return false, err
+	}
+	url := fmt.Sprintf(addr+constant.TopicListUrl, tenant, namespace)
+	resp, err := HttpGet(url, nil, nil)
+	if err != nil {
+		logrus.Errorf("list topics failed. namespace: %s/%s, err: %s", tenant, namespace, err)
+		return false, err
+	}
+	var topics []string
+	err = json.Unmarshal(resp, &topics)
+	if err != nil {
+		logrus.Errorf("unmarshal topic list failed. namespace: %s/%s, err: %s", tenant, namespace, err)
+		return false, err
+	}
+	for _, topic := range topics {
+		if topic == pulsarTopic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckBrokerHealth run the health check of the pulsar broker, it produces and consumes a heartbeat message
+func CheckBrokerHealth(addr string) error {
+	_, err := HttpGet(addr+constant.BrokerHealthUrl, nil, nil)
+	if err != nil {
+		logrus.Errorf("pulsar broker health check failed. addr: %s, err: %s", addr, err)
+		return err
+	}
+	return nil
+}
+
 func ReadLastedMsg(partitionedTopic string, maxWaitMs int, msgIdBytes []byte, pulsarClient pulsar.Client) (pulsar.Message, error) {
 	var msgId pulsar.MessageID
 	bytes, err := generateMsgBytes(msgIdBytes)
@@ -105,11 +246,15 @@ func readNextMsg(operation pulsar.ReaderOptions, maxWaitMs int, pulsarClient pul
 		return nil, err
 	}
 	defer reader.Close()
+	return nextMsg(reader, maxWaitMs)
+}
+
+func nextMsg(reader pulsar.Reader, maxWaitMs int) (pulsar.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(maxWaitMs)*time.Millisecond)
 	defer cancel()
 	message, err := reader.Next(ctx)
 	if err != nil {
-		logrus.Errorf("get message failed. topic: %s, err: %s", operation.Topic, err)
+		logrus.Errorf("get message failed. topic: %s, err: %s", reader.Topic(), err)
 		if strings.Contains(err.Error(), constant.ReadMsgTimeoutErr) {
 			return message, nil
 		}